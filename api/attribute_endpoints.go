@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+type AttributeAPI struct {
+	Store handlers.DirectoryStore
+}
+
+// CreateAttributeDefinition handles POST /api/v1/attributes/{entityType}
+func (aa *AttributeAPI) CreateAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	var def models.AttributeDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	def.EntityType = mux.Vars(r)["entityType"]
+
+	if err := aa.Store.CreateAttributeDefinition(r.Context(), &def); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(def)
+}
+
+// GetAttributeDefinitions handles GET /api/v1/attributes/{entityType}
+func (aa *AttributeAPI) GetAttributeDefinitions(w http.ResponseWriter, r *http.Request) {
+	defs, err := aa.Store.GetAttributeDefinitions(r.Context(), mux.Vars(r)["entityType"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defs)
+}
+
+// GetAttributeDefinition handles GET /api/v1/attributes/{entityType}/{name}
+func (aa *AttributeAPI) GetAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	def, err := aa.Store.GetAttributeDefinition(r.Context(), vars["entityType"], vars["name"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(def)
+}
+
+// UpdateAttributeDefinition handles PUT /api/v1/attributes/{entityType}/{name}
+func (aa *AttributeAPI) UpdateAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var def models.AttributeDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	def.EntityType = vars["entityType"]
+	def.Name = vars["name"]
+
+	if err := aa.Store.UpdateAttributeDefinition(r.Context(), &def); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(def)
+}
+
+// DeleteAttributeDefinition handles DELETE /api/v1/attributes/{entityType}/{name}
+func (aa *AttributeAPI) DeleteAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := aa.Store.DeleteAttributeDefinition(r.Context(), vars["entityType"], vars["name"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterAttributeRoutes registers the attribute schema routes
+func (aa *AttributeAPI) RegisterAttributeRoutes(router *mux.Router) {
+	attributeRouter := router.PathPrefix("/attributes/{entityType:user|group}").Subrouter()
+
+	attributeRouter.HandleFunc("", aa.CreateAttributeDefinition).Methods("POST")
+	attributeRouter.HandleFunc("", aa.GetAttributeDefinitions).Methods("GET")
+	attributeRouter.HandleFunc("/{name}", aa.GetAttributeDefinition).Methods("GET")
+	attributeRouter.HandleFunc("/{name}", aa.UpdateAttributeDefinition).Methods("PUT")
+	attributeRouter.HandleFunc("/{name}", aa.DeleteAttributeDefinition).Methods("DELETE")
+}
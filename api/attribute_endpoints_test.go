@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// userIDs returns the IDs of a page of users
+func userIDs(page handlers.Page[models.User]) []string {
+	out := []string{}
+	for _, user := range page.Items {
+		out = append(out, user.ID)
+	}
+	return out
+}
+
+func TestUserAttributes(t *testing.T) {
+	a := newTestAPI(t)
+	for _, def := range []models.AttributeDefinition{
+		{Name: "badge", Type: models.AttributeInt},
+		{Name: "dept", Type: models.AttributeEnum, EnumValues: models.StringList{"sales", "support"}},
+		{Name: "skills", Type: models.AttributeString, MultiValued: true},
+		{Name: "start", Type: models.AttributeDate},
+		{Name: "contractor", Type: models.AttributeBool},
+	} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/attributes/user", def)
+	}
+
+	create := func(status int, attrs string) *models.User {
+		t.Helper()
+		rec := a.expect(status, "POST", "/api/v1/users", strings.NewReader(`{"name":"User","email":"user@example.com","attributes":`+attrs+`}`))
+		var user models.User
+		if status == http.StatusCreated {
+			decode(t, rec, &user)
+		} else if code := problemCode(t, rec); code != "invalid_attribute" {
+			t.Errorf("code = %q, want invalid_attribute", code)
+		}
+		return &user
+	}
+	user := create(http.StatusCreated, `{"badge":9007199254740992,"dept":"sales","skills":["go","sql"],"start":"2025-01-15","contractor":false}`)
+	want := models.Attributes{"badge": float64(1 << 53), "dept": "sales", "skills": []interface{}{"go", "sql"}, "start": "2025-01-15", "contractor": false}
+	if !reflect.DeepEqual(user.Attributes, want) {
+		t.Errorf("attributes = %#v, want %#v", user.Attributes, want)
+	}
+	create(http.StatusCreated, `{"badge":2,"dept":"support","skills":["sql"],"contractor":true}`)
+	create(http.StatusCreated, `{}`)
+
+	for _, attrs := range []string{
+		`{"badge":1.5}`,
+		`{"badge":9007199254740994}`,
+		`{"badge":"1"}`,
+		`{"dept":"finance"}`,
+		`{"skills":"go"}`,
+		`{"skills":[1]}`,
+		`{"start":"15/01/2025"}`,
+		`{"team":"x"}`,
+	} {
+		create(http.StatusUnprocessableEntity, attrs)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"attr.badge=9007199254740992", []string{"UI000001"}},
+		{"attr.badge=2&attr.contractor=true", []string{"UI000002"}},
+		{"attr.badge=2&attr.contractor=false", []string{}},
+		{"attr.dept=sales", []string{"UI000001"}},
+		// A multi-valued attribute matches when its list contains the value
+		{"attr.skills=sql", []string{"UI000001", "UI000002"}},
+		{"attr.skills=go", []string{"UI000001"}},
+		{"attr.skills=rust", []string{}},
+		{"attr.start=2025-01-15", []string{"UI000001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var page handlers.Page[models.User]
+			decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users?"+tt.query, nil), &page)
+			if got := userIDs(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for _, query := range []string{"attr.team=x", "attr.badge=two", "attr.contractor=maybe", "attr.dept=finance", "attr.start=2025-13-01"} {
+		rec := a.expect(http.StatusBadRequest, "GET", "/api/v1/users?"+query, nil)
+		if code := problemCode(t, rec); code != "invalid_filter" {
+			t.Errorf("%s: code = %q, want invalid_filter", query, code)
+		}
+	}
+
+	// Updates replace the attributes, and omitting them keeps the stored values
+	a.expect(http.StatusOK, "PUT", "/api/v1/users/UI000001", strings.NewReader(`{"name":"User","email":"user@example.com","attributes":{"badge":3}}`))
+	var updated models.User
+	decode(t, a.expect(http.StatusOK, "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"name":"Ada"}`), "Content-Type", "application/merge-patch+json"), &updated)
+	if !reflect.DeepEqual(updated.Attributes, models.Attributes{"badge": float64(3)}) {
+		t.Errorf("attributes after update = %#v", updated.Attributes)
+	}
+
+	// Deleting a definition removes its values
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/attributes/user/badge", nil)
+	var cleared models.User
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil), &cleared)
+	if len(cleared.Attributes) != 0 {
+		t.Errorf("attributes after deleting the definition = %#v", cleared.Attributes)
+	}
+}
+
+// A definition cannot become stricter than the values stored for it
+func TestAttributeDefinitionChanges(t *testing.T) {
+	a := newTestAPI(t)
+	dept := models.AttributeDefinition{Name: "dept", Type: models.AttributeEnum, EnumValues: models.StringList{"sales", "support", "legal"}}
+	a.expect(http.StatusCreated, "POST", "/api/v1/attributes/group", dept)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Sales", Attributes: models.Attributes{"dept": "sales"}})
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Help", Attributes: models.Attributes{"dept": "support"}})
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Other"})
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/groups/GRP002", nil)
+
+	update := func(status int, def models.AttributeDefinition) {
+		t.Helper()
+		rec := a.expect(status, "PUT", "/api/v1/attributes/group/dept", def)
+		if status != http.StatusOK {
+			if code := problemCode(t, rec); code != "invalid_attribute" {
+				t.Errorf("code = %q, want invalid_attribute", code)
+			}
+		}
+	}
+	// Unused enum values can be removed; one used by a soft-deleted group cannot
+	update(http.StatusOK, models.AttributeDefinition{Type: models.AttributeEnum, EnumValues: models.StringList{"sales", "support"}})
+	update(http.StatusUnprocessableEntity, models.AttributeDefinition{Type: models.AttributeEnum, EnumValues: models.StringList{"sales"}})
+	update(http.StatusUnprocessableEntity, models.AttributeDefinition{Type: models.AttributeEnum, Required: true, EnumValues: models.StringList{"sales", "support"}})
+	update(http.StatusUnprocessableEntity, models.AttributeDefinition{Type: models.AttributeString})
+	update(http.StatusUnprocessableEntity, models.AttributeDefinition{Type: models.AttributeEnum, MultiValued: true, EnumValues: models.StringList{"sales", "support"}})
+
+	// Once every group has a value, the attribute can become required
+	a.expect(http.StatusOK, "PUT", "/api/v1/groups/GRP003", models.Group{Name: "Other", Attributes: models.Attributes{"dept": "sales"}})
+	update(http.StatusOK, models.AttributeDefinition{Type: models.AttributeEnum, Required: true, EnumValues: models.StringList{"sales", "support"}})
+	rec := a.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/groups", models.Group{Name: "New"})
+	if code := problemCode(t, rec); code != "invalid_attribute" {
+		t.Errorf("code = %q, want invalid_attribute", code)
+	}
+
+	// A new required attribute needs an empty directory
+	rec = a.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/attributes/group", models.AttributeDefinition{Name: "owner", Type: models.AttributeString, Required: true})
+	if code := problemCode(t, rec); code != "invalid_attribute" {
+		t.Errorf("code = %q, want invalid_attribute", code)
+	}
+	a.expect(http.StatusCreated, "POST", "/api/v1/attributes/user", models.AttributeDefinition{Name: "owner", Type: models.AttributeString, Required: true})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+type AuditAPI struct {
+	Store handlers.DirectoryStore
+}
+
+// ListAudit handles GET /api/v1/audit
+// Query parameters: entity_type, entity_id, actor, operation, since, until
+// (RFC 3339), limit and cursor. Entries are paged newest first.
+func (aa *AuditAPI) ListAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := handlers.AuditFilter{
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		Actor:      query.Get("actor"),
+		Operation:  query.Get("operation"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		writeError(w, r, badRequest("invalid_parameter", "Invalid since parameter: expected RFC 3339 timestamp"))
+		return
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		writeError(w, r, badRequest("invalid_parameter", "Invalid until parameter: expected RFC 3339 timestamp"))
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			writeError(w, r, badRequest("invalid_parameter", "Invalid limit parameter"))
+			return
+		}
+	}
+
+	page, err := aa.Store.ListAudit(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RegisterAuditRoutes registers all audit-related routes
+func (aa *AuditAPI) RegisterAuditRoutes(router *mux.Router) {
+	router.HandleFunc("/audit", aa.ListAudit).Methods("GET")
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// auditKeys returns the ID and operation of each audit entry
+func auditKeys(page handlers.Page[models.AuditEntry]) []string {
+	keys := []string{}
+	for _, entry := range page.Items {
+		keys = append(keys, strconv.FormatInt(entry.ID, 10)+" "+entry.Operation)
+	}
+	return keys
+}
+
+// walkAudit follows next_cursor through every page of an audit query
+func (a *testAPI) walkAudit(query string) []string {
+	a.t.Helper()
+	keys := []string{}
+	cursor := ""
+	for pages := 0; pages < 20; pages++ {
+		target := "/api/v1/audit?" + query
+		if cursor != "" {
+			target += "&cursor=" + url.QueryEscape(cursor)
+		}
+		var page handlers.Page[models.AuditEntry]
+		decode(a.t, a.expect(http.StatusOK, "GET", target, nil), &page)
+		keys = append(keys, auditKeys(page)...)
+		if page.NextCursor == "" {
+			return keys
+		}
+		cursor = page.NextCursor
+	}
+	a.t.Fatalf("%s: cursor walk did not end", query)
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	a := newTestAPI(t)
+
+	// The X-Actor header is ignored unless the server trusts it
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"}, "X-Actor", "mallory")
+	a.server.TrustActorHeader = true
+	a.expect(http.StatusOK, "PUT", "/api/v1/users/UI000001", models.User{Name: "Ava", Email: "ada@example.com"}, "X-Actor", "hr")
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"}, "X-Actor", "hr")
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/users/UI000001", nil, "X-Actor", "it")
+
+	var page handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit?entity_type=user&entity_id=UI000001", nil), &page)
+	if got := auditKeys(page); !reflect.DeepEqual(got, []string{"4 delete", "2 update", "1 create"}) {
+		t.Fatalf("entries = %v", got)
+	}
+	var actors []string
+	for _, entry := range page.Items {
+		actors = append(actors, entry.Actor)
+	}
+	if !reflect.DeepEqual(actors, []string{"it", "hr", "anonymous"}) {
+		t.Errorf("actors = %v", actors)
+	}
+
+	// Changes hold the fields that differ, with null for a missing side
+	update, create := page.Items[1].Changes, page.Items[2].Changes
+	if !reflect.DeepEqual(update["name"], models.FieldChange{Before: "Ada", After: "Ava"}) {
+		t.Errorf("update changes = %+v", update)
+	}
+	if _, ok := update["email"]; ok {
+		t.Errorf("update records the unchanged email: %+v", update)
+	}
+	if !reflect.DeepEqual(create["email"], models.FieldChange{Before: nil, After: "ada@example.com"}) {
+		t.Errorf("create changes = %+v", create)
+	}
+	if !reflect.DeepEqual(page.Items[0].Changes["name"], models.FieldChange{Before: "Ava", After: nil}) {
+		t.Errorf("delete changes = %+v", page.Items[0].Changes)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"4 delete", "3 create", "2 update", "1 create"}},
+		{"actor=hr", []string{"3 create", "2 update"}},
+		{"operation=create", []string{"3 create", "1 create"}},
+		{"entity_type=group", []string{"3 create"}},
+		{"actor=hr&operation=create", []string{"3 create"}},
+		{"actor=mallory", []string{}},
+		{"since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), []string{}},
+		{"until=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)) + "&limit=1", []string{"4 delete", "3 create", "2 update", "1 create"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := a.walkAudit(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An authenticated caller is always the actor, whatever X-Actor says
+func TestAuditActorAuthenticated(t *testing.T) {
+	a := newAuthTestAPI(t)
+	a.server.TrustActorHeader = true
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"},
+		"X-Test-Subject", testAdmin, "X-Actor", "mallory")
+
+	var page handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit", nil, "X-Test-Subject", testAdmin), &page)
+	if len(page.Items) != 1 || page.Items[0].Actor != testAdmin {
+		t.Errorf("entries = %+v, want one by %s", page.Items, testAdmin)
+	}
+
+	// Reading the audit log needs audit:read
+	a.expect(http.StatusForbidden, "GET", "/api/v1/audit", nil, "X-Test-Subject", "key", "X-Test-Scopes", "users")
+	a.expect(http.StatusOK, "GET", "/api/v1/audit", nil, "X-Test-Subject", "key", "X-Test-Scopes", "audit:read")
+}
+
+func TestAuditPaging(t *testing.T) {
+	a := newTestAPI(t)
+	base := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	// Entries that share a time are ordered by ID
+	for _, offset := range []time.Duration{0, 0, time.Second, time.Second, 2 * time.Second, 2500 * time.Millisecond, 3 * time.Second} {
+		entry := &models.AuditEntry{OccurredAt: base.Add(offset), Actor: "hr", EntityType: models.EntityUser, EntityID: "UI000001", Operation: "update"}
+		if err := a.server.Store.RecordAudit(context.Background(), entry); err != nil {
+			t.Fatalf("RecordAudit() error = %v", err)
+		}
+	}
+	at := func(offset time.Duration) string { return url.QueryEscape(base.Add(offset).Format(time.RFC3339)) }
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"limit=2", []string{"7 update", "6 update", "5 update", "4 update", "3 update", "2 update", "1 update"}},
+		{"limit=3", []string{"7 update", "6 update", "5 update", "4 update", "3 update", "2 update", "1 update"}},
+		// since is inclusive and until exclusive
+		{"limit=1&since=" + at(time.Second) + "&until=" + at(3*time.Second), []string{"6 update", "5 update", "4 update", "3 update"}},
+		{"limit=1&until=" + at(time.Second), []string{"2 update", "1 update"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := a.walkAudit(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A full last page has no next cursor
+	var page handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit?limit=7", nil), &page)
+	if len(page.Items) != 7 || page.NextCursor != "" {
+		t.Errorf("page of every entry = %v, next cursor %q", auditKeys(page), page.NextCursor)
+	}
+}
+
+func TestAuditRejected(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(2)
+	var users handlers.Page[models.User]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users?limit=1", nil), &users)
+	var audit handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit?limit=1", nil), &audit)
+	if users.NextCursor == "" || audit.NextCursor == "" {
+		t.Fatalf("missing next cursors: %+v, %+v", users, audit)
+	}
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"since=yesterday", "invalid_parameter"},
+		{"until=2025-01-15", "invalid_parameter"},
+		{"limit=0", "invalid_parameter"},
+		{"limit=x", "invalid_parameter"},
+		{"cursor=not-a-cursor", "invalid_list_options"},
+		{"cursor=" + users.NextCursor, "invalid_list_options"},
+	}
+	for _, tt := range tests {
+		rec := a.expect(http.StatusBadRequest, "GET", "/api/v1/audit?"+tt.query, nil)
+		if code := problemCode(t, rec); code != tt.code {
+			t.Errorf("%s: code = %q, want %s", tt.query, code, tt.code)
+		}
+	}
+	// Audit cursors are not accepted by other lists
+	a.expect(http.StatusBadRequest, "GET", "/api/v1/users?cursor="+audit.NextCursor, nil)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+)
+
+// authRealm names the protection space in WWW-Authenticate challenges
+const authRealm = "lotus-directory"
+
+// authMiddleware rejects requests without valid credentials and puts the
+// authenticated caller on the request context. Without an Authenticator the
+// API is open and requests carry no principal.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := s.Authenticator.Authenticate(r)
+		if err != nil {
+			challenge := `Bearer realm="` + authRealm + `"`
+			if errors.Is(err, auth.ErrInvalidToken) {
+				challenge += `, error="invalid_token"`
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
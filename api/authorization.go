@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// permissionsKey is the context key of the permissions of the caller
+type permissionsKey struct{}
+
+// errBuiltinRoleDeleted is returned for deleting a built-in role, which would
+// revoke its permissions from every holder at once
+var errBuiltinRoleDeleted = errors.New("built-in roles authorize the directory API and cannot be deleted")
+
+// isBuiltinRole reports whether roleID is one of auth.BuiltinRoles
+func isBuiltinRole(roleID string) bool {
+	for _, role := range auth.BuiltinRoles {
+		if role.ID == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenError is returned when the caller lacks the permission a route requires
+type forbiddenError struct {
+	permission auth.Permission // Empty for a route without a documented permission
+}
+
+func (e *forbiddenError) Error() string {
+	if e.permission == "" {
+		return "no permission grants access to this route"
+	}
+	return "missing permission " + string(e.permission)
+}
+
+// permissionsMiddleware resolves the permissions of the authenticated caller
+// from the built-in directory roles of the matching user. Requests without a
+// principal, when authentication is disabled, are not authorized.
+func (s *Server) permissionsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.PrincipalFromContext(r.Context())
+		if principal == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		perms, err := s.callerPermissions(r.Context(), principal)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), permissionsKey{}, perms)))
+	})
+}
+
+// callerPermissions returns the permissions of a principal. Credentials that
+// carry permissions, such as API keys, are limited to them. AdminSubjects
+// hold every permission; any other subject is looked up as a user ID and
+// granted the permissions of its effective roles while the user is active.
+func (s *Server) callerPermissions(ctx context.Context, principal *auth.Principal) (auth.PermissionSet, error) {
+	if principal.Permissions != nil {
+		return principal.Permissions, nil // Scopes of an API key
+	}
+	if slices.Contains(s.AdminSubjects, principal.Subject) {
+		return auth.RolePermissions([]string{auth.RoleDirectoryAdmin}), nil
+	}
+	user, err := s.Store.GetUserByID(ctx, principal.Subject)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return auth.PermissionSet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return auth.PermissionSet{}, nil
+	}
+
+	var roleIDs []string
+	opts := handlers.ListOptions{}
+	for {
+		page, err := s.Store.GetEffectiveRoles(ctx, user.ID, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range page.Items {
+			roleIDs = append(roleIDs, role.ID)
+		}
+		if page.NextCursor == "" {
+			return auth.RolePermissions(roleIDs), nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// authorizeMiddleware rejects requests whose caller lacks the permission of
+// the matched route with 403 Forbidden
+func authorizeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perms, ok := r.Context().Value(permissionsKey{}).(auth.PermissionSet)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		required, known := routePermissions()[routeKey(r.Method, template)]
+		if !known || (required != "" && !perms.Has(required)) {
+			writeError(w, r, &forbiddenError{permission: required})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePermissions returns a forbidden error naming the first of perms the
+// caller lacks. Without authentication every permission is held.
+func requirePermissions(ctx context.Context, perms auth.PermissionSet) error {
+	held, ok := ctx.Value(permissionsKey{}).(auth.PermissionSet)
+	if !ok {
+		return nil
+	}
+	for _, p := range auth.AllPermissions {
+		if perms.Has(p) && !held.Has(p) {
+			return &forbiddenError{permission: p}
+		}
+	}
+	return nil
+}
+
+// requireRoleGrant checks that the caller may grant the roles to a user or a
+// group. A built-in role can only be granted by a caller that already holds
+// every permission it carries, so roles:assign and roles:write cannot be used
+// to become a directory admin. Other roles carry no permissions.
+func requireRoleGrant(ctx context.Context, roleIDs ...string) error {
+	return requirePermissions(ctx, auth.RolePermissions(roleIDs))
+}
+
+// requireGroupGrant checks that the caller may add users or groups to a
+// group. Its members receive the built-in roles granted to the group and to
+// the groups it is nested in, which requireRoleGrant must allow.
+func requireGroupGrant(ctx context.Context, store handlers.DirectoryStore, groupID string) error {
+	for _, role := range auth.BuiltinRoles {
+		if requireRoleGrant(ctx, role.ID) == nil {
+			continue // The caller may grant the role anyway
+		}
+		granted, err := groupGrantsRole(ctx, store, role.ID, groupID)
+		if err != nil {
+			return err
+		}
+		if granted {
+			return requireRoleGrant(ctx, role.ID)
+		}
+	}
+	return nil
+}
+
+// groupGrantsRole reports whether the members of a group receive a role,
+// because the role is granted to the group or to a group it is nested in
+func groupGrantsRole(ctx context.Context, store handlers.DirectoryStore, roleID, groupID string) (bool, error) {
+	opts := handlers.ListOptions{}
+	for {
+		page, err := store.GetRoleGroups(ctx, roleID, opts)
+		if errors.Is(err, handlers.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, linked := range page.Items {
+			if linked == groupID {
+				return true, nil
+			}
+			members, err := store.GetGroupMembers(ctx, linked, true, handlers.ListOptions{Limit: 1})
+			if errors.Is(err, handlers.ErrNotFound) {
+				continue // A soft-deleted group grants nothing
+			}
+			if err != nil {
+				return false, err
+			}
+			if slices.Contains(members.Groups, groupID) {
+				return true, nil
+			}
+		}
+		if page.NextCursor == "" {
+			return false, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// requireUserRelationships checks the roles and groups set by a user write
+// against the routes that manage them: changing roles requires roles:assign
+// and changing groups groups:write, with the built-in role checks of those
+// routes. current is the stored user, or nil for a new one; nil lists in user
+// are left unchanged by the write.
+func requireUserRelationships(ctx context.Context, store handlers.DirectoryStore, current, user *models.User) error {
+	if _, ok := ctx.Value(permissionsKey{}).(auth.PermissionSet); !ok {
+		return nil
+	}
+	if current == nil {
+		current = &models.User{}
+	}
+	if user.Roles != nil {
+		added, changed := addedIDs(roleIDs(current.Roles), roleIDs(user.Roles))
+		if changed {
+			if err := requirePermissions(ctx, auth.PermissionSet{auth.RolesAssign: true}); err != nil {
+				return err
+			}
+		}
+		if err := requireRoleGrant(ctx, added...); err != nil {
+			return err
+		}
+	}
+	if user.GroupIDs != nil {
+		added, changed := addedIDs(current.GroupIDs, user.GroupIDs)
+		if changed {
+			if err := requirePermissions(ctx, auth.PermissionSet{auth.GroupsWrite: true}); err != nil {
+				return err
+			}
+		}
+		for _, groupID := range added {
+			if err := requireGroupGrant(ctx, store, groupID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requireGroupRelationships applies the checks of the membership routes to
+// the members and nested groups a group write adds
+func requireGroupRelationships(ctx context.Context, store handlers.DirectoryStore, current, group *models.Group) error {
+	addedUsers, _ := addedIDs(current.Members, group.Members)
+	addedGroups, _ := addedIDs(current.MemberGroups, group.MemberGroups)
+	if len(addedUsers) == 0 && len(addedGroups) == 0 {
+		return nil
+	}
+	return requireGroupGrant(ctx, store, group.ID)
+}
+
+// requireRoleRelationships applies the checks of the role group routes to the
+// groups a role write adds. current is nil for a new role.
+func requireRoleRelationships(ctx context.Context, current, role *models.Role) error {
+	if current == nil {
+		current = &models.Role{}
+	}
+	if added, _ := addedIDs(current.Groups, role.Groups); len(added) > 0 {
+		return requireRoleGrant(ctx, role.ID)
+	}
+	return nil
+}
+
+// addedIDs returns the IDs in next that are not in current, and whether the
+// two lists hold different IDs
+func addedIDs(current, next []string) ([]string, bool) {
+	var added []string
+	for _, id := range next {
+		if !slices.Contains(current, id) {
+			added = append(added, id)
+		}
+	}
+	changed := len(added) > 0
+	for _, id := range current {
+		if !slices.Contains(next, id) {
+			changed = true
+		}
+	}
+	return added, changed
+}
+
+// roleIDs returns the IDs of roles
+func roleIDs(roles []models.Role) []string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.ID
+	}
+	return ids
+}
+
+// APIKeyAuthenticator returns an Authenticator for the API keys of the
+// server's service accounts
+func (s *Server) APIKeyAuthenticator(trustedProxies []netip.Prefix) *auth.APIKeyAuthenticator {
+	return &auth.APIKeyAuthenticator{
+		Lookup: func(ctx context.Context, id string) (*models.APIKey, error) {
+			key, err := s.Store.GetAPIKey(ctx, id)
+			if errors.Is(err, handlers.ErrNotFound) {
+				return nil, nil
+			}
+			return key, err
+		},
+		TrustedProxies: trustedProxies,
+	}
+}
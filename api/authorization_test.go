@@ -0,0 +1,186 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// Callers cannot grant a built-in role whose permissions they lack, whether
+// directly, by linking a group to it or by adding members to such a group
+func TestBuiltinRoleGrants(t *testing.T) {
+	a := newAuthTestAPI(t)
+	asAdmin := []string{"X-Test-Subject", testAdmin}
+	roleManager := []string{"X-Test-Subject", "UI000001"}
+	groupManager := []string{"X-Test-Subject", "UI000002"}
+
+	for i := 0; i < 3; i++ {
+		a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "User", Email: "user@example.com"}, asAdmin...)
+	}
+	for _, name := range []string{"Admins", "Nested", "Managers", "Team"} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: name}, asAdmin...)
+	}
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: auth.RoleRoleManager}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/users/UI000002/roles", AssignRoleRequest{RoleID: auth.RoleGroupManager}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/roles/"+auth.RoleDirectoryAdmin+"/groups", AddGroupRequest{GroupID: "GRP001"}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP001/groups", AddGroupRequest{GroupID: "GRP002"}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/roles/"+auth.RoleRoleManager+"/groups", AddGroupRequest{GroupID: "GRP003"}, asAdmin...)
+
+	tests := []struct {
+		name    string
+		caller  []string
+		method  string
+		path    string
+		body    interface{}
+		status  int
+		missing auth.Permission
+	}{
+		// A role manager can assign roles, but only those it could have granted itself
+		{"assign admin to self", roleManager, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: auth.RoleDirectoryAdmin}, http.StatusForbidden, auth.UsersWrite},
+		{"assign reader", roleManager, "POST", "/api/v1/users/UI000003/roles", AssignRoleRequest{RoleID: auth.RoleDirectoryReader}, http.StatusForbidden, auth.AttributesRead},
+		{"assign admin in bulk", roleManager, "POST", "/api/v1/users/UI000003/roles/bulk", AssignRolesRequest{RoleIDs: []string{"ROLE001", auth.RoleDirectoryAdmin}}, http.StatusForbidden, auth.UsersWrite},
+		{"assign admin to users", roleManager, "POST", "/api/v1/roles/" + auth.RoleDirectoryAdmin + "/users/bulk", BulkAssignRequest{UserIDs: []string{"UI000001"}}, http.StatusForbidden, auth.UsersWrite},
+		{"link group to admin", roleManager, "POST", "/api/v1/roles/" + auth.RoleDirectoryAdmin + "/groups", AddGroupRequest{GroupID: "GRP004"}, http.StatusForbidden, auth.UsersWrite},
+		{"link groups to admin", roleManager, "POST", "/api/v1/roles/" + auth.RoleDirectoryAdmin + "/groups/bulk", AddGroupsRequest{GroupIDs: []string{"GRP004"}}, http.StatusForbidden, auth.UsersWrite},
+		{"assign own role", roleManager, "POST", "/api/v1/users/UI000003/roles", AssignRoleRequest{RoleID: auth.RoleRoleManager}, http.StatusNoContent, ""},
+		{"assign custom role", roleManager, "POST", "/api/v1/users/UI000003/roles/bulk", AssignRolesRequest{RoleIDs: []string{"ROLE001"}}, http.StatusOK, ""},
+		{"link group to custom role", roleManager, "POST", "/api/v1/roles/ROLE001/groups", AddGroupRequest{GroupID: "GRP004"}, http.StatusNoContent, ""},
+
+		// A group manager can manage members, but not of groups that grant
+		// roles beyond its own permissions, directly or through nesting
+		{"join admin group", groupManager, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: "UI000002"}, http.StatusForbidden, auth.UsersWrite},
+		{"join admin group in bulk", groupManager, "POST", "/api/v1/groups/GRP001/users/bulk", AddUsersRequest{UserIDs: []string{"UI000002"}}, http.StatusForbidden, auth.UsersWrite},
+		{"join nested admin group", groupManager, "POST", "/api/v1/groups/GRP002/users", AddUserRequest{UserID: "UI000002"}, http.StatusForbidden, auth.UsersWrite},
+		{"nest group in admin group", groupManager, "POST", "/api/v1/groups/GRP001/groups", AddGroupRequest{GroupID: "GRP004"}, http.StatusForbidden, auth.UsersWrite},
+		{"nest groups in admin group", groupManager, "POST", "/api/v1/groups/GRP002/groups/bulk", AddGroupsRequest{GroupIDs: []string{"GRP004"}}, http.StatusForbidden, auth.UsersWrite},
+		{"join role manager group", groupManager, "POST", "/api/v1/groups/GRP003/users", AddUserRequest{UserID: "UI000002"}, http.StatusForbidden, auth.RolesRead},
+		{"join plain group", groupManager, "POST", "/api/v1/groups/GRP004/users", AddUserRequest{UserID: "UI000002"}, http.StatusNoContent, ""},
+
+		// Holding every permission allows any grant
+		{"admin assigns admin", asAdmin, "POST", "/api/v1/users/UI000003/roles", AssignRoleRequest{RoleID: auth.RoleDirectoryAdmin}, http.StatusNoContent, ""},
+		{"admin adds admin group member", asAdmin, "POST", "/api/v1/groups/GRP002/users", AddUserRequest{UserID: "UI000003"}, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.expect(tt.status, tt.method, tt.path, tt.body, tt.caller...)
+			if tt.status != http.StatusForbidden {
+				return
+			}
+			var p struct {
+				Code       string          `json:"code"`
+				Permission auth.Permission `json:"permission"`
+			}
+			decode(t, rec, &p)
+			if p.Code != "forbidden" || p.Permission != tt.missing {
+				t.Errorf("problem = %+v, want forbidden for %s", p, tt.missing)
+			}
+		})
+	}
+
+	// The refused grants left the role manager without admin permissions
+	a.expect(http.StatusForbidden, "POST", "/api/v1/users", models.User{Name: "User", Email: "user@example.com"}, roleManager...)
+}
+
+// Deleting a built-in role would revoke it from every holder, so no caller
+// can, while custom roles stay deletable with roles:write
+func TestBuiltinRoleDelete(t *testing.T) {
+	a := newAuthTestAPI(t)
+	asAdmin := []string{"X-Test-Subject", testAdmin}
+	roleManager := []string{"X-Test-Subject", "UI000001"}
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "User", Email: "user@example.com"}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: auth.RoleRoleManager}, asAdmin...)
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"}, asAdmin...)
+
+	for _, role := range auth.BuiltinRoles {
+		for _, caller := range [][]string{roleManager, asAdmin} {
+			rec := a.expect(http.StatusForbidden, "DELETE", "/api/v1/roles/"+role.ID, nil, caller...)
+			if code := problemCode(t, rec); code != "builtin_role" {
+				t.Errorf("DELETE %s as %s: code = %q, want builtin_role", role.ID, caller[1], code)
+			}
+		}
+		a.expect(http.StatusOK, "GET", "/api/v1/roles/"+role.ID, nil, asAdmin...)
+	}
+	// The role manager kept its permissions
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/roles/ROLE001", nil, roleManager...)
+}
+
+// Entity writes that set relationships need the permissions of the routes
+// that manage those relationships
+func TestRelationshipFieldWrites(t *testing.T) {
+	a := newAuthTestAPI(t)
+	asAdmin := []string{"X-Test-Subject", testAdmin}
+	as := func(scopes string) []string {
+		return []string{"X-Test-Subject", "key", "X-Test-Scopes", scopes}
+	}
+	mergePatch := func(scopes string) []string {
+		return append(as(scopes), "Content-Type", "application/merge-patch+json")
+	}
+
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"}, asAdmin...)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Admins"}, asAdmin...)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Team"}, asAdmin...)
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"}, asAdmin...)
+	a.expect(http.StatusNoContent, "POST", "/api/v1/roles/"+auth.RoleDirectoryAdmin+"/groups", AddGroupRequest{GroupID: "GRP001"}, asAdmin...)
+
+	var user models.User
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil, asAdmin...), &user)
+	user.Name = "Ada King"
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		headers []string
+		status  int
+		missing auth.Permission
+	}{
+		{"create user with roles", "POST", "/api/v1/users", models.User{Name: "Bob", Email: "bob@example.com", Roles: []models.Role{{ID: "ROLE001"}}}, as("users"), http.StatusForbidden, auth.RolesAssign},
+		{"create user with groups", "POST", "/api/v1/users", models.User{Name: "Bob", Email: "bob@example.com", GroupIDs: []string{"GRP002"}}, as("users"), http.StatusForbidden, auth.GroupsWrite},
+		{"put user roles", "PUT", "/api/v1/users/UI000001", models.User{Name: "Ada", Email: "ada@example.com", Roles: []models.Role{{ID: "ROLE001"}}}, as("users"), http.StatusForbidden, auth.RolesAssign},
+		{"patch user groups", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"group_ids":["GRP002"]}`), mergePatch("users"), http.StatusForbidden, auth.GroupsWrite},
+		{"put user unchanged relationships", "PUT", "/api/v1/users/UI000001", user, as("users"), http.StatusOK, ""},
+
+		{"patch user admin role", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"roles":[{"id":"directory-admin"}]}`), mergePatch("users roles:assign"), http.StatusForbidden, auth.GroupsRead},
+		{"patch user custom role", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"roles":[{"id":"ROLE001"}]}`), mergePatch("users roles:assign"), http.StatusOK, ""},
+		{"patch user admin group", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"group_ids":["GRP001"]}`), mergePatch("users groups:write"), http.StatusForbidden, auth.GroupsRead},
+		{"patch user plain group", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"group_ids":["GRP002"]}`), mergePatch("users groups:write"), http.StatusOK, ""},
+
+		{"put admin group members", "PUT", "/api/v1/groups/GRP001", models.Group{Name: "Admins", Members: []string{"UI000001"}}, as("groups"), http.StatusForbidden, auth.UsersRead},
+		{"patch admin group nested groups", "PATCH", "/api/v1/groups/GRP001", strings.NewReader(`{"member_groups":["GRP002"]}`), mergePatch("groups"), http.StatusForbidden, auth.UsersRead},
+		{"patch plain group members", "PATCH", "/api/v1/groups/GRP002", strings.NewReader(`{"members":["UI000001"]}`), mergePatch("groups"), http.StatusOK, ""},
+
+		{"patch admin role groups", "PATCH", "/api/v1/roles/" + auth.RoleDirectoryAdmin, strings.NewReader(`{"groups":["GRP001","GRP002"]}`), mergePatch("roles"), http.StatusForbidden, auth.UsersRead},
+		{"put admin role groups", "PUT", "/api/v1/roles/" + auth.RoleDirectoryAdmin, models.Role{Name: auth.RoleDirectoryAdmin, Groups: []string{"GRP002"}}, as("roles"), http.StatusForbidden, auth.UsersRead},
+		{"patch admin role description", "PATCH", "/api/v1/roles/" + auth.RoleDirectoryAdmin, strings.NewReader(`{"description":"Everything"}`), mergePatch("roles"), http.StatusOK, ""},
+		{"patch custom role groups", "PATCH", "/api/v1/roles/ROLE001", strings.NewReader(`{"groups":["GRP002"]}`), mergePatch("roles"), http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.expect(tt.status, tt.method, tt.path, tt.body, tt.headers...)
+			if tt.status != http.StatusForbidden {
+				return
+			}
+			var p struct {
+				Code       string          `json:"code"`
+				Permission auth.Permission `json:"permission"`
+			}
+			decode(t, rec, &p)
+			if p.Code != "forbidden" || p.Permission != tt.missing {
+				t.Errorf("problem = %+v, want forbidden for %s", p, tt.missing)
+			}
+		})
+	}
+
+	// The refused writes changed nothing
+	var groups handlers.Page[string]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/roles/"+auth.RoleDirectoryAdmin+"/groups", nil, asAdmin...), &groups)
+	if len(groups.Items) != 1 || groups.Items[0] != "GRP001" {
+		t.Errorf("admin role groups = %v", groups.Items)
+	}
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/patch"
+)
+
+// maxBatchOperations bounds the number of operations in one batch
+const maxBatchOperations = 100
+
+var (
+	// batchRefPattern restricts the names that operations are referenced by
+	batchRefPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+	// batchReference matches a reference to an earlier result, e.g. ${eng.id}
+	batchReference = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_-]*)\.([A-Za-z0-9_]+)\}`)
+)
+
+// errBatchFailed aborts the transaction of a batch whose operation failed
+var errBatchFailed = errors.New("batch operation failed")
+
+type BatchAPI struct {
+	Store handlers.DirectoryStore
+}
+
+// BatchOperation is one request of a batch. Path is relative to /api/v1, e.g.
+// /groups or /roles/${admins.id}/groups.
+type BatchOperation struct {
+	Ref     string          `json:"ref,omitempty"` // Name for referencing the result in later operations
+	Method  string          `json:"method"`
+	Path    string          `json:"path"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	IfMatch string          `json:"if_match,omitempty"` // ETag for conditional PUT, PATCH and DELETE
+}
+
+// BatchRequest is an ordered list of operations applied in one transaction
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperationResult is the response to one operation of a batch
+type BatchOperationResult struct {
+	Ref        string          `json:"ref,omitempty"`
+	Status     string          `json:"status"`                // succeeded, failed, rolled_back or skipped
+	StatusCode int             `json:"status_code,omitempty"` // HTTP status of the operation, absent when skipped
+	ETag       string          `json:"etag,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"` // Response body, a problem object for a failed operation
+}
+
+// BatchResult reports the outcome of every operation of a batch
+type BatchResult struct {
+	RolledBack bool                   `json:"rolled_back"`
+	Results    []BatchOperationResult `json:"results"`
+}
+
+// batchResponse buffers the response to one operation
+type batchResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchResponse) Header() http.Header { return r.header }
+
+func (r *batchResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *batchResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+// batchRouter routes the operations of a batch to the user, group and role
+// endpoints backed by store
+func batchRouter(store handlers.DirectoryStore) *mux.Router {
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	(&UserAPI{Store: store}).RegisterUserRoutes(apiRouter)
+	(&GroupAPI{Store: store}).RegisterGroupRoutes(apiRouter)
+	(&RoleAPI{Store: store}).RegisterRoleRoutes(apiRouter)
+	apiRouter.Use(authorizeMiddleware) // Each operation needs the permission of its route
+	return router
+}
+
+// validate checks the shape of a batch before any operation runs
+func (req *BatchRequest) validate() error {
+	if len(req.Operations) == 0 {
+		return badRequest("invalid_request", "No operations provided")
+	}
+	if len(req.Operations) > maxBatchOperations {
+		return badRequest("invalid_request", "A batch has at most %d operations", maxBatchOperations)
+	}
+	refs := map[string]bool{}
+	for i, op := range req.Operations {
+		switch op.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return badRequest("invalid_request", "operation %d: unsupported method %q", i, op.Method)
+		}
+		if p, _, _ := strings.Cut(op.Path, "?"); !strings.HasPrefix(p, "/") || path.Clean(p) != p {
+			return badRequest("invalid_request", "operation %d: path must be an absolute path without . or .. segments", i)
+		}
+		for _, match := range batchReference.FindAllStringSubmatch(op.Path+string(op.Body), -1) {
+			if !refs[match[1]] {
+				return badRequest("invalid_request", "operation %d: %s does not name an earlier operation", i, match[0])
+			}
+		}
+		if op.Ref != "" {
+			if !batchRefPattern.MatchString(op.Ref) {
+				return badRequest("invalid_request", "operation %d: invalid ref %q", i, op.Ref)
+			}
+			if refs[op.Ref] {
+				return badRequest("invalid_request", "operation %d: duplicate ref %q", i, op.Ref)
+			}
+			refs[op.Ref] = true
+		}
+	}
+	return nil
+}
+
+// batchRefs holds the decoded response bodies of the operations with a ref
+type batchRefs map[string]map[string]interface{}
+
+// resolve returns the value of a ${ref.field} reference
+func (refs batchRefs) resolve(ref, field string) (interface{}, error) {
+	value, ok := refs[ref][field]
+	if !ok || value == nil {
+		return nil, badRequest("invalid_reference", "${%s.%s} is not a field of the result of %s", ref, field, ref)
+	}
+	return value, nil
+}
+
+// substitute replaces the references in s. A string that is a single
+// reference takes the type of the referenced value; references within longer
+// strings are formatted as text.
+func (refs batchRefs) substitute(s string) (interface{}, error) {
+	if match := batchReference.FindStringSubmatch(s); match != nil && match[0] == s {
+		return refs.resolve(match[1], match[2])
+	}
+	return refs.replace(s, func(text string) string { return text })
+}
+
+// replace formats the references in s as text, passed through escape
+func (refs batchRefs) replace(s string, escape func(string) string) (string, error) {
+	var err error
+	out := batchReference.ReplaceAllStringFunc(s, func(reference string) string {
+		match := batchReference.FindStringSubmatch(reference)
+		value, resolveErr := refs.resolve(match[1], match[2])
+		if resolveErr != nil {
+			err = resolveErr
+			return reference
+		}
+		return escape(fmt.Sprint(value))
+	})
+	return out, err
+}
+
+// substituteJSON replaces the references in the string values of a JSON document
+func (refs batchRefs) substituteJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return refs.substitute(v)
+	case []interface{}:
+		for i := range v {
+			resolved, err := refs.substituteJSON(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	case map[string]interface{}:
+		for key := range v {
+			resolved, err := refs.substituteJSON(v[key])
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	}
+	return value, nil
+}
+
+// newRequest builds the HTTP request of an operation with its references resolved
+func (refs batchRefs) newRequest(r *http.Request, op BatchOperation) (*http.Request, error) {
+	// A referenced value stays within its path segment or query parameter
+	opPath, query, hasQuery := strings.Cut(op.Path, "?")
+	target, err := refs.replace(opPath, url.PathEscape)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean(target) != target {
+		return nil, badRequest("invalid_reference", "operation path %q resolves to %q, which has . or .. segments", op.Path, target)
+	}
+	if hasQuery {
+		if query, err = refs.replace(query, url.QueryEscape); err != nil {
+			return nil, err
+		}
+		target += "?" + query
+	}
+	var body []byte
+	if len(op.Body) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal(op.Body, &doc); err != nil {
+			return nil, errInvalidJSON
+		}
+		if doc, err = refs.substituteJSON(doc); err != nil {
+			return nil, err
+		}
+		if body, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), op.Method, "/api/v1"+target, bytes.NewReader(body))
+	if err != nil {
+		return nil, badRequest("invalid_request", "invalid path %q", op.Path)
+	}
+	contentType := "application/json"
+	if op.Method == http.MethodPatch {
+		// A JSON Patch is an array of operations; any other document is a merge patch
+		contentType = patch.MergePatchType
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			contentType = patch.JSONPatchType
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	if op.IfMatch != "" {
+		req.Header.Set("If-Match", op.IfMatch)
+	}
+	return req, nil
+}
+
+// RunBatch handles POST /api/v1/batch. The operations run in order in one
+// transaction, which is rolled back as soon as an operation fails; the
+// remaining operations are skipped.
+//
+// Responses: 200 when every operation succeeded and 409 Conflict when the
+// batch was rolled back, both with a BatchResult.
+func (ba *BatchAPI) RunBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := &BatchResult{Results: make([]BatchOperationResult, len(req.Operations))}
+	for i, op := range req.Operations {
+		result.Results[i] = BatchOperationResult{Ref: op.Ref, Status: handlers.BulkSkipped}
+	}
+
+	err := ba.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		router := batchRouter(tx)
+		refs := batchRefs{}
+		for i, op := range req.Operations {
+			res := &batchResponse{header: http.Header{}}
+			opReq, err := refs.newRequest(r, op)
+			var match mux.RouteMatch
+			switch {
+			case err != nil:
+				writeError(res, r, err)
+			case !router.Match(opReq, &match) || match.MatchErr != nil:
+				writeError(res, opReq, badRequest("invalid_operation", "%s %s is not a user, group or role operation", op.Method, opReq.URL.Path))
+			default:
+				router.ServeHTTP(res, opReq)
+			}
+
+			out := &result.Results[i]
+			out.StatusCode = res.status
+			out.ETag = res.header.Get("ETag")
+			if res.body.Len() > 0 {
+				out.Body = json.RawMessage(bytes.TrimSpace(res.body.Bytes()))
+			}
+			if res.status >= http.StatusBadRequest {
+				out.Status = handlers.BulkFailed
+				return errBatchFailed
+			}
+			out.Status = handlers.BulkSucceeded
+			if op.Ref != "" {
+				var fields map[string]interface{}
+				json.Unmarshal(res.body.Bytes(), &fields)
+				refs[op.Ref] = fields
+			}
+		}
+		return nil
+	})
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, errBatchFailed):
+		status = http.StatusConflict
+		result.RolledBack = true
+		for i := range result.Results {
+			if result.Results[i].Status == handlers.BulkSucceeded {
+				result.Results[i].Status = handlers.BulkRolledBack
+			}
+		}
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// RegisterBatchRoutes registers the batch route
+func (ba *BatchAPI) RegisterBatchRoutes(router *mux.Router) {
+	router.HandleFunc("/batch", ba.RunBatch).Methods("POST")
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// op builds a batch operation with a JSON body
+func op(ref, method, path, body string) BatchOperation {
+	operation := BatchOperation{Ref: ref, Method: method, Path: path}
+	if body != "" {
+		operation.Body = json.RawMessage(body)
+	}
+	return operation
+}
+
+// runBatch runs the operations, expecting the status, and returns the result
+func (a *testAPI) runBatch(status int, ops []BatchOperation, headers ...string) BatchResult {
+	a.t.Helper()
+	var result BatchResult
+	decode(a.t, a.expect(status, "POST", "/api/v1/batch", BatchRequest{Operations: ops}, headers...), &result)
+	return result
+}
+
+// statuses returns the status and status code of each result
+func statuses(result BatchResult) []string {
+	var out []string
+	for _, r := range result.Results {
+		out = append(out, fmt.Sprintf("%s %d", r.Status, r.StatusCode))
+	}
+	return out
+}
+
+func TestBatch(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(1)
+
+	result := a.runBatch(http.StatusOK, []BatchOperation{
+		op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+		op("dev", "POST", "/roles", `{"name":"developer"}`),
+		op("", "POST", "/roles/${dev.id}/groups", `{"group_id":"${eng.id}"}`),
+		op("", "POST", "/groups/${eng.id}/users", `{"user_id":"UI000001"}`),
+		// References within longer strings are replaced by their text
+		op("", "PATCH", "/groups/${eng.id}", `{"description":"${eng.name} at ${dev.name}"}`),
+	})
+	want := []string{"succeeded 201", "succeeded 201", "succeeded 204", "succeeded 204", "succeeded 200"}
+	if got := statuses(result); result.RolledBack || !reflect.DeepEqual(got, want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	if result.Results[0].Ref != "eng" || result.Results[4].ETag != `"2"` {
+		t.Errorf("results = %+v", result.Results)
+	}
+
+	var group models.Group
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001", nil), &group)
+	if group.Description != "Engineering at developer" || !reflect.DeepEqual(group.Members, []string{"UI000001"}) {
+		t.Errorf("group = %+v", group)
+	}
+	var role models.Role
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/roles/ROLE001", nil), &role)
+	if !reflect.DeepEqual(role.Groups, []string{"GRP001"}) {
+		t.Errorf("role = %+v", role)
+	}
+}
+
+// A failed operation rolls back the earlier ones and skips the rest
+func TestBatchRollback(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(1)
+
+	result := a.runBatch(http.StatusConflict, []BatchOperation{
+		op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+		op("", "POST", "/groups/${eng.id}/users", `{"user_id":"UI000001"}`),
+		op("", "PUT", "/users/UI000001", `{"name":"Ada","email":"ada@example.com"}`),
+		op("", "POST", "/groups/${eng.id}/users", `{"user_id":"UI000999"}`),
+		op("", "DELETE", "/users/UI000001", ""),
+	})
+	want := []string{"rolled_back 201", "rolled_back 204", "rolled_back 200", "failed 404", "skipped 0"}
+	if got := statuses(result); !result.RolledBack || !reflect.DeepEqual(got, want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	var problem struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(result.Results[3].Body, &problem); err != nil || problem.Code != "user_not_found" {
+		t.Errorf("failed operation body = %s", result.Results[3].Body)
+	}
+
+	a.expect(http.StatusNotFound, "GET", "/api/v1/groups/GRP001", nil)
+	var user models.User
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil), &user)
+	if user.Name != "User" || len(user.GroupIDs) != 0 {
+		t.Errorf("user after rollback = %+v", user)
+	}
+	// Nothing of the batch was audited
+	var entries handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit?entity_type=group", nil), &entries)
+	if len(entries.Items) != 0 {
+		t.Errorf("audit entries of the rolled back batch = %+v", entries.Items)
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	a := newTestAPI(t)
+	create := op("", "POST", "/groups", `{"name":"Engineering"}`)
+	tooMany := make([]BatchOperation, maxBatchOperations+1)
+	for i := range tooMany {
+		tooMany[i] = create
+	}
+
+	tests := []struct {
+		name string
+		ops  []BatchOperation
+	}{
+		{"no operations", nil},
+		{"too many operations", tooMany},
+		{"unsupported method", []BatchOperation{op("", "OPTIONS", "/groups", "")}},
+		{"relative path", []BatchOperation{op("", "GET", "groups", "")}},
+		{"dot segments", []BatchOperation{op("", "GET", "/groups/../users", "")}},
+		{"invalid ref", []BatchOperation{op("1st", "POST", "/groups", `{"name":"Engineering"}`)}},
+		{"duplicate ref", []BatchOperation{op("eng", "POST", "/groups", `{"name":"A"}`), op("eng", "POST", "/groups", `{"name":"B"}`)}},
+		{"forward ref", []BatchOperation{
+			op("", "POST", "/groups/${eng.id}/users", `{"user_id":"UI000001"}`),
+			op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+		}},
+		{"forward ref in body", []BatchOperation{
+			op("", "POST", "/groups", `{"name":"${eng.name}"}`),
+			op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+		}},
+		{"self ref", []BatchOperation{op("eng", "PATCH", "/groups/${eng.id}", `{"name":"x"}`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.expect(http.StatusBadRequest, "POST", "/api/v1/batch", BatchRequest{Operations: tt.ops})
+			if code := problemCode(t, rec); code != "invalid_request" {
+				t.Errorf("code = %q, want invalid_request", code)
+			}
+		})
+	}
+	a.expect(http.StatusNotFound, "GET", "/api/v1/groups/GRP001", nil)
+
+	// The cap itself is allowed
+	a.runBatch(http.StatusOK, tooMany[:maxBatchOperations])
+}
+
+// Operations that cannot run fail the batch with a problem of their own
+func TestBatchOperationErrors(t *testing.T) {
+	a := newTestAPI(t)
+	tests := []struct {
+		name string
+		op   BatchOperation
+		code string
+	}{
+		{"not an entity route", op("", "GET", "/audit", ""), "invalid_operation"},
+		{"unknown route", op("", "GET", "/nowhere", ""), "invalid_operation"},
+		{"method not allowed", op("", "DELETE", "/groups", ""), "invalid_operation"},
+		{"unknown field", op("", "PATCH", "/groups/${eng.nope}", `{"name":"x"}`), "invalid_reference"},
+		{"null field", op("", "PATCH", "/groups/GRP001", `{"description":"${eng.deleted_at}"}`), "invalid_reference"},
+		// A referenced value cannot climb out of its path segment
+		{"dot segment value", op("", "GET", "/groups/${dots.name}", ""), "invalid_reference"},
+		{"entity error", op("", "POST", "/groups", `{"name":""}`), "validation_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := a.runBatch(http.StatusConflict, []BatchOperation{
+				op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+				op("dots", "POST", "/groups", `{"name":".."}`),
+				tt.op,
+			})
+			last := result.Results[2]
+			var problem struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(last.Body, &problem); err != nil || last.Status != handlers.BulkFailed || problem.Code != tt.code {
+				t.Errorf("result = %s %d %s, want code %s", last.Status, last.StatusCode, last.Body, tt.code)
+			}
+		})
+	}
+}
+
+// Referenced values are escaped to stay within their path segment or query
+// parameter
+func TestBatchReferenceEscaping(t *testing.T) {
+	a := newTestAPI(t)
+	result := a.runBatch(http.StatusOK, []BatchOperation{
+		op("rd", "POST", "/groups", `{"name":"R&D Ops?x=1 #2"}`),
+		op("", "POST", "/groups", `{"name":"R&D"}`),
+		op("list", "GET", `/groups?filter=name+eq+"${rd.name}"&limit=5`, ""),
+	})
+	var page handlers.Page[models.Group]
+	if err := json.Unmarshal(result.Results[2].Body, &page); err != nil || len(page.Items) != 1 || page.Items[0].ID != "GRP001" {
+		t.Errorf("filtered list = %s", result.Results[2].Body)
+	}
+
+	result = a.runBatch(http.StatusConflict, []BatchOperation{
+		op("rd", "GET", "/groups/GRP001", ""),
+		op("", "GET", "/groups/${rd.name}", ""),
+	})
+	var problem struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(result.Results[1].Body, &problem); err != nil || problem.Code != "group_not_found" || !strings.HasSuffix(problem.Detail, "R&D Ops?x=1 #2") {
+		t.Errorf("lookup by name = %s", result.Results[1].Body)
+	}
+}
+
+func TestBatchRefSubstitution(t *testing.T) {
+	refs := batchRefs{"eng": {"id": "GRP001", "version": float64(3), "active": true, "members": []interface{}{"UI000001"}}}
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		// A whole-string reference keeps the type of the value
+		{"${eng.id}", "GRP001"},
+		{"${eng.version}", float64(3)},
+		{"${eng.active}", true},
+		{"${eng.members}", []interface{}{"UI000001"}},
+		// Within a longer string it is formatted as text
+		{"v${eng.version}", "v3"},
+		{"${eng.id}/${eng.version}", "GRP001/3"},
+		{"${eng.id} ", "GRP001 "},
+		{"no reference", "no reference"},
+		{"${eng}", "${eng}"},
+	}
+	for _, tt := range tests {
+		got, err := refs.substitute(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("substitute(%q) = %#v, %v, want %#v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"${eng.name}", "x${other.id}"} {
+		if got, err := refs.substitute(in); err == nil {
+			t.Errorf("substitute(%q) = %#v, want an error", in, got)
+		}
+	}
+
+	doc, err := refs.substituteJSON(map[string]interface{}{
+		"group_ids": []interface{}{"${eng.id}", "GRP002"},
+		"nested":    map[string]interface{}{"version": "${eng.version}", "n": float64(1)},
+	})
+	want := map[string]interface{}{
+		"group_ids": []interface{}{"GRP001", "GRP002"},
+		"nested":    map[string]interface{}{"version": float64(3), "n": float64(1)},
+	}
+	if err != nil || !reflect.DeepEqual(doc, want) {
+		t.Errorf("substituteJSON() = %#v, %v", doc, err)
+	}
+}
+
+// A PATCH body that is an array is a JSON Patch; any other body is a merge patch
+func TestBatchPatchContentType(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering", Description: "Builds things"})
+
+	result := a.runBatch(http.StatusOK, []BatchOperation{
+		op("", "PATCH", "/groups/GRP001", ` [{"op":"test","path":"/description","value":"Builds things"},{"op":"replace","path":"/name","value":"Platform"}]`),
+		op("", "PATCH", "/groups/GRP001", `{"description":null}`),
+	})
+	if result.RolledBack {
+		t.Fatalf("results = %v", statuses(result))
+	}
+	var group models.Group
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001", nil), &group)
+	if group.Name != "Platform" || group.Description != "" || group.Version != 3 {
+		t.Errorf("patched group = %+v", group)
+	}
+
+	// A failing JSON Patch test fails the batch
+	result = a.runBatch(http.StatusConflict, []BatchOperation{
+		op("", "PATCH", "/groups/GRP001", `[{"op":"test","path":"/name","value":"Engineering"}]`),
+	})
+	if result.Results[0].Status != handlers.BulkFailed {
+		t.Errorf("results = %v", statuses(result))
+	}
+}
+
+// Each operation needs the permission of its route; the batch route itself
+// is open to any authenticated caller
+func TestBatchAuthorization(t *testing.T) {
+	a := newAuthTestAPI(t)
+	groupsOnly := []string{"X-Test-Subject", "key", "X-Test-Scopes", "groups"}
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"}, "X-Test-Subject", testAdmin)
+
+	result := a.runBatch(http.StatusConflict, []BatchOperation{
+		op("eng", "POST", "/groups", `{"name":"Engineering"}`),
+		op("", "POST", "/groups/${eng.id}/users", `{"user_id":"UI000001"}`),
+		op("", "PATCH", "/users/UI000001", `{"name":"Ada King"}`),
+		op("", "DELETE", "/groups/${eng.id}", ""),
+	}, groupsOnly...)
+	want := []string{"rolled_back 201", "rolled_back 204", "failed 403", "skipped 0"}
+	if got := statuses(result); !reflect.DeepEqual(got, want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	var problem struct {
+		Code       string          `json:"code"`
+		Permission auth.Permission `json:"permission"`
+	}
+	if err := json.Unmarshal(result.Results[2].Body, &problem); err != nil || problem.Code != "forbidden" || problem.Permission != auth.UsersWrite {
+		t.Errorf("forbidden operation body = %s", result.Results[2].Body)
+	}
+	a.expect(http.StatusNotFound, "GET", "/api/v1/groups/GRP001", nil, groupsOnly...)
+
+	a.runBatch(http.StatusOK, []BatchOperation{op("", "POST", "/groups", `{"name":"Engineering"}`)}, groupsOnly...)
+	a.expect(http.StatusUnauthorized, "POST", "/api/v1/batch", BatchRequest{Operations: []BatchOperation{op("", "GET", "/groups", "")}})
+}
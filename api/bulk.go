@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+// runBulk applies op to each ID and writes the per-item results. With
+// ?atomic=true the whole batch is applied in one transaction.
+//
+// Responses: 200 when no item failed, 207 Multi-Status when some items failed
+// in the default mode, and 409 Conflict when an atomic batch was rolled back.
+func runBulk(w http.ResponseWriter, r *http.Request, store handlers.DirectoryStore, ids []string, op handlers.BulkOp) {
+	atomic, err := boolParam(r, "atomic")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(ids) == 0 {
+		writeError(w, r, badRequest("invalid_request", "No IDs provided"))
+		return
+	}
+
+	result, err := handlers.RunBulk(r.Context(), store, ids, atomic, op)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case result.RolledBack:
+		status = http.StatusConflict
+	case result.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"github.com/lotusatx/lotus-directory-engine-backend/patch"
+)
+
+// problemContentType is the media type of error responses (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 problem details object with a machine-readable code
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+
+	Errors     []models.FieldError `json:"errors,omitempty"`     // Fields that failed validation
+	Permission auth.Permission     `json:"permission,omitempty"` // Permission the caller lacks
+}
+
+// requestError is a malformed parameter, header or body of a request
+type requestError struct {
+	code    string
+	message string
+}
+
+func (e *requestError) Error() string { return e.message }
+
+// badRequest returns a request error with the given code and formatted message
+func badRequest(code string, format string, args ...interface{}) error {
+	return &requestError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errInvalidJSON is returned for a request body that is not valid JSON for the endpoint
+var errInvalidJSON = badRequest("invalid_json", "Invalid JSON format")
+
+// newProblem builds the problem details of a response to r
+func newProblem(r *http.Request, status int, code, detail string) problem {
+	return problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+}
+
+// writeProblem writes p as an application/problem+json response
+func writeProblem(w http.ResponseWriter, p problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError reports err as a problem response. Request and domain errors are
+// mapped to their status and code; any other error is logged and reported as
+// 500 Internal Server Error without its message, which may contain database details.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := errorStatus(err)
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		detail = "The server failed to process the request"
+	}
+	if status == http.StatusUnsupportedMediaType {
+		w.Header().Set("Accept-Patch", acceptPatch)
+	}
+	p := newProblem(r, status, code, detail)
+	p.Errors = handlers.ErrorFields(err)
+	var forbidden *forbiddenError
+	if errors.As(err, &forbidden) {
+		p.Permission = forbidden.permission
+	}
+	writeProblem(w, p)
+}
+
+// errorStatus returns the HTTP status and error code of err
+func errorStatus(err error) (int, string) {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		return http.StatusBadRequest, reqErr.code
+	case errors.Is(err, patch.ErrInvalid):
+		return http.StatusBadRequest, "invalid_patch"
+	case errors.Is(err, patch.ErrTestFailed):
+		return http.StatusConflict, "patch_test_failed"
+	case errors.Is(err, errUnsupportedPatchType):
+		return http.StatusUnsupportedMediaType, "unsupported_media_type"
+	case errors.Is(err, patch.ErrConflict):
+		return http.StatusConflict, "patch_conflict"
+	case errors.Is(err, errUnprocessablePatch):
+		return http.StatusUnprocessableEntity, "invalid_patch_result"
+	case errors.Is(err, auth.ErrMissingCredentials):
+		return http.StatusUnauthorized, "unauthenticated"
+	case errors.Is(err, auth.ErrInvalidToken):
+		return http.StatusUnauthorized, "invalid_token"
+	case errors.As(err, new(*forbiddenError)):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, errBuiltinRoleDeleted):
+		return http.StatusForbidden, "builtin_role"
+	case errors.Is(err, errIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity, "idempotency_key_reused"
+	case errors.Is(err, errIdempotencyKeyInProgress):
+		return http.StatusConflict, "idempotency_key_in_progress"
+	case errors.Is(err, errRequestTooLarge):
+		return http.StatusRequestEntityTooLarge, "request_too_large"
+	case errors.Is(err, errOIDCDisabled):
+		return http.StatusNotFound, "oidc_disabled"
+	}
+
+	code := handlers.ErrorCode(err)
+	switch {
+	case errors.Is(err, handlers.ErrNotFound):
+		return http.StatusNotFound, code
+	case errors.Is(err, handlers.ErrAlreadyExists), errors.Is(err, handlers.ErrConflict):
+		return http.StatusConflict, code
+	case errors.Is(err, handlers.ErrValidation):
+		return http.StatusUnprocessableEntity, code
+	case errors.Is(err, handlers.ErrInvalidRequest):
+		return http.StatusBadRequest, code
+	case errors.Is(err, handlers.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, code
+	}
+	return http.StatusInternalServerError, "internal_error"
+}
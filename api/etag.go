@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setETag sets the ETag header for an entity at the given version
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// ifMatchVersion returns the version named by the If-Match header, or 0 when
+// the header is absent or "*" so that the write is unconditional
+func ifMatchVersion(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	value = strings.TrimPrefix(value, "W/")
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return 0, badRequest("invalid_header", "invalid If-Match header: %s", value)
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, badRequest("invalid_header", "invalid If-Match header: %s", value)
+	}
+	return version, nil
+}
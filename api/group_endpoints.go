@@ -6,11 +6,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
-	"gorm.io/gorm"
 )
 
 type GroupAPI struct {
-	DB *gorm.DB
+	Store handlers.DirectoryStore
 }
 
 type AddUsersRequest struct {
@@ -25,15 +24,16 @@ type AddUserRequest struct {
 func (ga *GroupAPI) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	var group models.Group
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	if err := handlers.CreateGroup(ga.DB, &group); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ga.Store.CreateGroup(r.Context(), &group); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, group.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(group)
@@ -44,21 +44,28 @@ func (ga *GroupAPI) GetGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	groupID := vars["id"]
 
-	group, err := handlers.GetGroupByID(ga.DB, groupID)
+	group, err := ga.Store.GetGroupByID(r.Context(), groupID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, group.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(group)
 }
 
 // GetAllGroups handles GET /api/groups
 func (ga *GroupAPI) GetAllGroups(w http.ResponseWriter, r *http.Request) {
-	groups, err := handlers.GetAllGroups(ga.DB)
+	opts, err := listOptionsFromRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	groups, err := ga.Store.GetAllGroups(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -71,20 +78,91 @@ func (ga *GroupAPI) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	groupID := vars["id"]
 
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	var group models.Group
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	// Ensure the ID matches the URL parameter
+	// Ensure the ID matches the URL parameter; the version comes from If-Match only
 	group.ID = groupID
+	group.Version = expectedVersion
+
+	err = ga.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetGroupByID(r.Context(), groupID)
+		if err != nil {
+			return err
+		}
+		if err := requireGroupRelationships(r.Context(), tx, current, &group); err != nil {
+			return err
+		}
+		return tx.UpdateGroup(r.Context(), &group)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, group.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
 
-	if err := handlers.UpdateGroup(ga.DB, &group); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// PatchGroup handles PATCH /api/groups/{id}
+// The body is a JSON Merge Patch or a JSON Patch, chosen by Content-Type. The
+// group is loaded, patched and saved in one transaction.
+func (ga *GroupAPI) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p, err := patchFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	var group models.Group
+	err = ga.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetGroupByID(r.Context(), groupID)
+		if err != nil {
+			return err
+		}
+		group = models.Group{}
+		if err := applyPatch(p, current, &group, groupReadOnlyFields); err != nil {
+			return err
+		}
+		// Without If-Match the write is still conditional on the version that was patched
+		group.Version = current.Version
+		if expectedVersion != 0 {
+			group.Version = expectedVersion
+		}
+		group.Members = orEmpty(group.Members)
+		group.MemberGroups = orEmpty(group.MemberGroups)
+		if group.Attributes == nil {
+			group.Attributes = models.Attributes{}
+		}
+		if err := requireGroupRelationships(r.Context(), tx, current, &group); err != nil {
+			return err
+		}
+		return tx.UpdateGroup(r.Context(), &group)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, group.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(group)
 }
@@ -94,8 +172,14 @@ func (ga *GroupAPI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	groupID := vars["id"]
 
-	if err := handlers.DeleteGroup(ga.DB, groupID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := ga.Store.DeleteGroup(r.Context(), groupID, expectedVersion); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -109,12 +193,16 @@ func (ga *GroupAPI) AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 
 	var req AddUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireGroupGrant(r.Context(), ga.Store, groupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AddUserToGroup(ga.DB, groupID, req.UserID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ga.Store.AddUserToGroup(r.Context(), groupID, req.UserID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -128,16 +216,22 @@ func (ga *GroupAPI) AddUsersToGroup(w http.ResponseWriter, r *http.Request) {
 
 	var req AddUsersRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireGroupGrant(r.Context(), ga.Store, groupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AddUsersToGroup(ga.DB, groupID, req.UserIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ga.Store.GetGroupByID(r.Context(), groupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ga.Store, req.UserIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.AddUserToGroup(r.Context(), groupID, id)
+	})
 }
 
 // RemoveUserFromGroup handles DELETE /api/groups/{id}/users/{userId}
@@ -146,8 +240,8 @@ func (ga *GroupAPI) RemoveUserFromGroup(w http.ResponseWriter, r *http.Request)
 	groupID := vars["id"]
 	userID := vars["userId"]
 
-	if err := handlers.RemoveUserFromGroup(ga.DB, groupID, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ga.Store.RemoveUserFromGroup(r.Context(), groupID, userID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -161,31 +255,128 @@ func (ga *GroupAPI) RemoveUsersFromGroup(w http.ResponseWriter, r *http.Request)
 
 	var req AddUsersRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	if err := handlers.RemoveUsersFromGroup(ga.DB, groupID, req.UserIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ga.Store.GetGroupByID(r.Context(), groupID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	runBulk(w, r, ga.Store, req.UserIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.RemoveUserFromGroup(r.Context(), groupID, id)
+	})
+}
+
+// AddGroupToGroup handles POST /api/groups/{id}/groups
+func (ga *GroupAPI) AddGroupToGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+
+	var req AddGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireGroupGrant(r.Context(), ga.Store, groupID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := ga.Store.AddGroupToGroup(r.Context(), groupID, req.GroupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// AddGroupsToGroup handles POST /api/groups/{id}/groups/bulk
+func (ga *GroupAPI) AddGroupsToGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+
+	var req AddGroupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireGroupGrant(r.Context(), ga.Store, groupID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if _, err := ga.Store.GetGroupByID(r.Context(), groupID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	runBulk(w, r, ga.Store, req.GroupIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.AddGroupToGroup(r.Context(), groupID, id)
+	})
+}
+
+// RemoveGroupFromGroup handles DELETE /api/groups/{id}/groups/{childId}
+func (ga *GroupAPI) RemoveGroupFromGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+	childID := vars["childId"]
+
+	if err := ga.Store.RemoveGroupFromGroup(r.Context(), groupID, childID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveGroupsFromGroup handles DELETE /api/groups/{id}/groups/bulk
+func (ga *GroupAPI) RemoveGroupsFromGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+
+	var req AddGroupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+
+	if _, err := ga.Store.GetGroupByID(r.Context(), groupID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	runBulk(w, r, ga.Store, req.GroupIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.RemoveGroupFromGroup(r.Context(), groupID, id)
+	})
+}
+
 // GetGroupMembers handles GET /api/groups/{id}/members
 func (ga *GroupAPI) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	groupID := vars["id"]
 
-	members, err := handlers.GetGroupMembers(ga.DB, groupID)
+	transitive, err := boolParam(r, "transitive")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	opts, err := pageOptionsFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	members, err := ga.Store.GetGroupMembers(r.Context(), groupID, transitive, opts)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]string{"members": members})
+	json.NewEncoder(w).Encode(members)
 }
 
 // GetUserGroups handles GET /api/users/{userId}/groups
@@ -193,9 +384,21 @@ func (ga *GroupAPI) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["userId"]
 
-	groups, err := handlers.GetUserGroups(ga.DB, userID)
+	transitive, err := boolParam(r, "transitive")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	opts, err := pageOptionsFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	groups, err := ga.Store.GetUserGroups(r.Context(), userID, transitive, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -203,6 +406,22 @@ func (ga *GroupAPI) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(groups)
 }
 
+// RestoreGroup handles POST /api/groups/{id}/restore
+func (ga *GroupAPI) RestoreGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID := vars["id"]
+
+	group, err := ga.Store.RestoreGroup(r.Context(), groupID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, group.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
 // RegisterGroupRoutes registers all group-related routes
 func (ga *GroupAPI) RegisterGroupRoutes(router *mux.Router) {
 	groupRouter := router.PathPrefix("/groups").Subrouter()
@@ -212,14 +431,22 @@ func (ga *GroupAPI) RegisterGroupRoutes(router *mux.Router) {
 	groupRouter.HandleFunc("", ga.GetAllGroups).Methods("GET")
 	groupRouter.HandleFunc("/{id}", ga.GetGroup).Methods("GET")
 	groupRouter.HandleFunc("/{id}", ga.UpdateGroup).Methods("PUT")
+	groupRouter.HandleFunc("/{id}", ga.PatchGroup).Methods("PATCH")
 	groupRouter.HandleFunc("/{id}", ga.DeleteGroup).Methods("DELETE")
+	groupRouter.HandleFunc("/{id}/restore", ga.RestoreGroup).Methods("POST")
 	
 	// Member management
 	groupRouter.HandleFunc("/{id}/users", ga.AddUserToGroup).Methods("POST")
 	groupRouter.HandleFunc("/{id}/users/bulk", ga.AddUsersToGroup).Methods("POST")
-	groupRouter.HandleFunc("/{id}/users/{userId}", ga.RemoveUserFromGroup).Methods("DELETE")
 	groupRouter.HandleFunc("/{id}/users/bulk", ga.RemoveUsersFromGroup).Methods("DELETE")
+	groupRouter.HandleFunc("/{id}/users/{userId}", ga.RemoveUserFromGroup).Methods("DELETE")
 	groupRouter.HandleFunc("/{id}/members", ga.GetGroupMembers).Methods("GET")
+
+	// Nested groups
+	groupRouter.HandleFunc("/{id}/groups", ga.AddGroupToGroup).Methods("POST")
+	groupRouter.HandleFunc("/{id}/groups/bulk", ga.AddGroupsToGroup).Methods("POST")
+	groupRouter.HandleFunc("/{id}/groups/bulk", ga.RemoveGroupsFromGroup).Methods("DELETE")
+	groupRouter.HandleFunc("/{id}/groups/{childId}", ga.RemoveGroupFromGroup).Methods("DELETE")
 	
 	// User-group relationships
 	userRouter := router.PathPrefix("/users").Subrouter()
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// createUsers creates n users with generated IDs UI000001 onwards
+func (a *testAPI) createUsers(n int) {
+	a.t.Helper()
+	for i := 0; i < n; i++ {
+		a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "User", Email: "user@example.com"})
+	}
+}
+
+func TestGroupCRUD(t *testing.T) {
+	a := newTestAPI(t)
+
+	var group models.Group
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"}), &group)
+	if group.ID != "GRP001" {
+		t.Fatalf("created group = %+v", group)
+	}
+	decode(t, a.expect(http.StatusOK, "PUT", "/api/v1/groups/GRP001", models.Group{Name: "Engineering", Description: "Builds things"}), &group)
+	if group.Description != "Builds things" || group.Version != 2 {
+		t.Errorf("updated group = %+v", group)
+	}
+
+	var page handlers.Page[models.Group]
+	decode(t, a.expect(http.StatusOK, "GET", `/api/v1/groups?filter=name+eq+"Engineering"`, nil), &page)
+	if len(page.Items) != 1 {
+		t.Errorf("filtered list = %+v", page.Items)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/groups/GRP001", nil)
+	a.expect(http.StatusNotFound, "GET", "/api/v1/groups/GRP001", nil)
+	a.expect(http.StatusOK, "POST", "/api/v1/groups/GRP001/restore", nil)
+	a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001", nil)
+}
+
+func TestGroupMembership(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(3)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"})
+
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: "UI000001"})
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: "UI000001"})
+	if code := problemCode(t, rec); code != "relationship_already_exists" {
+		t.Errorf("code = %q, want relationship_already_exists", code)
+	}
+	rec = a.expect(http.StatusNotFound, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: "UI000999"})
+	if code := problemCode(t, rec); code != "user_not_found" {
+		t.Errorf("code = %q, want user_not_found", code)
+	}
+
+	// Bulk adds report each item: an existing member is a no-op, and only the
+	// missing user fails
+	var result handlers.BulkResult
+	decode(t, a.expect(http.StatusMultiStatus, "POST", "/api/v1/groups/GRP001/users/bulk",
+		AddUsersRequest{UserIDs: []string{"UI000001", "UI000002", "UI000999"}}), &result)
+	if result.Succeeded != 1 || result.Noop != 1 || result.Failed != 1 {
+		t.Errorf("bulk result = %+v", result)
+	}
+	decode(t, a.expect(http.StatusOK, "POST", "/api/v1/groups/GRP001/users/bulk",
+		AddUsersRequest{UserIDs: []string{"UI000003"}}), &result)
+	if result.Succeeded != 1 {
+		t.Errorf("bulk result = %+v", result)
+	}
+
+	var members handlers.GroupMembers
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members", nil), &members)
+	if !slices.Equal(members.Users, []string{"UI000001", "UI000002", "UI000003"}) {
+		t.Errorf("members = %v", members.Users)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/groups/GRP001/users/UI000002", nil)
+	a.expect(http.StatusNotFound, "DELETE", "/api/v1/groups/GRP001/users/UI000002", nil)
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members", nil), &members)
+	if !slices.Equal(members.Users, []string{"UI000001", "UI000003"}) {
+		t.Errorf("members after removal = %v", members.Users)
+	}
+
+	// An atomic bulk request with a missing user changes nothing
+	a.expect(http.StatusConflict, "POST", "/api/v1/groups/GRP001/users/bulk?atomic=true",
+		AddUsersRequest{UserIDs: []string{"UI000002", "UI000999"}})
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members", nil), &members)
+	if !slices.Equal(members.Users, []string{"UI000001", "UI000003"}) {
+		t.Errorf("members after rolled back add = %v", members.Users)
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(1)
+	for _, name := range []string{"Company", "Engineering", "Platform"} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: name})
+	}
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP001/groups", AddGroupRequest{GroupID: "GRP002"})
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP002/groups", AddGroupRequest{GroupID: "GRP003"})
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP003/users", AddUserRequest{UserID: "UI000001"})
+
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/groups/GRP003/groups", AddGroupRequest{GroupID: "GRP001"})
+	if code := problemCode(t, rec); code != "group_cycle" {
+		t.Errorf("code = %q, want group_cycle", code)
+	}
+
+	var page handlers.Page[models.Group]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001/groups", nil), &page)
+	if len(page.Items) != 1 {
+		t.Errorf("direct groups = %+v", page.Items)
+	}
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001/groups?transitive=true", nil), &page)
+	if len(page.Items) != 3 {
+		t.Errorf("transitive groups = %+v", page.Items)
+	}
+
+	var members handlers.GroupMembers
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members?transitive=true", nil), &members)
+	if !slices.Equal(members.Users, []string{"UI000001"}) {
+		t.Errorf("transitive members = %v", members.Users)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/groups/GRP002/groups/GRP003", nil)
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001/groups?transitive=true", nil), &page)
+	if len(page.Items) != 1 {
+		t.Errorf("transitive groups after unnesting = %+v", page.Items)
+	}
+}
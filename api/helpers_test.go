@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+// testAPI serves the API of a server backed by a fresh memory store
+type testAPI struct {
+	t       *testing.T
+	server  *Server
+	handler http.Handler
+}
+
+// newTestAPI creates a server with authentication disabled, like AUTH_MODE=none
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	server := NewServer(handlers.NewMemoryStore())
+	return &testAPI{t: t, server: server, handler: server.SetupRoutes()}
+}
+
+// testAdmin is the subject granted every permission by newAuthTestAPI
+const testAdmin = "admin"
+
+// subjectAuthenticator authenticates a request as the subject in its
+// X-Test-Subject header, standing in for a verified token. The scopes in an
+// X-Test-Scopes header limit the caller to them, like an API key.
+type subjectAuthenticator struct{}
+
+func (subjectAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	subject := r.Header.Get("X-Test-Subject")
+	if subject == "" {
+		return nil, auth.ErrMissingCredentials
+	}
+	principal := &auth.Principal{Subject: subject, Method: "jwt"}
+	if scopes := r.Header.Get("X-Test-Scopes"); scopes != "" {
+		perms, err := auth.ScopePermissions(strings.Fields(scopes))
+		if err != nil {
+			return nil, err
+		}
+		principal.Permissions = perms
+	}
+	return principal, nil
+}
+
+// newAuthTestAPI creates a server that authorizes each request as the user
+// named by its X-Test-Subject header, or as an admin for testAdmin
+func newAuthTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	a := newTestAPI(t)
+	a.server.Authenticator = subjectAuthenticator{}
+	a.server.AdminSubjects = []string{testAdmin}
+	return a
+}
+
+// do sends a request with a JSON body, unless body is nil or an io.Reader,
+// and returns the recorded response
+func (a *testAPI) do(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	a.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatalf("failed to encode body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect sends a request and fails the test unless the response has the status
+func (a *testAPI) expect(status int, method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	a.t.Helper()
+	rec := a.do(method, path, body, headers...)
+	if rec.Code != status {
+		a.t.Fatalf("%s %s: status = %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+	return rec
+}
+
+// decode unmarshals a response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// problemCode returns the code of a problem details response
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p struct {
+		Code string `json:"code"`
+	}
+	decode(t, rec, &p)
+	return p.Code
+}
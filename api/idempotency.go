@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+const (
+	// idempotencyKeyHeader names the header that makes a POST safe to retry
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength bounds the stored key
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodyBytes bounds the request body buffered to fingerprint a keyed request
+	maxIdempotentBodyBytes = 10 << 20
+	// DefaultIdempotencyTTL is how long responses are replayed by default
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// DefaultIdempotencyLease is how long a request holds its key by default,
+	// longer than the default read and write timeouts together
+	DefaultIdempotencyLease = 5 * time.Minute
+)
+
+// replayedHeaders are the response headers stored with an idempotent response.
+// Headers that depend on the retry, such as the CORS headers, are not replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "Accept-Patch", "X-Content-Type-Options"}
+
+var (
+	// errIdempotencyKeyReused is returned when a key is sent again with a different request
+	errIdempotencyKeyReused = errors.New("the Idempotency-Key was already used for a different request")
+	// errIdempotencyKeyInProgress is returned for a retry while the first request is still running
+	errIdempotencyKeyInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	// errRequestTooLarge is returned for a keyed request whose body exceeds maxIdempotentBodyBytes
+	errRequestTooLarge = fmt.Errorf("the request body of a request with an Idempotency-Key must not exceed %d bytes", maxIdempotentBodyBytes)
+)
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// validIdempotencyKey reports whether key is 1 to 255 printable ASCII characters
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint hashes what makes two requests the same: the method,
+// the path with its query and the body
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyMiddleware honors the Idempotency-Key header on POST requests.
+// The first response per key and caller is stored for s.IdempotencyTTL and
+// replayed to retries; a retry with a different request is rejected with 422.
+// Server errors are not stored, so the request runs again when retried, and
+// neither are responses marked Cache-Control: no-store, such as new API keys.
+// A request holds its key for s.IdempotencyLease; if it crashes without a
+// response, a retry after the lease runs the request again.
+func (s *Server) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" || s.IdempotencyTTL <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, r, badRequest("invalid_idempotency_key", "%s must be 1 to %d printable ASCII characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			if errors.As(err, new(*http.MaxBytesError)) {
+				writeError(w, r, errRequestTooLarge)
+				return
+			}
+			writeError(w, r, badRequest("invalid_request", "Failed to read request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// CreatedAt identifies the reservation, so keep it at the database precision
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		record := &models.IdempotencyRecord{
+			Actor:       handlers.ActorFromContext(r.Context()),
+			Key:         key,
+			Fingerprint: requestFingerprint(r, body),
+			CreatedAt:   now,
+			LockedUntil: now.Add(cmp.Or(s.IdempotencyLease, DefaultIdempotencyLease)),
+			ExpiresAt:   now.Add(s.IdempotencyTTL),
+		}
+		existing, err := s.Store.ReserveIdempotencyKey(r.Context(), record)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				writeError(w, r, errIdempotencyKeyReused)
+			case !existing.Completed():
+				writeError(w, r, errIdempotencyKeyInProgress)
+			default:
+				replayResponse(w, existing)
+			}
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		// Keep the reservation consistent even if the client goes away
+		ctx := context.WithoutCancel(r.Context())
+		defer func() {
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError || rec.Header().Get("Cache-Control") == "no-store" {
+				if err := s.Store.ReleaseIdempotencyKey(ctx, record); err != nil {
+					log.Printf("%v", err)
+				}
+				return
+			}
+			record.StatusCode = rec.status
+			record.Header = models.ResponseHeader{}
+			for _, name := range replayedHeaders {
+				if value := rec.Header().Get(name); value != "" {
+					record.Header[name] = value
+				}
+			}
+			record.Body = rec.body.Bytes()
+			if err := s.Store.CompleteIdempotencyKey(ctx, record); err != nil {
+				log.Printf("%v", err)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// replayResponse writes the stored response of an earlier request
+func replayResponse(w http.ResponseWriter, record *models.IdempotencyRecord) {
+	for name, value := range record.Header {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	a := newTestAPI(t)
+	a.server.TrustActorHeader = true
+	body := `{"name":"Ada","email":"ada@example.com"}`
+	first := a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "k1", "X-Actor", "hr")
+	retry := a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "k1", "X-Actor", "hr")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry was not replayed: %s", retry.Body.String())
+	}
+	a.expect(http.StatusNotFound, "GET", "/api/v1/users/UI000002", nil)
+
+	rec := a.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/users", strings.NewReader(`{"name":"Bob","email":"bob@example.com"}`),
+		"Idempotency-Key", "k1", "X-Actor", "hr")
+	if code := problemCode(t, rec); code != "idempotency_key_reused" {
+		t.Errorf("code = %q, want idempotency_key_reused", code)
+	}
+	// Keys are per caller
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(`{"name":"Bob","email":"bob@example.com"}`),
+		"Idempotency-Key", "k1", "X-Actor", "it")
+}
+
+// Keyed request bodies are buffered, so their size is bounded
+func TestIdempotencyBodyLimit(t *testing.T) {
+	a := newTestAPI(t)
+	body := `{"name":"Ada","email":"ada@example.com","description":"` + strings.Repeat("a", maxIdempotentBodyBytes) + `"}`
+	rec := a.expect(http.StatusRequestEntityTooLarge, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "big")
+	if code := problemCode(t, rec); code != "request_too_large" {
+		t.Errorf("code = %q, want request_too_large", code)
+	}
+	a.expect(http.StatusNotFound, "GET", "/api/v1/users/UI000001", nil)
+
+	// The key was not reserved, so it can be used for a smaller request
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(`{"name":"Ada","email":"ada@example.com"}`), "Idempotency-Key", "big")
+}
+
+// A request that crashed before it responded holds its key until its lease
+// ends; a retry then takes the key over and runs the request
+func TestIdempotencyLease(t *testing.T) {
+	a := newTestAPI(t)
+	a.server.TrustActorHeader = true
+	ctx := context.Background()
+	body := `{"name":"Ada","email":"ada@example.com"}`
+	now := time.Now().UTC()
+	reserve := func(key string, lockedUntil time.Time) *models.IdempotencyRecord {
+		t.Helper()
+		record := &models.IdempotencyRecord{
+			Actor:       "hr",
+			Key:         key,
+			Fingerprint: requestFingerprint(httptest.NewRequest("POST", "/api/v1/users", nil), []byte(body)),
+			CreatedAt:   now.Add(-time.Minute),
+			LockedUntil: lockedUntil,
+			ExpiresAt:   now.Add(time.Hour),
+		}
+		if existing, err := a.server.Store.ReserveIdempotencyKey(ctx, record); existing != nil || err != nil {
+			t.Fatalf("ReserveIdempotencyKey() = %v, %v", existing, err)
+		}
+		return record
+	}
+
+	reserve("running", now.Add(time.Minute))
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "running", "X-Actor", "hr")
+	if code := problemCode(t, rec); code != "idempotency_key_in_progress" {
+		t.Errorf("code = %q, want idempotency_key_in_progress", code)
+	}
+
+	// Once the lease ends, a retry runs the request and its response is kept
+	crashed := reserve("crashed", now.Add(-time.Second))
+	first := a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "crashed", "X-Actor", "hr")
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after the lease was replayed")
+	}
+
+	// The crashed request no longer owns the key and cannot change it
+	crashed.StatusCode = http.StatusAccepted
+	if err := a.server.Store.CompleteIdempotencyKey(ctx, crashed); err != nil {
+		t.Fatalf("CompleteIdempotencyKey() error = %v", err)
+	}
+	if err := a.server.Store.ReleaseIdempotencyKey(ctx, crashed); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey() error = %v", err)
+	}
+	retry := a.expect(http.StatusCreated, "POST", "/api/v1/users", strings.NewReader(body), "Idempotency-Key", "crashed", "X-Actor", "hr")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry was not replayed: %s", retry.Body.String())
+	}
+}
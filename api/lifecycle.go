@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Timeouts bound the phases of HTTP connections and of the shutdown
+type Timeouts struct {
+	ReadHeader time.Duration // Time to read the request headers
+	Read       time.Duration // Time to read the whole request, including the body
+	Write      time.Duration // Time from the end of the request headers to the end of the response
+	Idle       time.Duration // Time a keep-alive connection waits for the next request
+	Shutdown   time.Duration // Deadline for in-flight requests to finish after a shutdown signal
+	DrainDelay time.Duration // Time /health reports draining before the listener closes
+}
+
+// DefaultTimeouts returns timeouts that suit bulk operations on large groups
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		ReadHeader: 10 * time.Second,
+		Read:       30 * time.Second,
+		Write:      60 * time.Second,
+		Idle:       120 * time.Second,
+		Shutdown:   30 * time.Second,
+	}
+}
+
+// newHTTPServer returns an http.Server for handler with the server timeouts
+func (s *Server) newHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: s.Timeouts.ReadHeader,
+		ReadTimeout:       s.Timeouts.Read,
+		WriteTimeout:      s.Timeouts.Write,
+		IdleTimeout:       s.Timeouts.Idle,
+	}
+}
+
+// Draining reports whether the server is shutting down
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// serve runs server until it fails or ctx is cancelled, then shuts it down
+// gracefully. listen starts the listener, e.g. server.ListenAndServe.
+func (s *Server) serve(ctx context.Context, server *http.Server, listen func() error) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- listen()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	return s.shutdown(server)
+}
+
+// shutdown reports draining on /health for the drain delay, so that load
+// balancers stop sending traffic, then stops accepting connections and waits
+// for in-flight requests until the shutdown deadline. Requests still running
+// at the deadline have their connections closed, which cancels their context
+// and rolls back their transactions.
+func (s *Server) shutdown(server *http.Server) error {
+	s.draining.Store(true)
+	if s.Timeouts.DrainDelay > 0 {
+		log.Printf("Shutting down: draining for %s before closing the listener", s.Timeouts.DrainDelay)
+		time.Sleep(s.Timeouts.DrainDelay)
+	}
+
+	log.Printf("Shutting down: waiting up to %s for in-flight requests", s.Timeouts.Shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeouts.Shutdown)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("in-flight requests did not finish within %s", s.Timeouts.Shutdown)
+		}
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	log.Printf("Server stopped")
+	return nil
+}
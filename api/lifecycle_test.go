@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+// lifecycleTest runs a server on a local port with a /slow endpoint that
+// blocks until release is closed or its request is cancelled
+type lifecycleTest struct {
+	t       *testing.T
+	server  *Server
+	base    string
+	started chan struct{}
+	release chan struct{}
+	cancel  context.CancelFunc
+	done    chan error // result of serve
+}
+
+func newLifecycleTest(t *testing.T, timeouts Timeouts) *lifecycleTest {
+	t.Helper()
+	lt := &lifecycleTest{
+		t:       t,
+		server:  NewServer(handlers.NewMemoryStore()),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+	lt.server.Timeouts = timeouts
+	routes := lt.server.SetupRoutes()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slow" {
+			routes.ServeHTTP(w, r)
+			return
+		}
+		close(lt.started)
+		select {
+		case <-lt.release:
+			w.Write([]byte("finished"))
+		case <-r.Context().Done():
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	lt.base = "http://" + listener.Addr().String()
+	server := lt.server.newHTTPServer("", handler, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	lt.cancel = cancel
+	go func() {
+		lt.done <- lt.server.serve(ctx, server, func() error { return server.Serve(listener) })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-lt.release:
+		default:
+			close(lt.release)
+		}
+	})
+	return lt
+}
+
+// health returns the status code and body of GET /health
+func (lt *lifecycleTest) health() (int, string) {
+	lt.t.Helper()
+	resp, err := http.Get(lt.base + "/health")
+	if err != nil {
+		lt.t.Fatalf("GET /health error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// slow starts a request to /slow and waits until the server is handling it
+func (lt *lifecycleTest) slow() <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(lt.base + "/slow")
+		if err != nil {
+			result <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+	select {
+	case <-lt.started:
+	case <-time.After(5 * time.Second):
+		lt.t.Fatal("slow request did not start")
+	}
+	return result
+}
+
+// Once a shutdown begins /health reports draining, the listener closes after
+// the drain delay, and in-flight requests still finish
+func TestGracefulShutdown(t *testing.T) {
+	lt := newLifecycleTest(t, Timeouts{Shutdown: 5 * time.Second, DrainDelay: 300 * time.Millisecond})
+	if status, body := lt.health(); status != http.StatusOK || !strings.Contains(body, `"ok"`) {
+		t.Fatalf("health before shutdown = %d %s", status, body)
+	}
+	result := lt.slow()
+
+	lt.cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for !lt.server.Draining() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if status, body := lt.health(); status != http.StatusServiceUnavailable || !strings.Contains(body, `"draining"`) {
+		t.Errorf("health while draining = %d %s", status, body)
+	}
+
+	// New connections are refused once the drain delay ends
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(lt.base, "http://"))
+		if err != nil {
+			break
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-lt.done:
+		t.Fatalf("serve() returned %v before the in-flight request finished", err)
+	default:
+	}
+
+	close(lt.release)
+	if body := <-result; body != "finished" {
+		t.Errorf("in-flight request = %q, want finished", body)
+	}
+	if err := <-lt.done; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+}
+
+// Requests still running at the shutdown deadline are cancelled
+func TestShutdownDeadline(t *testing.T) {
+	lt := newLifecycleTest(t, Timeouts{Shutdown: 100 * time.Millisecond})
+	result := lt.slow()
+
+	lt.cancel()
+	if err := <-lt.done; err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Errorf("serve() error = %v, want a deadline error", err)
+	}
+	if body := <-result; body == "finished" {
+		t.Errorf("in-flight request finished after the deadline")
+	}
+}
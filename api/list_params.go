@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/filter"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+// attributeParamPrefix marks query parameters that filter on a custom attribute, e.g. attr.department=sales
+const attributeParamPrefix = "attr."
+
+// listOptionsFromRequest reads the list query parameters shared by the
+// collection endpoints: the paging parameters plus include_deleted, filter and attr.<name>.
+func listOptionsFromRequest(r *http.Request) (handlers.ListOptions, error) {
+	opts, err := pageOptionsFromRequest(r)
+	if err != nil {
+		return opts, err
+	}
+	includeDeleted, err := boolParam(r, "include_deleted")
+	if err != nil {
+		return opts, err
+	}
+	opts.IncludeDeleted = includeDeleted
+	if expr := r.URL.Query().Get("filter"); expr != "" {
+		opts.Filter, err = filter.Parse(expr)
+		if err != nil {
+			return opts, badRequest("invalid_filter", "invalid filter: %v", err)
+		}
+	}
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, attributeParamPrefix); ok && len(values) > 0 {
+			if opts.Attributes == nil {
+				opts.Attributes = map[string]string{}
+			}
+			opts.Attributes[name] = values[0]
+		}
+	}
+	return opts, nil
+}
+
+// pageOptionsFromRequest reads the paging parameters accepted by every list
+// endpoint: limit, cursor, sort, order and include_total
+func pageOptionsFromRequest(r *http.Request) (handlers.ListOptions, error) {
+	query := r.URL.Query()
+	opts := handlers.ListOptions{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return opts, badRequest("invalid_parameter", "invalid limit parameter: %s", limit)
+		}
+		opts.Limit = parsed
+	}
+	includeTotal, err := boolParam(r, "include_total")
+	if err != nil {
+		return opts, err
+	}
+	opts.IncludeTotal = includeTotal
+	return opts, nil
+}
+
+// boolParam reads an optional boolean query parameter, defaulting to false
+func boolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, badRequest("invalid_parameter", "invalid %s parameter: %s", name, value)
+	}
+	return parsed, nil
+}
+
+// userStatusesParam reads the optional comma-separated status filter of the
+// user list, rejecting unknown statuses
+func userStatusesParam(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("status")
+	if value == "" {
+		return nil, nil
+	}
+	statuses := strings.Split(value, ",")
+	for i, status := range statuses {
+		statuses[i] = strings.TrimSpace(status)
+		if !handlers.ValidUserStatus(statuses[i]) {
+			return nil, badRequest("invalid_parameter", "invalid status parameter: %s", status)
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"github.com/lotusatx/lotus-directory-engine-backend/oidc"
+)
+
+// SetPasswordRequest is the body of PUT /api/v1/users/{id}/password
+type SetPasswordRequest struct {
+	Password string `json:"password"` // Between 12 and 256 characters
+}
+
+// CreateOIDCClient handles POST /api/v1/oidc/clients. The response of a
+// confidential client is the only one that contains its secret; it is not
+// stored for idempotent replay.
+func (o *OIDCAPI) CreateOIDCClient(w http.ResponseWriter, r *http.Request) {
+	var client models.OIDCClient
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+
+	client.Secret = ""
+	secret := ""
+	if !client.Public {
+		var err error
+		if secret, client.SecretHash, err = oidc.NewClientSecret(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if err := o.Store.CreateOIDCClient(r.Context(), &client); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	client.Secret = secret
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(client)
+}
+
+// GetOIDCClients handles GET /api/v1/oidc/clients
+func (o *OIDCAPI) GetOIDCClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := o.Store.GetOIDCClients(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+// GetOIDCClient handles GET /api/v1/oidc/clients/{id}
+func (o *OIDCAPI) GetOIDCClient(w http.ResponseWriter, r *http.Request) {
+	client, err := o.Store.GetOIDCClient(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+// DeleteOIDCClient handles DELETE /api/v1/oidc/clients/{id}. Tokens already
+// issued to the client stay valid until they expire.
+func (o *OIDCAPI) DeleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	if err := o.Store.DeleteOIDCClient(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserPassword handles PUT /api/v1/users/{id}/password, setting the
+// password the user logs in with at the OpenID Connect provider
+func (o *OIDCAPI) SetUserPassword(w http.ResponseWriter, r *http.Request) {
+	var req SetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := handlers.ValidatePassword(req.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := o.Store.SetUserPassword(r.Context(), mux.Vars(r)["id"], hash); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUserPassword handles DELETE /api/v1/users/{id}/password. The user can
+// no longer log in; existing login sessions end at their next use.
+func (o *OIDCAPI) DeleteUserPassword(w http.ResponseWriter, r *http.Request) {
+	if err := o.Store.DeleteUserPassword(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterOIDCClientRoutes registers the routes managing the clients of the
+// OpenID Connect provider and the passwords of users
+func (o *OIDCAPI) RegisterOIDCClientRoutes(router *mux.Router) {
+	clientRouter := router.PathPrefix("/oidc/clients").Subrouter()
+
+	clientRouter.HandleFunc("", o.CreateOIDCClient).Methods("POST")
+	clientRouter.HandleFunc("", o.GetOIDCClients).Methods("GET")
+	clientRouter.HandleFunc("/{id}", o.GetOIDCClient).Methods("GET")
+	clientRouter.HandleFunc("/{id}", o.DeleteOIDCClient).Methods("DELETE")
+
+	router.HandleFunc("/users/{id}/password", o.SetUserPassword).Methods("PUT")
+	router.HandleFunc("/users/{id}/password", o.DeleteUserPassword).Methods("DELETE")
+}
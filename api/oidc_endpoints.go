@@ -0,0 +1,807 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/filter"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"github.com/lotusatx/lotus-directory-engine-backend/oidc"
+)
+
+const (
+	// sessionCookie holds the login session of the provider
+	sessionCookie = "lde_oidc_session"
+	// csrfCookie holds the token the login form must echo
+	csrfCookie = "lde_oidc_csrf"
+	// maxFormBytes bounds the form bodies of the provider endpoints
+	maxFormBytes = 64 << 10
+)
+
+// errOIDCDisabled is returned by the provider endpoints when no provider is configured
+var errOIDCDisabled = errors.New("the OpenID Connect provider is not enabled")
+
+// OIDCAPI serves the built-in OpenID Connect provider and the management of
+// its clients and user passwords
+type OIDCAPI struct {
+	Store    handlers.DirectoryStore
+	Provider *oidc.Provider // nil disables the provider endpoints
+}
+
+// TokenRequest is the form body of POST /oidc/token
+type TokenRequest struct {
+	GrantType    string `json:"grant_type"`    // authorization_code
+	Code         string `json:"code"`          // Code from the authorization response
+	RedirectURI  string `json:"redirect_uri"`  // redirect_uri of the authorization request
+	CodeVerifier string `json:"code_verifier"` // PKCE code_verifier
+	ClientID     string `json:"client_id"`     // Required unless the client authenticates with HTTP Basic
+	ClientSecret string `json:"client_secret"` // Secret of a confidential client, if not sent with HTTP Basic
+}
+
+// TokenResponse is the body of a successful token request
+type TokenResponse struct {
+	AccessToken string `json:"access_token"` // Bearer token for the userinfo endpoint
+	TokenType   string `json:"token_type"`   // Bearer
+	ExpiresIn   int    `json:"expires_in"`   // Lifetime of the access token in seconds
+	IDToken     string `json:"id_token"`     // Signed ID token with the mapped claims
+	Scope       string `json:"scope"`        // Granted scopes
+}
+
+// LoginRequest is the form body of POST /oidc/authorize: the authorization
+// request parameters and the credentials of the user
+type LoginRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"` // code
+	Scope               string `json:"scope"`         // Space-separated; must include openid
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"` // S256
+	Email               string `json:"email"`                 // Email address or user ID
+	Password            string `json:"password"`
+	CSRF                string `json:"csrf"` // Token of the login form
+}
+
+// OAuthError is an OAuth 2.0 error response (RFC 6749 section 5.2)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+
+	status    int    // HTTP status of a token or userinfo error
+	challenge string // WWW-Authenticate header of a 401 Unauthorized error
+}
+
+func (e *OAuthError) Error() string { return e.Code + ": " + e.Description }
+
+// newOAuthError returns an OAuth error with a 400 Bad Request status
+func newOAuthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description, status: http.StatusBadRequest}
+}
+
+// writeOAuthError writes an OAuth error response; internal failures are
+// logged and reported as server_error
+func writeOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		writeError(w, r, err)
+		return
+	}
+	if oauthErr.challenge != "" {
+		w.Header().Set("WWW-Authenticate", oauthErr.challenge)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(oauthErr.status)
+	json.NewEncoder(w).Encode(oauthErr)
+}
+
+// authorizationRequest is a validated authorization request
+type authorizationRequest struct {
+	client        *models.OIDCClient
+	redirectURI   string
+	scopes        []string // Granted scopes: requested, supported and allowed for the client
+	state         string
+	nonce         string
+	codeChallenge string
+	prompt        string
+}
+
+// parseAuthorizationRequest validates the parameters of an authorization
+// request. An error with a redirect URI is sent to the client; without one,
+// the client or redirect URI is unknown and the error is shown to the user.
+func (o *OIDCAPI) parseAuthorizationRequest(ctx context.Context, params url.Values) (*authorizationRequest, string, error) {
+	client, err := o.Store.GetOIDCClient(ctx, params.Get("client_id"))
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, "", newOAuthError("invalid_request", "unknown client_id")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	redirectURI := params.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, "", newOAuthError("invalid_request", "redirect_uri is not registered for the client")
+	}
+
+	req := &authorizationRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		state:         params.Get("state"),
+		nonce:         params.Get("nonce"),
+		codeChallenge: params.Get("code_challenge"),
+		prompt:        params.Get("prompt"),
+	}
+	if params.Get("response_type") != "code" {
+		return nil, redirectURI, newOAuthError("unsupported_response_type", "only the code response type is supported")
+	}
+	requested := strings.Fields(params.Get("scope"))
+	if !slices.Contains(requested, oidc.ScopeOpenID) {
+		return nil, redirectURI, newOAuthError("invalid_scope", "scope must include openid")
+	}
+	supported := o.Provider.Claims().Scopes()
+	for _, scope := range requested {
+		allowed := len(client.Scopes) == 0 || scope == oidc.ScopeOpenID || slices.Contains(client.Scopes, scope)
+		if allowed && slices.Contains(supported, scope) && !slices.Contains(req.scopes, scope) {
+			req.scopes = append(req.scopes, scope)
+		}
+	}
+	if params.Get("code_challenge_method") != "S256" || !oidc.ValidCodeChallenge(req.codeChallenge) {
+		return nil, redirectURI, newOAuthError("invalid_request", "a PKCE code_challenge with code_challenge_method S256 is required")
+	}
+	if len(req.state) > 512 || len(req.nonce) > 512 {
+		return nil, redirectURI, newOAuthError("invalid_request", "state and nonce must have at most 512 characters")
+	}
+	if params.Get("request") != "" || params.Get("request_uri") != "" {
+		return nil, redirectURI, newOAuthError("request_not_supported", "request objects are not supported")
+	}
+	return req, redirectURI, nil
+}
+
+// redirect sends the user back to the client with the given response parameters
+func (o *OIDCAPI) redirect(w http.ResponseWriter, r *http.Request, redirectURI, state string, params url.Values) {
+	target, _ := url.Parse(redirectURI) // Registered URIs are valid
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", o.Provider.Issuer()) // RFC 9207
+	target.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// authorizationError reports a failed authorization request to the client if
+// its redirect URI is known, and to the user otherwise
+func (o *OIDCAPI) authorizationError(w http.ResponseWriter, r *http.Request, redirectURI, state string, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		writeError(w, r, err)
+		return
+	}
+	if redirectURI == "" {
+		renderPage(w, http.StatusBadRequest, errorPage, pageData{Title: "Sign-in failed", Error: oauthErr.Description})
+		return
+	}
+	o.redirect(w, r, redirectURI, state, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}})
+}
+
+// Authorize handles GET /oidc/authorize. A user with a login session is sent
+// back to the client with a code; anyone else is shown the login form.
+func (o *OIDCAPI) Authorize(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	req, redirectURI, err := o.parseAuthorizationRequest(r.Context(), params)
+	if err != nil {
+		o.authorizationError(w, r, redirectURI, params.Get("state"), err)
+		return
+	}
+
+	if req.prompt != "login" {
+		session, err := o.currentSession(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if session != nil {
+			o.issueCode(w, r, req, session)
+			return
+		}
+	}
+	if req.prompt == "none" {
+		o.authorizationError(w, r, redirectURI, req.state, newOAuthError("login_required", "the user is not logged in"))
+		return
+	}
+	o.renderLogin(w, r, req.client, params, "", http.StatusOK)
+}
+
+// Login handles POST /oidc/authorize, the submitted login form
+func (o *OIDCAPI) Login(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		renderPage(w, http.StatusBadRequest, errorPage, pageData{Title: "Sign-in failed", Error: "The login form could not be read."})
+		return
+	}
+	params := r.PostForm
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(params.Get("csrf"))) != 1 {
+		renderPage(w, http.StatusBadRequest, errorPage, pageData{Title: "Sign-in failed", Error: "The login form has expired. Go back to the application and sign in again."})
+		return
+	}
+	req, redirectURI, err := o.parseAuthorizationRequest(r.Context(), params)
+	if err != nil {
+		o.authorizationError(w, r, redirectURI, params.Get("state"), err)
+		return
+	}
+
+	user, err := o.checkPassword(r.Context(), params.Get("email"), params.Get("password"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if user == nil {
+		o.renderLogin(w, r, req.client, params, "Invalid email or password.", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := o.Provider.NewSession(user.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	token, err := o.Provider.SessionToken(session)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: token, Path: "/oidc", Expires: session.Expires,
+		HttpOnly: true, Secure: o.Provider.SecureCookies(), SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: oidc.AuthorizePath, MaxAge: -1})
+	o.issueCode(w, r, req, session)
+}
+
+// dummyPasswordHash is verified for unknown users so that the response time
+// does not reveal which email addresses exist
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("not-a-password")
+	return hash
+})
+
+// checkPassword returns the active user with the given email address or ID
+// and password, or nil if the credentials do not match one
+func (o *OIDCAPI) checkPassword(ctx context.Context, login, password string) (*models.User, error) {
+	user, err := o.findUser(ctx, strings.TrimSpace(login))
+	if err != nil {
+		return nil, err
+	}
+	hash := dummyPasswordHash()
+	if user != nil {
+		credential, err := o.Store.GetUserPassword(ctx, user.ID)
+		switch {
+		case err == nil:
+			hash = credential.PasswordHash
+		case errors.Is(err, handlers.ErrNotFound):
+			user = nil
+		default:
+			return nil, err
+		}
+	}
+	if !auth.VerifyPassword(hash, password) || user == nil || user.Status != models.UserStatusActive {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// findUser returns the user with the given ID, or else the only user with
+// the given email address; nil if there is none or the address is ambiguous
+func (o *OIDCAPI) findUser(ctx context.Context, login string) (*models.User, error) {
+	if login == "" {
+		return nil, nil
+	}
+	user, err := o.Store.GetUserByID(ctx, login)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, handlers.ErrNotFound) {
+		return nil, err
+	}
+	page, err := o.Store.GetAllUsers(ctx, handlers.ListOptions{
+		Filter: &filter.Comparison{Field: "email", Op: filter.Eq, Value: login},
+		Limit:  2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) != 1 {
+		return nil, nil
+	}
+	return &page.Items[0], nil
+}
+
+// currentSession returns the login session of the request's cookie, or nil
+// if there is none, its user is no longer active or the password has changed
+func (o *OIDCAPI) currentSession(r *http.Request) (*oidc.Session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	session, err := o.Provider.VerifySession(cookie.Value)
+	if err != nil {
+		return nil, nil
+	}
+	user, err := o.Store.GetUserByID(r.Context(), session.Subject)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, nil
+	}
+
+	// Removing or changing the password ends the sessions started with it
+	credential, err := o.Store.GetUserPassword(r.Context(), user.ID)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.UpdatedAt.Truncate(time.Second).After(session.AuthTime) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// issueCode stores an authorization code for the session's user and sends it to the client
+func (o *OIDCAPI) issueCode(w http.ResponseWriter, r *http.Request, req *authorizationRequest, session *oidc.Session) {
+	code, err := oidc.RandomToken(32)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = o.Store.CreateAuthorizationCode(r.Context(), &models.AuthorizationCode{
+		Hash:          oidc.HashSecret(code),
+		ClientID:      req.client.ID,
+		UserID:        session.Subject,
+		RedirectURI:   req.redirectURI,
+		Scope:         strings.Join(req.scopes, " "),
+		Nonce:         req.nonce,
+		CodeChallenge: req.codeChallenge,
+		SessionID:     session.ID,
+		AuthTime:      session.AuthTime,
+		ExpiresAt:     time.Now().Add(o.Provider.CodeTTL()),
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o.redirect(w, r, req.redirectURI, req.state, url.Values{"code": {code}})
+}
+
+// renderLogin shows the login form for an authorization request. The email
+// field is prefilled from a failed attempt or the login_hint parameter.
+func (o *OIDCAPI) renderLogin(w http.ResponseWriter, r *http.Request, client *models.OIDCClient, params url.Values, message string, status int) {
+	csrf, err := oidc.RandomToken(32)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: csrfCookie, Value: csrf, Path: oidc.AuthorizePath,
+		HttpOnly: true, Secure: o.Provider.SecureCookies(), SameSite: http.SameSiteStrictMode,
+	})
+
+	hidden := map[string]string{"csrf": csrf}
+	for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		hidden[name] = params.Get(name)
+	}
+	renderPage(w, status, loginPage, pageData{
+		Title:  "Sign in to " + client.Name,
+		Error:  message,
+		Action: oidc.AuthorizePath,
+		Email:  cmp.Or(params.Get("email"), params.Get("login_hint")),
+		Hidden: hidden,
+	})
+}
+
+// Token handles POST /oidc/token, exchanging an authorization code for an ID
+// token and an access token
+func (o *OIDCAPI) Token(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, r, newOAuthError("invalid_request", "the body must be a form"))
+		return
+	}
+	client, err := o.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, r, newOAuthError("unsupported_grant_type", "only the authorization_code grant is supported"))
+		return
+	}
+
+	code, err := o.Store.ConsumeAuthorizationCode(r.Context(), oidc.HashSecret(r.PostForm.Get("code")))
+	if errors.Is(err, handlers.ErrNotFound) {
+		writeOAuthError(w, r, newOAuthError("invalid_grant", "the code is invalid, expired or already redeemed"))
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, r, newOAuthError("invalid_grant", "the code was issued to another client or redirect_uri"))
+		return
+	}
+	if !oidc.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		writeOAuthError(w, r, newOAuthError("invalid_grant", "code_verifier does not match the code_challenge"))
+		return
+	}
+	user, err := o.activeUser(r.Context(), code.UserID)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeOAuthError(w, r, newOAuthError("invalid_grant", "the user is no longer active"))
+		return
+	}
+
+	grant := oidc.Grant{
+		Subject:   user.ID,
+		ClientID:  client.ID,
+		Scopes:    strings.Fields(code.Scope),
+		Nonce:     code.Nonce,
+		SessionID: code.SessionID,
+		AuthTime:  code.AuthTime,
+	}
+	claims, err := o.userClaims(r.Context(), user, grant.Scopes)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	accessToken, expiresIn, err := o.Provider.AccessToken(grant)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	idToken, err := o.Provider.IDToken(grant, claims, accessToken)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   expiresIn,
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	})
+}
+
+// authenticateClient returns the client of a token request. Confidential
+// clients authenticate with HTTP Basic or client_secret in the form; public
+// clients only name themselves with client_id.
+func (o *OIDCAPI) authenticateClient(r *http.Request) (*models.OIDCClient, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// The credentials are form-encoded before Basic encoding (RFC 6749 section 2.3.1)
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err == nil {
+			secret, err = url.QueryUnescape(secret)
+		}
+		if err != nil {
+			return nil, invalidClient(basic)
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, err := o.Store.GetOIDCClient(r.Context(), clientID)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, invalidClient(basic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, invalidClient(basic)
+		}
+		return client, nil
+	}
+	if !oidc.VerifySecret(client.SecretHash, secret) {
+		return nil, invalidClient(basic)
+	}
+	return client, nil
+}
+
+// invalidClient reports failed client authentication with 401 Unauthorized,
+// challenging clients that used HTTP Basic to retry with it
+func invalidClient(basic bool) error {
+	err := &OAuthError{Code: "invalid_client", Description: "client authentication failed", status: http.StatusUnauthorized}
+	if basic {
+		err.challenge = `Basic realm="` + authRealm + `"`
+	}
+	return err
+}
+
+// activeUser returns the user with the ID, or nil if the user is deleted or not active
+func (o *OIDCAPI) activeUser(ctx context.Context, userID string) (*models.User, error) {
+	user, err := o.Store.GetUserByID(ctx, userID)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// userClaims resolves the mapped claims of a user for the granted scopes
+func (o *OIDCAPI) userClaims(ctx context.Context, user *models.User, scopes []string) (map[string]interface{}, error) {
+	return o.Provider.Claims().Resolve(scopes, func(source string) (interface{}, error) {
+		switch source {
+		case oidc.SourceID:
+			return user.ID, nil
+		case oidc.SourceEmail:
+			return user.Email, nil
+		case oidc.SourceName:
+			return user.Name, nil
+		case oidc.SourceStatus:
+			return user.Status, nil
+		case oidc.SourceGroups:
+			return o.userGroupIDs(ctx, user.ID)
+		case oidc.SourceRoles:
+			return o.userRoleNames(ctx, user.ID)
+		}
+		if name, ok := oidc.Attribute(source); ok {
+			return user.Attributes[name], nil
+		}
+		return nil, nil
+	})
+}
+
+// userGroupIDs returns the IDs of the groups of a user, including nested groups
+func (o *OIDCAPI) userGroupIDs(ctx context.Context, userID string) ([]string, error) {
+	ids := []string{}
+	opts := handlers.ListOptions{}
+	for {
+		page, err := o.Store.GetUserGroups(ctx, userID, true, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.Items {
+			ids = append(ids, group.ID)
+		}
+		if page.NextCursor == "" {
+			return ids, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// userRoleNames returns the names of the effective roles of a user
+func (o *OIDCAPI) userRoleNames(ctx context.Context, userID string) ([]string, error) {
+	names := []string{}
+	opts := handlers.ListOptions{}
+	for {
+		page, err := o.Store.GetEffectiveRoles(ctx, userID, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range page.Items {
+			names = append(names, role.Name)
+		}
+		if page.NextCursor == "" {
+			return names, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// UserInfo handles GET and POST /oidc/userinfo, returning the current claims
+// of the user of an access token
+func (o *OIDCAPI) UserInfo(w http.ResponseWriter, r *http.Request) {
+	unauthorized := func(description string) {
+		writeOAuthError(w, r, &OAuthError{Code: "invalid_token", Description: description, status: http.StatusUnauthorized,
+			challenge: `Bearer realm="` + authRealm + `", error="invalid_token", error_description="` + description + `"`})
+	}
+	token, err := auth.BearerToken(r)
+	if err != nil {
+		unauthorized("an access token is required")
+		return
+	}
+	grant, err := o.Provider.VerifyAccessToken(token)
+	if err != nil {
+		unauthorized("the access token is invalid or expired")
+		return
+	}
+	user, err := o.activeUser(r.Context(), grant.Subject)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	if user == nil {
+		unauthorized("the user is no longer active")
+		return
+	}
+
+	claims, err := o.userClaims(r.Context(), user, grant.Scopes)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+	claims["sub"] = user.ID
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// EndSession handles GET /oidc/logout. It ends the login session and, if the
+// client names a registered post_logout_redirect_uri, sends the user back to it.
+func (o *OIDCAPI) EndSession(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	clientID := params.Get("client_id")
+	if hint := params.Get("id_token_hint"); hint != "" {
+		_, audience, err := o.Provider.VerifyIDTokenHint(hint)
+		if err != nil || (clientID != "" && clientID != audience) {
+			renderPage(w, http.StatusBadRequest, errorPage, pageData{Title: "Sign-out failed", Error: "The id_token_hint is invalid."})
+			return
+		}
+		clientID = audience
+	}
+
+	redirectURI := params.Get("post_logout_redirect_uri")
+	if redirectURI != "" {
+		client, err := o.Store.GetOIDCClient(r.Context(), clientID)
+		if err != nil && !errors.Is(err, handlers.ErrNotFound) {
+			writeError(w, r, err)
+			return
+		}
+		if client == nil || !slices.Contains(client.PostLogoutRedirectURIs, redirectURI) {
+			renderPage(w, http.StatusBadRequest, errorPage, pageData{Title: "Sign-out failed", Error: "The post_logout_redirect_uri is not registered for the client."})
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/oidc", MaxAge: -1,
+		HttpOnly: true, Secure: o.Provider.SecureCookies(), SameSite: http.SameSiteLaxMode})
+	if redirectURI == "" {
+		renderPage(w, http.StatusOK, errorPage, pageData{Title: "Signed out", Message: "You have been signed out."})
+		return
+	}
+	target, _ := url.Parse(redirectURI)
+	if state := params.Get("state"); state != "" {
+		query := target.Query()
+		query.Set("state", state)
+		target.RawQuery = query.Encode()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Discovery handles GET /.well-known/openid-configuration
+func (o *OIDCAPI) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o.Provider.Discovery())
+}
+
+// JWKS handles GET /oidc/jwks
+func (o *OIDCAPI) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(o.Provider.Keys().JWKS())
+}
+
+// enabledMiddleware answers 404 Not Found while no provider is configured
+func (o *OIDCAPI) enabledMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if o.Provider == nil {
+			writeError(w, r, errOIDCDisabled)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RegisterOIDCRoutes registers the OpenID Connect provider endpoints. They are
+// served outside /api/v1: browsers and client applications call them without
+// API credentials.
+func (o *OIDCAPI) RegisterOIDCRoutes(router *mux.Router) {
+	providerRouter := router.NewRoute().Subrouter()
+	providerRouter.Use(o.enabledMiddleware)
+
+	providerRouter.HandleFunc(oidc.DiscoveryPath, o.Discovery).Methods("GET")
+	providerRouter.HandleFunc(oidc.JWKSPath, o.JWKS).Methods("GET")
+	providerRouter.HandleFunc(oidc.AuthorizePath, o.Authorize).Methods("GET")
+	providerRouter.HandleFunc(oidc.AuthorizePath, o.Login).Methods("POST")
+	providerRouter.HandleFunc(oidc.TokenPath, o.Token).Methods("POST")
+	providerRouter.HandleFunc(oidc.UserInfoPath, o.UserInfo).Methods("GET", "POST")
+	providerRouter.HandleFunc(oidc.EndSessionPath, o.EndSession).Methods("GET")
+}
+
+// pageData fills the templates of the provider's HTML pages
+type pageData struct {
+	Title   string
+	Error   string // Shown as an alert
+	Message string
+	Action  string            // Target of the login form
+	Email   string            // Prefilled login
+	Hidden  map[string]string // Authorization request parameters carried by the login form
+}
+
+// pageLayout is shared by the provider's HTML pages
+const pageLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;background:#f4f4f6;display:flex;justify-content:center;padding-top:10vh;margin:0}
+main{background:#fff;padding:2rem;border-radius:8px;box-shadow:0 1px 4px rgba(0,0,0,.15);width:20rem}
+h1{font-size:1.25rem;margin-top:0}
+label{display:block;margin-top:1rem;font-size:.9rem}
+input{width:100%;box-sizing:border-box;padding:.5rem;margin-top:.25rem}
+button{margin-top:1.5rem;width:100%;padding:.6rem}
+.error{color:#a4000f}
+</style>
+</head>
+<body><main>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{block "content" .}}{{end}}
+</main></body>
+</html>`
+
+var (
+	errorPage = template.Must(template.New("page").Parse(pageLayout))
+	loginPage = template.Must(template.Must(template.New("page").Parse(pageLayout)).Parse(`{{define "content"}}
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Email<input name="email" type="text" autocomplete="username" value="{{.Email}}" required autofocus></label>
+<label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+<button type="submit">Sign in</button>
+</form>
+{{end}}`))
+)
+
+// renderPage writes an HTML page that may not be framed or cached
+func renderPage(w http.ResponseWriter, status int, page *template.Template, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	page.Execute(w, data)
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"github.com/lotusatx/lotus-directory-engine-backend/oidc"
+)
+
+const (
+	testIssuer      = "http://localhost:8080"
+	testRedirectURI = "https://app.example.com/callback"
+	testPassword    = "correct horse battery staple"
+)
+
+// oidcTest runs authorization code flows against a provider backed by the
+// memory store, for a confidential client and one user with a password
+type oidcTest struct {
+	*testAPI
+	secret  string       // client_secret of the app client
+	session *http.Cookie // Login session after the first login
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+	keys, err := oidc.ParseKeySet(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseKeySet() error = %v", err)
+	}
+	a := newTestAPI(t)
+	if a.server.OIDCAPI.Provider, err = oidc.NewProvider(oidc.Config{Issuer: testIssuer, Keys: keys}); err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"})
+	a.expect(http.StatusNoContent, "PUT", "/api/v1/users/UI000001/password", SetPasswordRequest{Password: testPassword})
+	var client models.OIDCClient
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/oidc/clients", models.OIDCClient{
+		ID:           "app",
+		Name:         "App",
+		RedirectURIs: models.StringList{testRedirectURI, "https://app.example.com/other"},
+	}), &client)
+	if client.Secret == "" {
+		t.Fatalf("created client has no secret: %+v", client)
+	}
+	return &oidcTest{testAPI: a, secret: client.Secret}
+}
+
+// pkce returns a new code_verifier and its S256 code_challenge
+func pkce(t *testing.T) (verifier, challenge string) {
+	t.Helper()
+	verifier, err := oidc.RandomToken(32)
+	if err != nil {
+		t.Fatalf("RandomToken() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// form sends a form-encoded request with the cookies and returns the response
+func (o *oidcTest) form(method, path string, values url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	o.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(values.Encode()))
+	if method == "GET" {
+		req = httptest.NewRequest(method, path+"?"+values.Encode(), nil)
+	} else {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	o.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// cookie returns the cookie a response sets, or fails the test
+func cookie(t *testing.T, rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("response sets no %s cookie", name)
+	return nil
+}
+
+// authorize runs an authorization request for the challenge, logging in with
+// the password unless a login session exists, and returns the issued code
+func (o *oidcTest) authorize(challenge string) string {
+	o.t.Helper()
+	params := url.Values{
+		"client_id":             {"app"},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"state":                 {"af0ifjsldkj"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	var rec *httptest.ResponseRecorder
+	if o.session != nil {
+		rec = o.form("GET", oidc.AuthorizePath, params, o.session)
+	} else {
+		rec = o.form("GET", oidc.AuthorizePath, params)
+		if rec.Code != http.StatusOK {
+			o.t.Fatalf("GET %s: status = %d, want the login form: %s", oidc.AuthorizePath, rec.Code, rec.Body.String())
+		}
+		csrf := cookie(o.t, rec, csrfCookie)
+		params.Set("csrf", csrf.Value)
+		params.Set("email", "ada@example.com")
+		params.Set("password", testPassword)
+		rec = o.form("POST", oidc.AuthorizePath, params, csrf)
+		if rec.Code == http.StatusFound {
+			o.session = cookie(o.t, rec, sessionCookie)
+		}
+	}
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("authorization: status = %d, want 302: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		o.t.Fatalf("invalid Location: %v", err)
+	}
+	query := location.Query()
+	location.RawQuery = ""
+	if location.String() != testRedirectURI || query.Get("state") != "af0ifjsldkj" || query.Get("iss") != testIssuer {
+		o.t.Fatalf("redirected to %s", rec.Header().Get("Location"))
+	}
+	if query.Get("code") == "" {
+		o.t.Fatalf("no code in %s", rec.Header().Get("Location"))
+	}
+	return query.Get("code")
+}
+
+// token redeems a code at the token endpoint
+func (o *oidcTest) token(code, redirectURI, verifier string) *httptest.ResponseRecorder {
+	o.t.Helper()
+	return o.form("POST", oidc.TokenPath, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+		"client_id":     {"app"},
+		"client_secret": {o.secret},
+	})
+}
+
+// expectOAuthError fails the test unless the response is the OAuth error
+func expectOAuthError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var oauthErr OAuthError
+	decode(t, rec, &oauthErr)
+	if rec.Code != status || oauthErr.Code != code {
+		t.Errorf("status = %d, error = %q, want %d %s: %s", rec.Code, oauthErr.Code, status, code, rec.Body.String())
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	o := newOIDCTest(t)
+	verifier, challenge := pkce(t)
+	code := o.authorize(challenge)
+
+	rec := o.token(code, testRedirectURI, verifier)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens TokenResponse
+	decode(t, rec, &tokens)
+	if tokens.TokenType != "Bearer" || tokens.AccessToken == "" || tokens.IDToken == "" || tokens.Scope != "openid email" {
+		t.Fatalf("token response = %+v", tokens)
+	}
+
+	parts := strings.Split(tokens.IDToken, ".")
+	if len(parts) != 3 {
+		t.Fatalf("ID token is not a JWT: %s", tokens.IDToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("invalid ID token payload: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("invalid ID token claims: %v", err)
+	}
+	for name, want := range map[string]string{"iss": testIssuer, "sub": "UI000001", "aud": "app", "nonce": "n-0S6_WzA2Mj", "email": "ada@example.com"} {
+		if claims[name] != want {
+			t.Errorf("ID token %s = %v, want %s", name, claims[name], want)
+		}
+	}
+
+	var userInfo map[string]interface{}
+	decode(t, o.expect(http.StatusOK, "GET", oidc.UserInfoPath, nil, "Authorization", "Bearer "+tokens.AccessToken), &userInfo)
+	if userInfo["sub"] != "UI000001" || userInfo["email"] != "ada@example.com" {
+		t.Errorf("userinfo = %v", userInfo)
+	}
+	o.expect(http.StatusUnauthorized, "GET", oidc.UserInfoPath, nil, "Authorization", "Bearer "+tokens.IDToken+"x")
+
+	// The login session issues the next code without asking for the password
+	verifier, challenge = pkce(t)
+	if rec := o.token(o.authorize(challenge), testRedirectURI, verifier); rec.Code != http.StatusOK {
+		t.Errorf("token with the login session: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOIDCTokenRejected(t *testing.T) {
+	o := newOIDCTest(t)
+
+	t.Run("code reuse", func(t *testing.T) {
+		verifier, challenge := pkce(t)
+		code := o.authorize(challenge)
+		if rec := o.token(code, testRedirectURI, verifier); rec.Code != http.StatusOK {
+			t.Fatalf("first redemption: status = %d: %s", rec.Code, rec.Body.String())
+		}
+		expectOAuthError(t, o.token(code, testRedirectURI, verifier), http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("redirect_uri mismatch", func(t *testing.T) {
+		verifier, challenge := pkce(t)
+		code := o.authorize(challenge)
+		// Another URI registered for the same client does not match either
+		expectOAuthError(t, o.token(code, "https://app.example.com/other", verifier), http.StatusBadRequest, "invalid_grant")
+		// The failed attempt used up the code
+		expectOAuthError(t, o.token(code, testRedirectURI, verifier), http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("wrong code_verifier", func(t *testing.T) {
+		_, challenge := pkce(t)
+		code := o.authorize(challenge)
+		other, _ := pkce(t)
+		expectOAuthError(t, o.token(code, testRedirectURI, other), http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("missing code_verifier", func(t *testing.T) {
+		_, challenge := pkce(t)
+		expectOAuthError(t, o.token(o.authorize(challenge), testRedirectURI, ""), http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		verifier, _ := pkce(t)
+		expectOAuthError(t, o.token("not-a-code", testRedirectURI, verifier), http.StatusBadRequest, "invalid_grant")
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		verifier, challenge := pkce(t)
+		code := o.authorize(challenge)
+		secret := o.secret
+		o.secret = "wrong"
+		defer func() { o.secret = secret }()
+		expectOAuthError(t, o.token(code, testRedirectURI, verifier), http.StatusUnauthorized, "invalid_client")
+	})
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	o := newOIDCTest(t)
+	_, challenge := pkce(t)
+	params := url.Values{
+		"client_id":             {"app"},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"email":                 {"ada@example.com"},
+		"password":              {"not the password"},
+	}
+	csrf := cookie(t, o.form("GET", oidc.AuthorizePath, params), csrfCookie)
+	params.Set("csrf", csrf.Value)
+	if rec := o.form("POST", oidc.AuthorizePath, params, csrf); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want 401", rec.Code)
+	}
+
+	// The form must echo the token of its cookie
+	params.Set("password", testPassword)
+	params.Set("csrf", "forged")
+	if rec := o.form("POST", oidc.AuthorizePath, params, csrf); rec.Code != http.StatusBadRequest {
+		t.Errorf("forged csrf: status = %d, want 400", rec.Code)
+	}
+
+	// Without PKCE the user is sent back to the client with an error
+	params.Del("code_challenge")
+	rec := o.form("GET", oidc.AuthorizePath, params)
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || location.Query().Get("error") != "invalid_request" {
+		t.Errorf("without PKCE: status = %d, Location = %s", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,503 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"github.com/lotusatx/lotus-directory-engine-backend/oidc"
+	"github.com/lotusatx/lotus-directory-engine-backend/patch"
+)
+
+// apiVersion is the version of the API in the OpenAPI document
+const apiVersion = "1.0.0"
+
+// parameter is a query or header parameter of an operation
+type parameter struct {
+	name        string
+	in          string // query or header
+	description string
+	schema      schema
+	required    bool
+}
+
+// operation documents one registered route
+type operation struct {
+	id      string // operationId, named after the handler
+	method  string
+	path    string // Full path in mux template syntax, without variable patterns
+	tag     string
+	summary string
+	params  []parameter
+	body    interface{} // Request body, or nil
+	patch   bool        // The body is a JSON Merge Patch or JSON Patch document
+	form    bool        // The body is an application/x-www-form-urlencoded form
+	status  int         // Success status
+	result  interface{} // Success response body, or nil for no content
+	etag    bool        // The success response carries an ETag header
+	bulk    bool        // The response is a BulkResult, also sent with 207 and 409
+	partial interface{} // Body of a 409 response that reports a rolled back batch, or nil
+	errors  []int       // Statuses of problem responses
+
+	// OpenID Connect endpoints answer with pages, redirects and OAuth errors
+	html     bool // The success response and 400 and 401 errors are HTML pages
+	redirect bool // The request may be answered with 302 Found
+	oauth    bool // 400 and 401 errors are OAuth error responses
+
+	permission auth.Permission // Required of authenticated callers; empty allows any caller
+}
+
+var (
+	stringSchema  = schema{"type": "string"}
+	booleanSchema = schema{"type": "boolean"}
+	limitSchema   = schema{"type": "integer", "minimum": 1}
+)
+
+// Query and header parameters shared by several operations
+var (
+	limitParam          = parameter{name: "limit", in: "query", description: "Maximum number of items per page", schema: limitSchema}
+	cursorParam         = parameter{name: "cursor", in: "query", description: "next_cursor of the previous page", schema: stringSchema}
+	sortParam           = parameter{name: "sort", in: "query", description: "Field to sort by", schema: stringSchema}
+	orderParam          = parameter{name: "order", in: "query", description: "Sort order", schema: schema{"type": "string", "enum": []string{"asc", "desc"}}}
+	includeTotalParam   = parameter{name: "include_total", in: "query", description: "Include the number of matching items across all pages", schema: booleanSchema}
+	includeDeletedParam = parameter{name: "include_deleted", in: "query", description: "Include soft-deleted entities", schema: booleanSchema}
+	filterParam         = parameter{name: "filter", in: "query", description: "Filter expression, e.g. name sw \"Jo\" and status eq \"active\". Custom attributes are filtered with attr.<name>=<value> parameters.", schema: stringSchema}
+	transitiveParam     = parameter{name: "transitive", in: "query", description: "Include memberships through nested groups", schema: booleanSchema}
+	atomicParam         = parameter{name: "atomic", in: "query", description: "Apply all items in one transaction that is rolled back if any item fails", schema: booleanSchema}
+	ifMatchParam        = parameter{name: "If-Match", in: "header", description: "ETag of the version the write is based on; the write fails with 412 if the entity has changed", schema: stringSchema}
+	idempotencyKeyParam = parameter{name: "Idempotency-Key", in: "header", description: "Unique key of the request; retries with the same key receive the first response instead of running again", schema: schema{"type": "string", "maxLength": maxIdempotencyKeyLength}}
+	actorParam          = parameter{name: "X-Actor", in: "header", description: "Who performs the mutation, recorded in the audit log when authentication is disabled and AUTH_TRUST_ACTOR_HEADER is set", schema: stringSchema}
+)
+
+// authorizeParams are the parameters of an authorization request
+var authorizeParams = []parameter{
+	{name: "client_id", in: "query", description: "ID of a registered client", schema: stringSchema, required: true},
+	{name: "redirect_uri", in: "query", description: "One of the client's registered redirect URIs", schema: stringSchema, required: true},
+	{name: "response_type", in: "query", description: "Only the authorization code flow is supported", schema: schema{"type": "string", "enum": []string{"code"}}, required: true},
+	{name: "scope", in: "query", description: "Space-separated scopes, including openid", schema: stringSchema, required: true},
+	{name: "code_challenge", in: "query", description: "PKCE code challenge", schema: stringSchema, required: true},
+	{name: "code_challenge_method", in: "query", description: "PKCE method", schema: schema{"type": "string", "enum": []string{"S256"}}, required: true},
+	{name: "state", in: "query", description: "Value passed back to the redirect URI", schema: schema{"type": "string", "maxLength": 512}},
+	{name: "nonce", in: "query", description: "Value copied to the ID token", schema: schema{"type": "string", "maxLength": 512}},
+	{name: "prompt", in: "query", description: "none fails unless the user has a session; login always shows the login form", schema: schema{"type": "string", "enum": []string{"none", "login"}}},
+	{name: "login_hint", in: "query", description: "Email address to prefill", schema: stringSchema},
+}
+
+// pageParams are the paging parameters of every list
+var pageParams = []parameter{limitParam, cursorParam, sortParam, orderParam, includeTotalParam}
+
+// listParams are the parameters of the entity collections
+var listParams = append(append([]parameter{}, pageParams...), includeDeletedParam, filterParam)
+
+// operations lists every route of the API. TestOpenAPICoversRoutes fails when
+// a registered route is missing.
+func operations() []operation {
+	var ops []operation
+	for _, e := range []struct {
+		tag, name, path string
+		entity          interface{}
+		page            interface{}
+		read, write     auth.Permission
+	}{
+		{"Users", "User", "/api/v1/users", models.User{}, handlers.Page[models.User]{}, auth.UsersRead, auth.UsersWrite},
+		{"Groups", "Group", "/api/v1/groups", models.Group{}, handlers.Page[models.Group]{}, auth.GroupsRead, auth.GroupsWrite},
+		{"Roles", "Role", "/api/v1/roles", models.Role{}, handlers.Page[models.Role]{}, auth.RolesRead, auth.RolesWrite},
+	} {
+		lower := strings.ToLower(e.name)
+		list := listParams
+		if e.name == "User" {
+			list = append(append([]parameter{}, listParams...), parameter{name: "status", in: "query", description: "Comma-separated statuses to include: active, suspended, deprovisioned", schema: stringSchema})
+		}
+		ops = append(ops,
+			operation{id: "create" + e.name, method: "POST", path: e.path, tag: e.tag, summary: "Create a " + lower,
+				params: []parameter{actorParam}, body: e.entity, status: http.StatusCreated, result: e.entity, etag: true,
+				errors: []int{400, 409, 422}, permission: e.write},
+			operation{id: "getAll" + e.tag, method: "GET", path: e.path, tag: e.tag, summary: "List " + strings.ToLower(e.tag),
+				params: list, status: http.StatusOK, result: e.page, errors: []int{400}, permission: e.read},
+			operation{id: "get" + e.name, method: "GET", path: e.path + "/{id}", tag: e.tag, summary: "Get a " + lower,
+				status: http.StatusOK, result: e.entity, etag: true, errors: []int{404}, permission: e.read},
+			operation{id: "update" + e.name, method: "PUT", path: e.path + "/{id}", tag: e.tag, summary: "Replace a " + lower,
+				params: []parameter{ifMatchParam, actorParam}, body: e.entity, status: http.StatusOK, result: e.entity, etag: true,
+				errors: []int{400, 404, 409, 412, 422}, permission: e.write},
+			operation{id: "patch" + e.name, method: "PATCH", path: e.path + "/{id}", tag: e.tag, summary: "Patch a " + lower,
+				params: []parameter{ifMatchParam, actorParam}, patch: true, status: http.StatusOK, result: e.entity, etag: true,
+				errors: []int{400, 404, 409, 412, 415, 422}, permission: e.write},
+			operation{id: "delete" + e.name, method: "DELETE", path: e.path + "/{id}", tag: e.tag, summary: "Soft-delete a " + lower,
+				params: []parameter{ifMatchParam, actorParam}, status: http.StatusNoContent, errors: []int{400, 404, 412}, permission: e.write},
+			operation{id: "restore" + e.name, method: "POST", path: e.path + "/{id}/restore", tag: e.tag, summary: "Restore a soft-deleted " + lower,
+				params: []parameter{actorParam}, status: http.StatusOK, result: e.entity, etag: true, errors: []int{404}, permission: e.write},
+		)
+	}
+
+	for _, status := range []struct{ id, path, summary string }{
+		{"suspendUser", "suspend", "Suspend a user"},
+		{"reactivateUser", "reactivate", "Reactivate a suspended user"},
+		{"deprovisionUser", "deprovision", "Deprovision a user"},
+	} {
+		ops = append(ops, operation{id: status.id, method: "POST", path: "/api/v1/users/{id}/" + status.path, tag: "Users", summary: status.summary,
+			params: []parameter{actorParam}, status: http.StatusOK, result: models.User{}, etag: true, errors: []int{404, 409},
+			permission: auth.UsersWrite})
+	}
+
+	ops = append(ops,
+		// Group membership
+		operation{id: "addUserToGroup", method: "POST", path: "/api/v1/groups/{id}/users", tag: "Groups", summary: "Add a user to a group",
+			params: []parameter{actorParam}, body: AddUserRequest{}, status: http.StatusNoContent, errors: []int{400, 404, 409}, permission: auth.GroupsWrite},
+		operation{id: "addUsersToGroup", method: "POST", path: "/api/v1/groups/{id}/users/bulk", tag: "Groups", summary: "Add users to a group",
+			params: []parameter{atomicParam, actorParam}, body: AddUsersRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.GroupsWrite},
+		operation{id: "removeUsersFromGroup", method: "DELETE", path: "/api/v1/groups/{id}/users/bulk", tag: "Groups", summary: "Remove users from a group",
+			params: []parameter{atomicParam, actorParam}, body: AddUsersRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.GroupsWrite},
+		operation{id: "removeUserFromGroup", method: "DELETE", path: "/api/v1/groups/{id}/users/{userId}", tag: "Groups", summary: "Remove a user from a group",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.GroupsWrite},
+		operation{id: "getGroupMembers", method: "GET", path: "/api/v1/groups/{id}/members", tag: "Groups", summary: "List the members of a group",
+			params: append([]parameter{transitiveParam}, pageParams...), status: http.StatusOK, result: handlers.GroupMembers{}, errors: []int{400, 404}, permission: auth.GroupsRead},
+		operation{id: "addGroupToGroup", method: "POST", path: "/api/v1/groups/{id}/groups", tag: "Groups", summary: "Nest a group in a group",
+			params: []parameter{actorParam}, body: AddGroupRequest{}, status: http.StatusNoContent, errors: []int{400, 404, 409}, permission: auth.GroupsWrite},
+		operation{id: "addGroupsToGroup", method: "POST", path: "/api/v1/groups/{id}/groups/bulk", tag: "Groups", summary: "Nest groups in a group",
+			params: []parameter{atomicParam, actorParam}, body: AddGroupsRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.GroupsWrite},
+		operation{id: "removeGroupsFromGroup", method: "DELETE", path: "/api/v1/groups/{id}/groups/bulk", tag: "Groups", summary: "Remove nested groups from a group",
+			params: []parameter{atomicParam, actorParam}, body: AddGroupsRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.GroupsWrite},
+		operation{id: "removeGroupFromGroup", method: "DELETE", path: "/api/v1/groups/{id}/groups/{childId}", tag: "Groups", summary: "Remove a nested group from a group",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.GroupsWrite},
+		operation{id: "getUserGroups", method: "GET", path: "/api/v1/users/{userId}/groups", tag: "Users", summary: "List the groups of a user",
+			params: append([]parameter{transitiveParam}, pageParams...), status: http.StatusOK, result: handlers.Page[models.Group]{}, errors: []int{400, 404}, permission: auth.GroupsRead},
+
+		// Role assignment
+		operation{id: "addGroupToRole", method: "POST", path: "/api/v1/roles/{id}/groups", tag: "Roles", summary: "Associate a group with a role",
+			params: []parameter{actorParam}, body: AddGroupRequest{}, status: http.StatusNoContent, errors: []int{400, 404, 409}, permission: auth.RolesWrite},
+		operation{id: "addGroupsToRole", method: "POST", path: "/api/v1/roles/{id}/groups/bulk", tag: "Roles", summary: "Associate groups with a role",
+			params: []parameter{atomicParam, actorParam}, body: AddGroupsRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesWrite},
+		operation{id: "removeGroupsFromRole", method: "DELETE", path: "/api/v1/roles/{id}/groups/bulk", tag: "Roles", summary: "Remove groups from a role",
+			params: []parameter{atomicParam, actorParam}, body: AddGroupsRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesWrite},
+		operation{id: "removeGroupFromRole", method: "DELETE", path: "/api/v1/roles/{id}/groups/{groupId}", tag: "Roles", summary: "Remove a group from a role",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.RolesWrite},
+		operation{id: "getRoleGroups", method: "GET", path: "/api/v1/roles/{id}/groups", tag: "Roles", summary: "List the groups of a role",
+			params: pageParams, status: http.StatusOK, result: handlers.Page[string]{}, errors: []int{400, 404}, permission: auth.RolesRead},
+		operation{id: "bulkAssignRoleToUsers", method: "POST", path: "/api/v1/roles/{id}/users/bulk", tag: "Roles", summary: "Assign a role to users",
+			params: []parameter{atomicParam, actorParam}, body: BulkAssignRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesAssign},
+		operation{id: "bulkRemoveRoleFromUsers", method: "DELETE", path: "/api/v1/roles/{id}/users/bulk", tag: "Roles", summary: "Remove a role from users",
+			params: []parameter{atomicParam, actorParam}, body: BulkAssignRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesAssign},
+		operation{id: "assignRoleToUser", method: "POST", path: "/api/v1/users/{userId}/roles", tag: "Users", summary: "Assign a role to a user",
+			params: []parameter{actorParam}, body: AssignRoleRequest{}, status: http.StatusNoContent, errors: []int{400, 404, 409}, permission: auth.RolesAssign},
+		operation{id: "assignRolesToUser", method: "POST", path: "/api/v1/users/{userId}/roles/bulk", tag: "Users", summary: "Assign roles to a user",
+			params: []parameter{atomicParam, actorParam}, body: AssignRolesRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesAssign},
+		operation{id: "removeRolesFromUser", method: "DELETE", path: "/api/v1/users/{userId}/roles/bulk", tag: "Users", summary: "Remove roles from a user",
+			params: []parameter{atomicParam, actorParam}, body: AssignRolesRequest{}, bulk: true, errors: []int{400, 404}, permission: auth.RolesAssign},
+		operation{id: "removeRoleFromUser", method: "DELETE", path: "/api/v1/users/{userId}/roles/{roleId}", tag: "Users", summary: "Remove a role from a user",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.RolesAssign},
+		operation{id: "getUserRoles", method: "GET", path: "/api/v1/users/{userId}/roles", tag: "Users", summary: "List the roles of a user",
+			params: append([]parameter{{name: "effective", in: "query", description: "Include roles granted through group membership", schema: booleanSchema}}, pageParams...),
+			status: http.StatusOK, result: handlers.Page[models.Role]{}, errors: []int{400, 404}, permission: auth.RolesRead},
+
+		// Custom attributes
+		operation{id: "createAttributeDefinition", method: "POST", path: "/api/v1/attributes/{entityType}", tag: "Attributes", summary: "Define a custom attribute",
+			params: []parameter{actorParam}, body: models.AttributeDefinition{}, status: http.StatusCreated, result: models.AttributeDefinition{}, errors: []int{400, 409, 422}, permission: auth.AttributesWrite},
+		operation{id: "getAttributeDefinitions", method: "GET", path: "/api/v1/attributes/{entityType}", tag: "Attributes", summary: "List the custom attributes of an entity type",
+			status: http.StatusOK, result: []models.AttributeDefinition{}, permission: auth.AttributesRead},
+		operation{id: "getAttributeDefinition", method: "GET", path: "/api/v1/attributes/{entityType}/{name}", tag: "Attributes", summary: "Get a custom attribute definition",
+			status: http.StatusOK, result: models.AttributeDefinition{}, errors: []int{404}, permission: auth.AttributesRead},
+		operation{id: "updateAttributeDefinition", method: "PUT", path: "/api/v1/attributes/{entityType}/{name}", tag: "Attributes", summary: "Update a custom attribute definition",
+			params: []parameter{actorParam}, body: models.AttributeDefinition{}, status: http.StatusOK, result: models.AttributeDefinition{}, errors: []int{400, 404, 422}, permission: auth.AttributesWrite},
+		operation{id: "deleteAttributeDefinition", method: "DELETE", path: "/api/v1/attributes/{entityType}/{name}", tag: "Attributes", summary: "Delete a custom attribute definition",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.AttributesWrite},
+
+		// Service accounts
+		operation{id: "createServiceAccount", method: "POST", path: "/api/v1/service-accounts", tag: "Service Accounts", summary: "Create a service account",
+			params: []parameter{actorParam}, body: models.ServiceAccount{}, status: http.StatusCreated, result: models.ServiceAccount{},
+			errors: []int{400, 409, 422}, permission: auth.ServiceAccountsWrite},
+		operation{id: "getServiceAccounts", method: "GET", path: "/api/v1/service-accounts", tag: "Service Accounts", summary: "List service accounts and their keys",
+			status: http.StatusOK, result: []models.ServiceAccount{}, permission: auth.ServiceAccountsRead},
+		operation{id: "getServiceAccount", method: "GET", path: "/api/v1/service-accounts/{id}", tag: "Service Accounts", summary: "Get a service account and its keys",
+			status: http.StatusOK, result: models.ServiceAccount{}, errors: []int{404}, permission: auth.ServiceAccountsRead},
+		operation{id: "deleteServiceAccount", method: "DELETE", path: "/api/v1/service-accounts/{id}", tag: "Service Accounts", summary: "Delete a service account and revoke its keys",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.ServiceAccountsWrite},
+		operation{id: "createAPIKey", method: "POST", path: "/api/v1/service-accounts/{id}/keys", tag: "Service Accounts", summary: "Create an API key, returned only in this response",
+			params: []parameter{actorParam}, body: CreateAPIKeyRequest{}, status: http.StatusCreated, result: models.APIKey{},
+			errors: []int{400, 404, 409, 422}, permission: auth.ServiceAccountsWrite},
+		operation{id: "deleteAPIKey", method: "DELETE", path: "/api/v1/service-accounts/{id}/keys/{keyId}", tag: "Service Accounts", summary: "Revoke an API key",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.ServiceAccountsWrite},
+
+		// OpenID Connect clients and user passwords
+		operation{id: "createOIDCClient", method: "POST", path: "/api/v1/oidc/clients", tag: "OIDC Clients", summary: "Register a client; the secret of a confidential client is returned only in this response",
+			params: []parameter{actorParam}, body: models.OIDCClient{}, status: http.StatusCreated, result: models.OIDCClient{},
+			errors: []int{400, 409, 422}, permission: auth.OIDCClientsWrite},
+		operation{id: "getOIDCClients", method: "GET", path: "/api/v1/oidc/clients", tag: "OIDC Clients", summary: "List clients",
+			status: http.StatusOK, result: []models.OIDCClient{}, permission: auth.OIDCClientsRead},
+		operation{id: "getOIDCClient", method: "GET", path: "/api/v1/oidc/clients/{id}", tag: "OIDC Clients", summary: "Get a client",
+			status: http.StatusOK, result: models.OIDCClient{}, errors: []int{404}, permission: auth.OIDCClientsRead},
+		operation{id: "deleteOIDCClient", method: "DELETE", path: "/api/v1/oidc/clients/{id}", tag: "OIDC Clients", summary: "Delete a client",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.OIDCClientsWrite},
+		operation{id: "setUserPassword", method: "PUT", path: "/api/v1/users/{id}/password", tag: "Users", summary: "Set the password a user logs in with at the OpenID Connect provider",
+			params: []parameter{actorParam}, body: SetPasswordRequest{}, status: http.StatusNoContent, errors: []int{400, 404, 422}, permission: auth.CredentialsWrite},
+		operation{id: "deleteUserPassword", method: "DELETE", path: "/api/v1/users/{id}/password", tag: "Users", summary: "Remove the password of a user",
+			params: []parameter{actorParam}, status: http.StatusNoContent, errors: []int{404}, permission: auth.CredentialsWrite},
+
+		// OpenID Connect provider, answering 404 while it is not configured
+		operation{id: "getOpenIDConfiguration", method: "GET", path: oidc.DiscoveryPath, tag: "OpenID Connect", summary: "Get the provider metadata",
+			status: http.StatusOK, result: map[string]interface{}{}, errors: []int{404}},
+		operation{id: "getJWKS", method: "GET", path: oidc.JWKSPath, tag: "OpenID Connect", summary: "Get the public keys that sign tokens",
+			status: http.StatusOK, result: oidc.JWKSet{}, errors: []int{404}},
+		operation{id: "authorize", method: "GET", path: oidc.AuthorizePath, tag: "OpenID Connect", summary: "Start the authorization code flow with PKCE; shows the login form unless the user has a session",
+			params: authorizeParams, status: http.StatusOK, html: true, redirect: true, errors: []int{400, 404}},
+		operation{id: "login", method: "POST", path: oidc.AuthorizePath, tag: "OpenID Connect", summary: "Submit the login form",
+			form: true, body: LoginRequest{}, status: http.StatusOK, html: true, redirect: true, errors: []int{400, 401, 404}},
+		operation{id: "token", method: "POST", path: oidc.TokenPath, tag: "OpenID Connect", summary: "Redeem an authorization code for an ID token and access token",
+			form: true, body: TokenRequest{}, status: http.StatusOK, result: TokenResponse{}, oauth: true, errors: []int{400, 401, 404}},
+		operation{id: "getUserInfo", method: "GET", path: oidc.UserInfoPath, tag: "OpenID Connect", summary: "Get the claims of the user of an access token",
+			status: http.StatusOK, result: map[string]interface{}{}, oauth: true, errors: []int{401, 404}},
+		operation{id: "postUserInfo", method: "POST", path: oidc.UserInfoPath, tag: "OpenID Connect", summary: "Get the claims of the user of an access token",
+			status: http.StatusOK, result: map[string]interface{}{}, oauth: true, errors: []int{401, 404}},
+		operation{id: "endSession", method: "GET", path: oidc.EndSessionPath, tag: "OpenID Connect", summary: "End the login session",
+			params: []parameter{
+				{name: "id_token_hint", in: "query", description: "ID token previously issued to the client", schema: stringSchema},
+				{name: "client_id", in: "query", description: "Client requesting the logout", schema: stringSchema},
+				{name: "post_logout_redirect_uri", in: "query", description: "Registered URI to return to after the logout", schema: stringSchema},
+				{name: "state", in: "query", description: "Value passed back to the post_logout_redirect_uri", schema: stringSchema},
+			},
+			status: http.StatusOK, html: true, redirect: true, errors: []int{400, 404}},
+
+		// Batch
+		operation{id: "runBatch", method: "POST", path: "/api/v1/batch", tag: "Batch", summary: "Run user, group and role operations in one transaction",
+			params: []parameter{actorParam}, body: BatchRequest{}, status: http.StatusOK, result: BatchResult{}, partial: BatchResult{},
+			errors: []int{400}},
+
+		// Audit, search and service endpoints
+		operation{id: "listAudit", method: "GET", path: "/api/v1/audit", tag: "Audit", summary: "Query the audit log",
+			params: []parameter{
+				{name: "entity_type", in: "query", description: "user, group, role, attribute_definition, service_account or oidc_client", schema: stringSchema},
+				{name: "entity_id", in: "query", description: "ID of the mutated entity", schema: stringSchema},
+				{name: "actor", in: "query", description: "Who performed the mutation", schema: stringSchema},
+				{name: "operation", in: "query", description: "Operation, e.g. create or assign_role", schema: stringSchema},
+				{name: "since", in: "query", description: "Earliest time, inclusive", schema: schema{"type": "string", "format": "date-time"}},
+				{name: "until", in: "query", description: "Latest time, exclusive", schema: schema{"type": "string", "format": "date-time"}},
+				limitParam,
+				cursorParam,
+			},
+			status: http.StatusOK, result: handlers.Page[models.AuditEntry]{}, errors: []int{400}, permission: auth.AuditRead},
+		operation{id: "search", method: "GET", path: "/api/v1/search", tag: "Search", summary: "Search users, groups and roles",
+			params: []parameter{
+				{name: "q", in: "query", description: "Search text", schema: schema{"type": "string", "minLength": 2, "maxLength": 256}, required: true},
+				{name: "type", in: "query", description: "Comma-separated entity types to search: user, group, role", schema: stringSchema},
+				limitParam,
+			},
+			status: http.StatusOK, result: models.SearchResults{}, errors: []int{400}, permission: auth.SearchRead},
+		operation{id: "getOpenAPI", method: "GET", path: "/api/v1/openapi.json", tag: "Service", summary: "Get this OpenAPI document",
+			status: http.StatusOK, result: map[string]interface{}{}},
+		operation{id: "healthCheck", method: "GET", path: "/health", tag: "Service", summary: "Check that the server is running",
+			status: http.StatusOK, result: map[string]string{}},
+	)
+	return ops
+}
+
+// document returns the OpenAPI operation object
+func (op operation) document(reg *schemaRegistry) map[string]interface{} {
+	params := []map[string]interface{}{}
+	for _, name := range pathParams(op.path) {
+		p := map[string]interface{}{"name": name, "in": "path", "required": true, "schema": stringSchema}
+		if name == "entityType" {
+			p["schema"] = schema{"type": "string", "enum": []string{models.EntityUser, models.EntityGroup}}
+		}
+		params = append(params, p)
+	}
+	opParams := op.params
+	errorStatuses := append([]int{}, op.errors...)
+	authenticated := strings.HasPrefix(op.path, "/api/")
+	if authenticated {
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if op.method == http.MethodPost && authenticated {
+		opParams = append(append([]parameter{}, opParams...), idempotencyKeyParam)
+		errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity)
+	}
+	for _, p := range opParams {
+		params = append(params, map[string]interface{}{"name": p.name, "in": p.in, "description": p.description, "required": p.required, "schema": p.schema})
+	}
+
+	doc := map[string]interface{}{
+		"operationId": op.id,
+		"tags":        []string{op.tag},
+		"summary":     op.summary,
+		"parameters":  params,
+	}
+	if !authenticated {
+		doc["security"] = []interface{}{} // Open without credentials
+	}
+	if op.permission != "" {
+		doc["x-permission"] = op.permission
+	}
+	switch {
+	case op.patch:
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				patch.MergePatchType: map[string]interface{}{"schema": schema{"type": "object"}},
+				patch.JSONPatchType:  map[string]interface{}{"schema": reg.ref([]patch.Operation{})},
+			},
+		}
+	case op.form:
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{"schema": reg.ref(op.body)}},
+		}
+	case op.body != nil:
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": reg.ref(op.body)}},
+		}
+	}
+
+	responses := map[string]interface{}{}
+	if op.bulk {
+		result := map[string]interface{}{"application/json": map[string]interface{}{"schema": reg.ref(handlers.BulkResult{})}}
+		responses["200"] = map[string]interface{}{"description": "No item failed", "content": result}
+		responses["207"] = map[string]interface{}{"description": "Some items failed", "content": result}
+		responses["409"] = map[string]interface{}{"description": "An atomic batch was rolled back", "content": result}
+	} else {
+		success := map[string]interface{}{"description": http.StatusText(op.status)}
+		if op.html {
+			success["content"] = htmlContent
+		}
+		if op.result != nil {
+			success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": reg.ref(op.result)}}
+		}
+		if op.etag {
+			success["headers"] = map[string]interface{}{"ETag": map[string]interface{}{"description": "Version of the entity", "schema": stringSchema}}
+		}
+		responses[statusKey(op.status)] = success
+	}
+	if op.redirect {
+		responses[statusKey(http.StatusFound)] = map[string]interface{}{
+			"description": "Redirect to the client with the result, or to the login form",
+			"headers":     map[string]interface{}{"Location": map[string]interface{}{"description": "Redirect target", "schema": stringSchema}},
+		}
+	}
+	if op.partial != nil {
+		responses[statusKey(http.StatusConflict)] = map[string]interface{}{
+			"description": "An operation failed and the batch was rolled back",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": reg.ref(op.partial)}},
+		}
+	}
+	for _, status := range append(errorStatuses, http.StatusInternalServerError) {
+		if _, ok := responses[statusKey(status)]; ok {
+			continue
+		}
+		content := map[string]interface{}{problemContentType: map[string]interface{}{"schema": reg.ref(problem{})}}
+		if status == http.StatusBadRequest || status == http.StatusUnauthorized {
+			switch {
+			case op.html:
+				content = htmlContent
+			case op.oauth:
+				content = map[string]interface{}{"application/json": map[string]interface{}{"schema": reg.ref(OAuthError{})}}
+			}
+		}
+		responses[statusKey(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     content,
+		}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+// htmlContent is the content of a response that is an HTML page
+var htmlContent = map[string]interface{}{"text/html": map[string]interface{}{"schema": stringSchema}}
+
+// routeVariable matches a mux path variable with a pattern, e.g. {entityType:user|group}
+var routeVariable = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+// routeKey identifies a route by its method and path template without variable patterns
+func routeKey(method, template string) string {
+	return method + " " + routeVariable.ReplaceAllString(template, "{$1}")
+}
+
+// routePermissions maps the routeKey of every operation to its permission
+var routePermissions = sync.OnceValue(func() map[string]auth.Permission {
+	perms := map[string]auth.Permission{}
+	for _, op := range operations() {
+		perms[routeKey(op.method, op.path)] = op.permission
+	}
+	return perms
+})
+
+// pathParams returns the variable names in a path template
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.Trim(segment, "{}"))
+		}
+	}
+	return names
+}
+
+// statusKey formats a status code as a key of an OpenAPI responses object
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// buildOpenAPI returns the OpenAPI 3.1 document of the API
+func buildOpenAPI() map[string]interface{} {
+	reg := newSchemaRegistry()
+	paths := map[string]map[string]interface{}{}
+	tags := map[string]bool{}
+	for _, op := range operations() {
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]interface{}{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = op.document(reg)
+		tags[op.tag] = true
+	}
+	tagList := []map[string]string{}
+	for tag := range tags {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"] < tagList[j]["name"] })
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Lotus Directory Engine API",
+			"version":     apiVersion,
+			"description": "Users, groups, roles and their relationships. Errors are RFC 7807 problem details.",
+		},
+		"tags":     tagList,
+		"paths":    paths,
+		"security": []map[string][]string{{"bearerAuth": {}}, {"apiKeyAuth": {}}},
+		"components": map[string]interface{}{
+			"schemas": reg.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKeyAuth": map[string]interface{}{"type": "apiKey", "in": "header", "name": auth.APIKeyHeader},
+			},
+		},
+	}
+}
+
+// openAPIDocument is the encoded OpenAPI document, built on first use
+var openAPIDocument = sync.OnceValues(func() ([]byte, error) {
+	return json.Marshal(buildOpenAPI())
+})
+
+// GetOpenAPI handles GET /api/v1/openapi.json
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := openAPIDocument()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
+
+// RegisterOpenAPIRoutes registers the route serving the OpenAPI document
+func RegisterOpenAPIRoutes(router *mux.Router) {
+	router.HandleFunc("/openapi.json", GetOpenAPI).Methods("GET")
+}
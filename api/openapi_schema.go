@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// schema is a JSON Schema object of an OpenAPI 3.1 document
+type schema map[string]interface{}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaRegistry derives JSON Schemas from Go types through their json and
+// validate tags. Named struct types become components referenced with $ref.
+type schemaRegistry struct {
+	components map[string]schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{components: map[string]schema{}}
+}
+
+// ref returns a schema for the type of v, registering its components
+func (reg *schemaRegistry) ref(v interface{}) schema {
+	return reg.schemaOf(reflect.TypeOf(v))
+}
+
+// schemaOf returns the schema of t
+func (reg *schemaRegistry) schemaOf(t reflect.Type) schema {
+	switch t {
+	case timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return schema{"type": []string{"string", "null"}, "format": "date-time"}
+	case rawJSONType:
+		return schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := reg.schemaOf(t.Elem())
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+			return s
+		}
+		return schema{"oneOf": []schema{s, {"type": "null"}}}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": reg.schemaOf(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": reg.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return reg.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := reg.components[name]; !ok {
+			reg.components[name] = nil // Placeholder for recursive types
+			reg.components[name] = reg.structSchema(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	default:
+		return schema{}
+	}
+}
+
+// structSchema returns the object schema of a struct type
+func (reg *schemaRegistry) structSchema(t reflect.Type) schema {
+	properties := map[string]schema{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s := reg.schemaOf(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			ruleName, arg, _ := strings.Cut(rule, "=")
+			n, _ := strconv.Atoi(arg)
+			switch {
+			case ruleName == "required":
+				required = append(required, name)
+			case ruleName == "email":
+				s["format"] = "email"
+			case ruleName == "max" && field.Type.Kind() == reflect.String:
+				s["maxLength"] = n
+			case ruleName == "min" && field.Type.Kind() == reflect.String:
+				s["minLength"] = n
+			case ruleName == "max":
+				s["maxItems"] = n
+			case ruleName == "min":
+				s["minItems"] = n
+			}
+		}
+		properties[name] = s
+	}
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// schemaName names the component of a struct type. Instances of generic types
+// are named after their type argument, e.g. Page[models.User] becomes UserPage,
+// and unexported types are capitalized.
+func schemaName(t reflect.Type) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	base, arg, generic := strings.Cut(name, "[")
+	if !generic {
+		return name
+	}
+	arg = strings.TrimSuffix(arg, "]")
+	if i := strings.LastIndex(arg, "."); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if arg == "string" {
+		arg = "ID"
+	}
+	return arg + base
+}
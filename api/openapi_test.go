@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+// TestOpenAPICoversRoutes fails when a registered route is missing from the
+// OpenAPI document
+func TestOpenAPICoversRoutes(t *testing.T) {
+	paths := buildOpenAPI()["paths"].(map[string]map[string]interface{})
+
+	server := NewServer(handlers.NewMemoryStore())
+	routes := 0
+	err := server.routes().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // Subrouter prefixes have no handler of their own
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path = routeVariable.ReplaceAllString(path, "{$1}")
+		for _, method := range methods {
+			routes++
+			if _, ok := paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is not in the OpenAPI document", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+	if routes == 0 {
+		t.Fatal("no routes registered")
+	}
+
+	documented := 0
+	for _, operations := range paths {
+		documented += len(operations)
+	}
+	if documented != routes {
+		t.Errorf("the OpenAPI document has %d operations for %d routes", documented, routes)
+	}
+}
+
+// TestOpenAPIEndpoint checks the served document
+func TestOpenAPIEndpoint(t *testing.T) {
+	handler := NewServer(handlers.NewMemoryStore()).SetupRoutes()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	for _, name := range []string{"User", "Group", "Role", "UserPage", "AddUsersRequest", "AssignRolesRequest", "BulkResult", "Problem"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+
+	// Every $ref must resolve to a component
+	for _, ref := range regexp.MustCompile(`"\$ref":"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		if _, ok := doc.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("$ref to missing schema %s", ref[1])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// walkUsers follows next_cursor through every page of a user list query
+func (a *testAPI) walkUsers(path string) []string {
+	a.t.Helper()
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 20; pages++ {
+		target := path
+		if cursor != "" {
+			target += "&cursor=" + url.QueryEscape(cursor)
+		}
+		var page handlers.Page[models.User]
+		decode(a.t, a.expect(http.StatusOK, "GET", target, nil), &page)
+		ids = append(ids, userIDs(page)...)
+		if page.NextCursor == "" {
+			return ids
+		}
+		cursor = page.NextCursor
+	}
+	a.t.Fatalf("%s: cursor walk did not end", path)
+	return nil
+}
+
+func TestListPaging(t *testing.T) {
+	a := newTestAPI(t)
+	for _, name := range []string{"Bob", "Ada", "Bob", "Ada", "Cy", "Ada", "Bob"} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: name, Email: "user@example.com"})
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/api/v1/users?limit=2", []string{"UI000002", "UI000004", "UI000006", "UI000001", "UI000003", "UI000007", "UI000005"}},
+		{"/api/v1/users?limit=3&order=desc", []string{"UI000005", "UI000007", "UI000003", "UI000001", "UI000006", "UI000004", "UI000002"}},
+		{"/api/v1/users?limit=2&sort=email", []string{"UI000001", "UI000002", "UI000003", "UI000004", "UI000005", "UI000006", "UI000007"}},
+		{"/api/v1/users?limit=1&filter=" + url.QueryEscape(`name eq "Bob"`), []string{"UI000001", "UI000003", "UI000007"}},
+	}
+	for _, tt := range tests {
+		if got := a.walkUsers(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// Items changed between pages do not shift the rest of the walk
+	var first handlers.Page[models.User]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users?limit=3", nil), &first)
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/users/UI000002", nil)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Aaron", Email: "user@example.com"})
+	var next handlers.Page[models.User]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users?limit=3&cursor="+first.NextCursor, nil), &next)
+	if got := userIDs(next); !reflect.DeepEqual(got, []string{"UI000001", "UI000003", "UI000007"}) {
+		t.Errorf("page after changes = %v", got)
+	}
+}
+
+// A cursor is only accepted by the list and sort it was issued for
+func TestListCursorRejected(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(3)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"})
+	for _, id := range []string{"UI000001", "UI000002", "UI000003"} {
+		a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: id})
+	}
+
+	var users handlers.Page[models.User]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users?limit=1", nil), &users)
+	var members struct {
+		NextCursor string `json:"next_cursor"`
+	}
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members?limit=1", nil), &members)
+	if users.NextCursor == "" || members.NextCursor == "" {
+		t.Fatalf("missing next cursors: %+v, %+v", users, members)
+	}
+
+	for _, path := range []string{
+		"/api/v1/users?cursor=" + users.NextCursor[:len(users.NextCursor)-2],
+		"/api/v1/users?cursor=" + users.NextCursor + "x",
+		"/api/v1/users?sort=email&cursor=" + users.NextCursor,
+		"/api/v1/users?order=desc&cursor=" + users.NextCursor,
+		"/api/v1/groups?cursor=" + users.NextCursor,
+		"/api/v1/roles?cursor=" + users.NextCursor,
+		"/api/v1/users?cursor=" + members.NextCursor,
+		"/api/v1/roles/ROLE001/groups?cursor=" + members.NextCursor,
+	} {
+		rec := a.expect(http.StatusBadRequest, "GET", path, nil)
+		if code := problemCode(t, rec); code != "invalid_list_options" {
+			t.Errorf("%s: code = %q, want invalid_list_options", path, code)
+		}
+	}
+	a.expect(http.StatusOK, "GET", "/api/v1/groups/GRP001/members?cursor="+members.NextCursor, nil)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/patch"
+)
+
+// acceptPatch lists the patch media types in the Accept-Patch header
+const acceptPatch = patch.MergePatchType + ", " + patch.JSONPatchType
+
+// Fields of each entity that a PATCH cannot change. They are managed by the
+// server or, for status, changed through the lifecycle endpoints.
+var (
+	userReadOnlyFields  = []string{"id", "version", "status", "created_at", "updated_at", "disabled_at", "status_changed_at", "deleted_at"}
+	groupReadOnlyFields = []string{"id", "version", "deleted_at"}
+	roleReadOnlyFields  = []string{"id", "version", "deleted_at"}
+)
+
+var (
+	// errUnsupportedPatchType is returned for a PATCH body that is not a supported patch format
+	errUnsupportedPatchType = errors.New("unsupported patch media type")
+	// errUnprocessablePatch is returned when a patch applies but its result is not a valid entity
+	errUnprocessablePatch = errors.New("patched entity is not valid")
+)
+
+// patchFromRequest parses the body of a PATCH request in the format named by its Content-Type
+func patchFromRequest(r *http.Request) (patch.Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: expected %s", errUnsupportedPatchType, acceptPatch)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read body: %v", patch.ErrInvalid, err)
+	}
+	p, ok, err := patch.Parse(mediaType, body)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s, expected %s", errUnsupportedPatchType, mediaType, acceptPatch)
+	}
+	return p, err
+}
+
+// applyPatch applies p to the JSON encoding of current and decodes the result
+// into patched. Changes to read-only fields and unknown fields are rejected.
+func applyPatch(p patch.Patch, current, patched interface{}, readOnly []string) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return fmt.Errorf("failed to encode entity: %w", err)
+	}
+	result, err := p.Apply(doc)
+	if err != nil {
+		return err
+	}
+
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(doc, &before); err != nil {
+		return fmt.Errorf("failed to decode entity: %w", err)
+	}
+	if err := json.Unmarshal(result, &after); err != nil {
+		return fmt.Errorf("%w: the result must be a JSON object", errUnprocessablePatch)
+	}
+	for _, field := range readOnly {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			return fmt.Errorf("%w: %s is read-only", errUnprocessablePatch, field)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(patched); err != nil {
+		return fmt.Errorf("%w: %v", errUnprocessablePatch, err)
+	}
+	return nil
+}
+
+// orEmpty returns an empty slice for nil. A patched entity is complete, so a
+// nil list means the patch removed it and the relationship is cleared.
+func orEmpty[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
@@ -6,11 +6,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
-	"gorm.io/gorm"
 )
 
 type RoleAPI struct {
-	DB *gorm.DB
+	Store handlers.DirectoryStore
 }
 
 type AddGroupsRequest struct {
@@ -37,15 +36,20 @@ type BulkAssignRequest struct {
 func (ra *RoleAPI) CreateRole(w http.ResponseWriter, r *http.Request) {
 	var role models.Role
 	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleRelationships(r.Context(), nil, &role); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.CreateRole(ra.DB, &role); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ra.Store.CreateRole(r.Context(), &role); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, role.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(role)
@@ -56,21 +60,28 @@ func (ra *RoleAPI) GetRole(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roleID := vars["id"]
 
-	role, err := handlers.GetRoleByID(ra.DB, roleID)
+	role, err := ra.Store.GetRoleByID(r.Context(), roleID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, role.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(role)
 }
 
 // GetAllRoles handles GET /api/roles
 func (ra *RoleAPI) GetAllRoles(w http.ResponseWriter, r *http.Request) {
-	roles, err := handlers.GetAllRoles(ra.DB)
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	roles, err := ra.Store.GetAllRoles(r.Context(), opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -83,31 +94,110 @@ func (ra *RoleAPI) UpdateRole(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roleID := vars["id"]
 
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	var role models.Role
 	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	// Ensure the ID matches the URL parameter
+	// Ensure the ID matches the URL parameter; the version comes from If-Match only
 	role.ID = roleID
+	role.Version = expectedVersion
+
+	err = ra.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetRoleByID(r.Context(), roleID)
+		if err != nil {
+			return err
+		}
+		if err := requireRoleRelationships(r.Context(), current, &role); err != nil {
+			return err
+		}
+		return tx.UpdateRole(r.Context(), &role)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, role.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// PatchRole handles PATCH /api/roles/{id}
+// The body is a JSON Merge Patch or a JSON Patch, chosen by Content-Type. The
+// role is loaded, patched and saved in one transaction.
+func (ra *RoleAPI) PatchRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roleID := vars["id"]
 
-	if err := handlers.UpdateRole(ra.DB, &role); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p, err := patchFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var role models.Role
+	err = ra.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetRoleByID(r.Context(), roleID)
+		if err != nil {
+			return err
+		}
+		role = models.Role{}
+		if err := applyPatch(p, current, &role, roleReadOnlyFields); err != nil {
+			return err
+		}
+		// Without If-Match the write is still conditional on the version that was patched
+		role.Version = current.Version
+		if expectedVersion != 0 {
+			role.Version = expectedVersion
+		}
+		role.Groups = orEmpty(role.Groups)
+		if err := requireRoleRelationships(r.Context(), current, &role); err != nil {
+			return err
+		}
+		return tx.UpdateRole(r.Context(), &role)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, role.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(role)
 }
 
-// DeleteRole handles DELETE /api/roles/{id}
+// DeleteRole handles DELETE /api/roles/{id}. Built-in roles authorize the API
+// and cannot be deleted.
 func (ra *RoleAPI) DeleteRole(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roleID := vars["id"]
 
-	if err := handlers.DeleteRole(ra.DB, roleID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if isBuiltinRole(roleID) {
+		writeError(w, r, errBuiltinRoleDeleted)
+		return
+	}
+
+	if err := ra.Store.DeleteRole(r.Context(), roleID, expectedVersion); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -121,12 +211,16 @@ func (ra *RoleAPI) AddGroupToRole(w http.ResponseWriter, r *http.Request) {
 
 	var req AddGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleGrant(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AddGroupToRole(ra.DB, roleID, req.GroupID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ra.Store.AddGroupToRole(r.Context(), roleID, req.GroupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -140,16 +234,22 @@ func (ra *RoleAPI) AddGroupsToRole(w http.ResponseWriter, r *http.Request) {
 
 	var req AddGroupsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleGrant(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AddGroupsToRole(ra.DB, roleID, req.GroupIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetRoleByID(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.GroupIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.AddGroupToRole(r.Context(), roleID, id)
+	})
 }
 
 // RemoveGroupFromRole handles DELETE /api/roles/{id}/groups/{groupId}
@@ -158,8 +258,8 @@ func (ra *RoleAPI) RemoveGroupFromRole(w http.ResponseWriter, r *http.Request) {
 	roleID := vars["id"]
 	groupID := vars["groupId"]
 
-	if err := handlers.RemoveGroupFromRole(ra.DB, roleID, groupID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ra.Store.RemoveGroupFromRole(r.Context(), roleID, groupID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -173,16 +273,18 @@ func (ra *RoleAPI) RemoveGroupsFromRole(w http.ResponseWriter, r *http.Request)
 
 	var req AddGroupsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	if err := handlers.RemoveGroupsFromRole(ra.DB, roleID, req.GroupIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetRoleByID(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.GroupIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.RemoveGroupFromRole(r.Context(), roleID, id)
+	})
 }
 
 // AssignRoleToUser handles POST /api/users/{userId}/roles
@@ -192,12 +294,16 @@ func (ra *RoleAPI) AssignRoleToUser(w http.ResponseWriter, r *http.Request) {
 
 	var req AssignRoleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleGrant(r.Context(), req.RoleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AssignRoleToUser(ra.DB, userID, req.RoleID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ra.Store.AssignRoleToUser(r.Context(), userID, req.RoleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -211,16 +317,22 @@ func (ra *RoleAPI) AssignRolesToUser(w http.ResponseWriter, r *http.Request) {
 
 	var req AssignRolesRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleGrant(r.Context(), req.RoleIDs...); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.AssignRolesToUser(ra.DB, userID, req.RoleIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetUserByID(r.Context(), userID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.RoleIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.AssignRoleToUser(r.Context(), userID, id)
+	})
 }
 
 // RemoveRoleFromUser handles DELETE /api/users/{userId}/roles/{roleId}
@@ -229,8 +341,8 @@ func (ra *RoleAPI) RemoveRoleFromUser(w http.ResponseWriter, r *http.Request) {
 	userID := vars["userId"]
 	roleID := vars["roleId"]
 
-	if err := handlers.RemoveRoleFromUser(ra.DB, userID, roleID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ra.Store.RemoveRoleFromUser(r.Context(), userID, roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -244,16 +356,18 @@ func (ra *RoleAPI) RemoveRolesFromUser(w http.ResponseWriter, r *http.Request) {
 
 	var req AssignRolesRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	if err := handlers.RemoveRolesFromUser(ra.DB, userID, req.RoleIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetUserByID(r.Context(), userID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.RoleIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.RemoveRoleFromUser(r.Context(), userID, id)
+	})
 }
 
 // BulkAssignRoleToUsers handles POST /api/roles/{id}/users/bulk
@@ -263,16 +377,22 @@ func (ra *RoleAPI) BulkAssignRoleToUsers(w http.ResponseWriter, r *http.Request)
 
 	var req BulkAssignRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireRoleGrant(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.BulkAssignRoleToUsers(ra.DB, roleID, req.UserIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetRoleByID(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.UserIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.AssignRoleToUser(r.Context(), id, roleID)
+	})
 }
 
 // BulkRemoveRoleFromUsers handles DELETE /api/roles/{id}/users/bulk
@@ -282,26 +402,45 @@ func (ra *RoleAPI) BulkRemoveRoleFromUsers(w http.ResponseWriter, r *http.Reques
 
 	var req BulkAssignRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	if err := handlers.BulkRemoveRoleFromUsers(ra.DB, roleID, req.UserIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := ra.Store.GetRoleByID(r.Context(), roleID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	runBulk(w, r, ra.Store, req.UserIDs, func(store handlers.DirectoryStore, id string) error {
+		return store.RemoveRoleFromUser(r.Context(), id, roleID)
+	})
 }
 
-// GetUserRoles handles GET /api/users/{userId}/roles
+// GetUserRoles handles GET /api/users/{userId}/roles. With effective=true the
+// roles inherited through groups are included.
 func (ra *RoleAPI) GetUserRoles(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["userId"]
 
-	roles, err := handlers.GetUserRoles(ra.DB, userID)
+	effective, err := boolParam(r, "effective")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := pageOptionsFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var roles *handlers.Page[models.Role]
+	if effective {
+		roles, err = ra.Store.GetEffectiveRoles(r.Context(), userID, opts)
+	} else {
+		roles, err = ra.Store.GetUserRoles(r.Context(), userID, opts)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -314,14 +453,36 @@ func (ra *RoleAPI) GetRoleGroups(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roleID := vars["id"]
 
-	groups, err := handlers.GetRoleGroups(ra.DB, roleID)
+	opts, err := pageOptionsFromRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	groups, err := ra.Store.GetRoleGroups(r.Context(), roleID, opts)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]string{"groups": groups})
+	json.NewEncoder(w).Encode(groups)
+}
+
+// RestoreRole handles POST /api/roles/{id}/restore
+func (ra *RoleAPI) RestoreRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roleID := vars["id"]
+
+	role, err := ra.Store.RestoreRole(r.Context(), roleID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, role.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
 }
 
 // RegisterRoleRoutes registers all role-related routes
@@ -333,13 +494,15 @@ func (ra *RoleAPI) RegisterRoleRoutes(router *mux.Router) {
 	roleRouter.HandleFunc("", ra.GetAllRoles).Methods("GET")
 	roleRouter.HandleFunc("/{id}", ra.GetRole).Methods("GET")
 	roleRouter.HandleFunc("/{id}", ra.UpdateRole).Methods("PUT")
+	roleRouter.HandleFunc("/{id}", ra.PatchRole).Methods("PATCH")
 	roleRouter.HandleFunc("/{id}", ra.DeleteRole).Methods("DELETE")
+	roleRouter.HandleFunc("/{id}/restore", ra.RestoreRole).Methods("POST")
 	
 	// Group management for roles
 	roleRouter.HandleFunc("/{id}/groups", ra.AddGroupToRole).Methods("POST")
 	roleRouter.HandleFunc("/{id}/groups/bulk", ra.AddGroupsToRole).Methods("POST")
-	roleRouter.HandleFunc("/{id}/groups/{groupId}", ra.RemoveGroupFromRole).Methods("DELETE")
 	roleRouter.HandleFunc("/{id}/groups/bulk", ra.RemoveGroupsFromRole).Methods("DELETE")
+	roleRouter.HandleFunc("/{id}/groups/{groupId}", ra.RemoveGroupFromRole).Methods("DELETE")
 	roleRouter.HandleFunc("/{id}/groups", ra.GetRoleGroups).Methods("GET")
 	
 	// Bulk user assignment for roles
@@ -350,7 +513,7 @@ func (ra *RoleAPI) RegisterRoleRoutes(router *mux.Router) {
 	userRouter := router.PathPrefix("/users").Subrouter()
 	userRouter.HandleFunc("/{userId}/roles", ra.AssignRoleToUser).Methods("POST")
 	userRouter.HandleFunc("/{userId}/roles/bulk", ra.AssignRolesToUser).Methods("POST")
-	userRouter.HandleFunc("/{userId}/roles/{roleId}", ra.RemoveRoleFromUser).Methods("DELETE")
 	userRouter.HandleFunc("/{userId}/roles/bulk", ra.RemoveRolesFromUser).Methods("DELETE")
+	userRouter.HandleFunc("/{userId}/roles/{roleId}", ra.RemoveRoleFromUser).Methods("DELETE")
 	userRouter.HandleFunc("/{userId}/roles", ra.GetUserRoles).Methods("GET")
 }
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestRoleCRUD(t *testing.T) {
+	a := newTestAPI(t)
+
+	var role models.Role
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"}), &role)
+	if role.ID != "ROLE001" {
+		t.Fatalf("created role = %+v", role)
+	}
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/roles", models.Role{ID: "ROLE001", Name: "tester"})
+	if code := problemCode(t, rec); code != "role_already_exists" {
+		t.Errorf("code = %q, want role_already_exists", code)
+	}
+
+	// The built-in roles exist from the start
+	var page handlers.Page[models.Role]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/roles?limit=100", nil), &page)
+	if len(page.Items) != 5 {
+		t.Errorf("roles = %+v", page.Items)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/roles/ROLE001", nil)
+	a.expect(http.StatusNotFound, "GET", "/api/v1/roles/ROLE001", nil)
+	a.expect(http.StatusOK, "POST", "/api/v1/roles/ROLE001/restore", nil)
+	a.expect(http.StatusOK, "GET", "/api/v1/roles/ROLE001", nil)
+}
+
+func TestRoleAssignment(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(2)
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "on-call"})
+
+	a.expect(http.StatusNoContent, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: "ROLE001"})
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: "ROLE001"})
+	if code := problemCode(t, rec); code != "relationship_already_exists" {
+		t.Errorf("code = %q, want relationship_already_exists", code)
+	}
+	a.expect(http.StatusNotFound, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: "ROLE999"})
+
+	// Roles granted through a group are effective, not direct
+	a.expect(http.StatusNoContent, "POST", "/api/v1/roles/ROLE002/groups", AddGroupRequest{GroupID: "GRP001"})
+	a.expect(http.StatusNoContent, "POST", "/api/v1/groups/GRP001/users", AddUserRequest{UserID: "UI000001"})
+
+	var roles handlers.Page[models.Role]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001/roles", nil), &roles)
+	if len(roles.Items) != 1 || roles.Items[0].ID != "ROLE001" {
+		t.Errorf("direct roles = %+v", roles.Items)
+	}
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001/roles?effective=true", nil), &roles)
+	if len(roles.Items) != 2 {
+		t.Errorf("effective roles = %+v", roles.Items)
+	}
+
+	var groups handlers.Page[string]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/roles/ROLE002/groups", nil), &groups)
+	if !slices.Equal(groups.Items, []string{"GRP001"}) {
+		t.Errorf("role groups = %v", groups.Items)
+	}
+
+	var result handlers.BulkResult
+	decode(t, a.expect(http.StatusOK, "POST", "/api/v1/roles/ROLE002/users/bulk", BulkAssignRequest{UserIDs: []string{"UI000001", "UI000002"}}), &result)
+	if result.Succeeded != 2 {
+		t.Errorf("bulk assign = %+v", result)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/users/UI000001/roles/ROLE001", nil)
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/roles/ROLE002/groups/GRP001", nil)
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000002/roles?effective=true", nil), &roles)
+	if len(roles.Items) != 1 || roles.Items[0].ID != "ROLE002" {
+		t.Errorf("effective roles after removal = %+v", roles.Items)
+	}
+}
+
+func TestRolledBackBulkLeavesNoAudit(t *testing.T) {
+	a := newTestAPI(t)
+	a.createUsers(1)
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"})
+
+	a.expect(http.StatusConflict, "POST", "/api/v1/roles/ROLE001/users/bulk?atomic=true",
+		BulkAssignRequest{UserIDs: []string{"UI000001", "UI000999"}})
+	a.expect(http.StatusNoContent, "POST", "/api/v1/users/UI000001/roles", AssignRoleRequest{RoleID: "ROLE001"})
+
+	var page handlers.Page[models.AuditEntry]
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/audit?entity_id=UI000001", nil), &page)
+	entries := page.Items
+	var operations []string
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+	}
+	if !slices.Equal(operations, []string{"assign_role", "create"}) {
+		t.Errorf("audit operations = %v, want [assign_role create]", operations)
+	}
+	for i, entry := range entries {
+		if i > 0 && entry.ID >= entries[i-1].ID {
+			t.Errorf("audit IDs are not descending: %d after %d", entry.ID, entries[i-1].ID)
+		}
+	}
+}
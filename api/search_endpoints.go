@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+)
+
+type SearchAPI struct {
+	Store handlers.DirectoryStore
+}
+
+// Search handles GET /api/v1/search
+// Query parameters: q (required), type (comma-separated user, group, role) and limit
+func (sa *SearchAPI) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var opts handlers.SearchOptions
+	if types := query.Get("type"); types != "" {
+		for _, entity := range strings.Split(types, ",") {
+			opts.Types = append(opts.Types, strings.TrimSpace(entity))
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			writeError(w, r, badRequest("invalid_parameter", "invalid limit parameter: %s", limit))
+			return
+		}
+		opts.Limit = parsed
+	}
+
+	results, err := sa.Store.Search(r.Context(), query.Get("q"), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// RegisterSearchRoutes registers all search-related routes
+func (sa *SearchAPI) RegisterSearchRoutes(router *mux.Router) {
+	router.HandleFunc("/search", sa.Search).Methods("GET")
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// newSearchTestAPI creates a directory with a few users, groups and roles
+func newSearchTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	a := newAuthTestAPI(t)
+	asAdmin := []string{"X-Test-Subject", testAdmin}
+	for _, user := range []models.User{
+		{Name: "John Doe", Email: "john.doe@company.com"},
+		{Name: "Jane Smith", Email: "jane@company.com"},
+		{Name: "Johnny Cash", Email: "cash@company.com"},
+	} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/users", user, asAdmin...)
+	}
+	for _, group := range []models.Group{
+		{Name: "Engineering", Description: "Builds the platform"},
+		{Name: "Platform", Description: "Runs <engineering> infrastructure"},
+		{Name: "Engineering Alumni"},
+	} {
+		a.expect(http.StatusCreated, "POST", "/api/v1/groups", group, asAdmin...)
+	}
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/groups/GRP003", nil, asAdmin...)
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "engineer", Description: "Writes code"}, asAdmin...)
+	return a
+}
+
+// search runs a search as the admin and returns its results
+func (a *testAPI) search(query string) models.SearchResults {
+	a.t.Helper()
+	var results models.SearchResults
+	decode(a.t, a.expect(http.StatusOK, "GET", "/api/v1/search?"+query, nil, "X-Test-Subject", testAdmin), &results)
+	return results
+}
+
+// hitKeys returns the type, ID and score of each hit
+func hitKeys(results models.SearchResults) []string {
+	keys := []string{}
+	for _, hit := range results.Hits {
+		keys = append(keys, hit.Type+" "+hit.ID+" "+formatScore(hit.Score))
+	}
+	return keys
+}
+
+func formatScore(score float64) string {
+	data, _ := json.Marshal(score)
+	return string(data)
+}
+
+func TestSearch(t *testing.T) {
+	a := newSearchTestAPI(t)
+
+	// A typo still finds the user through trigram similarity
+	results := a.search("q=jonh")
+	if results.Query != "jonh" || len(results.Hits) == 0 {
+		t.Fatalf("results = %+v", results)
+	}
+	want := models.SearchHit{
+		Type: "user", ID: "UI000001", Name: "John Doe", Detail: "john.doe@company.com", Score: 0.24,
+		Highlights: []models.SearchHighlight{
+			{Field: "name", Snippet: "<mark>John</mark> Doe"},
+			{Field: "email", Snippet: "<mark>john</mark>.doe@company.com"},
+		},
+	}
+	if !reflect.DeepEqual(results.Hits[0], want) {
+		t.Errorf("first hit = %+v, want %+v", results.Hits[0], want)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Exact, prefix and substring matches rank above fuzzy ones; soft-deleted groups are skipped
+		{"q=engineering", []string{"group GRP001 1", "group GRP002 0.8", "role ROLE001 0.4"}},
+		{"q=Engineering&type=role", []string{"role ROLE001 0.4"}},
+		{"q=engineering&type=user,role", []string{"role ROLE001 0.4"}},
+		{"q=engineering&limit=2", []string{"group GRP001 1", "group GRP002 0.8"}},
+		{"q=john", []string{"user UI000001 0.9", "user UI000003 0.9"}},
+		// Every query word is a word of the entity
+		{"q=" + url.QueryEscape("doe john"), []string{"user UI000001 0.7", "user UI000003 0.267"}},
+		// Ties are ordered by type, then name
+		{"q=" + url.QueryEscape("  company.com "), []string{"user UI000002 0.8", "user UI000001 0.8", "user UI000003 0.8"}},
+		{"q=zz", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := hitKeys(a.search(tt.query)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Highlights are HTML-escaped
+	results = a.search("q=infrastructure&type=group")
+	if len(results.Hits) != 1 || !reflect.DeepEqual(results.Hits[0].Highlights, []models.SearchHighlight{
+		{Field: "description", Snippet: "Runs &lt;engineering&gt; <mark>infrastructure</mark>"},
+	}) {
+		t.Errorf("hits = %+v", results.Hits)
+	}
+}
+
+func TestSearchRejected(t *testing.T) {
+	a := newSearchTestAPI(t)
+	asAdmin := []string{"X-Test-Subject", testAdmin}
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"", "invalid_search"},
+		{"q=a", "invalid_search"},
+		{"q=" + url.QueryEscape(" a "), "invalid_search"},
+		{"q=ab&type=device", "invalid_search"},
+		{"q=ab&limit=0", "invalid_parameter"},
+		{"q=ab&limit=x", "invalid_parameter"},
+		{"q=" + strings.Repeat("a", 257), "invalid_search"},
+		// Length counts characters, not bytes
+		{"q=" + url.QueryEscape(strings.Repeat("é", 257)), "invalid_search"},
+	}
+	for _, tt := range tests {
+		rec := a.expect(http.StatusBadRequest, "GET", "/api/v1/search?"+tt.query, nil, asAdmin...)
+		if code := problemCode(t, rec); code != tt.code {
+			t.Errorf("%q: code = %q, want %s", tt.query, code, tt.code)
+		}
+	}
+	// The limit is capped rather than rejected, and 256 characters are allowed
+	a.search("q=ab&limit=1000")
+	a.search("q=" + url.QueryEscape(strings.Repeat("é", 256)))
+}
+
+// Search needs search:read
+func TestSearchAuthorization(t *testing.T) {
+	a := newSearchTestAPI(t)
+	rec := a.expect(http.StatusForbidden, "GET", "/api/v1/search?q=john", nil, "X-Test-Subject", "key", "X-Test-Scopes", "users groups roles")
+	var p struct {
+		Code       string          `json:"code"`
+		Permission auth.Permission `json:"permission"`
+	}
+	decode(t, rec, &p)
+	if p.Code != "forbidden" || p.Permission != auth.SearchRead {
+		t.Errorf("problem = %+v, want forbidden for search:read", p)
+	}
+	a.expect(http.StatusOK, "GET", "/api/v1/search?q=john", nil, "X-Test-Subject", "key", "X-Test-Scopes", "search:read")
+	a.expect(http.StatusUnauthorized, "GET", "/api/v1/search?q=john", nil)
+}
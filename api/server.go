@@ -1,34 +1,108 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
-	"gorm.io/gorm"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
 	"github.com/lotusatx/lotus-directory-engine-backend/secrets"
 )
 
 type Server struct {
-	DB       *gorm.DB
-	UserAPI  *UserAPI
-	GroupAPI *GroupAPI
-	RoleAPI  *RoleAPI
+	Store        handlers.DirectoryStore
+	UserAPI      *UserAPI
+	GroupAPI     *GroupAPI
+	RoleAPI      *RoleAPI
+	AuditAPI     *AuditAPI
+	AttributeAPI *AttributeAPI
+	SearchAPI    *SearchAPI
+	BatchAPI     *BatchAPI
+
+	ServiceAccountAPI *ServiceAccountAPI
+
+	// OIDCAPI serves the OpenID Connect provider, which is disabled until
+	// its Provider is set
+	OIDCAPI *OIDCAPI
+
+	// IdempotencyTTL is how long responses to POST requests with an
+	// Idempotency-Key are replayed; zero disables replay
+	IdempotencyTTL time.Duration
+	// IdempotencyLease is how long a request holds its Idempotency-Key before
+	// a retry may take over the key of a request that never finished
+	IdempotencyLease time.Duration
+
+	// Timeouts bound HTTP connections and the graceful shutdown
+	Timeouts Timeouts
+
+	// Authenticator verifies the callers of /api/v1; nil leaves the API open
+	Authenticator auth.Authenticator
+
+	// AdminSubjects are authenticated subjects granted every permission
+	// without a directory user, to bootstrap the built-in role assignments
+	AdminSubjects []string
+
+	// TrustActorHeader attributes unauthenticated mutations to the
+	// client-supplied X-Actor header rather than to anonymous. It is only
+	// meant for local development without an Authenticator.
+	TrustActorHeader bool
+
+	draining atomic.Bool // Set once a shutdown has begun
 }
 
-func NewServer(db *gorm.DB) *Server {
+// NewServer creates a server backed by any DirectoryStore implementation,
+// e.g. handlers.NewPostgresStore for production or handlers.NewMemoryStore for tests.
+// The store is wrapped so that every mutation is recorded in the audit log.
+func NewServer(store handlers.DirectoryStore) *Server {
+	store = handlers.NewAuditedStore(store)
 	return &Server{
-		DB:       db,
-		UserAPI:  &UserAPI{DB: db},
-		GroupAPI: &GroupAPI{DB: db},
-		RoleAPI:  &RoleAPI{DB: db},
+		Store:        store,
+		UserAPI:      &UserAPI{Store: store},
+		GroupAPI:     &GroupAPI{Store: store},
+		RoleAPI:      &RoleAPI{Store: store},
+		AuditAPI:     &AuditAPI{Store: store},
+		AttributeAPI: &AttributeAPI{Store: store},
+		SearchAPI:    &SearchAPI{Store: store},
+		BatchAPI:     &BatchAPI{Store: store},
+
+		ServiceAccountAPI: &ServiceAccountAPI{Store: store},
+		OIDCAPI:           &OIDCAPI{Store: store},
+
+		IdempotencyTTL:   DefaultIdempotencyTTL,
+		IdempotencyLease: DefaultIdempotencyLease,
+		Timeouts:         DefaultTimeouts(),
 	}
 }
 
 func (s *Server) SetupRoutes() http.Handler {
+	router := s.routes()
+	
+	// Setup CORS
+	corsOrigins := []string{"*"} // Default
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		corsOrigins = strings.Split(origins, ",")
+	}
+	
+	c := cors.New(cors.Options{
+		AllowedOrigins: corsOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag", "Accept-Patch", "Idempotent-Replayed"},
+	})
+	
+	return c.Handler(router)
+}
+
+// routes builds the router of every API route. The OpenAPI document must
+// cover each route registered here.
+func (s *Server) routes() *mux.Router {
 	router := mux.NewRouter()
 	
 	// API version prefix
@@ -38,27 +112,51 @@ func (s *Server) SetupRoutes() http.Handler {
 	s.UserAPI.RegisterUserRoutes(apiRouter)
 	s.GroupAPI.RegisterGroupRoutes(apiRouter)
 	s.RoleAPI.RegisterRoleRoutes(apiRouter)
+	s.AuditAPI.RegisterAuditRoutes(apiRouter)
+	s.AttributeAPI.RegisterAttributeRoutes(apiRouter)
+	s.SearchAPI.RegisterSearchRoutes(apiRouter)
+	s.BatchAPI.RegisterBatchRoutes(apiRouter)
+	s.ServiceAccountAPI.RegisterServiceAccountRoutes(apiRouter)
+	s.OIDCAPI.RegisterOIDCClientRoutes(apiRouter)
+	RegisterOpenAPIRoutes(apiRouter)
+	apiRouter.Use(s.authMiddleware, s.permissionsMiddleware, authorizeMiddleware, s.actorMiddleware, s.idempotencyMiddleware)
 	
 	// Health check endpoint
 	router.HandleFunc("/health", s.HealthCheck).Methods("GET")
 	
-	// Setup CORS
-	corsOrigins := []string{"*"} // Default
-	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
-		corsOrigins = strings.Split(origins, ",")
-	}
+	// OpenID Connect provider, authenticated by its own protocol
+	s.OIDCAPI.RegisterOIDCRoutes(router)
 	
-	c := cors.New(cors.Options{
-		AllowedOrigins: corsOrigins,
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"*"},
+	return router
+}
+
+// actorMiddleware attributes mutations to the authenticated caller. Without
+// authentication the caller is anonymous, or named by the X-Actor header when
+// TrustActorHeader is set.
+func (s *Server) actorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := ""
+		if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+			actor = principal.Subject
+		} else if s.TrustActorHeader {
+			actor = r.Header.Get("X-Actor")
+		}
+		if actor == "" {
+			actor = "anonymous"
+		}
+		next.ServeHTTP(w, r.WithContext(handlers.WithActor(r.Context(), actor)))
 	})
-	
-	return c.Handler(router)
 }
 
+// HealthCheck reports ok, or draining with 503 Service Unavailable once a
+// shutdown has begun so that readiness probes take the instance out of rotation
 func (s *Server) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if s.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining","service":"lotus-directory-engine"}`))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"ok","service":"lotus-directory-engine"}`))
 }
@@ -118,7 +216,9 @@ func loadPFXCertificate(pfxFile, password string) (tls.Certificate, error) {
 	return tls.Certificate{}, nil
 }
 
-func (s *Server) Start(port string) error {
+// Start serves the API on port until ctx is cancelled, then shuts down
+// gracefully. It returns nil after a clean shutdown.
+func (s *Server) Start(ctx context.Context, port string) error {
 	handler := s.SetupRoutes()
 	
 	// Try to load TLS configuration
@@ -128,26 +228,22 @@ func (s *Server) Start(port string) error {
 		return err
 	}
 	
+	server := s.newHTTPServer(":"+port, handler, tlsConfig)
+	listen := server.ListenAndServe
 	if tlsConfig != nil {
 		// HTTPS mode
-		server := &http.Server{
-			Addr:      ":" + port,
-			Handler:   handler,
-			TLSConfig: tlsConfig,
-		}
-		
 		log.Printf("Starting HTTPS server on port %s", port)
 		log.Printf("Health check available at: https://localhost:%s/health", port)
 		log.Printf("API endpoints available at: https://localhost:%s/api/v1/", port)
 		
-		return server.ListenAndServeTLS("", "")
+		listen = func() error { return server.ListenAndServeTLS("", "") }
 	} else {
 		// HTTP mode
 		log.Printf("Starting HTTP server on port %s", port)
 		log.Printf("Health check available at: http://localhost:%s/health", port)
 		log.Printf("API endpoints available at: http://localhost:%s/api/v1/", port)
 		log.Printf("Note: For production, configure TLS certificates for HTTPS")
-		
-		return http.ListenAndServe(":"+port, handler)
 	}
-}
\ No newline at end of file
+	
+	return s.serve(ctx, server, listen)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+type ServiceAccountAPI struct {
+	Store handlers.DirectoryStore
+}
+
+// CreateAPIKeyRequest is the body of POST /api/v1/service-accounts/{id}/keys
+type CreateAPIKeyRequest struct {
+	Name         string     `json:"name"`
+	Scopes       []string   `json:"scopes"`        // Permissions such as users:read, or resources such as users
+	AllowedCIDRs []string   `json:"allowed_cidrs"` // Networks the key may be used from; empty allows any
+	ExpiresAt    *time.Time `json:"expires_at"`    // When the key stops working; omit for a key that does not expire
+}
+
+// CreateServiceAccount handles POST /api/v1/service-accounts
+func (sa *ServiceAccountAPI) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var account models.ServiceAccount
+	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+
+	if err := sa.Store.CreateServiceAccount(r.Context(), &account); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// GetServiceAccounts handles GET /api/v1/service-accounts
+func (sa *ServiceAccountAPI) GetServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := sa.Store.GetServiceAccounts(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// GetServiceAccount handles GET /api/v1/service-accounts/{id}
+func (sa *ServiceAccountAPI) GetServiceAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := sa.Store.GetServiceAccount(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account)
+}
+
+// DeleteServiceAccount handles DELETE /api/v1/service-accounts/{id}. The
+// keys of the account stop working immediately.
+func (sa *ServiceAccountAPI) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	if err := sa.Store.DeleteServiceAccount(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateAPIKey handles POST /api/v1/service-accounts/{id}/keys. The response
+// is the only one that contains the key; it is not stored for idempotent
+// replay. Callers cannot grant scopes beyond their own permissions.
+func (sa *ServiceAccountAPI) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if granted, err := auth.ScopePermissions(req.Scopes); err == nil {
+		if err := requirePermissions(r.Context(), granted); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	key := models.APIKey{
+		ServiceAccountID: mux.Vars(r)["id"],
+		Name:             req.Name,
+		Scopes:           req.Scopes,
+		AllowedCIDRs:     req.AllowedCIDRs,
+		ExpiresAt:        req.ExpiresAt,
+	}
+	secret, id, hash, err := auth.NewAPIKey()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	key.ID, key.Hash = id, hash
+	if err := sa.Store.CreateAPIKey(r.Context(), &key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	key.Key = secret
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// DeleteAPIKey handles DELETE /api/v1/service-accounts/{id}/keys/{keyId}
+func (sa *ServiceAccountAPI) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := sa.Store.DeleteAPIKey(r.Context(), vars["id"], vars["keyId"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterServiceAccountRoutes registers the service account and API key routes
+func (sa *ServiceAccountAPI) RegisterServiceAccountRoutes(router *mux.Router) {
+	accountRouter := router.PathPrefix("/service-accounts").Subrouter()
+
+	accountRouter.HandleFunc("", sa.CreateServiceAccount).Methods("POST")
+	accountRouter.HandleFunc("", sa.GetServiceAccounts).Methods("GET")
+	accountRouter.HandleFunc("/{id}", sa.GetServiceAccount).Methods("GET")
+	accountRouter.HandleFunc("/{id}", sa.DeleteServiceAccount).Methods("DELETE")
+	accountRouter.HandleFunc("/{id}/keys", sa.CreateAPIKey).Methods("POST")
+	accountRouter.HandleFunc("/{id}/keys/{keyId}", sa.DeleteAPIKey).Methods("DELETE")
+}
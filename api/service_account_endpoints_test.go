@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestAPIKeyLimit(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts", models.ServiceAccount{ID: "hr-sync", Name: "HR sync"})
+
+	expires := time.Now().Add(time.Hour)
+	var first, second models.APIKey
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "current", Scopes: []string{"users:read"}}), &first)
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "next", Scopes: []string{"users:read"}, ExpiresAt: &expires}), &second)
+	if first.Key == "" || first.ID == second.ID {
+		t.Fatalf("created keys = %+v, %+v", first, second)
+	}
+
+	// Two active keys allow a rotation; a third must wait for one to go
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "third", Scopes: []string{"users:read"}})
+	if code := problemCode(t, rec); code != "api_key_limit_reached" {
+		t.Errorf("code = %q, want api_key_limit_reached", code)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/service-accounts/hr-sync/keys/"+first.ID, nil)
+	a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "third", Scopes: []string{"users:read"}})
+
+	// The key itself is only returned when it is created
+	var account models.ServiceAccount
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/service-accounts/hr-sync", nil), &account)
+	if len(account.Keys) != 2 {
+		t.Fatalf("keys = %+v", account.Keys)
+	}
+	for _, key := range account.Keys {
+		if key.Key != "" || key.Hash != "" {
+			t.Errorf("listed key exposes its secret: %+v", key)
+		}
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts", models.ServiceAccount{ID: "hr-sync", Name: "HR sync"})
+	var key models.APIKey
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "reader", Scopes: []string{"users:read"}}), &key)
+
+	a.server.Authenticator = a.server.APIKeyAuthenticator(nil)
+	a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil, auth.APIKeyHeader, key.Key)
+	rec := a.expect(http.StatusForbidden, "DELETE", "/api/v1/users/UI000001", nil, auth.APIKeyHeader, key.Key)
+	if code := problemCode(t, rec); code != "forbidden" {
+		t.Errorf("code = %q, want forbidden", code)
+	}
+	a.expect(http.StatusUnauthorized, "GET", "/api/v1/users/UI000001", nil, auth.APIKeyHeader, key.Key+"x")
+	a.expect(http.StatusUnauthorized, "GET", "/api/v1/users/UI000001", nil)
+
+	// A key cannot create keys with more permissions than its own
+	a.server.Authenticator = nil
+	decode(t, a.expect(http.StatusCreated, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "admin", Scopes: []string{"service-accounts"}}), &key)
+	a.server.Authenticator = a.server.APIKeyAuthenticator(nil)
+	rec = a.expect(http.StatusForbidden, "POST", "/api/v1/service-accounts/hr-sync/keys",
+		CreateAPIKeyRequest{Name: "escalated", Scopes: []string{"users"}}, auth.APIKeyHeader, key.Key)
+	if code := problemCode(t, rec); code != "forbidden" {
+		t.Errorf("code = %q, want forbidden", code)
+	}
+}
@@ -6,26 +6,30 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
-	"gorm.io/gorm"
 )
 
 type UserAPI struct {
-	DB *gorm.DB
+	Store handlers.DirectoryStore
 }
 
 // CreateUser handles POST /api/users
 func (ua *UserAPI) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
+		return
+	}
+	if err := requireUserRelationships(r.Context(), ua.Store, nil, &user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := handlers.CreateUser(ua.DB, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ua.Store.CreateUser(r.Context(), &user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
@@ -36,21 +40,33 @@ func (ua *UserAPI) GetUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
-	user, err := handlers.GetUserByID(ua.DB, userID)
+	user, err := ua.Store.GetUserByID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
 // GetAllUsers handles GET /api/users
 func (ua *UserAPI) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := handlers.GetAllUsers(ua.DB)
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts.Statuses, err = userStatusesParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	users, err := ua.Store.GetAllUsers(r.Context(), opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -63,20 +79,92 @@ func (ua *UserAPI) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		writeError(w, r, errInvalidJSON)
 		return
 	}
 
-	// Ensure the ID matches the URL parameter
+	// Ensure the ID matches the URL parameter; the version comes from If-Match only
 	user.ID = userID
+	user.Version = expectedVersion
+
+	err = ua.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetUserByID(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+		if err := requireUserRelationships(r.Context(), tx, current, &user); err != nil {
+			return err
+		}
+		return tx.UpdateUser(r.Context(), &user)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err := handlers.UpdateUser(ua.DB, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	setETag(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// PatchUser handles PATCH /api/users/{id}
+// The body is a JSON Merge Patch or a JSON Patch, chosen by Content-Type. The
+// user is loaded, patched and saved in one transaction. A failed JSON Patch
+// test operation is a conflict with the current state (409, RFC 5789).
+func (ua *UserAPI) PatchUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p, err := patchFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var user models.User
+	err = ua.Store.WithTx(r.Context(), func(tx handlers.DirectoryStore) error {
+		current, err := tx.GetUserByID(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+		user = models.User{}
+		if err := applyPatch(p, current, &user, userReadOnlyFields); err != nil {
+			return err
+		}
+		// Without If-Match the write is still conditional on the version that was patched
+		user.Version = current.Version
+		if expectedVersion != 0 {
+			user.Version = expectedVersion
+		}
+		user.Roles = orEmpty(user.Roles)
+		user.GroupIDs = orEmpty(user.GroupIDs)
+		if user.Attributes == nil {
+			user.Attributes = models.Attributes{}
+		}
+		if err := requireUserRelationships(r.Context(), tx, current, &user); err != nil {
+			return err
+		}
+		return tx.UpdateUser(r.Context(), &user)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	setETag(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -86,14 +174,67 @@ func (ua *UserAPI) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
-	if err := handlers.DeleteUser(ua.DB, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := ua.Store.DeleteUser(r.Context(), userID, expectedVersion); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RestoreUser handles POST /api/users/{id}/restore
+func (ua *UserAPI) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+
+	user, err := ua.Store.RestoreUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// SuspendUser handles POST /api/users/{id}/suspend
+func (ua *UserAPI) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	ua.changeStatus(w, r, models.UserStatusSuspended)
+}
+
+// ReactivateUser handles POST /api/users/{id}/reactivate
+func (ua *UserAPI) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	ua.changeStatus(w, r, models.UserStatusActive)
+}
+
+// DeprovisionUser handles POST /api/users/{id}/deprovision
+func (ua *UserAPI) DeprovisionUser(w http.ResponseWriter, r *http.Request) {
+	ua.changeStatus(w, r, models.UserStatusDeprovisioned)
+}
+
+// changeStatus moves the user in the URL to status and writes the updated user
+func (ua *UserAPI) changeStatus(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	userID := vars["id"]
+
+	user, err := ua.Store.ChangeUserStatus(r.Context(), userID, status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setETag(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
 // RegisterUserRoutes registers all user-related routes
 func (ua *UserAPI) RegisterUserRoutes(router *mux.Router) {
 	userRouter := router.PathPrefix("/users").Subrouter()
@@ -102,5 +243,10 @@ func (ua *UserAPI) RegisterUserRoutes(router *mux.Router) {
 	userRouter.HandleFunc("", ua.GetAllUsers).Methods("GET")
 	userRouter.HandleFunc("/{id}", ua.GetUser).Methods("GET")
 	userRouter.HandleFunc("/{id}", ua.UpdateUser).Methods("PUT")
+	userRouter.HandleFunc("/{id}", ua.PatchUser).Methods("PATCH")
 	userRouter.HandleFunc("/{id}", ua.DeleteUser).Methods("DELETE")
+	userRouter.HandleFunc("/{id}/restore", ua.RestoreUser).Methods("POST")
+	userRouter.HandleFunc("/{id}/suspend", ua.SuspendUser).Methods("POST")
+	userRouter.HandleFunc("/{id}/reactivate", ua.ReactivateUser).Methods("POST")
+	userRouter.HandleFunc("/{id}/deprovision", ua.DeprovisionUser).Methods("POST")
 }
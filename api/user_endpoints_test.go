@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestUserCRUD(t *testing.T) {
+	a := newTestAPI(t)
+
+	rec := a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	var user models.User
+	decode(t, rec, &user)
+	if user.ID != "UI000001" || user.Status != models.UserStatusActive || user.Version != 1 {
+		t.Fatalf("created user = %+v", user)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Error("create response has no ETag")
+	}
+
+	rec = a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil)
+	decode(t, rec, &user)
+	if user.Email != "ada@example.com" {
+		t.Errorf("email = %q", user.Email)
+	}
+
+	rec = a.expect(http.StatusOK, "GET", "/api/v1/users", nil)
+	var page handlers.Page[models.User]
+	decode(t, rec, &page)
+	if len(page.Items) != 1 || page.Items[0].ID != "UI000001" {
+		t.Errorf("list = %+v", page.Items)
+	}
+
+	rec = a.expect(http.StatusOK, "PUT", "/api/v1/users/UI000001",
+		models.User{Name: "Ada King", Email: "ada@example.com"}, "If-Match", etag)
+	decode(t, rec, &user)
+	if user.Name != "Ada King" || user.Version != 2 {
+		t.Errorf("updated user = %+v", user)
+	}
+
+	// The old ETag no longer names the current version
+	rec = a.expect(http.StatusPreconditionFailed, "PUT", "/api/v1/users/UI000001",
+		models.User{Name: "Stale", Email: "ada@example.com"}, "If-Match", etag)
+	if code := problemCode(t, rec); code != "version_mismatch" {
+		t.Errorf("code = %q, want version_mismatch", code)
+	}
+
+	rec = a.expect(http.StatusOK, "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"name":"Countess"}`),
+		"Content-Type", "application/merge-patch+json")
+	decode(t, rec, &user)
+	if user.Name != "Countess" || user.Email != "ada@example.com" {
+		t.Errorf("patched user = %+v", user)
+	}
+
+	a.expect(http.StatusNoContent, "DELETE", "/api/v1/users/UI000001", nil)
+	rec = a.expect(http.StatusNotFound, "GET", "/api/v1/users/UI000001", nil)
+	if code := problemCode(t, rec); code != "user_not_found" {
+		t.Errorf("code = %q, want user_not_found", code)
+	}
+	rec = a.expect(http.StatusOK, "GET", "/api/v1/users?include_deleted=true", nil)
+	decode(t, rec, &page)
+	if len(page.Items) != 1 || page.Items[0].DeletedAt.Time.IsZero() {
+		t.Errorf("list with deleted = %+v", page.Items)
+	}
+
+	a.expect(http.StatusOK, "POST", "/api/v1/users/UI000001/restore", nil)
+	a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil)
+}
+
+func TestCreateUserErrors(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{ID: "UI000100", Name: "Ada", Email: "ada@example.com"})
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"duplicate ID", models.User{ID: "UI000100", Name: "Other", Email: "other@example.com"}, http.StatusConflict, "user_already_exists"},
+		{"invalid email", models.User{Name: "Bob", Email: "not-an-email"}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"invalid ID", models.User{ID: "bob", Name: "Bob", Email: "bob@example.com"}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"ID of another entity type", models.User{ID: "GRP001", Name: "Bob", Email: "bob@example.com"}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"malformed JSON", strings.NewReader(`{"name":`), http.StatusBadRequest, "invalid_json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.expect(tt.status, "POST", "/api/v1/users", tt.body)
+			if code := problemCode(t, rec); code != tt.code {
+				t.Errorf("code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestPatchUserJSONPatch(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"})
+
+	var user models.User
+	decode(t, a.expect(http.StatusOK, "PATCH", "/api/v1/users/UI000001",
+		strings.NewReader(`[{"op":"test","path":"/name","value":"Ada"},{"op":"replace","path":"/name","value":"Ada King"}]`),
+		"Content-Type", "application/json-patch+json"), &user)
+	if user.Name != "Ada King" {
+		t.Errorf("patched user = %+v", user)
+	}
+
+	tests := []struct {
+		name   string
+		patch  string
+		status int
+		code   string
+	}{
+		{"failed test", `[{"op":"test","path":"/name","value":"Ada"},{"op":"replace","path":"/name","value":"Other"}]`, http.StatusConflict, "patch_test_failed"},
+		{"missing path", `[{"op":"remove","path":"/nickname"}]`, http.StatusConflict, "patch_conflict"},
+		{"malformed", `[{"op":"add","path":"/name"}]`, http.StatusBadRequest, "invalid_patch"},
+		{"read-only field", `[{"op":"replace","path":"/id","value":"UI000002"}]`, http.StatusUnprocessableEntity, "invalid_patch_result"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := a.expect(tt.status, "PATCH", "/api/v1/users/UI000001", strings.NewReader(tt.patch),
+				"Content-Type", "application/json-patch+json")
+			if code := problemCode(t, rec); code != tt.code {
+				t.Errorf("code = %q, want %q", code, tt.code)
+			}
+		})
+	}
+
+	// Failed patches change nothing
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil), &user)
+	if user.Name != "Ada King" || user.Version != 2 {
+		t.Errorf("user after failed patches = %+v", user)
+	}
+}
+
+func TestUserStatusTransitions(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"})
+
+	var user models.User
+	decode(t, a.expect(http.StatusOK, "POST", "/api/v1/users/UI000001/suspend", nil), &user)
+	if user.Status != models.UserStatusSuspended {
+		t.Errorf("status = %q, want suspended", user.Status)
+	}
+	decode(t, a.expect(http.StatusOK, "POST", "/api/v1/users/UI000001/reactivate", nil), &user)
+	if user.Status != models.UserStatusActive {
+		t.Errorf("status = %q, want active", user.Status)
+	}
+	a.expect(http.StatusOK, "POST", "/api/v1/users/UI000001/deprovision", nil)
+	rec := a.expect(http.StatusConflict, "POST", "/api/v1/users/UI000001/reactivate", nil)
+	if code := problemCode(t, rec); code != "invalid_status_transition" {
+		t.Errorf("code = %q, want invalid_status_transition", code)
+	}
+}
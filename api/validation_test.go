@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// Every write path checks the same rules and lists the failing fields
+func TestEntityValidation(t *testing.T) {
+	a := newTestAPI(t)
+	a.expect(http.StatusCreated, "POST", "/api/v1/users", models.User{Name: "Ada", Email: "ada@example.com"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{Name: "Engineering"})
+	a.expect(http.StatusCreated, "POST", "/api/v1/roles", models.Role{Name: "developer"})
+	long := strings.Repeat("x", 257)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        interface{}
+		contentType string
+		fields      []string
+	}{
+		{"create user without fields", "POST", "/api/v1/users", models.User{}, "", []string{"email", "name"}},
+		{"create user with long name", "POST", "/api/v1/users", models.User{Name: long, Email: "bob@example.com"}, "", []string{"name"}},
+		{"create user with display name email", "POST", "/api/v1/users", models.User{Name: "Bob", Email: "Bob <bob@example.com>"}, "", []string{"email"}},
+		{"create group without name", "POST", "/api/v1/groups", models.Group{Description: "x"}, "", []string{"name"}},
+		{"create group with long description", "POST", "/api/v1/groups", models.Group{Name: "Ops", Description: strings.Repeat("x", 1025)}, "", []string{"description"}},
+		{"create role with long name", "POST", "/api/v1/roles", models.Role{Name: long}, "", []string{"name"}},
+		{"replace user", "PUT", "/api/v1/users/UI000001", models.User{Name: "", Email: "ada"}, "", []string{"email", "name"}},
+		{"replace group", "PUT", "/api/v1/groups/GRP001", models.Group{Name: " "}, "", []string{"name"}},
+		{"replace role", "PUT", "/api/v1/roles/ROLE001", models.Role{Name: long}, "", []string{"name"}},
+		{"merge patch user", "PATCH", "/api/v1/users/UI000001", strings.NewReader(`{"email":"not-an-email"}`), "application/merge-patch+json", []string{"email"}},
+		{"JSON patch group", "PATCH", "/api/v1/groups/GRP001", strings.NewReader(`[{"op":"replace","path":"/name","value":""}]`), "application/json-patch+json", []string{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.contentType != "" {
+				headers = []string{"Content-Type", tt.contentType}
+			}
+			rec := a.expect(http.StatusUnprocessableEntity, tt.method, tt.path, tt.body, headers...)
+			var p struct {
+				Code   string              `json:"code"`
+				Errors []models.FieldError `json:"errors"`
+			}
+			decode(t, rec, &p)
+			var fields []string
+			for _, e := range p.Errors {
+				fields = append(fields, e.Field)
+			}
+			if p.Code != "validation_failed" || !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("problem = %+v, want validation_failed for %v", p, tt.fields)
+			}
+		})
+	}
+
+	// Nothing was changed by the rejected writes
+	var user models.User
+	decode(t, a.expect(http.StatusOK, "GET", "/api/v1/users/UI000001", nil), &user)
+	if user.Name != "Ada" || user.Email != "ada@example.com" || user.Version != 1 {
+		t.Errorf("user = %+v", user)
+	}
+}
+
+// A client-supplied ID must match the ID format of its entity type
+func TestClientIDFormat(t *testing.T) {
+	a := newTestAPI(t)
+	tests := []struct {
+		path string
+		body interface{}
+	}{
+		{"/api/v1/users", models.User{ID: "GRP001", Name: "Ada", Email: "ada@example.com"}},
+		{"/api/v1/groups", models.Group{ID: "ROLE001", Name: "Engineering"}},
+		{"/api/v1/roles", models.Role{ID: "developer", Name: "developer"}},
+	}
+	for _, tt := range tests {
+		rec := a.expect(http.StatusUnprocessableEntity, "POST", tt.path, tt.body)
+		var p struct {
+			Errors []models.FieldError `json:"errors"`
+		}
+		decode(t, rec, &p)
+		if len(p.Errors) != 1 || p.Errors[0].Field != "id" || p.Errors[0].Rule != "format" {
+			t.Errorf("POST %s: errors = %+v, want a format error on id", tt.path, p.Errors)
+		}
+	}
+	a.expect(http.StatusCreated, "POST", "/api/v1/groups", models.Group{ID: "GRP1000", Name: "Engineering"})
+}
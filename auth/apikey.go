@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+const (
+	// APIKeyHeader is the request header carrying an API key
+	APIKeyHeader = "X-API-Key"
+	// apiKeyPrefix marks API keys so that leaked keys are easy to recognize
+	apiKeyPrefix = "lde_"
+	// apiKeyIDBytes and apiKeySecretBytes are the random bytes of the two parts of a key
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+	// maxAPIKeyLength bounds the keys that are parsed
+	maxAPIKeyLength = 128
+)
+
+// NewAPIKey generates an API key. It returns the key, shown to the client
+// once, its public ID and the hash to store.
+func NewAPIKey() (key, id, hash string, err error) {
+	idBytes := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	id = hex.EncodeToString(idBytes)
+	key = apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, id, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 of a key. Keys carry 256 random
+// bits, so a fast hash is enough to make the stored hashes useless to an attacker.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKey returns the public ID of a key
+func ParseAPIKey(key string) (string, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	id, secret, found := strings.Cut(rest, "_")
+	if !ok || !found || len(key) > maxAPIKeyLength || len(id) != 2*apiKeyIDBytes || secret == "" {
+		return "", invalid("malformed API key")
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", invalid("malformed API key")
+	}
+	return id, nil
+}
+
+// APIKeyLookup returns the stored key with the given ID, or nil if there is none
+type APIKeyLookup func(ctx context.Context, id string) (*models.APIKey, error)
+
+// APIKeyAuthenticator authenticates service accounts by the X-API-Key header
+type APIKeyAuthenticator struct {
+	Lookup APIKeyLookup
+
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-For
+	// header names the client, for keys limited to source networks
+	TrustedProxies []netip.Prefix
+}
+
+// Authenticate verifies the API key of r. The caller is the service account
+// of the key, holding the permissions of its scopes.
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrMissingCredentials
+	}
+	id, err := ParseAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := a.Lookup(r.Context(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if stored == nil || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(HashAPIKey(key))) != 1 {
+		return nil, invalid("unknown API key")
+	}
+
+	if !stored.Active(time.Now()) {
+		return nil, invalid("API key has expired")
+	}
+	if len(stored.AllowedCIDRs) > 0 && !a.allowedAddress(r, stored.AllowedCIDRs) {
+		return nil, invalid("API key is not allowed from this address")
+	}
+	perms, err := ScopePermissions(stored.Scopes)
+	if err != nil {
+		return nil, invalid("API key has an %v", err)
+	}
+
+	principal := &Principal{
+		Subject:     "service-account:" + stored.ServiceAccountID,
+		Method:      "api_key",
+		Permissions: perms,
+	}
+	if stored.ExpiresAt != nil {
+		principal.ExpiresAt = *stored.ExpiresAt
+	}
+	return principal, nil
+}
+
+// allowedAddress reports whether the client of r is in one of the networks
+func (a *APIKeyAuthenticator) allowedAddress(r *http.Request, cidrs []string) bool {
+	client, ok := a.clientAddress(r)
+	if !ok {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns the address of the client that sent r. X-Forwarded-For
+// is only honored on connections from a trusted proxy; the client is then the
+// right-most forwarded address that is not a trusted proxy.
+func (a *APIKeyAuthenticator) clientAddress(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !a.trusted(addr) {
+		return addr, true
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+		if !a.trusted(addr) {
+			return addr, true
+		}
+	}
+	return addr, true
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (a *APIKeyAuthenticator) trusted(addr netip.Addr) bool {
+	for _, prefix := range a.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
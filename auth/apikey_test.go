@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, id, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(key, "lde_"+id+"_") || len(id) != 16 {
+		t.Errorf("key %q does not have the form lde_<id>_<secret> with id %q", key, id)
+	}
+	if hash != HashAPIKey(key) || len(hash) != 64 {
+		t.Errorf("hash = %q, want the SHA-256 of the key", hash)
+	}
+	parsed, err := ParseAPIKey(key)
+	if err != nil || parsed != id {
+		t.Errorf("ParseAPIKey() = %q, %v, want %q", parsed, err, id)
+	}
+
+	other, _, _, err := NewAPIKey()
+	if err != nil || other == key {
+		t.Errorf("second key = %q, %v, want a different key", other, err)
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	const id = "0123456789abcdef"
+	tests := []struct {
+		name   string
+		key    string
+		wantID string
+	}{
+		{"valid", "lde_" + id + "_c2VjcmV0", id},
+		// The secret is base64url and may itself contain underscores
+		{"underscores in secret", "lde_" + id + "_a_b__c_", id},
+		{"secret of underscores", "lde_" + id + "__", id},
+		{"empty", "", ""},
+		{"prefix only", "lde_", ""},
+		{"no prefix", id + "_secret", ""},
+		{"other prefix", "ldx_" + id + "_secret", ""},
+		{"uppercase prefix", "LDE_" + id + "_secret", ""},
+		{"no separator", "lde_" + id + "secret", ""},
+		{"empty secret", "lde_" + id + "_", ""},
+		{"empty ID", "lde__secret", ""},
+		{"short ID", "lde_0123_secret", ""},
+		{"long ID", "lde_" + id + "00_secret", ""},
+		{"ID with underscore", "lde_01234567_9abcdef_secret", ""},
+		{"ID not hex", "lde_0123456789abcdeg_secret", ""},
+		{"too long", "lde_" + id + "_" + strings.Repeat("a", maxAPIKeyLength), ""},
+		{"bearer token", "eyJhbGciOiJIUzI1NiJ9.e30.sig", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAPIKey(tt.key)
+			if tt.wantID == "" {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("ParseAPIKey() = %q, %v, want ErrInvalidToken", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.wantID {
+				t.Errorf("ParseAPIKey() = %q, %v, want %q", got, err, tt.wantID)
+			}
+		})
+	}
+}
+
+// testKeyStore holds API keys for an APIKeyAuthenticator
+type testKeyStore map[string]*models.APIKey
+
+func (s testKeyStore) lookup(ctx context.Context, id string) (*models.APIKey, error) {
+	return s[id], nil
+}
+
+// add stores a new key for the service account and returns it
+func (s testKeyStore) add(t *testing.T, key models.APIKey) string {
+	t.Helper()
+	secret, id, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey() error = %v", err)
+	}
+	key.ID, key.Hash = id, hash
+	if key.ServiceAccountID == "" {
+		key.ServiceAccountID = "hr-sync"
+	}
+	s[id] = &key
+	return secret
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store := testKeyStore{}
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	readKey := store.add(t, models.APIKey{Scopes: []string{"users:read", "groups"}, ExpiresAt: &future})
+	expiredKey := store.add(t, models.APIKey{Scopes: []string{"users"}, ExpiresAt: &past})
+	badScopeKey := store.add(t, models.APIKey{Scopes: []string{"users:delete"}})
+	a := &APIKeyAuthenticator{Lookup: store.lookup}
+
+	// Same ID, different secret: only the hash comparison can reject it
+	id, _ := ParseAPIKey(readKey)
+	forged := "lde_" + id + "_" + strings.Repeat("A", 43)
+	unknown := "lde_ffffffffffffffff_" + strings.Repeat("A", 43)
+
+	tests := []struct {
+		name string
+		key  string
+		want error
+	}{
+		{"no key", "", ErrMissingCredentials},
+		{"malformed", "not-a-key", ErrInvalidToken},
+		{"wrong secret", forged, ErrInvalidToken},
+		{"secret of another key", readKey[:len(readKey)-1], ErrInvalidToken},
+		{"unknown ID", unknown, ErrInvalidToken},
+		{"expired", expiredKey, ErrInvalidToken},
+		{"unknown scope", badScopeKey, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/users", nil)
+			if tt.key != "" {
+				r.Header.Set(APIKeyHeader, tt.key)
+			}
+			principal, err := a.Authenticate(r)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() = %+v, %v, want %v", principal, err, tt.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/users", nil)
+	r.Header.Set(APIKeyHeader, readKey)
+	principal, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if principal.Subject != "service-account:hr-sync" || principal.Method != "api_key" || !principal.ExpiresAt.Equal(future) {
+		t.Errorf("principal = %+v", principal)
+	}
+	want := PermissionSet{UsersRead: true, GroupsRead: true, GroupsWrite: true}
+	if len(principal.Permissions) != len(want) {
+		t.Errorf("permissions = %v, want %v", principal.Permissions, want)
+	}
+	for p := range want {
+		if !principal.Permissions.Has(p) {
+			t.Errorf("permissions = %v, want %v", principal.Permissions, want)
+		}
+	}
+}
+
+func TestScopePermissions(t *testing.T) {
+	tests := []struct {
+		scopes  []string
+		want    []Permission
+		wantErr bool
+	}{
+		{[]string{}, nil, false},
+		{[]string{"users:read"}, []Permission{UsersRead}, false},
+		{[]string{"users"}, []Permission{UsersRead, UsersWrite}, false},
+		{[]string{"roles"}, []Permission{RolesRead, RolesWrite, RolesAssign}, false},
+		{[]string{"service-accounts"}, []Permission{ServiceAccountsRead, ServiceAccountsWrite}, false},
+		{[]string{"users:read", "users", "audit:read"}, []Permission{UsersRead, UsersWrite, AuditRead}, false},
+		{[]string{"credentials:write"}, []Permission{CredentialsWrite}, false},
+		{[]string{"users:delete"}, nil, true},
+		{[]string{"Users"}, nil, true},
+		{[]string{"users:"}, nil, true},
+		{[]string{""}, nil, true},
+		{[]string{"*"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.scopes, " "), func(t *testing.T) {
+			got, err := ScopePermissions(tt.scopes)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ScopePermissions() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ScopePermissions() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("ScopePermissions() = %v, want %v", got, tt.want)
+			}
+			for _, p := range tt.want {
+				if !got.Has(p) {
+					t.Errorf("ScopePermissions() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	tests := []struct {
+		name      string
+		trusted   []netip.Prefix
+		remote    string
+		forwarded []string
+		want      string // Empty when the address cannot be determined
+	}{
+		{"direct", nil, "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"no port", nil, "203.0.113.5", nil, "203.0.113.5"},
+		{"IPv6", nil, "[2001:db8::1]:4000", nil, "2001:db8::1"},
+		{"IPv4-mapped IPv6", nil, "[::ffff:203.0.113.5]:4000", nil, "203.0.113.5"},
+		{"unparsable remote", nil, "proxy.internal:4000", nil, ""},
+		// Without trusted proxies the header is ignored, so it cannot be spoofed
+		{"forwarded from untrusted", nil, "203.0.113.5:4000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"forwarded from untrusted with proxies", proxies, "203.0.113.5:4000", []string{"10.1.1.1"}, "203.0.113.5"},
+		{"trusted without header", proxies, "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"trusted proxy", proxies, "10.0.0.2:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		// A client can put anything at the front; the right-most untrusted hop is used
+		{"spoofed first hop", proxies, "10.0.0.2:4000", []string{"192.0.2.1, 198.51.100.7"}, "198.51.100.7"},
+		{"proxy chain", proxies, "10.0.0.2:4000", []string{"198.51.100.7, 10.0.0.9", "10.0.0.3"}, "198.51.100.7"},
+		{"IPv6 proxy", proxies, "[fd00::2]:4000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"only proxies", proxies, "10.0.0.2:4000", []string{"10.0.0.9"}, "10.0.0.9"},
+		{"malformed hop", proxies, "10.0.0.2:4000", []string{"198.51.100.7, garbage"}, ""},
+		{"hop with port", proxies, "10.0.0.2:4000", []string{"198.51.100.7:1234"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIKeyAuthenticator{TrustedProxies: tt.trusted}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			got, ok := a.clientAddress(r)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("clientAddress() = %v, want no address", got)
+				}
+				return
+			}
+			if !ok || got != netip.MustParseAddr(tt.want) {
+				t.Errorf("clientAddress() = %v, %v, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAllowedCIDRs(t *testing.T) {
+	store := testKeyStore{}
+	key := store.add(t, models.APIKey{Scopes: []string{"users:read"}, AllowedCIDRs: models.StringList{"198.51.100.0/24", "2001:db8::/32"}})
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name      string
+		trusted   []netip.Prefix
+		remote    string
+		forwarded string
+		allowed   bool
+	}{
+		{"in network", nil, "198.51.100.7:4000", "", true},
+		{"in IPv6 network", nil, "[2001:db8::1]:4000", "", true},
+		{"outside network", nil, "203.0.113.5:4000", "", false},
+		{"spoofed header without trusted proxy", nil, "203.0.113.5:4000", "198.51.100.7", false},
+		{"through trusted proxy", proxies, "10.0.0.2:4000", "198.51.100.7", true},
+		{"outside network through trusted proxy", proxies, "10.0.0.2:4000", "203.0.113.5", false},
+		{"spoofed first hop through trusted proxy", proxies, "10.0.0.2:4000", "198.51.100.7, 203.0.113.5", false},
+		{"malformed header through trusted proxy", proxies, "10.0.0.2:4000", "nonsense", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIKeyAuthenticator{Lookup: store.lookup, TrustedProxies: tt.trusted}
+			r := httptest.NewRequest("GET", "/api/v1/users", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set(APIKeyHeader, key)
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			_, err := a.Authenticate(r)
+			if tt.allowed && err != nil {
+				t.Errorf("Authenticate() error = %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Authenticate() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLeeway tolerates clock skew between the token issuer and this server
+	DefaultLeeway = time.Minute
+	// minSecretLength is the smallest HS256 secret accepted, the size of the hash (RFC 7518)
+	minSecretLength = 32
+	// maxTokenLength bounds the tokens that are parsed
+	maxTokenLength = 8192
+	// jwksRefreshInterval is the shortest time between reloads of the JWKS
+	// file, so tokens with unknown key IDs cannot make every request read it
+	jwksRefreshInterval = time.Minute
+)
+
+// JWTConfig configures a JWTVerifier
+type JWTConfig struct {
+	Issuer   string        // Required value of the iss claim
+	Audience string        // Value the aud claim must contain
+	Secret   []byte        // HS256 key of at least 32 bytes; empty rejects HS256 tokens
+	JWKSFile string        // File with the RS256 and ES256 keys; empty rejects those tokens
+	Leeway   time.Duration // Clock skew tolerated when checking exp and nbf
+}
+
+// JWTVerifier authenticates requests with JWT bearer tokens
+type JWTVerifier struct {
+	cfg JWTConfig
+	now func() time.Time
+
+	mu       sync.RWMutex
+	keys     []verificationKey
+	loadedAt time.Time // When the JWKS file was last read
+}
+
+// NewJWTVerifier checks the configuration and loads the JWKS file, if any
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("the JWT issuer and audience must be configured")
+	}
+	if len(cfg.Secret) > 0 && len(cfg.Secret) < minSecretLength {
+		return nil, fmt.Errorf("the JWT secret must be at least %d bytes", minSecretLength)
+	}
+	v := &JWTVerifier{cfg: cfg, now: time.Now}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys, v.loadedAt = keys, v.now()
+	}
+	if len(cfg.Secret) == 0 && len(v.keys) == 0 {
+		return nil, fmt.Errorf("no JWT signing key configured: set a JWT secret or a JWKS file")
+	}
+	return v, nil
+}
+
+// invalid returns an error matching ErrInvalidToken
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrMissingCredentials
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", invalid("expected Authorization: Bearer <token>")
+	}
+	return token, nil
+}
+
+// Authenticate verifies the bearer token of r
+func (v *JWTVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	token, err := BearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.Verify(token)
+}
+
+// Verify checks the signature and claims of a compact JWS token
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	if len(token) > maxTokenLength {
+		return nil, invalid("token is longer than %d bytes", maxTokenLength)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("token is not a signed JWT")
+	}
+
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed header")
+	}
+	if len(header.Crit) > 0 {
+		return nil, invalid("unsupported critical header parameters")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed signature")
+	}
+	if err := v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("malformed claims")
+	}
+	return v.principal(claims)
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, dest interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// verifySignature checks the signature with the key for alg and kid
+func (v *JWTVerifier) verifySignature(alg, kid, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "HS256":
+		if len(v.cfg.Secret) == 0 {
+			return invalid("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, v.cfg.Secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid("signature verification failed")
+		}
+		return nil
+	case "RS256", "ES256":
+		found, ok := matchKey(v.currentKeys(), alg, kid, digest[:], signature)
+		if !found && v.reloadKeys() {
+			// The issuer may have rotated in a key since the file was read
+			found, ok = matchKey(v.currentKeys(), alg, kid, digest[:], signature)
+		}
+		if !found {
+			return invalid("no %s key matches kid %q", alg, kid)
+		}
+		if !ok {
+			return invalid("signature verification failed")
+		}
+		return nil
+	default:
+		return invalid("unsupported algorithm %q", alg)
+	}
+}
+
+// matchKey checks the signature with each key for alg and kid. It reports
+// whether any key matched and whether one of them verified the signature.
+func matchKey(keys []verificationKey, alg, kid string, digest, signature []byte) (found, ok bool) {
+	for _, key := range keys {
+		if key.alg != alg || (kid != "" && key.kid != kid) {
+			continue
+		}
+		found = true
+		if verifyWithKey(key, digest, signature) {
+			return true, true
+		}
+	}
+	return found, false
+}
+
+// currentKeys returns the keys of the JWKS file as last read
+func (v *JWTVerifier) currentKeys() []verificationKey {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.keys
+}
+
+// reloadKeys rereads the JWKS file unless it was read within
+// jwksRefreshInterval. It reports whether the keys were reloaded; if the file
+// cannot be read the previous keys stay in use.
+func (v *JWTVerifier) reloadKeys() bool {
+	if v.cfg.JWKSFile == "" {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	if now.Sub(v.loadedAt) < jwksRefreshInterval {
+		return false
+	}
+	v.loadedAt = now
+	keys, err := loadJWKS(v.cfg.JWKSFile)
+	if err != nil {
+		return false
+	}
+	v.keys = keys
+	return true
+}
+
+// verifyWithKey checks an RS256 or ES256 signature of digest
+func verifyWithKey(key verificationKey, digest, signature []byte) bool {
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the 32-byte r and s values
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// principal checks the registered claims and returns the caller they describe
+func (v *JWTVerifier) principal(claims map[string]interface{}) (*Principal, error) {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, invalid("token has no expiry")
+	}
+	expiresAt := time.Unix(int64(exp), 0)
+	if now.After(expiresAt.Add(v.cfg.Leeway)) {
+		return nil, invalid("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, invalid("token is not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, invalid("unexpected issuer")
+	}
+	if !hasAudience(claims["aud"], v.cfg.Audience) {
+		return nil, invalid("token is not intended for this audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, invalid("token has no subject")
+	}
+	return &Principal{
+		Subject:   sub,
+		Issuer:    v.cfg.Issuer,
+		Method:    "jwt",
+		ExpiresAt: expiresAt,
+		Claims:    claims,
+	}, nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of strings,
+// contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, value := range a {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example"
+	testAudience = "lotus"
+)
+
+var (
+	testSecret = []byte(strings.Repeat("s", 32))
+	testNow    = time.Unix(1700000000, 0)
+)
+
+// signedToken builds a compact JWS token with the given header and claims,
+// signed by sign
+func signedToken(t *testing.T, header, claims map[string]interface{}, sign func(input string) []byte) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := encode(header) + "." + encode(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign(input))
+}
+
+func hs256(secret []byte) func(string) []byte {
+	return func(input string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(input))
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func(string) []byte {
+	return func(input string) []byte {
+		digest := sha256.Sum256([]byte(input))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return signature
+	}
+}
+
+// es256 signs with the fixed-size r and s encoding of JWS
+func es256(t *testing.T, key *ecdsa.PrivateKey) func(string) []byte {
+	return func(input string) []byte {
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	}
+}
+
+// validClaims returns claims that pass every check at testNow
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "alice",
+		"exp": testNow.Add(time.Hour).Unix(),
+	}
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// writeJWKS writes a JWKS file with the keys to path
+func writeJWKS(t *testing.T, path string, keys ...map[string]interface{}) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+}
+
+// testKeys are generated once, as RSA key generation is slow
+var testKeys = struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}{}
+
+func testKeyPair(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+	if testKeys.rsa == nil {
+		var err error
+		if testKeys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("failed to generate RSA key: %v", err)
+		}
+		if testKeys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatalf("failed to generate EC key: %v", err)
+		}
+	}
+	return testKeys.rsa, testKeys.ec
+}
+
+// newTestVerifier returns a verifier accepting HS256 with testSecret and the
+// keys of a JWKS file holding the RSA key "rsa-1" and the EC key "ec-1"
+func newTestVerifier(t *testing.T) (*JWTVerifier, string) {
+	t.Helper()
+	rsaKey, ecKey := testKeyPair(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	v, err := NewJWTVerifier(JWTConfig{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		Secret:   testSecret,
+		JWKSFile: path,
+		Leeway:   DefaultLeeway,
+	})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier() error = %v", err)
+	}
+	v.now = func() time.Time { return testNow }
+	v.loadedAt = testNow
+	return v, path
+}
+
+func TestVerify(t *testing.T) {
+	v, _ := newTestVerifier(t)
+	rsaKey, ecKey := testKeyPair(t)
+
+	with := func(change func(claims map[string]interface{})) map[string]interface{} {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}
+	es := map[string]interface{}{"alg": "ES256", "kid": "ec-1"}
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"HS256", signedToken(t, hs, validClaims(), hs256(testSecret)), true},
+		{"RS256", signedToken(t, rs, validClaims(), rs256(t, rsaKey)), true},
+		{"ES256", signedToken(t, es, validClaims(), es256(t, ecKey)), true},
+		{"RS256 without kid", signedToken(t, map[string]interface{}{"alg": "RS256"}, validClaims(), rs256(t, rsaKey)), true},
+		{"audience in array", signedToken(t, hs, with(func(c map[string]interface{}) { c["aud"] = []string{"other", testAudience} }), hs256(testSecret)), true},
+		{"expired within leeway", signedToken(t, hs, with(func(c map[string]interface{}) { c["exp"] = testNow.Add(-30 * time.Second).Unix() }), hs256(testSecret)), true},
+		{"nbf within leeway", signedToken(t, hs, with(func(c map[string]interface{}) { c["nbf"] = testNow.Add(30 * time.Second).Unix() }), hs256(testSecret)), true},
+
+		{"expired", signedToken(t, hs, with(func(c map[string]interface{}) { c["exp"] = testNow.Add(-2 * time.Minute).Unix() }), hs256(testSecret)), false},
+		{"no expiry", signedToken(t, hs, with(func(c map[string]interface{}) { delete(c, "exp") }), hs256(testSecret)), false},
+		{"not valid yet", signedToken(t, hs, with(func(c map[string]interface{}) { c["nbf"] = testNow.Add(2 * time.Minute).Unix() }), hs256(testSecret)), false},
+		{"wrong issuer", signedToken(t, hs, with(func(c map[string]interface{}) { c["iss"] = "https://evil.example" }), hs256(testSecret)), false},
+		{"no issuer", signedToken(t, hs, with(func(c map[string]interface{}) { delete(c, "iss") }), hs256(testSecret)), false},
+		{"wrong audience", signedToken(t, hs, with(func(c map[string]interface{}) { c["aud"] = "other" }), hs256(testSecret)), false},
+		{"audience not in array", signedToken(t, hs, with(func(c map[string]interface{}) { c["aud"] = []string{"other"} }), hs256(testSecret)), false},
+		{"no subject", signedToken(t, hs, with(func(c map[string]interface{}) { delete(c, "sub") }), hs256(testSecret)), false},
+		{"wrong secret", signedToken(t, hs, validClaims(), hs256([]byte(strings.Repeat("x", 32)))), false},
+		{"critical header", signedToken(t, map[string]interface{}{"alg": "HS256", "crit": []string{"exp"}}, validClaims(), hs256(testSecret)), false},
+
+		// Algorithm confusion: the public key or no key must not verify a token
+		{"alg none", signedToken(t, map[string]interface{}{"alg": "none"}, validClaims(), func(string) []byte { return nil }), false},
+		{"alg None", signedToken(t, map[string]interface{}{"alg": "None"}, validClaims(), func(string) []byte { return nil }), false},
+		{"HS256 signed with the RSA public key", signedToken(t, map[string]interface{}{"alg": "HS256", "kid": "rsa-1"}, validClaims(), hs256(rsaJWKBytes(&rsaKey.PublicKey))), false},
+		{"RS256 header with ES256 key", signedToken(t, map[string]interface{}{"alg": "RS256", "kid": "ec-1"}, validClaims(), es256(t, ecKey)), false},
+		{"ES256 header with RSA signature", signedToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, validClaims(), rs256(t, rsaKey)), false},
+		{"RS256 with another kid", signedToken(t, map[string]interface{}{"alg": "RS256", "kid": "ec-1"}, validClaims(), rs256(t, rsaKey)), false},
+
+		{"not a JWT", "abc", false},
+		{"two segments", "a.b", false},
+		{"malformed header", "!!!.e30.", false},
+		{"too long", strings.Repeat("a", maxTokenLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := v.Verify(tt.token)
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				if principal.Subject != "alice" || principal.Issuer != testIssuer || principal.Method != "jwt" {
+					t.Errorf("principal = %+v", principal)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() = %+v, %v, want ErrInvalidToken", principal, err)
+			}
+		})
+	}
+}
+
+// rsaJWKBytes returns what an attacker would use as the HMAC key in an
+// algorithm confusion attack: the published public key
+func rsaJWKBytes(key *rsa.PublicKey) []byte {
+	data, _ := json.Marshal(rsaJWK("rsa-1", key))
+	return data
+}
+
+// A verifier without a secret rejects HS256 even when signed with the bytes of
+// its public key
+func TestVerifyRejectsHS256WithoutSecret(t *testing.T) {
+	rsaKey, _ := testKeyPair(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &rsaKey.PublicKey))
+	v, err := NewJWTVerifier(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSFile: path})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier() error = %v", err)
+	}
+	v.now = func() time.Time { return testNow }
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signedToken(t, map[string]interface{}{"alg": "HS256"}, validClaims(), hs256(data))
+	if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+	}
+}
+
+// ES256 signatures are the 64-byte concatenation of r and s, not ASN.1 DER
+func TestVerifyES256Encoding(t *testing.T) {
+	v, _ := newTestVerifier(t)
+	_, ecKey := testKeyPair(t)
+	header := map[string]interface{}{"alg": "ES256", "kid": "ec-1"}
+
+	der := func(input string) []byte {
+		digest := sha256.Sum256([]byte(input))
+		signature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return signature
+	}
+	if _, err := v.Verify(signedToken(t, header, validClaims(), der)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("DER signature: error = %v, want ErrInvalidToken", err)
+	}
+
+	// r and s are left-padded to 32 bytes; a signature with a short r or s
+	// must still verify, so sign until one occurs
+	for i := 0; i < 1000; i++ {
+		claims := validClaims()
+		claims["jti"] = i
+		var short bool
+		token := signedToken(t, header, claims, func(input string) []byte {
+			signature := es256(t, ecKey)(input)
+			short = signature[0] == 0 || signature[32] == 0
+			return signature
+		})
+		if _, err := v.Verify(token); err != nil {
+			t.Fatalf("signature %d: Verify() error = %v", i, err)
+		}
+		if short {
+			return
+		}
+	}
+	t.Log("no signature with a short r or s was produced")
+}
+
+func TestVerifyReloadsJWKSForUnknownKid(t *testing.T) {
+	v, path := newTestVerifier(t)
+	rsaKey, ecKey := testKeyPair(t)
+	now := testNow
+	v.now = func() time.Time { return now }
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	token := signedToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, validClaims(), rs256(t, rotated))
+
+	writeJWKS(t, path, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey), rsaJWK("rsa-2", &rotated.PublicKey))
+	// The file was read when the verifier was created, so it is not reread yet
+	if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() before the refresh interval: error = %v, want ErrInvalidToken", err)
+	}
+
+	now = now.Add(jwksRefreshInterval)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify() after the key rotated in: error = %v", err)
+	}
+
+	// A file that can no longer be read keeps the loaded keys in use
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(jwksRefreshInterval)
+	unknown := signedToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-3"}, validClaims(), rs256(t, rotated))
+	if _, err := v.Verify(unknown); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with an unknown kid: error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("Verify() after a failed reload: error = %v", err)
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, ecKey := testKeyPair(t)
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	offCurve := ecJWK("ec-1", &ecKey.PublicKey)
+	offCurve["y"] = offCurve["x"]
+
+	tests := []struct {
+		name    string
+		keys    []map[string]interface{}
+		want    int
+		wantErr bool
+	}{
+		{"RSA and EC", []map[string]interface{}{rsaJWK("a", &rsaKey.PublicKey), ecJWK("b", &ecKey.PublicKey)}, 2, false},
+		{"skips encryption keys", []map[string]interface{}{rsaJWK("a", &rsaKey.PublicKey), {"kty": "RSA", "use": "enc"}}, 1, false},
+		{"skips other key types", []map[string]interface{}{rsaJWK("a", &rsaKey.PublicKey), {"kty": "oct", "k": "c2VjcmV0"}}, 1, false},
+		{"skips other curves", []map[string]interface{}{rsaJWK("a", &rsaKey.PublicKey), {"kty": "EC", "crv": "P-384"}}, 1, false},
+		{"no usable keys", []map[string]interface{}{{"kty": "oct"}}, 0, true},
+		{"RSA key too small", []map[string]interface{}{rsaJWK("a", &small.PublicKey)}, 0, true},
+		{"point not on curve", []map[string]interface{}{offCurve}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "jwks.json")
+			writeJWKS(t, path, tt.keys...)
+			keys, err := loadJWKS(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadJWKS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.want {
+				t.Errorf("loaded %d keys, want %d", len(keys), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  JWTConfig
+	}{
+		{"no issuer", JWTConfig{Audience: testAudience, Secret: testSecret}},
+		{"no audience", JWTConfig{Issuer: testIssuer, Secret: testSecret}},
+		{"short secret", JWTConfig{Issuer: testIssuer, Audience: testAudience, Secret: []byte("short")}},
+		{"no key", JWTConfig{Issuer: testIssuer, Audience: testAudience}},
+		{"missing JWKS file", JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSFile: filepath.Join(t.TempDir(), "missing.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJWTVerifier(tt.cfg); err == nil {
+				t.Error("NewJWTVerifier() error = nil")
+			}
+		})
+	}
+}
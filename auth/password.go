@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// passwordScheme prefixes the stored password hashes
+	passwordScheme = "pbkdf2-sha256"
+	// passwordIterations is the PBKDF2-HMAC-SHA256 work factor recommended by OWASP
+	passwordIterations = 600000
+	// maxPasswordIterations bounds the work of verifying a stored hash
+	maxPasswordIterations = 10000000
+	// passwordSaltBytes and passwordKeyBytes are the sizes of the salt and derived key
+	passwordSaltBytes = 16
+	passwordKeyBytes  = 32
+
+	// MinPasswordLength and MaxPasswordLength bound user passwords in characters;
+	// the maximum keeps hashing cheap for long inputs
+	MinPasswordLength = 12
+	MaxPasswordLength = 256
+)
+
+// HashPassword returns the PBKDF2 hash of a password to store, formatted as
+// pbkdf2-sha256$<iterations>$<salt>$<key> with base64url salt and key
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(passwordIterations),
+		base64.RawURLEncoding.EncodeToString(salt),
+		base64.RawURLEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// VerifyPassword reports whether password matches a hash from HashPassword
+func VerifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 || iterations > maxPasswordIterations {
+		return false
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
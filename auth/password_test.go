@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	const password = "correct horse battery staple"
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme || parts[1] != strconv.Itoa(passwordIterations) {
+		t.Fatalf("HashPassword() = %q", hash)
+	}
+	if strings.Contains(hash, password) {
+		t.Errorf("hash contains the password")
+	}
+
+	if !VerifyPassword(hash, password) {
+		t.Errorf("VerifyPassword() = false for the hashed password")
+	}
+	for _, wrong := range []string{"", "Correct horse battery staple"} {
+		if VerifyPassword(hash, wrong) {
+			t.Errorf("VerifyPassword(%q) = true", wrong)
+		}
+	}
+
+	// Each hash has its own salt
+	again, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if again == hash || strings.Split(again, "$")[2] == parts[2] {
+		t.Errorf("two hashes share a salt: %q, %q", hash, again)
+	}
+}
+
+// testPasswordHash formats a hash like HashPassword with a cheap work factor
+func testPasswordHash(t *testing.T, password, salt string, iterations int) string {
+	t.Helper()
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), iterations, passwordKeyBytes)
+	if err != nil {
+		t.Fatalf("pbkdf2.Key() error = %v", err)
+	}
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(iterations),
+		base64.RawURLEncoding.EncodeToString([]byte(salt)),
+		base64.RawURLEncoding.EncodeToString(key),
+	}, "$")
+}
+
+func TestVerifyPassword(t *testing.T) {
+	const password = "a long enough password"
+	valid := testPasswordHash(t, password, "0123456789abcdef", 1000)
+	if !VerifyPassword(valid, password) {
+		t.Fatalf("VerifyPassword() = false for %q", valid)
+	}
+	parts := strings.Split(valid, "$")
+	join := func(p ...string) string { return strings.Join(p, "$") }
+	otherSalt := strings.Split(testPasswordHash(t, password, "fedcba9876543210", 1000), "$")[2]
+	otherKey := strings.Split(testPasswordHash(t, "another password", "0123456789abcdef", 1000), "$")[3]
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"unknown scheme", join("pbkdf2-sha1", parts[1], parts[2], parts[3])},
+		{"missing part", join(parts[0], parts[1], parts[2])},
+		{"extra part", valid + "$x"},
+		{"non-numeric iterations", join(parts[0], "many", parts[2], parts[3])},
+		{"zero iterations", join(parts[0], "0", parts[2], parts[3])},
+		{"changed iterations", join(parts[0], "1001", parts[2], parts[3])},
+		// An unbounded work factor in a stored hash would let it stall logins
+		{"too many iterations", join(parts[0], strconv.Itoa(maxPasswordIterations+1), parts[2], parts[3])},
+		{"invalid salt", join(parts[0], parts[1], "not base64!", parts[3])},
+		{"changed salt", join(parts[0], parts[1], otherSalt, parts[3])},
+		{"invalid key", join(parts[0], parts[1], parts[2], "not base64!")},
+		{"empty key", join(parts[0], parts[1], parts[2], "")},
+		{"changed key", join(parts[0], parts[1], parts[2], otherKey)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if VerifyPassword(tt.hash, password) {
+				t.Errorf("VerifyPassword(%q) = true", tt.hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Package auth authenticates API callers.
+//
+// Callers present a JWT in the Authorization header:
+//
+//	Authorization: Bearer <token>
+//
+// Tokens are signed with HS256 using a shared secret, or with RS256 or ES256
+// using a key from a local JWKS file. The issuer, audience and expiry are
+// always checked.
+//
+// Service accounts present an API key instead:
+//
+//	X-API-Key: lde_<id>_<secret>
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrMissingCredentials is returned for a request without credentials
+	ErrMissingCredentials = errors.New("missing credentials")
+	// ErrInvalidToken is returned for credentials that cannot be verified
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject   string                 // Stable ID of the caller, the sub claim of a JWT
+	Issuer    string                 // Who vouched for the caller, the iss claim of a JWT
+	Method    string                 // How the caller authenticated, e.g. jwt
+	ExpiresAt time.Time              // When the credentials expire
+	Claims    map[string]interface{} // All claims of the token
+
+	// Permissions granted by the credential itself, such as the scopes of an
+	// API key; nil when the caller is authorized by its directory roles
+	Permissions PermissionSet
+}
+
+// Authenticator verifies the credentials of a request
+type Authenticator interface {
+	// Authenticate returns the caller of r, or an error matching
+	// ErrMissingCredentials or ErrInvalidToken
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each Authenticator in turn. The first one whose credentials
+// are present in the request decides.
+type Chain []Authenticator
+
+// Authenticate returns the caller of r from the first Authenticator that
+// finds credentials
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(r)
+		if errors.Is(err, ErrMissingCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrMissingCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller stored by WithPrincipal, or nil
+// when the request was not authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
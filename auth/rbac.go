@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Permission allows one kind of API call
+type Permission string
+
+// Permissions checked by the API routes
+const (
+	UsersRead       Permission = "users:read"
+	UsersWrite      Permission = "users:write"
+	GroupsRead      Permission = "groups:read"
+	GroupsWrite     Permission = "groups:write" // Groups, their members and nested groups
+	RolesRead       Permission = "roles:read"
+	RolesWrite      Permission = "roles:write"  // Roles and the groups they are granted to
+	RolesAssign     Permission = "roles:assign" // Roles of individual users
+	AttributesRead  Permission = "attributes:read"
+	AttributesWrite Permission = "attributes:write"
+	AuditRead       Permission = "audit:read"
+	SearchRead      Permission = "search:read"
+
+	ServiceAccountsRead  Permission = "service-accounts:read"
+	ServiceAccountsWrite Permission = "service-accounts:write" // Service accounts and their API keys
+
+	OIDCClientsRead  Permission = "oidc-clients:read"
+	OIDCClientsWrite Permission = "oidc-clients:write"
+	CredentialsWrite Permission = "credentials:write" // Passwords users log in with at the OpenID Connect provider
+)
+
+// AllPermissions lists every permission
+var AllPermissions = []Permission{
+	UsersRead, UsersWrite, GroupsRead, GroupsWrite, RolesRead, RolesWrite, RolesAssign,
+	AttributesRead, AttributesWrite, AuditRead, SearchRead, ServiceAccountsRead, ServiceAccountsWrite,
+	OIDCClientsRead, OIDCClientsWrite, CredentialsWrite,
+}
+
+// Built-in directory role IDs. The roles are seeded by the builtin_roles
+// migration and granted like any other role, directly or through a group.
+const (
+	RoleDirectoryAdmin  = "directory-admin"
+	RoleDirectoryReader = "directory-reader"
+	RoleGroupManager    = "group-manager"
+	RoleRoleManager     = "role-manager"
+)
+
+// BuiltinRole is a directory role that grants permissions on the directory itself
+type BuiltinRole struct {
+	ID          string
+	Description string
+	Permissions []Permission
+}
+
+// BuiltinRoles are the roles that authorize the directory API
+var BuiltinRoles = []BuiltinRole{
+	{
+		ID:          RoleDirectoryAdmin,
+		Description: "Full access to the directory API",
+		Permissions: AllPermissions,
+	},
+	{
+		ID:          RoleDirectoryReader,
+		Description: "Read users, groups, roles, attributes and the audit log",
+		Permissions: []Permission{UsersRead, GroupsRead, RolesRead, AttributesRead, AuditRead, SearchRead},
+	},
+	{
+		ID:          RoleGroupManager,
+		Description: "Manage groups, their members and nested groups",
+		Permissions: []Permission{UsersRead, GroupsRead, GroupsWrite, AttributesRead, SearchRead},
+	},
+	{
+		ID:          RoleRoleManager,
+		Description: "Manage roles and assign them to users and groups",
+		Permissions: []Permission{UsersRead, GroupsRead, RolesRead, RolesWrite, RolesAssign, SearchRead},
+	},
+}
+
+// PermissionSet is the set of permissions of a caller
+type PermissionSet map[Permission]bool
+
+// Has reports whether the set contains p
+func (s PermissionSet) Has(p Permission) bool {
+	return s[p]
+}
+
+// RolePermissions returns the permissions granted by the built-in roles among
+// roleIDs. Other roles grant no permissions on the directory API.
+func RolePermissions(roleIDs []string) PermissionSet {
+	set := PermissionSet{}
+	for _, id := range roleIDs {
+		for _, role := range BuiltinRoles {
+			if role.ID == id {
+				for _, p := range role.Permissions {
+					set[p] = true
+				}
+			}
+		}
+	}
+	return set
+}
+
+// ScopePermissions expands the scopes of an API key into permissions. A scope
+// is a permission, e.g. users:read, or a resource granting all of its
+// permissions, e.g. users.
+func ScopePermissions(scopes []string) (PermissionSet, error) {
+	set := PermissionSet{}
+	for _, scope := range scopes {
+		matched := false
+		for _, p := range AllPermissions {
+			resource, _, _ := strings.Cut(string(p), ":")
+			if scope == string(p) || scope == resource {
+				set[p] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return set, nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 // getEnvOrDefault returns the value of an environment variable or a default value
@@ -14,6 +16,21 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getDurationOrDefault returns an environment variable parsed as a duration
+// (e.g. "720h"), or the default value if it is unset or invalid
+func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: invalid duration %q for %s, using %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
 // LoadEnvFile loads environment variables from .env file if it exists
 func LoadEnvFile() {
 	const envFileName = ".env"
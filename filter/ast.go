@@ -0,0 +1,79 @@
+// Package filter parses the filter expressions accepted by the list endpoints,
+// e.g. `email ew "@contoso.com" and (name sw "A" or not status eq "active")`.
+//
+// Parse turns an expression into an AST. Callers decide which fields exist and
+// how each node is evaluated, so an expression never reaches SQL as text.
+package filter
+
+import "fmt"
+
+// Operator is a comparison operator
+type Operator string
+
+// Comparison operators
+const (
+	Eq Operator = "eq" // equal
+	Ne Operator = "ne" // not equal
+	Co Operator = "co" // contains
+	Sw Operator = "sw" // starts with
+	Ew Operator = "ew" // ends with
+	Gt Operator = "gt" // greater than
+	Ge Operator = "ge" // greater than or equal
+	Lt Operator = "lt" // less than
+	Le Operator = "le" // less than or equal
+)
+
+// IsStringMatch reports whether the operator matches part of a string
+func (o Operator) IsStringMatch() bool {
+	return o == Co || o == Sw || o == Ew
+}
+
+// IsOrdering reports whether the operator compares order rather than equality
+func (o Operator) IsOrdering() bool {
+	return o == Gt || o == Ge || o == Lt || o == Le
+}
+
+// Expr is a node of a parsed filter expression
+type Expr interface {
+	String() string
+}
+
+// Comparison compares a field with a literal value. Value is a string,
+// float64 or bool.
+type Comparison struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+// Present matches entities where the field has a non-empty value
+type Present struct {
+	Field string
+}
+
+// And matches entities that match both sides
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches entities that match either side
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches entities that do not match Expr
+type Not struct {
+	Expr Expr
+}
+
+func (c *Comparison) String() string {
+	if s, ok := c.Value.(string); ok {
+		return fmt.Sprintf("%s %s %q", c.Field, c.Op, s)
+	}
+	return fmt.Sprintf("%s %s %v", c.Field, c.Op, c.Value)
+}
+
+func (p *Present) String() string { return p.Field + " pr" }
+func (a *And) String() string     { return "(" + a.Left.String() + " and " + a.Right.String() + ")" }
+func (o *Or) String() string      { return "(" + o.Left.String() + " or " + o.Right.String() + ")" }
+func (n *Not) String() string     { return "not " + n.Expr.String() }
@@ -0,0 +1,132 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind classifies a lexical token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenLParen
+	tokenRParen
+)
+
+// token is one lexical element of an expression. Pos is the byte offset of
+// its first character, used in error messages.
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	input string
+	pos   int
+}
+
+// next returns the next token, or a syntax error for input that cannot start one
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && isSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokenLParen, text: "(", pos: start}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokenRParen, text: ")", pos: start}, nil
+	case c == '"':
+		return l.lexString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return l.lexNumber()
+	case isIdentStart(c):
+		for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+			l.pos++
+		}
+		text := l.input[start:l.pos]
+		return token{kind: tokenIdent, text: text, pos: start}, nil
+	default:
+		return token{}, syntaxError(start, "unexpected character %q", rune(c))
+	}
+}
+
+// lexString reads a double-quoted string with backslash escapes
+func (l *lexer) lexString() (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			text := l.input[start:l.pos]
+			return token{kind: tokenString, text: text, value: b.String(), pos: start}, nil
+		case '\\':
+			if l.pos+1 >= len(l.input) {
+				return token{}, syntaxError(l.pos, "unterminated escape sequence")
+			}
+			escaped := l.input[l.pos+1]
+			switch escaped {
+			case '"', '\\':
+				b.WriteByte(escaped)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return token{}, syntaxError(l.pos, "unknown escape sequence \\%c", escaped)
+			}
+			l.pos += 2
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, syntaxError(start, "unterminated string")
+}
+
+// lexNumber reads an integer or decimal number
+func (l *lexer) lexNumber() (token, error) {
+	start := l.pos
+	if l.input[l.pos] == '-' {
+		l.pos++
+	}
+	for l.pos < len(l.input) && (unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+	text := l.input[start:l.pos]
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, syntaxError(start, "invalid number %q", text)
+	}
+	return token{kind: tokenNumber, text: text, value: value, pos: start}, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
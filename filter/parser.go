@@ -0,0 +1,205 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxLength is the longest expression Parse accepts
+	MaxLength = 4096
+	// maxDepth bounds nesting of parentheses and not, keeping recursion shallow
+	maxDepth = 32
+)
+
+// SyntaxError describes why an expression could not be parsed
+type SyntaxError struct {
+	Pos int // Byte offset of the offending input
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+func syntaxError(pos int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// operators maps the lowercase keyword of each comparison operator to it
+var operators = map[string]Operator{
+	"eq": Eq, "ne": Ne, "co": Co, "sw": Sw, "ew": Ew,
+	"gt": Gt, "ge": Ge, "lt": Lt, "le": Le,
+}
+
+// Parse parses a filter expression. The grammar, from lowest to highest precedence:
+//
+//	expr       = term { "or" term }
+//	term       = factor { "and" factor }
+//	factor     = "not" factor | "(" expr ")" | comparison
+//	comparison = field op value | field "pr"
+//	value      = string | number | "true" | "false"
+//
+// Keywords are case-insensitive. Strings are double-quoted with \" and \\ escapes.
+func Parse(input string) (Expr, error) {
+	if len(input) > MaxLength {
+		return nil, syntaxError(MaxLength, "filter is longer than %d characters", MaxLength)
+	}
+	p := &parser{lex: &lexer{input: input}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenEOF {
+		return nil, syntaxError(0, "empty filter")
+	}
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, syntaxError(p.tok.pos, "unexpected %s", describe(p.tok))
+	}
+	return expr, nil
+}
+
+// parser is a recursive descent parser with one token of lookahead
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// isKeyword reports whether the current token is the given keyword
+func (p *parser) isKeyword(keyword string) bool {
+	return p.tok.kind == tokenIdent && strings.EqualFold(p.tok.text, keyword)
+}
+
+func (p *parser) parseOr(depth int) (Expr, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Expr, error) {
+	left, err := p.parseFactor(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseFactor(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseFactor(depth int) (Expr, error) {
+	if depth >= maxDepth {
+		return nil, syntaxError(p.tok.pos, "filter is nested more than %d levels deep", maxDepth)
+	}
+	switch {
+	case p.isKeyword("not"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseFactor(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	case p.tok.kind == tokenLParen:
+		open := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, syntaxError(p.tok.pos, "expected ) to close ( at position %d, got %s", open, describe(p.tok))
+		}
+		return expr, p.advance()
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	if p.tok.kind != tokenIdent || isReserved(p.tok.text) {
+		return nil, syntaxError(p.tok.pos, "expected field name, got %s", describe(p.tok))
+	}
+	field := p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("pr") {
+		return &Present{Field: field}, p.advance()
+	}
+	if p.tok.kind != tokenIdent {
+		return nil, syntaxError(p.tok.pos, "expected operator after %s, got %s", field, describe(p.tok))
+	}
+	op, ok := operators[strings.ToLower(p.tok.text)]
+	if !ok {
+		return nil, syntaxError(p.tok.pos, "unknown operator %q", p.tok.text)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch {
+	case p.tok.kind == tokenString || p.tok.kind == tokenNumber:
+		value = p.tok.value
+	case p.isKeyword("true"):
+		value = true
+	case p.isKeyword("false"):
+		value = false
+	default:
+		return nil, syntaxError(p.tok.pos, "expected value after %s %s, got %s", field, op, describe(p.tok))
+	}
+	return &Comparison{Field: field, Op: op, Value: value}, p.advance()
+}
+
+// isReserved reports whether an identifier is a keyword rather than a field name
+func isReserved(text string) bool {
+	switch strings.ToLower(text) {
+	case "and", "or", "not", "pr", "true", "false":
+		return true
+	}
+	return false
+}
+
+// describe names a token for error messages
+func describe(tok token) string {
+	if tok.kind == tokenEOF {
+		return "end of filter"
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// and binds tighter than or, and both associate to the left
+		{`a eq "1" or b eq "2" and c eq "3"`, `(a eq "1" or (b eq "2" and c eq "3"))`},
+		{`a eq "1" and b eq "2" or c eq "3"`, `((a eq "1" and b eq "2") or c eq "3")`},
+		{`a pr and b pr and c pr`, `((a pr and b pr) and c pr)`},
+		{`(a eq "1" or b eq "2") and c eq "3"`, `((a eq "1" or b eq "2") and c eq "3")`},
+		// not applies to the factor that follows it only
+		{`not a pr and b pr`, `(not a pr and b pr)`},
+		{`not (a pr or b pr)`, `not (a pr or b pr)`},
+		{`not not a pr`, `not not a pr`},
+		// Keywords are case-insensitive, field names are kept as written
+		{`Name SW "A" AND NOT Email PR`, `(Name sw "A" and not Email pr)`},
+		// Quoting and escapes
+		{`name eq "say \"hi\""`, `name eq "say \"hi\""`},
+		{`name eq "back\\slash"`, `name eq "back\\slash"`},
+		{`name eq "tab\there"`, `name eq "tab\there"`},
+		{`name eq "a or b"`, `name eq "a or b"`},
+		{`name eq ""`, `name eq ""`},
+		{`name co "%_"`, `name co "%_"`},
+		// Numbers and booleans
+		{`age ge 21`, `age ge 21`},
+		{`score lt -1.5`, `score lt -1.5`},
+		{`active eq true`, `active eq true`},
+		{`active ne FALSE`, `active ne false`},
+		{"  a\tpr\n", `a pr`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("Parse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	expr, err := Parse(`a eq "x\"y" and b gt 2.5 and c eq true`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	outer := expr.(*And)
+	inner := outer.Left.(*And)
+	if v := inner.Left.(*Comparison).Value; v != `x"y` {
+		t.Errorf("string value = %#v", v)
+	}
+	if v := inner.Right.(*Comparison).Value; v != 2.5 {
+		t.Errorf("number value = %#v", v)
+	}
+	if v := outer.Right.(*Comparison).Value; v != true {
+		t.Errorf("bool value = %#v", v)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		pos   int
+	}{
+		{"empty", "", 0},
+		{"blank", "   ", 0},
+		{"field only", "name", 4},
+		{"missing value", "name eq", 7},
+		{"unknown operator", `name like "a"`, 5},
+		{"reserved field", `and eq "a"`, 0},
+		{"value is a field", "name eq other", 8},
+		{"unterminated string", `name eq "abc`, 8},
+		{"unterminated escape", `name eq "abc\`, 12},
+		{"unknown escape", `name eq "a\qb"`, 10},
+		{"invalid number", "age eq 1.2.3", 7},
+		{"lone minus", "age eq -", 7},
+		{"unexpected character", `name eq 'a'`, 8},
+		{"unclosed paren", `(name pr`, 8},
+		{"stray close paren", `name pr)`, 7},
+		{"empty parens", `()`, 1},
+		{"dangling and", `name pr and`, 11},
+		{"dangling or", `or name pr`, 0},
+		{"dangling not", `not`, 3},
+		{"missing connective", `a pr b pr`, 5},
+		{"too deep", strings.Repeat("(", 40) + "a pr" + strings.Repeat(")", 40), 32},
+		{"too deep not", strings.Repeat("not ", 40) + "a pr", 128},
+		{"too long", "a eq \"" + strings.Repeat("x", MaxLength) + "\"", MaxLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse() = %v, %v, want a SyntaxError", expr, err)
+			}
+			if syntaxErr.Pos != tt.pos {
+				t.Errorf("Pos = %d, want %d (%v)", syntaxErr.Pos, tt.pos, err)
+			}
+		})
+	}
+}
+
+// TestParseMalformedDoesNotPanic feeds every prefix of some valid filters to
+// Parse, which must return an expression or an error for each
+func TestParseMalformedDoesNotPanic(t *testing.T) {
+	inputs := []string{
+		`email ew "@contoso.com" and (name sw "A" or not status eq "active")`,
+		`not (a eq "x\"y\\" or b ge -1.5) and c pr`,
+		`((((a pr))))`,
+	}
+	for _, input := range inputs {
+		for i := 0; i <= len(input); i++ {
+			prefix := input[:i]
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("Parse(%q) panicked: %v", prefix, r)
+					}
+				}()
+				expr, err := Parse(prefix)
+				if (expr == nil) == (err == nil) {
+					t.Errorf("Parse(%q) = %v, %v", prefix, expr, err)
+				}
+			}()
+		}
+	}
+}
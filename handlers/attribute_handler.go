@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// attributeModel returns the model whose attributes column holds values for entityType
+func attributeModel(entityType string) interface{} {
+	if entityType == models.EntityGroup {
+		return &models.Group{}
+	}
+	return &models.User{}
+}
+
+// CreateAttributeDefinition adds an extension attribute to users or groups
+func CreateAttributeDefinition(db *gorm.DB, def *models.AttributeDefinition) error {
+	if err := validateAttributeDefinition(def); err != nil {
+		return fmt.Errorf("failed to create attribute definition: %w", err)
+	}
+	now := time.Now().UTC()
+	def.CreatedAt, def.UpdatedAt = now, now
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkStoredValues(nil, def, countStoredValues(tx, def.EntityType, def.Name)); err != nil {
+			return err
+		}
+		return tx.Create(def).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			err = alreadyExists("attribute definition", attributeKey(def.EntityType, def.Name))
+		}
+		return fmt.Errorf("failed to create attribute definition: %w", err)
+	}
+	return nil
+}
+
+// countStoredValues counts the rows of an entity type, soft-deleted ones
+// included, without a value for an attribute or matching a filter on it
+func countStoredValues(tx *gorm.DB, entityType, name string) storedValueCounter {
+	return func(filter *attributeFilter) (int64, error) {
+		query := tx.Unscoped().Model(attributeModel(entityType))
+		if filter == nil {
+			query = query.Where("attributes -> ? IS NULL", name)
+		} else {
+			doc, err := filter.containment()
+			if err != nil {
+				return 0, err
+			}
+			query = query.Where("attributes @> ?::jsonb", doc)
+		}
+		var n int64
+		if err := query.Count(&n).Error; err != nil {
+			return 0, fmt.Errorf("failed to check stored attribute values: %w", err)
+		}
+		return n, nil
+	}
+}
+
+// GetAttributeDefinitions retrieves the attribute definitions of an entity type ordered by name
+func GetAttributeDefinitions(db *gorm.DB, entityType string) ([]models.AttributeDefinition, error) {
+	defs := []models.AttributeDefinition{}
+	result := db.Where("entity_type = ?", entityType).Order("name").Find(&defs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query attribute definitions: %w", result.Error)
+	}
+	return defs, nil
+}
+
+// GetAttributeDefinition retrieves a single attribute definition
+func GetAttributeDefinition(db *gorm.DB, entityType string, name string) (*models.AttributeDefinition, error) {
+	var def models.AttributeDefinition
+	result := db.Where("entity_type = ? AND name = ?", entityType, name).First(&def)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, notFound("attribute definition", attributeKey(entityType, name))
+		}
+		return nil, fmt.Errorf("failed to get attribute definition: %w", result.Error)
+	}
+	return &def, nil
+}
+
+// UpdateAttributeDefinition changes the description, required flag and enum
+// values of an attribute. A stricter definition is rejected while stored
+// values violate it.
+func UpdateAttributeDefinition(db *gorm.DB, def *models.AttributeDefinition) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var stored models.AttributeDefinition
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entity_type = ? AND name = ?", def.EntityType, def.Name).
+			First(&stored)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return notFound("attribute definition", attributeKey(def.EntityType, def.Name))
+			}
+			return result.Error
+		}
+		if err := validateAttributeDefinition(def); err != nil {
+			return err
+		}
+		if err := checkDefinitionChange(&stored, def); err != nil {
+			return err
+		}
+		if err := checkStoredValues(&stored, def, countStoredValues(tx, def.EntityType, def.Name)); err != nil {
+			return err
+		}
+		return tx.Model(&stored).Updates(map[string]interface{}{
+			"required":    def.Required,
+			"enum_values": def.EnumValues,
+			"description": def.Description,
+			"updated_at":  time.Now().UTC(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update attribute definition: %w", err)
+	}
+	updated, err := GetAttributeDefinition(db, def.EntityType, def.Name)
+	if err != nil {
+		return err
+	}
+	*def = *updated
+	return nil
+}
+
+// DeleteAttributeDefinition removes an attribute definition along with its
+// values on every user or group, including soft-deleted ones
+func DeleteAttributeDefinition(db *gorm.DB, entityType string, name string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("entity_type = ? AND name = ?", entityType, name).Delete(&models.AttributeDefinition{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return notFound("attribute definition", attributeKey(entityType, name))
+		}
+		return tx.Unscoped().Model(attributeModel(entityType)).
+			Where("attributes -> ? IS NOT NULL", name).
+			Updates(map[string]interface{}{
+				"attributes": gorm.Expr("attributes - ?", name),
+				"version":    gorm.Expr("version + 1"),
+			}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete attribute definition: %w", err)
+	}
+	return nil
+}
+
+// entityAttributes validates attribute values against the definitions of an
+// entity type and returns them in canonical form
+func entityAttributes(db *gorm.DB, entityType string, attrs models.Attributes) (models.Attributes, error) {
+	defs, err := GetAttributeDefinitions(db, entityType)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeAttributes(defs, attrs)
+}
+
+// whereAttributes narrows a user or group query to rows matching the attr.<name> filters
+func whereAttributes(db *gorm.DB, query *gorm.DB, entityType string, filters map[string]string) (*gorm.DB, error) {
+	if len(filters) == 0 {
+		return query, nil
+	}
+	defs, err := GetAttributeDefinitions(db, entityType)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseAttributeFilters(defs, filters)
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range parsed {
+		doc, err := filter.containment()
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("attributes @> ?::jsonb", doc)
+	}
+	return query, nil
+}
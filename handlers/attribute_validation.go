@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// ErrInvalidAttribute is returned when an attribute definition, an attribute
+// value or an attribute filter does not match the attribute schema
+var ErrInvalidAttribute error = newError(ErrValidation, "invalid_attribute", "invalid attribute")
+
+// attributeDateLayout is the format of date attribute values
+const attributeDateLayout = "2006-01-02"
+
+// attributeNamePattern restricts attribute names to identifiers that are safe in
+// query parameters and JSON paths
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// attributeKey identifies an attribute definition as <entity type>.<name>
+func attributeKey(entityType, name string) string {
+	return entityType + "." + name
+}
+
+// invalidAttribute builds an error wrapping ErrInvalidAttribute
+func invalidAttribute(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAttribute, fmt.Sprintf(format, args...))
+}
+
+// validateAttributeDefinition checks that a definition is well formed
+func validateAttributeDefinition(def *models.AttributeDefinition) error {
+	if def.EntityType != models.EntityUser && def.EntityType != models.EntityGroup {
+		return invalidAttribute("entity type must be user or group, got %q", def.EntityType)
+	}
+	if !attributeNamePattern.MatchString(def.Name) {
+		return invalidAttribute("name %q must be lowercase letters, digits and underscores, starting with a letter", def.Name)
+	}
+	switch def.Type {
+	case models.AttributeString, models.AttributeInt, models.AttributeBool, models.AttributeDate:
+		if len(def.EnumValues) > 0 {
+			return invalidAttribute("%s: enum_values are only allowed on enum attributes", def.Name)
+		}
+	case models.AttributeEnum:
+		if len(def.EnumValues) == 0 {
+			return invalidAttribute("%s: enum attributes need at least one enum value", def.Name)
+		}
+	default:
+		return invalidAttribute("%s: unknown type %q", def.Name, def.Type)
+	}
+	if def.EnumValues == nil {
+		def.EnumValues = models.StringList{}
+	}
+	return nil
+}
+
+// checkDefinitionChange rejects changes that would invalidate stored values.
+// Type and multi_valued are fixed once a definition exists.
+func checkDefinitionChange(stored, updated *models.AttributeDefinition) error {
+	if stored.Type != updated.Type || stored.MultiValued != updated.MultiValued {
+		return invalidAttribute("%s: type and multi_valued cannot be changed", stored.Name)
+	}
+	return nil
+}
+
+// storedValueCounter counts the users or groups, soft-deleted ones included,
+// that have no value for an attribute when filter is nil, or that match filter
+type storedValueCounter func(filter *attributeFilter) (int64, error)
+
+// checkStoredValues rejects a definition that stored values would violate: a
+// newly required attribute that some entities lack, or dropped enum values
+// that are still in use. stored is nil for a new definition.
+func checkStoredValues(stored, def *models.AttributeDefinition, count storedValueCounter) error {
+	if def.Required && (stored == nil || !stored.Required) {
+		n, err := count(nil)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return invalidAttribute("%s cannot be required while %d %ss have no value", def.Name, n, def.EntityType)
+		}
+	}
+	if stored == nil {
+		return nil
+	}
+	for _, value := range stored.EnumValues {
+		if slices.Contains(def.EnumValues, value) {
+			continue
+		}
+		n, err := count(&attributeFilter{name: def.Name, value: value, multi: def.MultiValued})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return invalidAttribute("%s: enum value %q cannot be removed while %d %ss use it", def.Name, value, n, def.EntityType)
+		}
+	}
+	return nil
+}
+
+// normalizeAttributes validates attrs against the definitions of an entity type
+// and returns a copy with values in canonical form: ints as int64, dates as
+// YYYY-MM-DD strings. Null values are dropped. Required attributes must be set.
+func normalizeAttributes(defs []models.AttributeDefinition, attrs models.Attributes) (models.Attributes, error) {
+	byName := make(map[string]*models.AttributeDefinition, len(defs))
+	for i := range defs {
+		byName[defs[i].Name] = &defs[i]
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	normalized := models.Attributes{}
+	for _, name := range names {
+		value := attrs[name]
+		def, ok := byName[name]
+		if !ok {
+			return nil, invalidAttribute("unknown attribute %q", name)
+		}
+		if value == nil {
+			continue
+		}
+		canonical, err := normalizeAttributeValue(def, value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[name] = canonical
+	}
+	for _, def := range defs {
+		if _, ok := normalized[def.Name]; def.Required && !ok {
+			return nil, invalidAttribute("%s is required", def.Name)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeAttributeValue validates one value, or list of values for a multi-valued attribute
+func normalizeAttributeValue(def *models.AttributeDefinition, value interface{}) (interface{}, error) {
+	if !def.MultiValued {
+		return normalizeScalar(def, value)
+	}
+	var items []interface{}
+	switch list := value.(type) {
+	case []interface{}:
+		items = list
+	case []string:
+		for _, item := range list {
+			items = append(items, item)
+		}
+	default:
+		return nil, invalidAttribute("%s must be a list", def.Name)
+	}
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		canonical, err := normalizeScalar(def, item)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = canonical
+	}
+	return values, nil
+}
+
+// normalizeScalar validates a single value against the definition's type
+func normalizeScalar(def *models.AttributeDefinition, value interface{}) (interface{}, error) {
+	switch def.Type {
+	case models.AttributeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case models.AttributeInt:
+		switch n := value.(type) {
+		case int:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) <= 1<<53 {
+				return int64(n), nil
+			}
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case models.AttributeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case models.AttributeDate:
+		if s, ok := value.(string); ok {
+			if date, err := time.Parse(attributeDateLayout, s); err == nil {
+				return date.Format(attributeDateLayout), nil
+			}
+		}
+	case models.AttributeEnum:
+		if s, ok := value.(string); ok {
+			if slices.Contains(def.EnumValues, s) {
+				return s, nil
+			}
+			return nil, invalidAttribute("%s must be one of %v, got %q", def.Name, []string(def.EnumValues), s)
+		}
+	}
+	return nil, invalidAttribute("%s must be of type %s, got %v", def.Name, def.Type, value)
+}
+
+// parseAttributeScalar converts the text of a filter value to the definition's type
+func parseAttributeScalar(def *models.AttributeDefinition, raw string) (interface{}, error) {
+	switch def.Type {
+	case models.AttributeInt:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, invalidAttribute("%s must be of type int, got %q", def.Name, raw)
+		}
+		return n, nil
+	case models.AttributeBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, invalidAttribute("%s must be of type bool, got %q", def.Name, raw)
+		}
+		return b, nil
+	default:
+		return normalizeScalar(def, raw)
+	}
+}
+
+// attributeFilter matches entities whose attribute equals a value, or for a
+// multi-valued attribute, contains it
+type attributeFilter struct {
+	name  string
+	value interface{}
+	multi bool
+}
+
+// parseAttributeFilters resolves the attr.<name> list filters against the
+// definitions of an entity type, sorted by attribute name
+func parseAttributeFilters(defs []models.AttributeDefinition, filters map[string]string) ([]attributeFilter, error) {
+	byName := make(map[string]*models.AttributeDefinition, len(defs))
+	for i := range defs {
+		byName[defs[i].Name] = &defs[i]
+	}
+	parsed := make([]attributeFilter, 0, len(filters))
+	for name, raw := range filters {
+		def, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown attribute %q", ErrInvalidFilter, name)
+		}
+		value, err := parseAttributeScalar(def, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		parsed = append(parsed, attributeFilter{name: name, value: value, multi: def.MultiValued})
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].name < parsed[j].name })
+	return parsed, nil
+}
+
+// containment returns the JSONB document that an attributes column must contain (@>) to match
+func (f attributeFilter) containment() (string, error) {
+	var doc map[string]interface{}
+	if f.multi {
+		doc = map[string]interface{}{f.name: []interface{}{f.value}}
+	} else {
+		doc = map[string]interface{}{f.name: f.value}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode attribute filter: %w", err)
+	}
+	return string(data), nil
+}
+
+// matches reports whether attrs satisfies the filter
+func (f attributeFilter) matches(attrs models.Attributes) bool {
+	value, ok := attrs[f.name]
+	if !ok {
+		return false
+	}
+	if !f.multi {
+		return jsonEqual(value, f.value)
+	}
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		if jsonEqual(item, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual compares two values by their JSON encoding, so that int64(1) and float64(1) are equal
+func jsonEqual(a, b interface{}) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
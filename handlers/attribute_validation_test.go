@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+func TestNormalizeScalar(t *testing.T) {
+	intDef := &models.AttributeDefinition{Name: "n", Type: models.AttributeInt}
+	enumDef := &models.AttributeDefinition{Name: "dept", Type: models.AttributeEnum, EnumValues: models.StringList{"sales", "support"}}
+	tests := []struct {
+		name  string
+		def   *models.AttributeDefinition
+		value interface{}
+		want  interface{} // nil when the value is rejected
+	}{
+		{"string", &models.AttributeDefinition{Type: models.AttributeString}, "x", "x"},
+		{"string from number", &models.AttributeDefinition{Type: models.AttributeString}, float64(1), nil},
+		// JSON numbers decode as float64 and become int64 while exact
+		{"int from float", intDef, float64(42), int64(42)},
+		{"negative int", intDef, float64(-7), int64(-7)},
+		{"int at 2^53", intDef, float64(1 << 53), int64(1 << 53)},
+		{"int at -2^53", intDef, float64(-(1 << 53)), int64(-(1 << 53))},
+		{"int beyond 2^53", intDef, float64(1<<53) * 2, nil},
+		{"fractional int", intDef, 1.5, nil},
+		{"infinite int", intDef, math.Inf(1), nil},
+		{"NaN int", intDef, math.NaN(), nil},
+		{"int from int", intDef, 3, int64(3)},
+		{"int from json.Number", intDef, json.Number("9007199254740993"), int64(9007199254740993)},
+		{"int from fractional json.Number", intDef, json.Number("1.5"), nil},
+		{"int from string", intDef, "42", nil},
+		{"bool", &models.AttributeDefinition{Type: models.AttributeBool}, true, true},
+		{"bool from string", &models.AttributeDefinition{Type: models.AttributeBool}, "true", nil},
+		{"date", &models.AttributeDefinition{Type: models.AttributeDate}, "2025-01-15", "2025-01-15"},
+		{"invalid date", &models.AttributeDefinition{Type: models.AttributeDate}, "2025-02-30", nil},
+		{"date with time", &models.AttributeDefinition{Type: models.AttributeDate}, "2025-01-15T10:00:00Z", nil},
+		{"enum value", enumDef, "sales", "sales"},
+		{"unknown enum value", enumDef, "finance", nil},
+		{"enum values are case-sensitive", enumDef, "Sales", nil},
+		{"enum from number", enumDef, float64(1), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeScalar(tt.def, tt.value)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidAttribute) {
+					t.Errorf("normalizeScalar(%v) = %#v, %v, want ErrInvalidAttribute", tt.value, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("normalizeScalar(%v) = %#v, %v, want %#v", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeAttributes(t *testing.T) {
+	defs := []models.AttributeDefinition{
+		{Name: "badge", Type: models.AttributeInt, Required: true},
+		{Name: "skills", Type: models.AttributeString, MultiValued: true},
+		{Name: "start", Type: models.AttributeDate},
+	}
+	got, err := normalizeAttributes(defs, models.Attributes{
+		"badge":  float64(7),
+		"skills": []interface{}{"go", "sql"},
+		"start":  nil, // Null clears a value
+	})
+	want := models.Attributes{"badge": int64(7), "skills": []interface{}{"go", "sql"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeAttributes() = %#v, %v, want %#v", got, err, want)
+	}
+	got, err = normalizeAttributes(defs, models.Attributes{"badge": float64(7), "skills": []string{"go"}})
+	if err != nil || !reflect.DeepEqual(got["skills"], []interface{}{"go"}) {
+		t.Errorf("normalizeAttributes([]string) = %#v, %v", got, err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		attrs models.Attributes
+		msg   string
+	}{
+		{"missing required", models.Attributes{"skills": []interface{}{"go"}}, "badge is required"},
+		{"null required", models.Attributes{"badge": nil}, "badge is required"},
+		{"unknown attribute", models.Attributes{"badge": float64(1), "team": "x"}, `unknown attribute "team"`},
+		{"scalar for list", models.Attributes{"badge": float64(1), "skills": "go"}, "skills must be a list"},
+		{"invalid list item", models.Attributes{"badge": float64(1), "skills": []interface{}{"go", float64(1)}}, "skills must be of type string"},
+		{"list for scalar", models.Attributes{"badge": []interface{}{float64(1)}}, "badge must be of type int"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := normalizeAttributes(defs, tt.attrs)
+			if !errors.Is(err, ErrInvalidAttribute) || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("normalizeAttributes() error = %v, want %q", err, tt.msg)
+			}
+		})
+	}
+}
+
+func TestAttributeFilters(t *testing.T) {
+	defs := []models.AttributeDefinition{
+		{Name: "badge", Type: models.AttributeInt},
+		{Name: "contractor", Type: models.AttributeBool},
+		{Name: "skills", Type: models.AttributeString, MultiValued: true},
+		{Name: "dept", Type: models.AttributeEnum, EnumValues: models.StringList{"sales"}},
+	}
+	filters, err := parseAttributeFilters(defs, map[string]string{"skills": "go", "badge": "7", "contractor": "true"})
+	if err != nil {
+		t.Fatalf("parseAttributeFilters() error = %v", err)
+	}
+	want := []attributeFilter{
+		{name: "badge", value: int64(7)},
+		{name: "contractor", value: true},
+		{name: "skills", value: "go", multi: true},
+	}
+	if !reflect.DeepEqual(filters, want) {
+		t.Fatalf("parseAttributeFilters() = %#v", filters)
+	}
+
+	// Values compare by their JSON form, so stored float64 and int64 are equal
+	stored := models.Attributes{"badge": float64(7), "contractor": true, "skills": []interface{}{"sql", "go"}}
+	for _, f := range filters {
+		if !f.matches(stored) {
+			t.Errorf("%+v does not match %v", f, stored)
+		}
+	}
+	for _, attrs := range []models.Attributes{
+		{},
+		{"badge": int64(8)},
+		{"skills": []interface{}{"golang"}},
+		{"skills": "go"},
+		{"contractor": "true"},
+	} {
+		for _, f := range filters {
+			if _, ok := attrs[f.name]; ok && f.matches(attrs) {
+				t.Errorf("%+v matches %v", f, attrs)
+			}
+		}
+	}
+
+	containments := map[string]string{
+		"badge":      `{"badge":7}`,
+		"contractor": `{"contractor":true}`,
+		"skills":     `{"skills":["go"]}`,
+	}
+	for _, f := range filters {
+		if doc, err := f.containment(); err != nil || doc != containments[f.name] {
+			t.Errorf("containment() of %s = %s, %v, want %s", f.name, doc, err, containments[f.name])
+		}
+	}
+
+	for _, invalid := range []map[string]string{
+		{"team": "x"},
+		{"badge": "seven"},
+		{"badge": "1.5"},
+		{"contractor": "maybe"},
+		{"dept": "finance"},
+	} {
+		if _, err := parseAttributeFilters(defs, invalid); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("parseAttributeFilters(%v) error = %v, want ErrInvalidFilter", invalid, err)
+		}
+	}
+}
+
+func TestCheckStoredValues(t *testing.T) {
+	stored := &models.AttributeDefinition{EntityType: models.EntityUser, Name: "dept", Type: models.AttributeEnum, EnumValues: models.StringList{"sales", "support", "legal"}}
+	// Two users lack a value and one uses support
+	count := func(filter *attributeFilter) (int64, error) {
+		switch {
+		case filter == nil:
+			return 2, nil
+		case filter.value == "support":
+			return 1, nil
+		}
+		return 0, nil
+	}
+	tests := []struct {
+		name    string
+		stored  *models.AttributeDefinition
+		def     models.AttributeDefinition
+		wantErr string
+	}{
+		{"unchanged", stored, *stored, ""},
+		{"unused enum value removed", stored, models.AttributeDefinition{EnumValues: models.StringList{"sales", "support"}}, ""},
+		{"enum value in use removed", stored, models.AttributeDefinition{EnumValues: models.StringList{"sales"}}, `enum value "support" cannot be removed while 1 users use it`},
+		{"made required", stored, models.AttributeDefinition{Required: true, EnumValues: stored.EnumValues}, "dept cannot be required while 2 users have no value"},
+		{"created required", nil, models.AttributeDefinition{Required: true}, "dept cannot be required while 2 users have no value"},
+		{"created optional", nil, models.AttributeDefinition{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := tt.def
+			def.EntityType, def.Name, def.Type = models.EntityUser, "dept", models.AttributeEnum
+			err := checkStoredValues(tt.stored, &def, count)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkStoredValues() error = %v", err)
+			}
+			if tt.wantErr != "" && (!errors.Is(err, ErrInvalidAttribute) || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkStoredValues() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// auditSortKeys pages the audit log newest first by time, then by ID
+var auditSortKeys = sortKeys{
+	"id":          {column: "audit_log.id"},
+	"occurred_at": {column: "audit_log.occurred_at", time: true},
+}
+
+// AuditFilter narrows an audit log query. Zero values match everything.
+type AuditFilter struct {
+	EntityType string
+	EntityID   string
+	Actor      string
+	Operation  string
+	Since      *time.Time // inclusive
+	Until      *time.Time // exclusive
+	Limit      int
+	Cursor     string // next_cursor of the previous page
+}
+
+// auditPage is the validated paging part of an audit filter
+type auditPage struct {
+	spec    *pageSpec
+	afterID int64 // ID of the cursor's entry; valid when spec.after is set
+}
+
+// page validates the limit and cursor of the filter
+func (f AuditFilter) page() (*auditPage, error) {
+	opts := ListOptions{Sort: "occurred_at", Order: "desc", Limit: f.Limit, Cursor: f.Cursor}
+	spec, err := opts.pageSpec(auditSortKeys)
+	if err != nil {
+		return nil, err
+	}
+	page := &auditPage{spec: spec}
+	if spec.after != nil {
+		if page.afterID, err = strconv.ParseInt(spec.after.ID, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+		}
+	}
+	return page, nil
+}
+
+// follows reports whether entry comes after the cursor, newest first
+func (p *auditPage) follows(entry models.AuditEntry) bool {
+	if p.spec.after == nil {
+		return true
+	}
+	after := p.spec.cursorValue().(time.Time)
+	if c := entry.OccurredAt.Compare(after); c != 0 {
+		return c < 0
+	}
+	return entry.ID < p.afterID
+}
+
+// result trims entries, fetched one past the limit, to a page
+func (p *auditPage) result(entries []models.AuditEntry) *Page[models.AuditEntry] {
+	page := &Page[models.AuditEntry]{Items: entries}
+	if len(entries) > p.spec.limit {
+		page.Items = entries[:p.spec.limit]
+		last := page.Items[p.spec.limit-1]
+		page.NextCursor = pageCursor{
+			List:  p.spec.list,
+			Sort:  p.spec.sort,
+			Desc:  true,
+			Value: last.OccurredAt.Format(time.RFC3339Nano),
+			ID:    strconv.FormatInt(last.ID, 10),
+		}.encode()
+	}
+	return page
+}
+
+// matches reports whether an entry satisfies the filter
+func (f AuditFilter) matches(entry models.AuditEntry) bool {
+	if f.EntityType != "" && entry.EntityType != f.EntityType {
+		return false
+	}
+	if f.EntityID != "" && entry.EntityID != f.EntityID {
+		return false
+	}
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Operation != "" && entry.Operation != f.Operation {
+		return false
+	}
+	if f.Since != nil && entry.OccurredAt.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !entry.OccurredAt.Before(*f.Until) {
+		return false
+	}
+	return true
+}
+
+// RecordAudit appends an entry to the audit log
+func RecordAudit(db *gorm.DB, entry *models.AuditEntry) error {
+	result := db.Create(entry)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record audit entry: %w", result.Error)
+	}
+	return nil
+}
+
+// ListAudit retrieves one page of the audit entries matching the filter, newest first
+func ListAudit(db *gorm.DB, filter AuditFilter) (*Page[models.AuditEntry], error) {
+	page, err := filter.page()
+	if err != nil {
+		return nil, err
+	}
+	query := db.Model(&models.AuditEntry{})
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Operation != "" {
+		query = query.Where("operation = ?", filter.Operation)
+	}
+	if filter.Since != nil {
+		query = query.Where("occurred_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("occurred_at < ?", *filter.Until)
+	}
+	if page.spec.after != nil {
+		query = query.Where("(occurred_at, id) < (?, ?)", page.spec.cursorValue(), page.afterID)
+	}
+
+	entries := []models.AuditEntry{}
+	result := query.Order("occurred_at DESC, id DESC").Limit(page.spec.limit + 1).Find(&entries)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", result.Error)
+	}
+	return page.result(entries), nil
+}
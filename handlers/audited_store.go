@@ -0,0 +1,527 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// AuditedStore wraps a DirectoryStore and records every mutation in the audit
+// log. Each mutation and its audit entry are written in the same transaction.
+type AuditedStore struct {
+	inner DirectoryStore
+}
+
+// NewAuditedStore wraps store so that its mutations are audited
+func NewAuditedStore(store DirectoryStore) *AuditedStore {
+	if audited, ok := store.(*AuditedStore); ok {
+		return audited
+	}
+	return &AuditedStore{inner: store}
+}
+
+// snapshotFunc loads the current state of the audited entity, or nil if it does not exist
+type snapshotFunc func(ctx context.Context, tx DirectoryStore) interface{}
+
+func userSnapshot(userID string) snapshotFunc {
+	return func(ctx context.Context, tx DirectoryStore) interface{} {
+		user, err := tx.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil
+		}
+		return user
+	}
+}
+
+func groupSnapshot(groupID string) snapshotFunc {
+	return func(ctx context.Context, tx DirectoryStore) interface{} {
+		group, err := tx.GetGroupByID(ctx, groupID)
+		if err != nil {
+			return nil
+		}
+		return group
+	}
+}
+
+func roleSnapshot(roleID string) snapshotFunc {
+	return func(ctx context.Context, tx DirectoryStore) interface{} {
+		role, err := tx.GetRoleByID(ctx, roleID)
+		if err != nil {
+			return nil
+		}
+		return role
+	}
+}
+
+func attributeDefinitionSnapshot(entityType, name string) func(id string) snapshotFunc {
+	return func(string) snapshotFunc {
+		return func(ctx context.Context, tx DirectoryStore) interface{} {
+			def, err := tx.GetAttributeDefinition(ctx, entityType, name)
+			if err != nil {
+				return nil
+			}
+			return def
+		}
+	}
+}
+
+func serviceAccountSnapshot(accountID string) snapshotFunc {
+	return func(ctx context.Context, tx DirectoryStore) interface{} {
+		account, err := tx.GetServiceAccount(ctx, accountID)
+		if err != nil {
+			return nil
+		}
+		return account
+	}
+}
+
+func oidcClientSnapshot(clientID string) snapshotFunc {
+	return func(ctx context.Context, tx DirectoryStore) interface{} {
+		client, err := tx.GetOIDCClient(ctx, clientID)
+		if err != nil {
+			return nil
+		}
+		return client
+	}
+}
+
+// mutate runs op in a transaction and records an audit entry describing how
+// the entity changed. entityID is evaluated after op so that it can refer to
+// IDs assigned during creation.
+func (s *AuditedStore) mutate(ctx context.Context, entityType string, entityID func() string, operation string,
+	snapshot func(id string) snapshotFunc, op func(tx DirectoryStore) error) error {
+	return s.inner.WithTx(ctx, func(tx DirectoryStore) error {
+		var before interface{}
+		if id := entityID(); id != "" {
+			before = snapshot(id)(ctx, tx)
+		}
+		if err := op(tx); err != nil {
+			return err
+		}
+		id := entityID()
+		after := snapshot(id)(ctx, tx)
+
+		changes, err := diff(before, after)
+		if err != nil {
+			return fmt.Errorf("failed to compute audit diff: %w", err)
+		}
+		return tx.RecordAudit(ctx, &models.AuditEntry{
+			OccurredAt: time.Now().UTC(),
+			Actor:      ActorFromContext(ctx),
+			EntityType: entityType,
+			EntityID:   id,
+			Operation:  operation,
+			Changes:    changes,
+		})
+	})
+}
+
+func fixedID(id string) func() string {
+	return func() string { return id }
+}
+
+// diff returns the top-level JSON fields whose values differ between before and after
+func diff(before, after interface{}) (models.AuditChanges, error) {
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing field is treated as null so that null-valued fields such as
+	// deleted_at do not show up as changes when an entity appears or disappears
+	changes := models.AuditChanges{}
+	for key, value := range beforeFields {
+		if other := afterFields[key]; !reflect.DeepEqual(value, other) {
+			changes[key] = models.FieldChange{Before: value, After: other}
+		}
+	}
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok && value != nil {
+			changes[key] = models.FieldChange{Before: nil, After: value}
+		}
+	}
+	return changes, nil
+}
+
+// toFields converts an entity to a map of its JSON fields
+func toFields(entity interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if entity == nil || reflect.ValueOf(entity).IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (s *AuditedStore) CreateUser(ctx context.Context, user *models.User) error {
+	return s.mutate(ctx, models.EntityUser, func() string { return user.ID }, "create", userSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateUser(ctx, user) })
+}
+
+func (s *AuditedStore) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	return s.inner.GetUserByID(ctx, userID)
+}
+
+func (s *AuditedStore) GetAllUsers(ctx context.Context, opts ListOptions) (*Page[models.User], error) {
+	return s.inner.GetAllUsers(ctx, opts)
+}
+
+func (s *AuditedStore) UpdateUser(ctx context.Context, user *models.User) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(user.ID), "update", userSnapshot,
+		func(tx DirectoryStore) error { return tx.UpdateUser(ctx, user) })
+}
+
+func (s *AuditedStore) DeleteUser(ctx context.Context, userID string, expectedVersion int64) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(userID), "delete", userSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteUser(ctx, userID, expectedVersion) })
+}
+
+func (s *AuditedStore) RestoreUser(ctx context.Context, userID string) (*models.User, error) {
+	var restored *models.User
+	err := s.mutate(ctx, models.EntityUser, fixedID(userID), "restore", userSnapshot,
+		func(tx DirectoryStore) error {
+			var err error
+			restored, err = tx.RestoreUser(ctx, userID)
+			return err
+		})
+	return restored, err
+}
+
+// statusOperations names the audit operation recorded for each target user status
+var statusOperations = map[string]string{
+	models.UserStatusActive:        "reactivate",
+	models.UserStatusSuspended:     "suspend",
+	models.UserStatusDeprovisioned: "deprovision",
+}
+
+func (s *AuditedStore) ChangeUserStatus(ctx context.Context, userID string, status string) (*models.User, error) {
+	var changed *models.User
+	err := s.mutate(ctx, models.EntityUser, fixedID(userID), statusOperations[status], userSnapshot,
+		func(tx DirectoryStore) error {
+			var err error
+			changed, err = tx.ChangeUserStatus(ctx, userID, status)
+			return err
+		})
+	return changed, err
+}
+
+func (s *AuditedStore) CreateGroup(ctx context.Context, group *models.Group) error {
+	return s.mutate(ctx, models.EntityGroup, func() string { return group.ID }, "create", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateGroup(ctx, group) })
+}
+
+func (s *AuditedStore) GetGroupByID(ctx context.Context, groupID string) (*models.Group, error) {
+	return s.inner.GetGroupByID(ctx, groupID)
+}
+
+func (s *AuditedStore) GetAllGroups(ctx context.Context, opts ListOptions) (*Page[models.Group], error) {
+	return s.inner.GetAllGroups(ctx, opts)
+}
+
+func (s *AuditedStore) UpdateGroup(ctx context.Context, group *models.Group) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(group.ID), "update", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.UpdateGroup(ctx, group) })
+}
+
+func (s *AuditedStore) DeleteGroup(ctx context.Context, groupID string, expectedVersion int64) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(groupID), "delete", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteGroup(ctx, groupID, expectedVersion) })
+}
+
+func (s *AuditedStore) RestoreGroup(ctx context.Context, groupID string) (*models.Group, error) {
+	var restored *models.Group
+	err := s.mutate(ctx, models.EntityGroup, fixedID(groupID), "restore", groupSnapshot,
+		func(tx DirectoryStore) error {
+			var err error
+			restored, err = tx.RestoreGroup(ctx, groupID)
+			return err
+		})
+	return restored, err
+}
+
+func (s *AuditedStore) AddUserToGroup(ctx context.Context, groupID string, userID string) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(groupID), "add_member", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.AddUserToGroup(ctx, groupID, userID) })
+}
+
+func (s *AuditedStore) RemoveUserFromGroup(ctx context.Context, groupID string, userID string) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(groupID), "remove_member", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.RemoveUserFromGroup(ctx, groupID, userID) })
+}
+
+func (s *AuditedStore) AddGroupToGroup(ctx context.Context, parentID string, childID string) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(parentID), "add_member_group", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.AddGroupToGroup(ctx, parentID, childID) })
+}
+
+func (s *AuditedStore) RemoveGroupFromGroup(ctx context.Context, parentID string, childID string) error {
+	return s.mutate(ctx, models.EntityGroup, fixedID(parentID), "remove_member_group", groupSnapshot,
+		func(tx DirectoryStore) error { return tx.RemoveGroupFromGroup(ctx, parentID, childID) })
+}
+
+func (s *AuditedStore) GetGroupMembers(ctx context.Context, groupID string, transitive bool, opts ListOptions) (*GroupMembers, error) {
+	return s.inner.GetGroupMembers(ctx, groupID, transitive, opts)
+}
+
+func (s *AuditedStore) GetUserGroups(ctx context.Context, userID string, transitive bool, opts ListOptions) (*Page[models.Group], error) {
+	return s.inner.GetUserGroups(ctx, userID, transitive, opts)
+}
+
+func (s *AuditedStore) CreateRole(ctx context.Context, role *models.Role) error {
+	return s.mutate(ctx, models.EntityRole, func() string { return role.ID }, "create", roleSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateRole(ctx, role) })
+}
+
+func (s *AuditedStore) GetRoleByID(ctx context.Context, roleID string) (*models.Role, error) {
+	return s.inner.GetRoleByID(ctx, roleID)
+}
+
+func (s *AuditedStore) GetAllRoles(ctx context.Context, opts ListOptions) (*Page[models.Role], error) {
+	return s.inner.GetAllRoles(ctx, opts)
+}
+
+func (s *AuditedStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	return s.mutate(ctx, models.EntityRole, fixedID(role.ID), "update", roleSnapshot,
+		func(tx DirectoryStore) error { return tx.UpdateRole(ctx, role) })
+}
+
+func (s *AuditedStore) DeleteRole(ctx context.Context, roleID string, expectedVersion int64) error {
+	return s.mutate(ctx, models.EntityRole, fixedID(roleID), "delete", roleSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteRole(ctx, roleID, expectedVersion) })
+}
+
+func (s *AuditedStore) RestoreRole(ctx context.Context, roleID string) (*models.Role, error) {
+	var restored *models.Role
+	err := s.mutate(ctx, models.EntityRole, fixedID(roleID), "restore", roleSnapshot,
+		func(tx DirectoryStore) error {
+			var err error
+			restored, err = tx.RestoreRole(ctx, roleID)
+			return err
+		})
+	return restored, err
+}
+
+func (s *AuditedStore) AddGroupToRole(ctx context.Context, roleID string, groupID string) error {
+	return s.mutate(ctx, models.EntityRole, fixedID(roleID), "add_group", roleSnapshot,
+		func(tx DirectoryStore) error { return tx.AddGroupToRole(ctx, roleID, groupID) })
+}
+
+func (s *AuditedStore) RemoveGroupFromRole(ctx context.Context, roleID string, groupID string) error {
+	return s.mutate(ctx, models.EntityRole, fixedID(roleID), "remove_group", roleSnapshot,
+		func(tx DirectoryStore) error { return tx.RemoveGroupFromRole(ctx, roleID, groupID) })
+}
+
+func (s *AuditedStore) GetRoleGroups(ctx context.Context, roleID string, opts ListOptions) (*Page[string], error) {
+	return s.inner.GetRoleGroups(ctx, roleID, opts)
+}
+
+func (s *AuditedStore) AssignRoleToUser(ctx context.Context, userID string, roleID string) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(userID), "assign_role", userSnapshot,
+		func(tx DirectoryStore) error { return tx.AssignRoleToUser(ctx, userID, roleID) })
+}
+
+func (s *AuditedStore) RemoveRoleFromUser(ctx context.Context, userID string, roleID string) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(userID), "remove_role", userSnapshot,
+		func(tx DirectoryStore) error { return tx.RemoveRoleFromUser(ctx, userID, roleID) })
+}
+
+func (s *AuditedStore) GetUserRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	return s.inner.GetUserRoles(ctx, userID, opts)
+}
+
+func (s *AuditedStore) GetEffectiveRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	return s.inner.GetEffectiveRoles(ctx, userID, opts)
+}
+
+func (s *AuditedStore) CreateOIDCClient(ctx context.Context, client *models.OIDCClient) error {
+	return s.mutate(ctx, models.EntityOIDCClient, fixedID(client.ID), "create", oidcClientSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateOIDCClient(ctx, client) })
+}
+
+func (s *AuditedStore) GetOIDCClient(ctx context.Context, clientID string) (*models.OIDCClient, error) {
+	return s.inner.GetOIDCClient(ctx, clientID)
+}
+
+func (s *AuditedStore) GetOIDCClients(ctx context.Context) ([]models.OIDCClient, error) {
+	return s.inner.GetOIDCClients(ctx)
+}
+
+func (s *AuditedStore) DeleteOIDCClient(ctx context.Context, clientID string) error {
+	return s.mutate(ctx, models.EntityOIDCClient, fixedID(clientID), "delete", oidcClientSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteOIDCClient(ctx, clientID) })
+}
+
+// CreateAuthorizationCode is not audited: codes are short-lived protocol
+// state, and the login itself changes no entity
+func (s *AuditedStore) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	return s.inner.CreateAuthorizationCode(ctx, code)
+}
+
+func (s *AuditedStore) ConsumeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	return s.inner.ConsumeAuthorizationCode(ctx, hash)
+}
+
+// SetUserPassword is audited as an operation on the user; the hash is kept
+// out of the user snapshot, so the entry records who set it but no changes
+func (s *AuditedStore) SetUserPassword(ctx context.Context, userID string, passwordHash string) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(userID), "set_password", userSnapshot,
+		func(tx DirectoryStore) error { return tx.SetUserPassword(ctx, userID, passwordHash) })
+}
+
+func (s *AuditedStore) GetUserPassword(ctx context.Context, userID string) (*models.UserCredential, error) {
+	return s.inner.GetUserPassword(ctx, userID)
+}
+
+func (s *AuditedStore) DeleteUserPassword(ctx context.Context, userID string) error {
+	return s.mutate(ctx, models.EntityUser, fixedID(userID), "delete_password", userSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteUserPassword(ctx, userID) })
+}
+
+func (s *AuditedStore) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return s.inner.RecordAudit(ctx, entry)
+}
+
+func (s *AuditedStore) ListAudit(ctx context.Context, filter AuditFilter) (*Page[models.AuditEntry], error) {
+	return s.inner.ListAudit(ctx, filter)
+}
+
+func (s *AuditedStore) Search(ctx context.Context, query string, opts SearchOptions) (*models.SearchResults, error) {
+	return s.inner.Search(ctx, query, opts)
+}
+
+func (s *AuditedStore) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	return s.inner.ReserveIdempotencyKey(ctx, record)
+}
+
+func (s *AuditedStore) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	return s.inner.CompleteIdempotencyKey(ctx, record)
+}
+
+func (s *AuditedStore) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	return s.inner.ReleaseIdempotencyKey(ctx, record)
+}
+
+func (s *AuditedStore) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	return s.inner.PurgeIdempotencyKeys(ctx, cutoff)
+}
+
+// PurgeDeleted records one audit entry per purged entity in the same
+// transaction as the purge
+func (s *AuditedStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (*PurgeResult, error) {
+	var result *PurgeResult
+	err := s.inner.WithTx(ctx, func(tx DirectoryStore) error {
+		var err error
+		result, err = tx.PurgeDeleted(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, purged := range []struct {
+			entityType string
+			ids        []string
+		}{
+			{models.EntityUser, result.Users},
+			{models.EntityGroup, result.Groups},
+			{models.EntityRole, result.Roles},
+		} {
+			for _, id := range purged.ids {
+				err := tx.RecordAudit(ctx, &models.AuditEntry{
+					OccurredAt: now,
+					Actor:      ActorFromContext(ctx),
+					EntityType: purged.entityType,
+					EntityID:   id,
+					Operation:  "purge",
+					Changes:    models.AuditChanges{},
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AuditedStore) CreateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	return s.mutate(ctx, models.EntityAttributeDefinition, fixedID(attributeKey(def.EntityType, def.Name)), "create",
+		attributeDefinitionSnapshot(def.EntityType, def.Name),
+		func(tx DirectoryStore) error { return tx.CreateAttributeDefinition(ctx, def) })
+}
+
+func (s *AuditedStore) GetAttributeDefinitions(ctx context.Context, entityType string) ([]models.AttributeDefinition, error) {
+	return s.inner.GetAttributeDefinitions(ctx, entityType)
+}
+
+func (s *AuditedStore) GetAttributeDefinition(ctx context.Context, entityType string, name string) (*models.AttributeDefinition, error) {
+	return s.inner.GetAttributeDefinition(ctx, entityType, name)
+}
+
+func (s *AuditedStore) UpdateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	return s.mutate(ctx, models.EntityAttributeDefinition, fixedID(attributeKey(def.EntityType, def.Name)), "update",
+		attributeDefinitionSnapshot(def.EntityType, def.Name),
+		func(tx DirectoryStore) error { return tx.UpdateAttributeDefinition(ctx, def) })
+}
+
+func (s *AuditedStore) DeleteAttributeDefinition(ctx context.Context, entityType string, name string) error {
+	return s.mutate(ctx, models.EntityAttributeDefinition, fixedID(attributeKey(entityType, name)), "delete",
+		attributeDefinitionSnapshot(entityType, name),
+		func(tx DirectoryStore) error { return tx.DeleteAttributeDefinition(ctx, entityType, name) })
+}
+
+func (s *AuditedStore) CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error {
+	return s.mutate(ctx, models.EntityServiceAccount, fixedID(account.ID), "create", serviceAccountSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateServiceAccount(ctx, account) })
+}
+
+func (s *AuditedStore) GetServiceAccount(ctx context.Context, accountID string) (*models.ServiceAccount, error) {
+	return s.inner.GetServiceAccount(ctx, accountID)
+}
+
+func (s *AuditedStore) GetServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	return s.inner.GetServiceAccounts(ctx)
+}
+
+func (s *AuditedStore) DeleteServiceAccount(ctx context.Context, accountID string) error {
+	return s.mutate(ctx, models.EntityServiceAccount, fixedID(accountID), "delete", serviceAccountSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteServiceAccount(ctx, accountID) })
+}
+
+// CreateAPIKey is audited as a change to the keys of the service account; the
+// hash and the key itself are never part of the audit entry
+func (s *AuditedStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return s.mutate(ctx, models.EntityServiceAccount, fixedID(key.ServiceAccountID), "create_api_key", serviceAccountSnapshot,
+		func(tx DirectoryStore) error { return tx.CreateAPIKey(ctx, key) })
+}
+
+func (s *AuditedStore) GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error) {
+	return s.inner.GetAPIKey(ctx, keyID)
+}
+
+func (s *AuditedStore) DeleteAPIKey(ctx context.Context, accountID string, keyID string) error {
+	return s.mutate(ctx, models.EntityServiceAccount, fixedID(accountID), "delete_api_key", serviceAccountSnapshot,
+		func(tx DirectoryStore) error { return tx.DeleteAPIKey(ctx, accountID, keyID) })
+}
+
+func (s *AuditedStore) WithTx(ctx context.Context, fn func(tx DirectoryStore) error) error {
+	return s.inner.WithTx(ctx, func(tx DirectoryStore) error {
+		return fn(&AuditedStore{inner: tx})
+	})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ErrNoChange is matched by errors reporting that an operation had nothing to
+// do, e.g. adding a user to a group it already belongs to
+var ErrNoChange = errors.New("no change")
+
+// relationshipExists reports adding a relationship that already exists. It
+// matches ErrAlreadyExists and ErrNoChange.
+func relationshipExists(format string, args ...interface{}) error {
+	err := newError(ErrAlreadyExists, "relationship_already_exists", format, args...)
+	err.noChange = true
+	return err
+}
+
+// relationshipNotFound reports removing a relationship that does not exist. It
+// matches ErrNotFound and ErrNoChange.
+func relationshipNotFound(format string, args ...interface{}) error {
+	err := newError(ErrNotFound, "relationship_not_found", format, args...)
+	err.noChange = true
+	return err
+}
+
+// Outcomes of a single item in a bulk operation
+const (
+	BulkSucceeded  = "succeeded"   // The item was applied
+	BulkNoop       = "noop"        // The item was already in the requested state
+	BulkFailed     = "failed"      // The item could not be applied
+	BulkRolledBack = "rolled_back" // The item was applied, then undone by an atomic rollback
+	BulkSkipped    = "skipped"     // The item was not attempted because an atomic batch had already failed
+)
+
+// BulkItemResult is the outcome of one item of a bulk operation
+type BulkItemResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"` // Machine-readable error code of a failed item
+}
+
+// BulkResult reports the outcome of every item of a bulk operation
+type BulkResult struct {
+	Atomic     bool             `json:"atomic"`
+	RolledBack bool             `json:"rolled_back"`
+	Succeeded  int              `json:"succeeded"`
+	Noop       int              `json:"noop"`
+	Failed     int              `json:"failed"`
+	Items      []BulkItemResult `json:"items"`
+}
+
+// BulkOp applies a bulk operation to a single ID
+type BulkOp func(store DirectoryStore, id string) error
+
+// errBulkItemFailed aborts the transaction of an atomic bulk operation
+var errBulkItemFailed = errors.New("bulk item failed")
+
+// RunBulk applies op to each ID in order. By default every item is applied on
+// its own, so a failure does not affect the other items. In atomic mode all
+// items run in one transaction that is rolled back as soon as an item fails.
+// No-ops are reported separately and never count as failures.
+func RunBulk(ctx context.Context, store DirectoryStore, ids []string, atomic bool, op BulkOp) (*BulkResult, error) {
+	result := &BulkResult{Atomic: atomic, Items: make([]BulkItemResult, len(ids))}
+	for i, id := range ids {
+		result.Items[i] = BulkItemResult{ID: id, Status: BulkSkipped}
+	}
+
+	apply := func(store DirectoryStore, i int) bool {
+		err := op(store, ids[i])
+		switch {
+		case err == nil:
+			result.Items[i].Status = BulkSucceeded
+		case errors.Is(err, ErrNoChange):
+			result.Items[i].Status = BulkNoop
+		default:
+			result.Items[i].Status = BulkFailed
+			result.Items[i].Error, result.Items[i].Code = err.Error(), ErrorCode(err)
+			if result.Items[i].Code == "" {
+				log.Printf("Bulk operation failed for %s: %v", ids[i], err)
+				result.Items[i].Error, result.Items[i].Code = "internal error", "internal_error"
+			}
+			return false
+		}
+		return true
+	}
+
+	if !atomic {
+		for i := range ids {
+			apply(store, i)
+		}
+		result.count()
+		return result, nil
+	}
+
+	err := store.WithTx(ctx, func(tx DirectoryStore) error {
+		for i := range ids {
+			if !apply(tx, i) {
+				return errBulkItemFailed
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBulkItemFailed) {
+		result.RolledBack = true
+		for i := range result.Items {
+			if result.Items[i].Status == BulkSucceeded {
+				result.Items[i].Status = BulkRolledBack
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	result.count()
+	return result, nil
+}
+
+// count tallies the item outcomes
+func (r *BulkResult) count() {
+	r.Succeeded, r.Noop, r.Failed = 0, 0, 0
+	for _, item := range r.Items {
+		switch item.Status {
+		case BulkSucceeded:
+			r.Succeeded++
+		case BulkNoop:
+			r.Noop++
+		case BulkFailed:
+			r.Failed++
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import "context"
+
+type actorKey struct{}
+
+// SystemActor is recorded for mutations made outside an HTTP request
+const SystemActor = "system"
+
+// WithActor returns a context that attributes mutations to the given actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor, or SystemActor
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
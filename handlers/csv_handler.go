@@ -1,12 +1,18 @@
 package handlers
 
 import (
-	"fmt"
 	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
 )
 
-func readCsvFile(filePath string) ([]models.User, error) {
+// readCsvFile reads groups from a CSV file whose header row names the id, name
+// and description columns. Every group is validated as CreateGroup would, so
+// a file with an invalid row is rejected as a whole.
+func readCsvFile(filePath string) ([]models.Group, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CSV file: %w", err)
@@ -18,20 +24,32 @@ func readCsvFile(filePath string) ([]models.User, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CSV file: %w", err)
 	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("failed to read CSV file: missing header row")
+	}
 
-	var groups []models.Group
-	for i, record := range records {
-		if i == 0 {
-			continue 		
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
 		}
+		return ""
+	}
+
+	groups := make([]models.Group, 0, len(records)-1)
+	for i, record := range records[1:] {
 		group := models.Group{
-			Name: record[0],
-			Description: record[1],
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+			ID:          field(record, "id"),
+			Name:        field(record, "name"),
+			Description: field(record, "description"),
+		}
+		if err := validateCreate("group", &group); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+2, err)
 		}
 		groups = append(groups, group)
 	}
-
 	return groups, nil
-}
\ No newline at end of file
+}
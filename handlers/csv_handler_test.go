@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCsv(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "groups.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	return path
+}
+
+func TestReadCsvFile(t *testing.T) {
+	groups, err := readCsvFile(writeCsv(t, "Name,ID,Description\nEngineering,GRP001,Builds things\n Ops ,, \n"))
+	if err != nil {
+		t.Fatalf("readCsvFile() error = %v", err)
+	}
+	if len(groups) != 2 || groups[0].ID != "GRP001" || groups[0].Description != "Builds things" || groups[1].Name != "Ops" {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+// An import is checked with the rules of CreateGroup and rejected as a whole
+func TestReadCsvFileValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		csv   string
+		row   string
+		field string
+	}{
+		{"missing name", "id,name\nGRP001,Engineering\nGRP002,\n", "row 3", "name"},
+		{"missing name column", "id,description\nGRP001,Builds things\n", "row 2", "name"},
+		{"long description", "name,description\nOps," + strings.Repeat("x", 1025) + "\n", "row 2", "description"},
+		{"long name", "name\n" + strings.Repeat("x", 257) + "\n", "row 2", "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := readCsvFile(writeCsv(t, tt.csv))
+			if !errors.Is(err, ErrValidation) || groups != nil {
+				t.Fatalf("readCsvFile() = %v, %v, want a validation error", groups, err)
+			}
+			if !strings.HasPrefix(err.Error(), tt.row+":") {
+				t.Errorf("error = %v, want it to name %s", err, tt.row)
+			}
+			if fields := ErrorFields(err); len(fields) != 1 || fields[0].Field != tt.field {
+				t.Errorf("fields = %+v, want %s", fields, tt.field)
+			}
+		})
+	}
+}
@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"strings"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/migrations"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"github.com/lotusatx/lotus-directory-engine-backend/models"
 )
 
-func ConfigureDbConnection(connectionString string) (*gorm.DB, error) {
+// OpenDbConnection opens and verifies a database connection without touching the schema
+func OpenDbConnection(connectionString string) (*gorm.DB, error) {
 	// Ensure SSL is disabled for local/development PostgreSQL servers
 	if !strings.Contains(connectionString, "sslmode=") {
 		if strings.Contains(connectionString, "?") {
@@ -17,28 +20,39 @@ func ConfigureDbConnection(connectionString string) (*gorm.DB, error) {
 			connectionString += "?sslmode=disable"
 		}
 	}
-	
-	db, err := gorm.Open(postgres.Open(connectionString), &gorm.Config{})
+
+	db, err := gorm.Open(postgres.Open(connectionString), &gorm.Config{TranslateError: true})
 	if err != nil {
 		fmt.Println("Failed to open database connection:", err)
 		return nil, err
 	}
-	
+
 	err = testDbConnection(db)
 	if err != nil {
 		fmt.Println("Database connection test failed:", err)
 		return nil, err
 	}
 	fmt.Println("Database connection configured successfully")
-	
-	// Auto-migrate the schema
-	err = migrateDatabase(db)
+
+	return db, nil
+}
+
+// ConfigureDbConnection opens the database and checks its schema version.
+// Pending migrations are applied when autoMigrate is true; otherwise they are
+// reported as an error. A schema newer than this binary is always an error.
+func ConfigureDbConnection(connectionString string, autoMigrate bool) (*gorm.DB, error) {
+	db, err := OpenDbConnection(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateDatabase(db, autoMigrate)
 	if err != nil {
 		fmt.Println("Database migration failed:", err)
 		return nil, err
 	}
-	fmt.Println("Database migration completed successfully")
-	
+	fmt.Println("Database schema is up to date")
+
 	return db, nil
 }
 
@@ -54,8 +68,39 @@ func testDbConnection(db *gorm.DB) error {
 	return nil
 }
 
-func migrateDatabase(db *gorm.DB) error {
-	return db.AutoMigrate(&models.User{}, &models.Group{}, &models.Role{})
+// NewMigrator creates a schema migrator for the database behind a gorm connection
+func NewMigrator(db *gorm.DB) (*migrations.Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return migrations.NewMigrator(sqlDB)
 }
 
-func Create
\ No newline at end of file
+func migrateDatabase(db *gorm.DB, autoMigrate bool) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	if !autoMigrate {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("database schema has %d pending migration(s); run \"migrate up\" first", len(pending))
+		}
+		return nil
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+	return nil
+}
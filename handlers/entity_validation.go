@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// validateCreate checks a new entity against the validate rules of its model
+func validateCreate(entity string, v interface{}) error {
+	return fieldErrors(entity, models.Validate(v))
+}
+
+// validateUpdate checks an entity update against the validate rules of its
+// model. The ID comes from the URL and names a stored entity, so it is not
+// checked: entities created before a rule was added stay writable.
+func validateUpdate(entity string, v interface{}) error {
+	var fields []models.FieldError
+	for _, field := range models.Validate(v) {
+		if field.Field != "id" {
+			fields = append(fields, field)
+		}
+	}
+	return fieldErrors(entity, fields)
+}
+
+// fieldErrors returns a validation error listing the fields, or nil if there are none
+func fieldErrors(entity string, fields []models.FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field.Message
+	}
+	err := newError(ErrValidation, "validation_failed", "invalid %s: %s", entity, strings.Join(messages, "; "))
+	err.Fields = fields
+	return err
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// Error kinds. Every error a store reports about the request rather than about
+// itself matches exactly one of them with errors.Is; any other error is an
+// internal failure.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrConflict           = errors.New("conflict")
+	ErrValidation         = errors.New("validation failed")
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Error is a domain error with a kind and a machine-readable code. The message
+// is safe to show to API clients.
+type Error struct {
+	Kind    error  // One of the error kinds above
+	Code    string // Machine-readable code, e.g. user_not_found
+	Message string
+	Fields  []models.FieldError // Fields that failed validation, for ErrValidation errors
+
+	noChange bool // The operation had nothing to do; also matches ErrNoChange
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Is matches the error's kind, and ErrNoChange for no-op errors
+func (e *Error) Is(target error) bool {
+	return target == e.Kind || (e.noChange && target == ErrNoChange)
+}
+
+// newError returns an error of the given kind and code with a formatted message
+func newError(kind error, code string, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode returns the machine-readable code of a domain error, or "" for an internal error
+func ErrorCode(err error) string {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Code
+	}
+	return ""
+}
+
+// ErrorFields returns the fields that failed validation of a validation error
+func ErrorFields(err error) []models.FieldError {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Fields
+	}
+	return nil
+}
+
+// entityCode turns an entity name such as "attribute definition" into a code prefix
+func entityCode(entity string) string {
+	return strings.ReplaceAll(entity, " ", "_")
+}
+
+// notFound reports that no entity of the given kind has the ID
+func notFound(entity, id string) error {
+	return newError(ErrNotFound, entityCode(entity)+"_not_found", "%s not found: %s", entity, id)
+}
+
+// deletedNotFound reports that no soft-deleted entity of the given kind has the ID
+func deletedNotFound(entity, id string) error {
+	return newError(ErrNotFound, entityCode(entity)+"_not_found", "deleted %s not found: %s", entity, id)
+}
+
+// alreadyExists reports that an entity of the given kind already has the ID
+func alreadyExists(entity, id string) error {
+	return newError(ErrAlreadyExists, entityCode(entity)+"_already_exists", "%s already exists: %s", entity, id)
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/filter"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidFilter is returned when a filter expression names a field the
+// list cannot be filtered by or compares a field with a value of the wrong type
+var ErrInvalidFilter error = newError(ErrInvalidRequest, "invalid_filter", "invalid filter")
+
+// filterField is a field a list can be filtered by
+type filterField struct {
+	column string // SQL expression yielding the field's value
+	time   bool   // Values are timestamps compared chronologically rather than text
+}
+
+// filterFields maps the JSON field names accepted in filters to their columns
+type filterFields map[string]filterField
+
+var (
+	userFilterFields = filterFields{
+		"id":         {column: "users.id"},
+		"name":       {column: "users.name"},
+		"email":      {column: "users.email"},
+		"status":     {column: "users.status"},
+		"created_at": {column: "users.created_at", time: true},
+		"updated_at": {column: "users.updated_at", time: true},
+	}
+	groupFilterFields = filterFields{
+		"id":          {column: "groups.id"},
+		"name":        {column: "groups.name"},
+		"description": {column: "COALESCE(groups.description, '')"},
+	}
+	roleFilterFields = filterFields{
+		"id":          {column: "roles.id"},
+		"name":        {column: "roles.name"},
+		"description": {column: "COALESCE(roles.description, '')"},
+	}
+)
+
+// field resolves a field name, listing the allowed names when it is unknown
+func (f filterFields) field(name string) (filterField, error) {
+	if field, ok := f[name]; ok {
+		return field, nil
+	}
+	allowed := make([]string, 0, len(f))
+	for key := range f {
+		allowed = append(allowed, key)
+	}
+	sort.Strings(allowed)
+	return filterField{}, fmt.Errorf("%w: cannot filter by %q, expected one of %s", ErrInvalidFilter, name, strings.Join(allowed, ", "))
+}
+
+// comparisonValue checks that a comparison's value suits its field and
+// returns it as a string, or a time.Time for timestamp fields
+func comparisonValue(field filterField, c *filter.Comparison) (interface{}, error) {
+	text, ok := c.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be compared with a string, got %v", ErrInvalidFilter, c.Field, c.Value)
+	}
+	if !field.time {
+		return text, nil
+	}
+	if c.Op.IsStringMatch() {
+		return nil, fmt.Errorf("%w: operator %s cannot be used on timestamp field %s", ErrInvalidFilter, c.Op, c.Field)
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an RFC 3339 timestamp, got %q", ErrInvalidFilter, c.Field, text)
+	}
+	return t, nil
+}
+
+// sqlOperators maps the equality and ordering operators to SQL
+var sqlOperators = map[filter.Operator]string{
+	filter.Eq: "=", filter.Ne: "<>",
+	filter.Gt: ">", filter.Ge: ">=", filter.Lt: "<", filter.Le: "<=",
+}
+
+// filterSQL translates a filter expression into a parameterized SQL condition.
+// Field names are resolved to fixed column expressions and every value is a
+// bind parameter, so no part of the expression is spliced into the SQL.
+func filterSQL(expr filter.Expr, fields filterFields) (string, []interface{}, error) {
+	switch node := expr.(type) {
+	case *filter.And:
+		return joinFilterSQL(node.Left, node.Right, "AND", fields)
+	case *filter.Or:
+		return joinFilterSQL(node.Left, node.Right, "OR", fields)
+	case *filter.Not:
+		inner, args, err := filterSQL(node.Expr, fields)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT (%s)", inner), args, nil
+	case *filter.Present:
+		field, err := fields.field(node.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		if field.time {
+			return fmt.Sprintf("%s IS NOT NULL", field.column), nil, nil
+		}
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", field.column, field.column), nil, nil
+	case *filter.Comparison:
+		field, err := fields.field(node.Field)
+		if err != nil {
+			return "", nil, err
+		}
+		value, err := comparisonValue(field, node)
+		if err != nil {
+			return "", nil, err
+		}
+		if node.Op.IsStringMatch() {
+			return fmt.Sprintf(`%s ILIKE ? ESCAPE '\'`, field.column), []interface{}{likePattern(node.Op, value.(string))}, nil
+		}
+		return fmt.Sprintf("%s %s ?", field.column, sqlOperators[node.Op]), []interface{}{value}, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unsupported expression %v", ErrInvalidFilter, expr)
+	}
+}
+
+// joinFilterSQL translates both operands of an and/or expression and joins them with keyword
+func joinFilterSQL(left, right filter.Expr, keyword string, fields filterFields) (string, []interface{}, error) {
+	leftSQL, leftArgs, err := filterSQL(left, fields)
+	if err != nil {
+		return "", nil, err
+	}
+	rightSQL, rightArgs, err := filterSQL(right, fields)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("(%s %s %s)", leftSQL, keyword, rightSQL), append(leftArgs, rightArgs...), nil
+}
+
+// likePattern builds the ILIKE pattern for co, sw and ew, escaping wildcards in the value
+func likePattern(op filter.Operator, value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	switch op {
+	case filter.Sw:
+		return escaped + "%"
+	case filter.Ew:
+		return "%" + escaped
+	default:
+		return "%" + escaped + "%"
+	}
+}
+
+// whereFilter narrows query to the rows matching the filter expression, if any
+func whereFilter(query *gorm.DB, expr filter.Expr, fields filterFields) (*gorm.DB, error) {
+	if expr == nil {
+		return query, nil
+	}
+	clause, args, err := filterSQL(expr, fields)
+	if err != nil {
+		return nil, err
+	}
+	return query.Where(clause, args...), nil
+}
+
+// filterPredicate is a filter expression compiled for entities held in memory.
+// It is given the entity's JSON fields.
+type filterPredicate func(values map[string]interface{}) bool
+
+// compileFilter checks a filter expression against the fields of a list and
+// returns a predicate with the same semantics as filterSQL. A nil expression
+// matches everything.
+func compileFilter(expr filter.Expr, fields filterFields) (filterPredicate, error) {
+	if expr == nil {
+		return func(map[string]interface{}) bool { return true }, nil
+	}
+	switch node := expr.(type) {
+	case *filter.And:
+		left, right, err := compilePair(node.Left, node.Right, fields)
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]interface{}) bool { return left(v) && right(v) }, nil
+	case *filter.Or:
+		left, right, err := compilePair(node.Left, node.Right, fields)
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]interface{}) bool { return left(v) || right(v) }, nil
+	case *filter.Not:
+		inner, err := compileFilter(node.Expr, fields)
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]interface{}) bool { return !inner(v) }, nil
+	case *filter.Present:
+		if _, err := fields.field(node.Field); err != nil {
+			return nil, err
+		}
+		return func(v map[string]interface{}) bool {
+			s, _ := v[node.Field].(string)
+			return s != ""
+		}, nil
+	case *filter.Comparison:
+		field, err := fields.field(node.Field)
+		if err != nil {
+			return nil, err
+		}
+		value, err := comparisonValue(field, node)
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]interface{}) bool {
+			s, _ := v[node.Field].(string)
+			return compareFilterValue(field, node.Op, s, value)
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported expression %v", ErrInvalidFilter, expr)
+	}
+}
+
+// compilePair compiles both operands of an and/or expression
+func compilePair(left, right filter.Expr, fields filterFields) (filterPredicate, filterPredicate, error) {
+	l, err := compileFilter(left, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := compileFilter(right, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, r, nil
+}
+
+// compareFilterValue applies a comparison to a field value held in memory
+func compareFilterValue(field filterField, op filter.Operator, actual string, value interface{}) bool {
+	if op.IsStringMatch() {
+		actual, pattern := strings.ToLower(actual), strings.ToLower(value.(string))
+		switch op {
+		case filter.Sw:
+			return strings.HasPrefix(actual, pattern)
+		case filter.Ew:
+			return strings.HasSuffix(actual, pattern)
+		default:
+			return strings.Contains(actual, pattern)
+		}
+	}
+
+	var c int
+	if field.time {
+		t, err := time.Parse(time.RFC3339Nano, actual)
+		if err != nil {
+			return false
+		}
+		c = t.Compare(value.(time.Time))
+	} else {
+		c = strings.Compare(actual, value.(string))
+	}
+	switch op {
+	case filter.Eq:
+		return c == 0
+	case filter.Ne:
+		return c != 0
+	case filter.Gt:
+		return c > 0
+	case filter.Ge:
+		return c >= 0
+	case filter.Lt:
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+// filterValues returns the JSON fields of an entity for a filterPredicate
+func filterValues(item interface{}) map[string]interface{} {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/filter"
+)
+
+func mustParse(t *testing.T, input string) filter.Expr {
+	t.Helper()
+	expr, err := filter.Parse(input)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", input, err)
+	}
+	return expr
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		op    filter.Operator
+		value string
+		want  string
+	}{
+		{filter.Co, "abc", `%abc%`},
+		{filter.Sw, "abc", `abc%`},
+		{filter.Ew, "abc", `%abc`},
+		{filter.Co, "100%", `%100\%%`},
+		{filter.Sw, "a_b", `a\_b%`},
+		{filter.Ew, `C:\dir`, `%C:\\dir`},
+		{filter.Co, `\%_`, `%\\\%\_%`},
+		{filter.Co, "", `%%`},
+	}
+	for _, tt := range tests {
+		if got := likePattern(tt.op, tt.value); got != tt.want {
+			t.Errorf("likePattern(%s, %q) = %q, want %q", tt.op, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFilterSQL(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		input string
+		sql   string
+		args  []interface{}
+	}{
+		{`name eq "Ada"`, `users.name = ?`, []interface{}{"Ada"}},
+		{`name co "50%_off"`, `users.name ILIKE ? ESCAPE '\'`, []interface{}{`%50\%\_off%`}},
+		{`email ew "@example.com"`, `users.email ILIKE ? ESCAPE '\'`, []interface{}{`%@example.com`}},
+		{`email pr`, `(users.email IS NOT NULL AND users.email <> '')`, nil},
+		{`created_at pr`, `users.created_at IS NOT NULL`, nil},
+		{`created_at ge "2024-01-02T03:04:05Z"`, `users.created_at >= ?`, []interface{}{created}},
+		{
+			`name eq "a" or status ne "active" and not email pr`,
+			`(users.name = ? OR (users.status <> ? AND NOT ((users.email IS NOT NULL AND users.email <> ''))))`,
+			[]interface{}{"a", "active"},
+		},
+		// A value that looks like SQL stays a bind parameter
+		{`name eq "x' OR '1'='1"`, `users.name = ?`, []interface{}{"x' OR '1'='1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			sql, args, err := filterSQL(mustParse(t, tt.input), userFilterFields)
+			if err != nil {
+				t.Fatalf("filterSQL() error = %v", err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown field", `password eq "x"`},
+		{"unknown field in present", `password pr`},
+		{"unknown field on the right", `name eq "a" and nope eq "b"`},
+		{"unknown field under not", `not nope pr`},
+		{"number value", `name eq 1`},
+		{"bool value", `name eq true`},
+		{"string match on timestamp", `created_at co "2024"`},
+		{"invalid timestamp", `created_at gt "yesterday"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr := mustParse(t, tt.input)
+			if _, _, err := filterSQL(expr, userFilterFields); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("filterSQL() error = %v, want ErrInvalidFilter", err)
+			}
+			if _, err := compileFilter(expr, userFilterFields); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("compileFilter() error = %v, want ErrInvalidFilter", err)
+			}
+		})
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	values := map[string]interface{}{
+		"id":         "UI000001",
+		"name":       "Ada Lovelace",
+		"email":      "ada@example.com",
+		"status":     "active",
+		"created_at": "2024-01-02T03:04:05Z",
+	}
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`name eq "Ada Lovelace"`, true},
+		{`name eq "ada lovelace"`, false},
+		{`name co "LOVE"`, true},
+		{`name sw "ada"`, true},
+		{`email ew "@EXAMPLE.com"`, true},
+		// Wildcards are matched literally, as the escaped ILIKE pattern does
+		{`name co "%"`, false},
+		{`name co "_"`, false},
+		{`email pr`, true},
+		{`updated_at pr`, false},
+		{`created_at gt "2024-01-01T00:00:00Z"`, true},
+		{`created_at lt "2024-01-02T03:04:05.5Z"`, true},
+		{`created_at eq "2024-01-02T04:04:05+01:00"`, true},
+		{`id gt "UI000000"`, true},
+		{`status eq "suspended" or name sw "A" and email pr`, true},
+		{`(status eq "suspended" or name sw "A") and not email pr`, false},
+		{`not status eq "active"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			match, err := compileFilter(mustParse(t, tt.input), userFilterFields)
+			if err != nil {
+				t.Fatalf("compileFilter() error = %v", err)
+			}
+			if got := match(values); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	match, err := compileFilter(nil, userFilterFields)
+	if err != nil || !match(nil) {
+		t.Errorf("nil expression does not match everything: %v", err)
+	}
+}
@@ -1,208 +1,296 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
 	"gorm.io/gorm"
 )
 
-// CreateGroup creates a new group in the database
+// CreateGroup creates a new group in the database along with any member users
+// and nested groups in the request
 func CreateGroup(db *gorm.DB, group *models.Group) error {
-	result := db.Create(group)
-	if result.Error != nil {
-		return fmt.Errorf("failed to create group: %w", result.Error)
+	if err := validateCreate("group", group); err != nil {
+		return err
+	}
+	group.DeletedAt = gorm.DeletedAt{}
+	group.Version = 1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		attrs, err := entityAttributes(tx, models.EntityGroup, group.Attributes)
+		if err != nil {
+			return err
+		}
+		group.Attributes = attrs
+		if err := tx.Create(group).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return alreadyExists("group", group.ID)
+			}
+			return err
+		}
+		if err := replaceGroupMembers(tx, group.ID, group.Members); err != nil {
+			return err
+		}
+		return replaceGroupGroups(tx, group.ID, group.MemberGroups)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
 	}
+	group.Members = nonNil(group.Members)
+	group.MemberGroups = nonNil(group.MemberGroups)
 	return nil
 }
 
-// GetGroupByID retrieves a group by its ID
+// GetGroupByID retrieves a group by its ID with its members
 func GetGroupByID(db *gorm.DB, groupID string) (*models.Group, error) {
 	var group models.Group
 	result := db.Where("id = ?", groupID).First(&group)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("group not found: %s", groupID)
+			return nil, notFound("group", groupID)
 		}
 		return nil, fmt.Errorf("failed to get group: %w", result.Error)
 	}
-	return &group, nil
+	groups := []models.Group{group}
+	if err := attachGroupMembers(db, groups); err != nil {
+		return nil, err
+	}
+	return &groups[0], nil
 }
 
-// GetAllGroups retrieves all groups
-func GetAllGroups(db *gorm.DB) ([]models.Group, error) {
-	var groups []models.Group
-	result := db.Order("name").Find(&groups)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query groups: %w", result.Error)
+// GetAllGroups retrieves a page of groups with their members
+func GetAllGroups(db *gorm.DB, opts ListOptions) (*Page[models.Group], error) {
+	query, err := whereAttributes(db, opts.scope(db), models.EntityGroup, opts.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
 	}
-	return groups, nil
+	query, err = whereFilter(query, opts.Filter, groupFilterFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	page, err := findPage[models.Group](query, opts, groupSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	if err := attachGroupMembers(db, page.Items); err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
-// UpdateGroup updates an existing group
+// UpdateGroup updates an existing group. Members, MemberGroups and Attributes
+// replace the existing values when present in the request and are left untouched when nil.
+// A non-zero Version makes the update conditional on the stored version.
 func UpdateGroup(db *gorm.DB, group *models.Group) error {
-	result := db.Save(group)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update group: %w", result.Error)
+	if err := validateUpdate("group", group); err != nil {
+		return err
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("group not found: %s", group.ID)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"name": group.Name, "description": group.Description, "version": gorm.Expr("version + 1")}
+		if group.Attributes != nil {
+			attrs, err := entityAttributes(tx, models.EntityGroup, group.Attributes)
+			if err != nil {
+				return err
+			}
+			updates["attributes"] = attrs
+		}
+		result := whereVersion(tx.Model(&models.Group{}).Where("id = ?", group.ID), group.Version).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return versionConflict(tx, &models.Group{}, "group", group.ID, group.Version)
+		}
+		if group.Members != nil {
+			if err := replaceGroupMembers(tx, group.ID, group.Members); err != nil {
+				return err
+			}
+		}
+		if group.MemberGroups != nil {
+			return replaceGroupGroups(tx, group.ID, group.MemberGroups)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+	updated, err := GetGroupByID(db, group.ID)
+	if err != nil {
+		return err
 	}
+	*group = *updated
 	return nil
 }
 
-// DeleteGroup deletes a group by ID
-func DeleteGroup(db *gorm.DB, groupID string) error {
-	result := db.Delete(&models.Group{}, "id = ?", groupID)
+// DeleteGroup soft-deletes a group by ID. Its members and role associations
+// are kept so that RestoreGroup can bring them back. A non-zero expectedVersion
+// makes the delete conditional on the stored version.
+func DeleteGroup(db *gorm.DB, groupID string, expectedVersion int64) error {
+	result := whereVersion(db, expectedVersion).Delete(&models.Group{}, "id = ?", groupID)
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete group: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("group not found: %s", groupID)
+		return versionConflict(db, &models.Group{}, "group", groupID, expectedVersion)
 	}
 	return nil
 }
 
+// RestoreGroup brings back a soft-deleted group along with its prior relationships
+func RestoreGroup(db *gorm.DB, groupID string) (*models.Group, error) {
+	result := db.Unscoped().Model(&models.Group{}).
+		Where("id = ? AND deleted_at IS NOT NULL", groupID).
+		Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to restore group: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, deletedNotFound("group", groupID)
+	}
+	return GetGroupByID(db, groupID)
+}
+
 // AddUserToGroup adds a single user to a group
 func AddUserToGroup(db *gorm.DB, groupID string, userID string) error {
-	group, err := GetGroupByID(db, groupID)
-	if err != nil {
+	if err := requireGroup(db, groupID); err != nil {
 		return err
 	}
-
-	// Check if user already exists in the group
-	for _, member := range group.Members {
-		if member == userID {
-			return fmt.Errorf("user %s is already a member of group %s", userID, groupID)
-		}
+	if err := requireUser(db, userID); err != nil {
+		return err
 	}
 
-	// Add user to members list
-	group.Members = append(group.Members, userID)
-	
-	result := db.Save(group)
-	if result.Error != nil {
-		return fmt.Errorf("failed to add user to group: %w", result.Error)
+	added, err := insertIgnoringDuplicates(db, &models.GroupMember{GroupID: groupID, UserID: userID})
+	if err != nil {
+		return fmt.Errorf("failed to add user to group: %w", err)
+	}
+	if added == 0 {
+		return relationshipExists("user %s is already a member of group %s", userID, groupID)
 	}
 	return nil
 }
 
-// AddUsersToGroup adds multiple users to a group
-func AddUsersToGroup(db *gorm.DB, groupID string, userIDs []string) error {
-	group, err := GetGroupByID(db, groupID)
-	if err != nil {
+// RemoveUserFromGroup removes a single user from a group
+func RemoveUserFromGroup(db *gorm.DB, groupID string, userID string) error {
+	if err := requireGroup(db, groupID); err != nil {
 		return err
 	}
 
-	// Create a map of existing members for quick lookup
-	existingMembers := make(map[string]bool)
-	for _, member := range group.Members {
-		existingMembers[member] = true
-	}
-
-	// Add only new users
-	addedCount := 0
-	for _, userID := range userIDs {
-		if !existingMembers[userID] {
-			group.Members = append(group.Members, userID)
-			existingMembers[userID] = true
-			addedCount++
-		}
-	}
-
-	if addedCount == 0 {
-		return fmt.Errorf("all specified users are already members of the group")
-	}
-
-	result := db.Save(group)
+	result := db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&models.GroupMember{})
 	if result.Error != nil {
-		return fmt.Errorf("failed to add users to group: %w", result.Error)
+		return fmt.Errorf("failed to remove user from group: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return relationshipNotFound("user %s is not a member of group %s", userID, groupID)
 	}
 	return nil
 }
 
-// RemoveUserFromGroup removes a single user from a group
-func RemoveUserFromGroup(db *gorm.DB, groupID string, userID string) error {
-	group, err := GetGroupByID(db, groupID)
-	if err != nil {
-		return err
-	}
+// AddGroupToGroup nests the child group in the parent group
+func AddGroupToGroup(db *gorm.DB, parentID string, childID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := requireGroup(tx, parentID); err != nil {
+			return err
+		}
+		if err := requireGroup(tx, childID); err != nil {
+			return err
+		}
+		if err := lockGroupHierarchy(tx); err != nil {
+			return err
+		}
+		if err := checkNoCycle(tx, parentID, childID); err != nil {
+			return err
+		}
 
-	// Find and remove the user
-	found := false
-	newMembers := make([]string, 0, len(group.Members))
-	for _, member := range group.Members {
-		if member != userID {
-			newMembers = append(newMembers, member)
-		} else {
-			found = true
+		added, err := insertIgnoringDuplicates(tx, &models.GroupGroup{ParentGroupID: parentID, ChildGroupID: childID})
+		if err != nil {
+			return fmt.Errorf("failed to add group to group: %w", err)
 		}
-	}
+		if added == 0 {
+			return relationshipExists("group %s is already a member of group %s", childID, parentID)
+		}
+		return nil
+	})
+}
 
-	if !found {
-		return fmt.Errorf("user %s is not a member of group %s", userID, groupID)
+// RemoveGroupFromGroup removes a nested group from the parent group
+func RemoveGroupFromGroup(db *gorm.DB, parentID string, childID string) error {
+	if err := requireGroup(db, parentID); err != nil {
+		return err
 	}
 
-	group.Members = newMembers
-	result := db.Save(group)
+	result := db.Where("parent_group_id = ? AND child_group_id = ?", parentID, childID).Delete(&models.GroupGroup{})
 	if result.Error != nil {
-		return fmt.Errorf("failed to remove user from group: %w", result.Error)
+		return fmt.Errorf("failed to remove group from group: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return relationshipNotFound("group %s is not a member of group %s", childID, parentID)
 	}
 	return nil
 }
 
-// RemoveUsersFromGroup removes multiple users from a group
-func RemoveUsersFromGroup(db *gorm.DB, groupID string, userIDs []string) error {
-	group, err := GetGroupByID(db, groupID)
-	if err != nil {
-		return err
-	}
-
-	// Create a map of users to remove
-	usersToRemove := make(map[string]bool)
-	for _, userID := range userIDs {
-		usersToRemove[userID] = true
+// GetGroupMembers retrieves the users and groups that are members of a group.
+// With transitive set, members of nested groups at any depth are included and
+// users that are not active are left out, since they have no effective access.
+// Member users are paged by ID.
+func GetGroupMembers(db *gorm.DB, groupID string, transitive bool, opts ListOptions) (*GroupMembers, error) {
+	if err := requireGroup(db, groupID); err != nil {
+		return nil, err
 	}
 
-	// Filter out the users to remove
-	newMembers := make([]string, 0, len(group.Members))
-	removedCount := 0
-	for _, member := range group.Members {
-		if !usersToRemove[member] {
-			newMembers = append(newMembers, member)
-		} else {
-			removedCount++
+	members := &GroupMembers{Users: []string{}, Groups: []string{}}
+	if transitive {
+		descendants, err := descendantGroups(db, groupID, true)
+		if err != nil {
+			return nil, err
+		}
+		members.Groups = descendants
+	} else {
+		result := db.Model(&models.GroupGroup{}).
+			Joins("JOIN groups ON groups.id = group_groups.child_group_id AND groups.deleted_at IS NULL").
+			Where("group_groups.parent_group_id = ?", groupID).
+			Order("group_groups.child_group_id").
+			Pluck("group_groups.child_group_id", &members.Groups)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to get nested groups: %w", result.Error)
 		}
 	}
 
-	if removedCount == 0 {
-		return fmt.Errorf("none of the specified users are members of the group")
+	groupIDs := []string{groupID}
+	if transitive {
+		groupIDs = append(groupIDs, members.Groups...)
 	}
-
-	group.Members = newMembers
-	result := db.Save(group)
-	if result.Error != nil {
-		return fmt.Errorf("failed to remove users from group: %w", result.Error)
+	query := db.Model(&models.GroupMember{}).
+		Distinct("group_members.user_id").
+		Joins("JOIN users ON users.id = group_members.user_id AND users.deleted_at IS NULL").
+		Where("group_members.group_id IN ?", groupIDs)
+	if transitive {
+		query = query.Where("users.status = ?", models.UserStatusActive)
 	}
-	return nil
-}
-
-// GetGroupMembers retrieves all user IDs that are members of a group
-func GetGroupMembers(db *gorm.DB, groupID string) ([]string, error) {
-	group, err := GetGroupByID(db, groupID)
+	page, err := pluckPage(query, "group_members.user_id", opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get group members: %w", err)
 	}
-	return group.Members, nil
+	members.setUsers(page)
+	return members, nil
 }
 
-// GetUserGroups retrieves all groups that a user is a member of
-func GetUserGroups(db *gorm.DB, userID string) ([]models.Group, error) {
-	var groups []models.Group
-	// This assumes Members is stored as a JSON array or similar
-	// The exact query depends on your database schema
-	result := db.Where("? = ANY(members)", userID).Find(&groups)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to get user groups: %w", result.Error)
+// GetUserGroups retrieves a page of the groups that a user is a member of. With
+// transitive set, the groups those groups are nested in are included too.
+func GetUserGroups(db *gorm.DB, userID string, transitive bool, opts ListOptions) (*Page[models.Group], error) {
+	query := db.Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id = ?", userID)
+	if transitive {
+		var groupIDs []string
+		if err := db.Raw(userGroupsTransitiveSQL, userID).Scan(&groupIDs).Error; err != nil {
+			return nil, fmt.Errorf("failed to resolve user groups: %w", err)
+		}
+		query = db.Where("id IN ?", groupIDs)
+	}
+	page, err := findPage[models.Group](query, opts, groupSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+	if err := attachGroupMembers(db, page.Items); err != nil {
+		return nil, err
 	}
-	return groups, nil
+	return page, nil
 }
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// ErrGroupCycle is returned when nesting a group would make it contain itself
+var ErrGroupCycle error = newError(ErrConflict, "group_cycle", "group cycle")
+
+// groupHierarchyLockID is the transaction-scoped advisory lock that serializes
+// changes to group nesting, so concurrent inserts cannot form a cycle together
+const groupHierarchyLockID = 73051121
+
+// GroupMembers lists the users and groups that belong to a group. Users are
+// paged like other lists; nested groups are always listed in full.
+type GroupMembers struct {
+	Users      []string `json:"members"`
+	Groups     []string `json:"member_groups"`
+	NextCursor string   `json:"next_cursor,omitempty"` // Cursor for the next page of member users
+	Total      *int64   `json:"total,omitempty"`       // Number of member users across all pages, when requested
+}
+
+// setUsers fills in the member users from a page of user IDs
+func (m *GroupMembers) setUsers(page *Page[string]) {
+	m.Users = page.Items
+	m.NextCursor = page.NextCursor
+	m.Total = page.Total
+}
+
+// descendantGroupsSQL walks group_groups downwards over every edge, including
+// edges to soft-deleted groups, which still count for cycle detection
+const descendantGroupsSQL = `
+WITH RECURSIVE descendants(id) AS (
+    SELECT child_group_id FROM group_groups WHERE parent_group_id = ?
+    UNION
+    SELECT gg.child_group_id FROM group_groups gg JOIN descendants d ON gg.parent_group_id = d.id
+)
+SELECT id FROM descendants ORDER BY id`
+
+// liveDescendantGroupsSQL walks group_groups downwards, stopping at soft-deleted groups
+const liveDescendantGroupsSQL = `
+WITH RECURSIVE descendants(id) AS (
+    SELECT gg.child_group_id FROM group_groups gg
+    JOIN groups g ON g.id = gg.child_group_id AND g.deleted_at IS NULL
+    WHERE gg.parent_group_id = ?
+    UNION
+    SELECT gg.child_group_id FROM group_groups gg
+    JOIN descendants d ON gg.parent_group_id = d.id
+    JOIN groups g ON g.id = gg.child_group_id AND g.deleted_at IS NULL
+)
+SELECT id FROM descendants ORDER BY id`
+
+// userGroupsTransitiveSQL starts from the live groups a user belongs to
+// directly and walks group_groups upwards, stopping at soft-deleted groups
+const userGroupsTransitiveSQL = `
+WITH RECURSIVE ancestors(id) AS (
+    SELECT gm.group_id FROM group_members gm
+    JOIN groups g ON g.id = gm.group_id AND g.deleted_at IS NULL
+    WHERE gm.user_id = ?
+    UNION
+    SELECT gg.parent_group_id FROM group_groups gg
+    JOIN ancestors a ON gg.child_group_id = a.id
+    JOIN groups g ON g.id = gg.parent_group_id AND g.deleted_at IS NULL
+)
+SELECT id FROM ancestors`
+
+// lockGroupHierarchy takes the group nesting lock for the rest of the transaction
+func lockGroupHierarchy(tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", groupHierarchyLockID).Error; err != nil {
+		return fmt.Errorf("failed to lock group hierarchy: %w", err)
+	}
+	return nil
+}
+
+// descendantGroups returns the IDs of the groups nested in groupID at any depth
+func descendantGroups(db *gorm.DB, groupID string, liveOnly bool) ([]string, error) {
+	query := descendantGroupsSQL
+	if liveOnly {
+		query = liveDescendantGroupsSQL
+	}
+	ids := []string{}
+	if err := db.Raw(query, groupID).Scan(&ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to resolve nested groups: %w", err)
+	}
+	return ids, nil
+}
+
+// checkNoCycle returns an error if nesting childID in parentID would make a
+// group contain itself. The caller must hold the group hierarchy lock.
+func checkNoCycle(db *gorm.DB, parentID, childID string) error {
+	if parentID == childID {
+		return fmt.Errorf("%w: group %s cannot be a member of itself", ErrGroupCycle, parentID)
+	}
+	descendants, err := descendantGroups(db, childID, false)
+	if err != nil {
+		return err
+	}
+	for _, id := range descendants {
+		if id == parentID {
+			return fmt.Errorf("%w: group %s already contains group %s", ErrGroupCycle, childID, parentID)
+		}
+	}
+	return nil
+}
+
+// replaceGroupGroups replaces the nested group list of a group
+func replaceGroupGroups(db *gorm.DB, parentID string, childIDs []string) error {
+	if err := requireAll(db, &models.Group{}, "group", childIDs); err != nil {
+		return err
+	}
+	if err := lockGroupHierarchy(db); err != nil {
+		return err
+	}
+	for _, childID := range childIDs {
+		if err := checkNoCycle(db, parentID, childID); err != nil {
+			return err
+		}
+	}
+	err := db.Where("parent_group_id = ? AND child_group_id IN (?)", parentID, liveIDs(db, &models.Group{})).
+		Delete(&models.GroupGroup{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear nested groups: %w", err)
+	}
+	if len(childIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.GroupGroup, len(childIDs))
+	for i, childID := range childIDs {
+		rows[i] = models.GroupGroup{ParentGroupID: parentID, ChildGroupID: childID}
+	}
+	if _, err := insertIgnoringDuplicates(db, &rows); err != nil {
+		return fmt.Errorf("failed to set nested groups: %w", err)
+	}
+	return nil
+}
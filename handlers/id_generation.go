@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/ids"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// maxSequenceAttempts bounds how many taken sequence numbers are skipped
+// before ID generation gives up
+const maxSequenceAttempts = 100
+
+// IDFormats are the ID formats of users, groups and roles
+type IDFormats struct {
+	User  ids.Format
+	Group ids.Format
+	Role  ids.Format
+}
+
+// DefaultIDFormats returns sequences in the style of the API documentation:
+// UI000001, GRP001 and ROLE001
+func DefaultIDFormats() IDFormats {
+	return IDFormats{
+		User:  ids.Sequence("UI", 6),
+		Group: ids.Sequence("GRP", 3),
+		Role:  ids.Sequence("ROLE", 3),
+	}
+}
+
+// of returns the ID format of an entity type
+func (f IDFormats) of(entity string) ids.Format {
+	switch entity {
+	case models.EntityUser:
+		return f.User
+	case models.EntityGroup:
+		return f.Group
+	default:
+		return f.Role
+	}
+}
+
+// idSequence is the counter behind the sequence IDs of an entity type
+type idSequence interface {
+	// next returns the next number of the sequence
+	next() (int64, error)
+	// taken reports whether an entity, including a soft-deleted one, has the ID
+	taken(id string) (bool, error)
+}
+
+// assignID checks a client-supplied ID against the format of the entity type,
+// or generates an ID in that format when none was supplied
+func assignID(entity string, format ids.Format, id *string, seq idSequence) error {
+	if *id != "" {
+		if !format.Match(*id) {
+			return fieldErrors(entity, []models.FieldError{{Field: "id", Rule: "format", Message: "id must be " + format.Describe()}})
+		}
+		return nil
+	}
+	if format.Kind != ids.KindSequence {
+		generated, err := format.Generate()
+		if err != nil {
+			return fmt.Errorf("failed to generate %s ID: %w", entity, err)
+		}
+		*id = generated
+		return nil
+	}
+	for attempt := 0; attempt < maxSequenceAttempts; attempt++ {
+		n, err := seq.next()
+		if err != nil {
+			return fmt.Errorf("failed to generate %s ID: %w", entity, err)
+		}
+		candidate := format.FormatSequence(n)
+		taken, err := seq.taken(candidate)
+		if err != nil {
+			return fmt.Errorf("failed to generate %s ID: %w", entity, err)
+		}
+		if !taken {
+			*id = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to generate %s ID: the next %d sequence numbers are taken", entity, maxSequenceAttempts)
+}
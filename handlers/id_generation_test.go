@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/ids"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// fakeSequence counts from zero and reports the IDs in used as taken
+type fakeSequence struct {
+	n    int64
+	used map[string]bool
+}
+
+func (s *fakeSequence) next() (int64, error) {
+	s.n++
+	return s.n, nil
+}
+
+func (s *fakeSequence) taken(id string) (bool, error) {
+	return s.used[id], nil
+}
+
+func TestAssignID(t *testing.T) {
+	format := ids.Sequence("UI", 6)
+
+	// Sequence numbers whose ID a client already took are skipped
+	seq := &fakeSequence{used: map[string]bool{"UI000001": true, "UI000002": true}}
+	id := ""
+	if err := assignID(models.EntityUser, format, &id, seq); err != nil || id != "UI000003" {
+		t.Errorf("assignID() = %q, %v, want UI000003", id, err)
+	}
+
+	// A client-supplied ID is kept when it matches and does not use the sequence
+	id = "UI000100"
+	if err := assignID(models.EntityUser, format, &id, seq); err != nil || id != "UI000100" || seq.n != 3 {
+		t.Errorf("assignID() = %q, %v after %d numbers", id, err, seq.n)
+	}
+
+	for _, bad := range []string{"bob", "UI1", "GRP001", "ui000001", "0190b6c2-5a3e-7d4f-8b1a-2c3d4e5f6a7b"} {
+		id := bad
+		err := assignID(models.EntityUser, format, &id, seq)
+		fields := ErrorFields(err)
+		if !errors.Is(err, ErrValidation) || len(fields) != 1 || fields[0].Field != "id" || fields[0].Rule != "format" {
+			t.Errorf("assignID(%q) error = %v, fields %+v, want a format error on id", bad, err, fields)
+		}
+	}
+
+	// Generation gives up rather than scanning the whole sequence
+	used := map[string]bool{}
+	for n := int64(1); n <= maxSequenceAttempts; n++ {
+		used[format.FormatSequence(n)] = true
+	}
+	id = ""
+	if err := assignID(models.EntityUser, format, &id, &fakeSequence{used: used}); err == nil || id != "" {
+		t.Errorf("assignID() = %q, %v, want an error", id, err)
+	}
+}
+
+func TestMemoryStoreIDFormats(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.SetIDFormats(IDFormats{User: ids.UUIDv7(), Group: ids.ULID(), Role: ids.Sequence("R-", 2)})
+
+	user := &models.User{Name: "Ada", Email: "ada@example.com"}
+	group := &models.Group{Name: "Engineering"}
+	role := &models.Role{Name: "developer"}
+	if err := store.CreateUser(ctx, user); err != nil || !ids.UUIDv7().Match(user.ID) {
+		t.Errorf("CreateUser() ID = %q, %v", user.ID, err)
+	}
+	if err := store.CreateGroup(ctx, group); err != nil || !ids.ULID().Match(group.ID) {
+		t.Errorf("CreateGroup() ID = %q, %v", group.ID, err)
+	}
+	if err := store.CreateRole(ctx, role); err != nil || role.ID != "R-01" {
+		t.Errorf("CreateRole() ID = %q, %v", role.ID, err)
+	}
+
+	// Client IDs are checked against the configured format, not the default one
+	tests := []struct {
+		name   string
+		create func() error
+	}{
+		{"user", func() error {
+			return store.CreateUser(ctx, &models.User{ID: "UI000001", Name: "Bob", Email: "bob@example.com"})
+		}},
+		{"group", func() error { return store.CreateGroup(ctx, &models.Group{ID: "GRP001", Name: "Ops"}) }},
+		{"role", func() error { return store.CreateRole(ctx, &models.Role{ID: "ROLE001", Name: "admin"}) }},
+	}
+	for _, tt := range tests {
+		if err := tt.create(); !errors.Is(err, ErrValidation) {
+			t.Errorf("create %s with a default-format ID: error = %v, want a validation error", tt.name, err)
+		}
+	}
+	if err := store.CreateRole(ctx, &models.Role{ID: "R-07", Name: "auditor"}); err != nil {
+		t.Errorf("CreateRole() with a matching ID: %v", err)
+	}
+}
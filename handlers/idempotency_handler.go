@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReserveIdempotencyKey claims the key of record for a new request. If an
+// unexpired record with the same caller and key exists, it is returned and
+// nothing is stored; otherwise record is stored in progress and nil is returned.
+func ReserveIdempotencyKey(db *gorm.DB, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	var existing *models.IdempotencyRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// An expired record, or the reservation of a request whose lease
+		// ended without a response, no longer holds the key
+		err := tx.Where("actor = ? AND key = ? AND (expires_at <= ? OR (status_code = 0 AND locked_until <= ?))",
+			record.Actor, record.Key, record.CreatedAt, record.CreatedAt).
+			Delete(&models.IdempotencyRecord{}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil || result.RowsAffected == 1 {
+			return result.Error
+		}
+		existing = &models.IdempotencyRecord{}
+		return tx.Where("actor = ? AND key = ?", record.Actor, record.Key).Take(existing).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of a reserved request, unless
+// another request took the key over
+func CompleteIdempotencyKey(db *gorm.DB, record *models.IdempotencyRecord) error {
+	err := db.Model(&models.IdempotencyRecord{}).
+		Where("actor = ? AND key = ? AND created_at = ?", record.Actor, record.Key, record.CreatedAt).
+		Updates(map[string]interface{}{
+			"status_code": record.StatusCode,
+			"header":      record.Header,
+			"body":        record.Body,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the reservation of a request that did not
+// complete, so that a retry runs it again
+func ReleaseIdempotencyKey(db *gorm.DB, record *models.IdempotencyRecord) error {
+	err := db.Where("actor = ? AND key = ? AND created_at = ? AND status_code = 0", record.Actor, record.Key, record.CreatedAt).
+		Delete(&models.IdempotencyRecord{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys removes the records that expired before cutoff and
+// returns how many were removed
+func PurgeIdempotencyKeys(db *gorm.DB, cutoff time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", cutoff).Delete(&models.IdempotencyRecord{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RunIdempotencyPurgeLoop removes expired idempotency records every interval
+// until ctx is cancelled
+func RunIdempotencyPurgeLoop(ctx context.Context, store DirectoryStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := store.PurgeIdempotencyKeys(ctx, time.Now()); err != nil {
+			log.Printf("Purge of expired idempotency keys failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/lotusatx/lotus-directory-engine-backend/filter"
+	"gorm.io/gorm"
+)
+
+// ListOptions controls which rows a list query returns and how they are paged
+type ListOptions struct {
+	IncludeDeleted bool              // Also return soft-deleted rows; ignored for relationship listings
+	Statuses       []string          // Only return users in one of these statuses; ignored for groups and roles
+	Attributes     map[string]string // Only return entities whose attribute equals (or, if multi-valued, contains) the value; ignored for roles
+	Filter         filter.Expr       // Only return entities matching the filter expression; ignored for relationship listings
+
+	Limit        int    // Page size; defaults to 100 and is capped at 1000
+	Cursor       string // next_cursor of the previous page
+	Sort         string // JSON field to sort by; defaults to name, or id for lists of IDs
+	Order        string // asc (default) or desc
+	IncludeTotal bool   // Also count the matching rows across all pages
+}
+
+// scope applies the options to a gorm query
+func (o ListOptions) scope(db *gorm.DB) *gorm.DB {
+	if o.IncludeDeleted {
+		return db.Unscoped()
+	}
+	return db
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// requireUser returns an error if no user with the given ID exists
+func requireUser(db *gorm.DB, userID string) error {
+	var count int64
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if count == 0 {
+		return notFound("user", userID)
+	}
+	return nil
+}
+
+// requireGroup returns an error if no group with the given ID exists
+func requireGroup(db *gorm.DB, groupID string) error {
+	var count int64
+	if err := db.Model(&models.Group{}).Where("id = ?", groupID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+	if count == 0 {
+		return notFound("group", groupID)
+	}
+	return nil
+}
+
+// requireRole returns an error if no role with the given ID exists
+func requireRole(db *gorm.DB, roleID string) error {
+	var count int64
+	if err := db.Model(&models.Role{}).Where("id = ?", roleID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if count == 0 {
+		return notFound("role", roleID)
+	}
+	return nil
+}
+
+// requireAll returns an error naming the first ID in ids that has no row in the model's table
+func requireAll(db *gorm.DB, model interface{}, kind string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var found []string
+	if err := db.Model(model).Where("id IN ?", ids).Pluck("id", &found).Error; err != nil {
+		return fmt.Errorf("failed to look up %ss: %w", kind, err)
+	}
+	existing := make(map[string]bool, len(found))
+	for _, id := range found {
+		existing[id] = true
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			return notFound(kind, id)
+		}
+	}
+	return nil
+}
+
+// liveIDs returns a subquery selecting the IDs of rows that are not soft-deleted.
+// Replacing a relationship list only touches rows pointing at live entities so
+// that relationships with tombstoned entities survive until they are restored.
+func liveIDs(db *gorm.DB, model interface{}) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Model(model).Select("id")
+}
+
+// insertIgnoringDuplicates inserts join rows, skipping rows that already exist,
+// and returns the number of rows actually inserted
+func insertIgnoringDuplicates(db *gorm.DB, rows interface{}) (int64, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(rows)
+	return result.RowsAffected, result.Error
+}
+
+// attachGroupMembers fills in the Members and MemberGroups fields of each group
+// from group_members and group_groups
+func attachGroupMembers(db *gorm.DB, groups []models.Group) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	ids := make([]string, len(groups))
+	for i := range groups {
+		ids[i] = groups[i].ID
+	}
+
+	var rows []models.GroupMember
+	err := db.Joins("JOIN users ON users.id = group_members.user_id AND users.deleted_at IS NULL").
+		Where("group_members.group_id IN ?", ids).
+		Order("group_members.user_id").
+		Find(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to load group members: %w", err)
+	}
+
+	members := make(map[string][]string)
+	for _, row := range rows {
+		members[row.GroupID] = append(members[row.GroupID], row.UserID)
+	}
+
+	var nested []models.GroupGroup
+	err = db.Joins("JOIN groups ON groups.id = group_groups.child_group_id AND groups.deleted_at IS NULL").
+		Where("group_groups.parent_group_id IN ?", ids).
+		Order("group_groups.child_group_id").
+		Find(&nested).Error
+	if err != nil {
+		return fmt.Errorf("failed to load nested groups: %w", err)
+	}
+	memberGroups := make(map[string][]string)
+	for _, row := range nested {
+		memberGroups[row.ParentGroupID] = append(memberGroups[row.ParentGroupID], row.ChildGroupID)
+	}
+
+	for i := range groups {
+		groups[i].Members = nonNil(members[groups[i].ID])
+		groups[i].MemberGroups = nonNil(memberGroups[groups[i].ID])
+	}
+	return nil
+}
+
+// attachRoleGroups fills in the Groups field of each role from role_groups
+func attachRoleGroups(db *gorm.DB, roles []models.Role) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	ids := make([]string, len(roles))
+	for i := range roles {
+		ids[i] = roles[i].ID
+	}
+
+	var rows []models.RoleGroup
+	err := db.Joins("JOIN groups ON groups.id = role_groups.group_id AND groups.deleted_at IS NULL").
+		Where("role_groups.role_id IN ?", ids).
+		Order("role_groups.group_id").
+		Find(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to load role groups: %w", err)
+	}
+
+	groups := make(map[string][]string)
+	for _, row := range rows {
+		groups[row.RoleID] = append(groups[row.RoleID], row.GroupID)
+	}
+	for i := range roles {
+		roles[i].Groups = nonNil(groups[roles[i].ID])
+	}
+	return nil
+}
+
+// attachUserRelations fills in the Roles and GroupIDs fields of each user
+// from user_roles and group_members
+func attachUserRelations(db *gorm.DB, users []models.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	ids := make([]string, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+
+	var memberships []models.GroupMember
+	err := db.Joins("JOIN groups ON groups.id = group_members.group_id AND groups.deleted_at IS NULL").
+		Where("group_members.user_id IN ?", ids).
+		Order("group_members.group_id").
+		Find(&memberships).Error
+	if err != nil {
+		return fmt.Errorf("failed to load user groups: %w", err)
+	}
+	groupIDs := make(map[string][]string)
+	for _, row := range memberships {
+		groupIDs[row.UserID] = append(groupIDs[row.UserID], row.GroupID)
+	}
+
+	var assignments []struct {
+		UserID string
+		models.Role
+	}
+	err = db.Table("user_roles").
+		Select("user_roles.user_id, roles.*").
+		Joins("JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Where("user_roles.user_id IN ?", ids).
+		Order("roles.name").
+		Scan(&assignments).Error
+	if err != nil {
+		return fmt.Errorf("failed to load user roles: %w", err)
+	}
+
+	roleList := make([]models.Role, len(assignments))
+	for i := range assignments {
+		roleList[i] = assignments[i].Role
+	}
+	if err := attachRoleGroups(db, roleList); err != nil {
+		return err
+	}
+	roles := make(map[string][]models.Role)
+	for i, assignment := range assignments {
+		roles[assignment.UserID] = append(roles[assignment.UserID], roleList[i])
+	}
+
+	for i := range users {
+		users[i].GroupIDs = nonNil(groupIDs[users[i].ID])
+		users[i].Roles = roles[users[i].ID]
+		if users[i].Roles == nil {
+			users[i].Roles = []models.Role{}
+		}
+	}
+	return nil
+}
+
+// replaceGroupMembers replaces the member list of a group
+func replaceGroupMembers(db *gorm.DB, groupID string, userIDs []string) error {
+	if err := requireAll(db, &models.User{}, "user", userIDs); err != nil {
+		return err
+	}
+	err := db.Where("group_id = ? AND user_id IN (?)", groupID, liveIDs(db, &models.User{})).
+		Delete(&models.GroupMember{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear group members: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.GroupMember, len(userIDs))
+	for i, userID := range userIDs {
+		rows[i] = models.GroupMember{GroupID: groupID, UserID: userID}
+	}
+	if _, err := insertIgnoringDuplicates(db, &rows); err != nil {
+		return fmt.Errorf("failed to set group members: %w", err)
+	}
+	return nil
+}
+
+// replaceRoleGroups replaces the group list of a role
+func replaceRoleGroups(db *gorm.DB, roleID string, groupIDs []string) error {
+	if err := requireAll(db, &models.Group{}, "group", groupIDs); err != nil {
+		return err
+	}
+	err := db.Where("role_id = ? AND group_id IN (?)", roleID, liveIDs(db, &models.Group{})).
+		Delete(&models.RoleGroup{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear role groups: %w", err)
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.RoleGroup, len(groupIDs))
+	for i, groupID := range groupIDs {
+		rows[i] = models.RoleGroup{RoleID: roleID, GroupID: groupID}
+	}
+	if _, err := insertIgnoringDuplicates(db, &rows); err != nil {
+		return fmt.Errorf("failed to set role groups: %w", err)
+	}
+	return nil
+}
+
+// replaceUserRoles replaces the role assignments of a user
+func replaceUserRoles(db *gorm.DB, userID string, roles []models.Role) error {
+	roleIDs := make([]string, len(roles))
+	for i, role := range roles {
+		roleIDs[i] = role.ID
+	}
+	if err := requireAll(db, &models.Role{}, "role", roleIDs); err != nil {
+		return err
+	}
+	err := db.Where("user_id = ? AND role_id IN (?)", userID, liveIDs(db, &models.Role{})).
+		Delete(&models.UserRole{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear user roles: %w", err)
+	}
+	if len(roleIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.UserRole, len(roleIDs))
+	for i, roleID := range roleIDs {
+		rows[i] = models.UserRole{UserID: userID, RoleID: roleID}
+	}
+	if _, err := insertIgnoringDuplicates(db, &rows); err != nil {
+		return fmt.Errorf("failed to set user roles: %w", err)
+	}
+	return nil
+}
+
+// replaceUserGroups replaces the group memberships of a user
+func replaceUserGroups(db *gorm.DB, userID string, groupIDs []string) error {
+	if err := requireAll(db, &models.Group{}, "group", groupIDs); err != nil {
+		return err
+	}
+	err := db.Where("user_id = ? AND group_id IN (?)", userID, liveIDs(db, &models.Group{})).
+		Delete(&models.GroupMember{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear user groups: %w", err)
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.GroupMember, len(groupIDs))
+	for i, groupID := range groupIDs {
+		rows[i] = models.GroupMember{GroupID: groupID, UserID: userID}
+	}
+	if _, err := insertIgnoringDuplicates(db, &rows); err != nil {
+		return fmt.Errorf("failed to set user groups: %w", err)
+	}
+	return nil
+}
+
+// nonNil returns an empty slice instead of nil so lists encode as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,1791 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// idSet is a set of entity IDs
+type idSet map[string]bool
+
+// sorted returns the members of the set in ascending order
+func (s idSet) sorted() []string {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sortedWhere returns the members of the set accepted by keep in ascending order
+func (s idSet) sortedWhere(keep func(id string) bool) []string {
+	ids := []string{}
+	for _, id := range s.sorted() {
+		if keep(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// memoryData holds the tables of a MemoryStore
+type memoryData struct {
+	sequences    map[string]int64 // entity type -> last sequence number of generated IDs
+	users        map[string]models.User
+	groups       map[string]models.Group
+	roles        map[string]models.Role
+	groupMembers map[string]idSet                      // group ID -> member user IDs
+	groupGroups  map[string]idSet                      // parent group ID -> nested group IDs
+	roleGroups   map[string]idSet                      // role ID -> group IDs
+	userRoles    map[string]idSet                      // user ID -> role IDs
+	attributes   map[string]models.AttributeDefinition // entity type + "." + name -> definition
+	audit        []models.AuditEntry
+	idempotency  map[string]models.IdempotencyRecord // actor + "\x00" + key -> record
+
+	serviceAccounts map[string]models.ServiceAccount
+	apiKeys         map[string]models.APIKey // key ID -> key
+
+	oidcClients map[string]models.OIDCClient
+	authCodes   map[string]models.AuthorizationCode // code hash -> code
+	credentials map[string]models.UserCredential    // user ID -> password
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		sequences:    make(map[string]int64),
+		users:        make(map[string]models.User),
+		groups:       make(map[string]models.Group),
+		roles:        make(map[string]models.Role),
+		groupMembers: make(map[string]idSet),
+		groupGroups:  make(map[string]idSet),
+		roleGroups:   make(map[string]idSet),
+		userRoles:    make(map[string]idSet),
+		attributes:   make(map[string]models.AttributeDefinition),
+		idempotency:  make(map[string]models.IdempotencyRecord),
+
+		serviceAccounts: make(map[string]models.ServiceAccount),
+		apiKeys:         make(map[string]models.APIKey),
+
+		oidcClients: make(map[string]models.OIDCClient),
+		authCodes:   make(map[string]models.AuthorizationCode),
+		credentials: make(map[string]models.UserCredential),
+	}
+}
+
+// clone returns a copy of the tables, used to stage transactions
+func (d *memoryData) clone() *memoryData {
+	c := newMemoryData()
+	for entity, n := range d.sequences {
+		c.sequences[entity] = n
+	}
+	for id, user := range d.users {
+		c.users[id] = user
+	}
+	for id, group := range d.groups {
+		c.groups[id] = group
+	}
+	for id, role := range d.roles {
+		c.roles[id] = role
+	}
+	for key, def := range d.attributes {
+		c.attributes[key] = def
+	}
+	for key, record := range d.idempotency {
+		c.idempotency[key] = record
+	}
+	for id, account := range d.serviceAccounts {
+		c.serviceAccounts[id] = account
+	}
+	for id, key := range d.apiKeys {
+		c.apiKeys[id] = key
+	}
+	for id, client := range d.oidcClients {
+		c.oidcClients[id] = client
+	}
+	for hash, code := range d.authCodes {
+		c.authCodes[hash] = code
+	}
+	for id, credential := range d.credentials {
+		c.credentials[id] = credential
+	}
+	for _, pair := range []struct{ src, dst map[string]idSet }{
+		{d.groupMembers, c.groupMembers},
+		{d.groupGroups, c.groupGroups},
+		{d.roleGroups, c.roleGroups},
+		{d.userRoles, c.userRoles},
+	} {
+		for key, set := range pair.src {
+			copied := make(idSet, len(set))
+			for id := range set {
+				copied[id] = true
+			}
+			pair.dst[key] = copied
+		}
+	}
+	// The audit log is append-only, so the copy shares its backing array
+	// instead of copying it: entries a transaction appends lie past the end of
+	// the original slice, and a rolled back transaction's entries are
+	// overwritten by the next append.
+	c.audit = d.audit
+	return c
+}
+
+// MemoryStore is a concurrency-safe, in-process DirectoryStore for tests and
+// local demos only. It mirrors the behavior and error messages of PostgresStore,
+// but every transaction copies the whole directory while blocking other
+// writers, so it does not scale to production data or traffic.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	data      *memoryData
+	idFormats IDFormats
+}
+
+// NewMemoryStore creates an in-memory DirectoryStore holding only the
+// built-in directory roles, like a freshly migrated database
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{data: newMemoryData(), idFormats: DefaultIDFormats()}
+	for _, role := range auth.BuiltinRoles {
+		m.data.roles[role.ID] = models.Role{ID: role.ID, Name: role.ID, Description: role.Description, Version: 1}
+	}
+	return m
+}
+
+// SetIDFormats sets the formats of generated and client-supplied IDs
+func (m *MemoryStore) SetIDFormats(formats IDFormats) {
+	m.idFormats = formats
+}
+
+// memorySequence numbers the generated IDs of an entity type
+type memorySequence struct {
+	d      *memoryData
+	entity string
+}
+
+func (s memorySequence) next() (int64, error) {
+	s.d.sequences[s.entity]++
+	return s.d.sequences[s.entity], nil
+}
+
+func (s memorySequence) taken(id string) (bool, error) {
+	var exists bool
+	switch s.entity {
+	case models.EntityUser:
+		_, exists = s.d.users[id]
+	case models.EntityGroup:
+		_, exists = s.d.groups[id]
+	default:
+		_, exists = s.d.roles[id]
+	}
+	return exists, nil
+}
+
+// link adds id to the set stored under key and reports whether it was newly added
+func link(sets map[string]idSet, key, id string) bool {
+	set, ok := sets[key]
+	if !ok {
+		set = make(idSet)
+		sets[key] = set
+	}
+	if set[id] {
+		return false
+	}
+	set[id] = true
+	return true
+}
+
+// unlink removes id from the set stored under key and reports whether it was present
+func unlink(sets map[string]idSet, key, id string) bool {
+	set := sets[key]
+	if !set[id] {
+		return false
+	}
+	delete(set, id)
+	return true
+}
+
+// userLive reports whether the user exists and is not soft-deleted
+func (d *memoryData) userLive(userID string) bool {
+	user, ok := d.users[userID]
+	return ok && !user.DeletedAt.Valid
+}
+
+// userActive reports whether the user is live and in the active status
+func (d *memoryData) userActive(userID string) bool {
+	return d.userLive(userID) && d.users[userID].Status == models.UserStatusActive
+}
+
+// groupLive reports whether the group exists and is not soft-deleted
+func (d *memoryData) groupLive(groupID string) bool {
+	group, ok := d.groups[groupID]
+	return ok && !group.DeletedAt.Valid
+}
+
+// roleLive reports whether the role exists and is not soft-deleted
+func (d *memoryData) roleLive(roleID string) bool {
+	role, ok := d.roles[roleID]
+	return ok && !role.DeletedAt.Valid
+}
+
+func (d *memoryData) requireUser(userID string) error {
+	if !d.userLive(userID) {
+		return notFound("user", userID)
+	}
+	return nil
+}
+
+func (d *memoryData) requireGroup(groupID string) error {
+	if !d.groupLive(groupID) {
+		return notFound("group", groupID)
+	}
+	return nil
+}
+
+func (d *memoryData) requireRole(roleID string) error {
+	if !d.roleLive(roleID) {
+		return notFound("role", roleID)
+	}
+	return nil
+}
+
+func (d *memoryData) requireUsers(userIDs []string) error {
+	for _, userID := range userIDs {
+		if err := d.requireUser(userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) requireGroups(groupIDs []string) error {
+	for _, groupID := range groupIDs {
+		if err := d.requireGroup(groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) requireRoles(roleIDs []string) error {
+	for _, roleID := range roleIDs {
+		if err := d.requireRole(roleID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userView returns a copy of a stored user with its relationships to live
+// groups and roles filled in
+func (d *memoryData) userView(userID string) models.User {
+	user := d.users[userID]
+	user.Attributes = maps.Clone(user.Attributes)
+
+	user.GroupIDs = []string{}
+	for groupID, members := range d.groupMembers {
+		if members[userID] && d.groupLive(groupID) {
+			user.GroupIDs = append(user.GroupIDs, groupID)
+		}
+	}
+	sort.Strings(user.GroupIDs)
+
+	user.Roles = []models.Role{}
+	for roleID := range d.userRoles[userID] {
+		if d.roleLive(roleID) {
+			user.Roles = append(user.Roles, d.roleView(roleID))
+		}
+	}
+	sortRoles(user.Roles)
+	return user
+}
+
+// groupView returns a copy of a stored group with its live members and nested groups filled in
+func (d *memoryData) groupView(groupID string) models.Group {
+	group := d.groups[groupID]
+	group.Attributes = maps.Clone(group.Attributes)
+	group.Members = d.groupMembers[groupID].sortedWhere(d.userLive)
+	group.MemberGroups = d.groupGroups[groupID].sortedWhere(d.groupLive)
+	return group
+}
+
+// attributeDefinitions returns the attribute definitions of an entity type ordered by name
+func (d *memoryData) attributeDefinitions(entityType string) []models.AttributeDefinition {
+	defs := []models.AttributeDefinition{}
+	for _, def := range d.attributes {
+		if def.EntityType == entityType {
+			defs = append(defs, def)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// serviceAccountView returns a copy of a stored service account with its keys, oldest first
+func (d *memoryData) serviceAccountView(accountID string) models.ServiceAccount {
+	account := d.serviceAccounts[accountID]
+	account.Keys = []models.APIKey{}
+	for _, key := range d.apiKeys {
+		if key.ServiceAccountID == accountID {
+			account.Keys = append(account.Keys, key)
+		}
+	}
+	sort.Slice(account.Keys, func(i, j int) bool {
+		a, b := account.Keys[i], account.Keys[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return account
+}
+
+// matchesAttributes reports whether attrs satisfies every filter
+func matchesAttributes(attrs models.Attributes, filters []attributeFilter) bool {
+	for _, filter := range filters {
+		if !filter.matches(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+// userGroups returns the live groups a user belongs to. With transitive, the
+// groups those groups are nested in are included at any depth.
+func (d *memoryData) userGroups(userID string, transitive bool) idSet {
+	found := make(idSet)
+	var queue []string
+	for groupID, members := range d.groupMembers {
+		if members[userID] && d.groupLive(groupID) {
+			found[groupID] = true
+			queue = append(queue, groupID)
+		}
+	}
+	for transitive && len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for parentID, children := range d.groupGroups {
+			if children[current] && !found[parentID] && d.groupLive(parentID) {
+				found[parentID] = true
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return found
+}
+
+// descendants returns the groups nested in groupID at any depth. With
+// liveOnly, traversal stops at soft-deleted groups.
+func (d *memoryData) descendants(groupID string, liveOnly bool) idSet {
+	found := make(idSet)
+	queue := []string{groupID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for childID := range d.groupGroups[current] {
+			if found[childID] || (liveOnly && !d.groupLive(childID)) {
+				continue
+			}
+			found[childID] = true
+			queue = append(queue, childID)
+		}
+	}
+	return found
+}
+
+// checkNoCycle returns an error if nesting childID in parentID would make a group contain itself
+func (d *memoryData) checkNoCycle(parentID, childID string) error {
+	if parentID == childID {
+		return fmt.Errorf("%w: group %s cannot be a member of itself", ErrGroupCycle, parentID)
+	}
+	if d.descendants(childID, false)[parentID] {
+		return fmt.Errorf("%w: group %s already contains group %s", ErrGroupCycle, childID, parentID)
+	}
+	return nil
+}
+
+// roleView returns a copy of a stored role with its live groups filled in
+func (d *memoryData) roleView(roleID string) models.Role {
+	role := d.roles[roleID]
+	role.Groups = d.roleGroups[roleID].sortedWhere(d.groupLive)
+	return role
+}
+
+// The set* helpers replace the relationships with live entities only, so that
+// relationships with soft-deleted entities come back when they are restored.
+
+func (d *memoryData) setUserRoles(userID string, roles []models.Role) {
+	for roleID := range d.userRoles[userID] {
+		if d.roleLive(roleID) {
+			delete(d.userRoles[userID], roleID)
+		}
+	}
+	for _, role := range roles {
+		link(d.userRoles, userID, role.ID)
+	}
+}
+
+func (d *memoryData) setUserGroups(userID string, groupIDs []string) {
+	for groupID, members := range d.groupMembers {
+		if d.groupLive(groupID) {
+			delete(members, userID)
+		}
+	}
+	for _, groupID := range groupIDs {
+		link(d.groupMembers, groupID, userID)
+	}
+}
+
+func (d *memoryData) setGroupMembers(groupID string, userIDs []string) {
+	for userID := range d.groupMembers[groupID] {
+		if d.userLive(userID) {
+			delete(d.groupMembers[groupID], userID)
+		}
+	}
+	for _, userID := range userIDs {
+		link(d.groupMembers, groupID, userID)
+	}
+}
+
+// setGroupGroups expects the caller to have checked childIDs for cycles
+func (d *memoryData) setGroupGroups(parentID string, childIDs []string) {
+	for childID := range d.groupGroups[parentID] {
+		if d.groupLive(childID) {
+			delete(d.groupGroups[parentID], childID)
+		}
+	}
+	for _, childID := range childIDs {
+		link(d.groupGroups, parentID, childID)
+	}
+}
+
+func (d *memoryData) setRoleGroups(roleID string, groupIDs []string) {
+	for groupID := range d.roleGroups[roleID] {
+		if d.groupLive(groupID) {
+			delete(d.roleGroups[roleID], groupID)
+		}
+	}
+	for _, groupID := range groupIDs {
+		link(d.roleGroups, roleID, groupID)
+	}
+}
+
+// sortRoles orders roles by name, then ID
+func sortRoles(roles []models.Role) {
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Name != roles[j].Name {
+			return roles[i].Name < roles[j].Name
+		}
+		return roles[i].ID < roles[j].ID
+	})
+}
+
+func roleIDsOf(roles []models.Role) []string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.ID
+	}
+	return ids
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := assignID(models.EntityUser, m.idFormats.User, &user.ID, memorySequence{d, models.EntityUser}); err != nil {
+		return err
+	}
+	if err := validateCreate("user", user); err != nil {
+		return err
+	}
+
+	if _, exists := d.users[user.ID]; exists {
+		return alreadyExists("user", user.ID)
+	}
+	if err := d.requireRoles(roleIDsOf(user.Roles)); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if err := d.requireGroups(user.GroupIDs); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	attrs, err := normalizeAttributes(d.attributeDefinitions(models.EntityUser), user.Attributes)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	stored := *user
+	stored.Attributes = attrs
+	stored.Roles, stored.GroupIDs = nil, nil
+	stored.DeletedAt = gorm.DeletedAt{}
+	stored.Version = 1
+	newUserLifecycle(&stored, time.Now().UTC())
+	d.users[user.ID] = stored
+	d.setUserRoles(user.ID, user.Roles)
+	d.setUserGroups(user.ID, user.GroupIDs)
+	*user = d.userView(user.ID)
+	return nil
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return nil, err
+	}
+	user := d.userView(userID)
+	return &user, nil
+}
+
+func (m *MemoryStore) GetAllUsers(ctx context.Context, opts ListOptions) (*Page[models.User], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	filters, err := parseAttributeFilters(d.attributeDefinitions(models.EntityUser), opts.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	matches, err := compileFilter(opts.Filter, userFilterFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	users := make([]models.User, 0, len(d.users))
+	for userID := range d.users {
+		if !opts.IncludeDeleted && !d.userLive(userID) {
+			continue
+		}
+		if len(opts.Statuses) > 0 && !slices.Contains(opts.Statuses, d.users[userID].Status) {
+			continue
+		}
+		if !matchesAttributes(d.users[userID].Attributes, filters) || !matches(filterValues(d.users[userID])) {
+			continue
+		}
+		users = append(users, d.users[userID])
+	}
+	page, err := pageSlice(users, opts, userSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	for i := range page.Items {
+		page.Items[i] = d.userView(page.Items[i].ID)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) UpdateUser(ctx context.Context, user *models.User) error {
+	if err := validateUpdate("user", user); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	stored, ok := d.users[user.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return fmt.Errorf("failed to update user: %w", notFound("user", user.ID))
+	}
+	if err := checkVersion("user", user.ID, stored.Version, user.Version); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if user.Roles != nil {
+		if err := d.requireRoles(roleIDsOf(user.Roles)); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	if user.GroupIDs != nil {
+		if err := d.requireGroups(user.GroupIDs); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	if user.Attributes != nil {
+		attrs, err := normalizeAttributes(d.attributeDefinitions(models.EntityUser), user.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		stored.Attributes = attrs
+	}
+
+	stored.Email = user.Email
+	stored.Name = user.Name
+	stored.UpdatedAt = time.Now().UTC()
+	stored.Version++
+	d.users[user.ID] = stored
+	if user.Roles != nil {
+		d.setUserRoles(user.ID, user.Roles)
+	}
+	if user.GroupIDs != nil {
+		d.setUserGroups(user.ID, user.GroupIDs)
+	}
+	*user = d.userView(user.ID)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, userID string, expectedVersion int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return err
+	}
+	user := d.users[userID]
+	if err := checkVersion("user", userID, user.Version, expectedVersion); err != nil {
+		return err
+	}
+	user.DeletedAt = deletedNow()
+	d.users[userID] = user
+	return nil
+}
+
+func (m *MemoryStore) RestoreUser(ctx context.Context, userID string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	user, ok := d.users[userID]
+	if !ok || !user.DeletedAt.Valid {
+		return nil, deletedNotFound("user", userID)
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.Version++
+	d.users[userID] = user
+	view := d.userView(userID)
+	return &view, nil
+}
+
+func (m *MemoryStore) ChangeUserStatus(ctx context.Context, userID string, status string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return nil, fmt.Errorf("failed to change user status: %w", err)
+	}
+	user := d.users[userID]
+	if err := checkUserTransition(userID, user.Status, status); err != nil {
+		return nil, fmt.Errorf("failed to change user status: %w", err)
+	}
+	applyUserStatus(&user, status, time.Now().UTC())
+	user.Version++
+	d.users[userID] = user
+	view := d.userView(userID)
+	return &view, nil
+}
+
+func (m *MemoryStore) CreateGroup(ctx context.Context, group *models.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := assignID(models.EntityGroup, m.idFormats.Group, &group.ID, memorySequence{d, models.EntityGroup}); err != nil {
+		return err
+	}
+	if err := validateCreate("group", group); err != nil {
+		return err
+	}
+
+	if _, exists := d.groups[group.ID]; exists {
+		return alreadyExists("group", group.ID)
+	}
+	if err := d.requireUsers(group.Members); err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+	if err := d.requireGroups(group.MemberGroups); err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+	for _, childID := range group.MemberGroups {
+		if childID == group.ID {
+			return fmt.Errorf("failed to create group: %w: group %s cannot be a member of itself", ErrGroupCycle, childID)
+		}
+	}
+	attrs, err := normalizeAttributes(d.attributeDefinitions(models.EntityGroup), group.Attributes)
+	if err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+
+	stored := *group
+	stored.Attributes = attrs
+	stored.Members, stored.MemberGroups = nil, nil
+	stored.DeletedAt = gorm.DeletedAt{}
+	stored.Version = 1
+	d.groups[group.ID] = stored
+	d.setGroupMembers(group.ID, group.Members)
+	d.setGroupGroups(group.ID, group.MemberGroups)
+	*group = d.groupView(group.ID)
+	return nil
+}
+
+func (m *MemoryStore) GetGroupByID(ctx context.Context, groupID string) (*models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireGroup(groupID); err != nil {
+		return nil, err
+	}
+	group := d.groupView(groupID)
+	return &group, nil
+}
+
+func (m *MemoryStore) GetAllGroups(ctx context.Context, opts ListOptions) (*Page[models.Group], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	filters, err := parseAttributeFilters(d.attributeDefinitions(models.EntityGroup), opts.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	matches, err := compileFilter(opts.Filter, groupFilterFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	groups := make([]models.Group, 0, len(d.groups))
+	for groupID, group := range d.groups {
+		if (opts.IncludeDeleted || d.groupLive(groupID)) && matchesAttributes(group.Attributes, filters) && matches(filterValues(group)) {
+			groups = append(groups, group)
+		}
+	}
+	page, err := pageSlice(groups, opts, groupSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	for i := range page.Items {
+		page.Items[i] = d.groupView(page.Items[i].ID)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) UpdateGroup(ctx context.Context, group *models.Group) error {
+	if err := validateUpdate("group", group); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	stored, ok := d.groups[group.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return fmt.Errorf("failed to update group: %w", notFound("group", group.ID))
+	}
+	if err := checkVersion("group", group.ID, stored.Version, group.Version); err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+	if group.Members != nil {
+		if err := d.requireUsers(group.Members); err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+	}
+	if group.MemberGroups != nil {
+		if err := d.requireGroups(group.MemberGroups); err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+		for _, childID := range group.MemberGroups {
+			if err := d.checkNoCycle(group.ID, childID); err != nil {
+				return fmt.Errorf("failed to update group: %w", err)
+			}
+		}
+	}
+	if group.Attributes != nil {
+		attrs, err := normalizeAttributes(d.attributeDefinitions(models.EntityGroup), group.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+		stored.Attributes = attrs
+	}
+
+	stored.Name = group.Name
+	stored.Description = group.Description
+	stored.Version++
+	d.groups[group.ID] = stored
+	if group.Members != nil {
+		d.setGroupMembers(group.ID, group.Members)
+	}
+	if group.MemberGroups != nil {
+		d.setGroupGroups(group.ID, group.MemberGroups)
+	}
+	*group = d.groupView(group.ID)
+	return nil
+}
+
+func (m *MemoryStore) DeleteGroup(ctx context.Context, groupID string, expectedVersion int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireGroup(groupID); err != nil {
+		return err
+	}
+	group := d.groups[groupID]
+	if err := checkVersion("group", groupID, group.Version, expectedVersion); err != nil {
+		return err
+	}
+	group.DeletedAt = deletedNow()
+	d.groups[groupID] = group
+	return nil
+}
+
+func (m *MemoryStore) RestoreGroup(ctx context.Context, groupID string) (*models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	group, ok := d.groups[groupID]
+	if !ok || !group.DeletedAt.Valid {
+		return nil, deletedNotFound("group", groupID)
+	}
+	group.DeletedAt = gorm.DeletedAt{}
+	group.Version++
+	d.groups[groupID] = group
+	view := d.groupView(groupID)
+	return &view, nil
+}
+
+func (m *MemoryStore) AddUserToGroup(ctx context.Context, groupID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireGroup(groupID); err != nil {
+		return err
+	}
+	if err := d.requireUser(userID); err != nil {
+		return err
+	}
+	if !link(d.groupMembers, groupID, userID) {
+		return relationshipExists("user %s is already a member of group %s", userID, groupID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) RemoveUserFromGroup(ctx context.Context, groupID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireGroup(groupID); err != nil {
+		return err
+	}
+	if !unlink(d.groupMembers, groupID, userID) {
+		return relationshipNotFound("user %s is not a member of group %s", userID, groupID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddGroupToGroup(ctx context.Context, parentID string, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireGroup(parentID); err != nil {
+		return err
+	}
+	if err := d.requireGroup(childID); err != nil {
+		return err
+	}
+	if err := d.checkNoCycle(parentID, childID); err != nil {
+		return err
+	}
+	if !link(d.groupGroups, parentID, childID) {
+		return relationshipExists("group %s is already a member of group %s", childID, parentID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) RemoveGroupFromGroup(ctx context.Context, parentID string, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireGroup(parentID); err != nil {
+		return err
+	}
+	if !unlink(d.groupGroups, parentID, childID) {
+		return relationshipNotFound("group %s is not a member of group %s", childID, parentID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetGroupMembers(ctx context.Context, groupID string, transitive bool, opts ListOptions) (*GroupMembers, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireGroup(groupID); err != nil {
+		return nil, err
+	}
+	members := &GroupMembers{}
+	var userIDs []string
+	if transitive {
+		nested := d.descendants(groupID, true)
+		users := make(idSet)
+		for _, id := range append(nested.sorted(), groupID) {
+			for userID := range d.groupMembers[id] {
+				users[userID] = true
+			}
+		}
+		userIDs = users.sortedWhere(d.userActive)
+		members.Groups = nested.sorted()
+	} else {
+		userIDs = d.groupMembers[groupID].sortedWhere(d.userLive)
+		members.Groups = d.groupGroups[groupID].sortedWhere(d.groupLive)
+	}
+
+	page, err := pageSlice(userIDs, opts, idSortKeys("group_members.user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+	members.setUsers(page)
+	return members, nil
+}
+
+func (m *MemoryStore) GetUserGroups(ctx context.Context, userID string, transitive bool, opts ListOptions) (*Page[models.Group], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	groups := []models.Group{}
+	for groupID := range d.userGroups(userID, transitive) {
+		groups = append(groups, d.groupView(groupID))
+	}
+	page, err := pageSlice(groups, opts, groupSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) CreateRole(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := assignID(models.EntityRole, m.idFormats.Role, &role.ID, memorySequence{d, models.EntityRole}); err != nil {
+		return err
+	}
+	if err := validateCreate("role", role); err != nil {
+		return err
+	}
+
+	if _, exists := d.roles[role.ID]; exists {
+		return alreadyExists("role", role.ID)
+	}
+	if err := d.requireGroups(role.Groups); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	stored := *role
+	stored.Groups = nil
+	stored.DeletedAt = gorm.DeletedAt{}
+	stored.Version = 1
+	d.roles[role.ID] = stored
+	d.setRoleGroups(role.ID, role.Groups)
+	*role = d.roleView(role.ID)
+	return nil
+}
+
+func (m *MemoryStore) GetRoleByID(ctx context.Context, roleID string) (*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireRole(roleID); err != nil {
+		return nil, err
+	}
+	role := d.roleView(roleID)
+	return &role, nil
+}
+
+func (m *MemoryStore) GetAllRoles(ctx context.Context, opts ListOptions) (*Page[models.Role], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	matches, err := compileFilter(opts.Filter, roleFilterFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	roles := make([]models.Role, 0, len(d.roles))
+	for roleID := range d.roles {
+		if !opts.IncludeDeleted && !d.roleLive(roleID) {
+			continue
+		}
+		if role := d.roleView(roleID); matches(filterValues(role)) {
+			roles = append(roles, role)
+		}
+	}
+	page, err := pageSlice(roles, opts, roleSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	if err := validateUpdate("role", role); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	stored, ok := d.roles[role.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return fmt.Errorf("failed to update role: %w", notFound("role", role.ID))
+	}
+	if err := checkVersion("role", role.ID, stored.Version, role.Version); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	if role.Groups != nil {
+		if err := d.requireGroups(role.Groups); err != nil {
+			return fmt.Errorf("failed to update role: %w", err)
+		}
+	}
+
+	stored.Name = role.Name
+	stored.Description = role.Description
+	stored.Version++
+	d.roles[role.ID] = stored
+	if role.Groups != nil {
+		d.setRoleGroups(role.ID, role.Groups)
+	}
+	*role = d.roleView(role.ID)
+	return nil
+}
+
+func (m *MemoryStore) DeleteRole(ctx context.Context, roleID string, expectedVersion int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireRole(roleID); err != nil {
+		return err
+	}
+	role := d.roles[roleID]
+	if err := checkVersion("role", roleID, role.Version, expectedVersion); err != nil {
+		return err
+	}
+	role.DeletedAt = deletedNow()
+	d.roles[roleID] = role
+	return nil
+}
+
+func (m *MemoryStore) RestoreRole(ctx context.Context, roleID string) (*models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	role, ok := d.roles[roleID]
+	if !ok || !role.DeletedAt.Valid {
+		return nil, deletedNotFound("role", roleID)
+	}
+	role.DeletedAt = gorm.DeletedAt{}
+	role.Version++
+	d.roles[roleID] = role
+	view := d.roleView(roleID)
+	return &view, nil
+}
+
+func (m *MemoryStore) AddGroupToRole(ctx context.Context, roleID string, groupID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireRole(roleID); err != nil {
+		return err
+	}
+	if err := d.requireGroup(groupID); err != nil {
+		return err
+	}
+	if !link(d.roleGroups, roleID, groupID) {
+		return relationshipExists("group %s is already associated with role %s", groupID, roleID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) RemoveGroupFromRole(ctx context.Context, roleID string, groupID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireRole(roleID); err != nil {
+		return err
+	}
+	if !unlink(d.roleGroups, roleID, groupID) {
+		return relationshipNotFound("group %s is not associated with role %s", groupID, roleID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetRoleGroups(ctx context.Context, roleID string, opts ListOptions) (*Page[string], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireRole(roleID); err != nil {
+		return nil, err
+	}
+	page, err := pageSlice(d.roleGroups[roleID].sortedWhere(d.groupLive), opts, idSortKeys("role_groups.group_id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role groups: %w", err)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) AssignRoleToUser(ctx context.Context, userID string, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return err
+	}
+	if err := d.requireRole(roleID); err != nil {
+		return err
+	}
+	if !link(d.userRoles, userID, roleID) {
+		return relationshipExists("user %s already has role %s", userID, roleID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) RemoveRoleFromUser(ctx context.Context, userID string, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return err
+	}
+	if !unlink(d.userRoles, userID, roleID) {
+		return relationshipNotFound("user %s does not have role %s", userID, roleID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return nil, err
+	}
+	page, err := pageSlice(d.userView(userID).Roles, opts, roleSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) GetEffectiveRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return nil, err
+	}
+	roles := []models.Role{}
+	if !d.userActive(userID) {
+		return pageSlice(roles, opts, roleSortKeys)
+	}
+
+	roleIDs := make(idSet)
+	for roleID := range d.userRoles[userID] {
+		roleIDs[roleID] = true
+	}
+	for groupID := range d.userGroups(userID, true) {
+		for roleID, groups := range d.roleGroups {
+			if groups[groupID] {
+				roleIDs[roleID] = true
+			}
+		}
+	}
+	for _, roleID := range roleIDs.sortedWhere(d.roleLive) {
+		roles = append(roles, d.roleView(roleID))
+	}
+	page, err := pageSlice(roles, opts, roleSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get effective roles: %w", err)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) CreateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := validateAttributeDefinition(def); err != nil {
+		return fmt.Errorf("failed to create attribute definition: %w", err)
+	}
+	key := attributeKey(def.EntityType, def.Name)
+	if _, exists := d.attributes[key]; exists {
+		return alreadyExists("attribute definition", key)
+	}
+	if err := checkStoredValues(nil, def, d.countStoredValues(def.EntityType, def.Name)); err != nil {
+		return fmt.Errorf("failed to create attribute definition: %w", err)
+	}
+	now := time.Now().UTC()
+	def.CreatedAt, def.UpdatedAt = now, now
+	d.attributes[key] = *def
+	return nil
+}
+
+func (m *MemoryStore) GetAttributeDefinitions(ctx context.Context, entityType string) ([]models.AttributeDefinition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.data.attributeDefinitions(entityType), nil
+}
+
+func (m *MemoryStore) GetAttributeDefinition(ctx context.Context, entityType string, name string) (*models.AttributeDefinition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	def, ok := d.attributes[attributeKey(entityType, name)]
+	if !ok {
+		return nil, notFound("attribute definition", attributeKey(entityType, name))
+	}
+	return &def, nil
+}
+
+func (m *MemoryStore) UpdateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	key := attributeKey(def.EntityType, def.Name)
+	stored, ok := d.attributes[key]
+	if !ok {
+		return fmt.Errorf("failed to update attribute definition: %w", notFound("attribute definition", key))
+	}
+	if err := validateAttributeDefinition(def); err != nil {
+		return fmt.Errorf("failed to update attribute definition: %w", err)
+	}
+	if err := checkDefinitionChange(&stored, def); err != nil {
+		return fmt.Errorf("failed to update attribute definition: %w", err)
+	}
+	if err := checkStoredValues(&stored, def, d.countStoredValues(def.EntityType, def.Name)); err != nil {
+		return fmt.Errorf("failed to update attribute definition: %w", err)
+	}
+	stored.Required = def.Required
+	stored.EnumValues = def.EnumValues
+	stored.Description = def.Description
+	stored.UpdatedAt = time.Now().UTC()
+	d.attributes[key] = stored
+	*def = stored
+	return nil
+}
+
+// countStoredValues counts the users or groups without a value for an
+// attribute or matching a filter on it
+func (d *memoryData) countStoredValues(entityType, name string) storedValueCounter {
+	return func(filter *attributeFilter) (int64, error) {
+		var stored []models.Attributes
+		switch entityType {
+		case models.EntityUser:
+			for _, user := range d.users {
+				stored = append(stored, user.Attributes)
+			}
+		case models.EntityGroup:
+			for _, group := range d.groups {
+				stored = append(stored, group.Attributes)
+			}
+		}
+		var n int64
+		for _, attrs := range stored {
+			if _, ok := attrs[name]; (filter == nil && !ok) || (filter != nil && filter.matches(attrs)) {
+				n++
+			}
+		}
+		return n, nil
+	}
+}
+
+func (m *MemoryStore) DeleteAttributeDefinition(ctx context.Context, entityType string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	key := attributeKey(entityType, name)
+	if _, ok := d.attributes[key]; !ok {
+		return fmt.Errorf("failed to delete attribute definition: %w", notFound("attribute definition", key))
+	}
+	delete(d.attributes, key)
+	switch entityType {
+	case models.EntityUser:
+		for id, user := range d.users {
+			if _, ok := user.Attributes[name]; ok {
+				user.Attributes = maps.Clone(user.Attributes)
+				delete(user.Attributes, name)
+				user.Version++
+				d.users[id] = user
+			}
+		}
+	case models.EntityGroup:
+		for id, group := range d.groups {
+			if _, ok := group.Attributes[name]; ok {
+				group.Attributes = maps.Clone(group.Attributes)
+				delete(group.Attributes, name)
+				group.Version++
+				d.groups[id] = group
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := validateCreate("service account", account); err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	if _, exists := d.serviceAccounts[account.ID]; exists {
+		return fmt.Errorf("failed to create service account: %w", alreadyExists("service account", account.ID))
+	}
+	now := time.Now().UTC()
+	account.CreatedAt, account.UpdatedAt = now, now
+	account.Keys = []models.APIKey{}
+	d.serviceAccounts[account.ID] = *account
+	return nil
+}
+
+func (m *MemoryStore) GetServiceAccount(ctx context.Context, accountID string) (*models.ServiceAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	if _, ok := d.serviceAccounts[accountID]; !ok {
+		return nil, notFound("service account", accountID)
+	}
+	account := d.serviceAccountView(accountID)
+	return &account, nil
+}
+
+func (m *MemoryStore) GetServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	accounts := []models.ServiceAccount{}
+	for _, id := range slices.Sorted(maps.Keys(d.serviceAccounts)) {
+		accounts = append(accounts, d.serviceAccountView(id))
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStore) DeleteServiceAccount(ctx context.Context, accountID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if _, ok := d.serviceAccounts[accountID]; !ok {
+		return notFound("service account", accountID)
+	}
+	delete(d.serviceAccounts, accountID)
+	for id, key := range d.apiKeys {
+		if key.ServiceAccountID == accountID {
+			delete(d.apiKeys, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	now := time.Now().UTC()
+	if err := validateAPIKey(key, now); err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	account, ok := d.serviceAccounts[key.ServiceAccountID]
+	if !ok {
+		return fmt.Errorf("failed to create API key: %w", notFound("service account", key.ServiceAccountID))
+	}
+	active := 0
+	for _, stored := range d.apiKeys {
+		if stored.ServiceAccountID == key.ServiceAccountID && stored.Active(now) {
+			active++
+		}
+	}
+	if active >= models.MaxActiveAPIKeys {
+		return fmt.Errorf("failed to create API key: %w", apiKeyLimitReached(key.ServiceAccountID))
+	}
+	if _, exists := d.apiKeys[key.ID]; exists {
+		return fmt.Errorf("failed to create API key: %w", alreadyExists("api key", key.ID))
+	}
+	key.CreatedAt = now
+	stored := *key
+	stored.Key = ""
+	d.apiKeys[key.ID] = stored
+	account.UpdatedAt = now
+	d.serviceAccounts[account.ID] = account
+	return nil
+}
+
+func (m *MemoryStore) GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	key, ok := d.apiKeys[keyID]
+	if !ok {
+		return nil, notFound("api key", keyID)
+	}
+	return &key, nil
+}
+
+func (m *MemoryStore) DeleteAPIKey(ctx context.Context, accountID string, keyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	key, ok := d.apiKeys[keyID]
+	if !ok || key.ServiceAccountID != accountID {
+		return notFound("api key", keyID)
+	}
+	delete(d.apiKeys, keyID)
+	return nil
+}
+
+func (m *MemoryStore) CreateOIDCClient(ctx context.Context, client *models.OIDCClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := validateOIDCClient(client); err != nil {
+		return fmt.Errorf("failed to create OIDC client: %w", err)
+	}
+	if _, exists := d.oidcClients[client.ID]; exists {
+		return fmt.Errorf("failed to create OIDC client: %w", alreadyExists("oidc client", client.ID))
+	}
+	now := time.Now().UTC()
+	client.CreatedAt, client.UpdatedAt = now, now
+	stored := *client
+	stored.Secret = ""
+	d.oidcClients[client.ID] = stored
+	return nil
+}
+
+func (m *MemoryStore) GetOIDCClient(ctx context.Context, clientID string) (*models.OIDCClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	client, ok := d.oidcClients[clientID]
+	if !ok {
+		return nil, notFound("oidc client", clientID)
+	}
+	return &client, nil
+}
+
+func (m *MemoryStore) GetOIDCClients(ctx context.Context) ([]models.OIDCClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	clients := []models.OIDCClient{}
+	for _, id := range slices.Sorted(maps.Keys(d.oidcClients)) {
+		clients = append(clients, d.oidcClients[id])
+	}
+	return clients, nil
+}
+
+func (m *MemoryStore) DeleteOIDCClient(ctx context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if _, ok := d.oidcClients[clientID]; !ok {
+		return notFound("oidc client", clientID)
+	}
+	delete(d.oidcClients, clientID)
+	for hash, code := range d.authCodes {
+		if code.ClientID == clientID {
+			delete(d.authCodes, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	now := time.Now().UTC()
+	for hash, stored := range d.authCodes {
+		if stored.ExpiresAt.Before(now) {
+			delete(d.authCodes, hash)
+		}
+	}
+	code.CreatedAt = now
+	d.authCodes[code.Hash] = *code
+	return nil
+}
+
+func (m *MemoryStore) ConsumeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	code, ok := d.authCodes[hash]
+	delete(d.authCodes, hash)
+	if !ok || !time.Now().Before(code.ExpiresAt) {
+		return nil, newError(ErrNotFound, "authorization_code_not_found", "authorization code is invalid, expired or already redeemed")
+	}
+	return &code, nil
+}
+
+func (m *MemoryStore) SetUserPassword(ctx context.Context, userID string, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if err := d.requireUser(userID); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	d.credentials[userID] = models.UserCredential{UserID: userID, PasswordHash: passwordHash, UpdatedAt: time.Now().UTC()}
+	return nil
+}
+
+func (m *MemoryStore) GetUserPassword(ctx context.Context, userID string) (*models.UserCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	credential, ok := d.credentials[userID]
+	if !ok {
+		return nil, notFound("password", userID)
+	}
+	return &credential, nil
+}
+
+func (m *MemoryStore) DeleteUserPassword(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	if _, ok := d.credentials[userID]; !ok {
+		return notFound("password", userID)
+	}
+	delete(d.credentials, userID)
+	return nil
+}
+
+func (m *MemoryStore) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	entry.ID = int64(len(d.audit)) + 1
+	d.audit = append(d.audit, *entry)
+	return nil
+}
+
+func (m *MemoryStore) ListAudit(ctx context.Context, filter AuditFilter) (*Page[models.AuditEntry], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	page, err := filter.page()
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.AuditEntry{}
+	for i := len(d.audit) - 1; i >= 0 && len(entries) <= page.spec.limit; i-- {
+		if filter.matches(d.audit[i]) && page.follows(d.audit[i]) {
+			entries = append(entries, d.audit[i])
+		}
+	}
+	return page.result(entries), nil
+}
+
+func (m *MemoryStore) Search(ctx context.Context, query string, opts SearchOptions) (*models.SearchResults, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d := m.data
+
+	q, err := newSearchQuery(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	hits := []models.SearchHit{}
+	match := func(source searchSource, id, name, detail string) {
+		if score := q.score(name, detail); score > 0 {
+			hits = append(hits, q.hit(source, id, name, detail, score))
+		}
+	}
+	for _, source := range q.sources {
+		switch source.entity {
+		case models.EntityUser:
+			for userID, user := range d.users {
+				if d.userLive(userID) {
+					match(source, userID, user.Name, user.Email)
+				}
+			}
+		case models.EntityGroup:
+			for groupID, group := range d.groups {
+				if d.groupLive(groupID) {
+					match(source, groupID, group.Name, group.Description)
+				}
+			}
+		case models.EntityRole:
+			for roleID, role := range d.roles {
+				if d.roleLive(roleID) {
+					match(source, roleID, role.Name, role.Description)
+				}
+			}
+		}
+	}
+	return &models.SearchResults{Query: q.text, Hits: q.rankHits(hits)}, nil
+}
+
+// idempotencyKey returns the key of a caller's idempotency record
+func idempotencyKey(actor, key string) string {
+	return actor + "\x00" + key
+}
+
+func (m *MemoryStore) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	k := idempotencyKey(record.Actor, record.Key)
+	existing, ok := d.idempotency[k]
+	if ok && existing.ExpiresAt.After(record.CreatedAt) && (existing.Completed() || existing.LockedUntil.After(record.CreatedAt)) {
+		return &existing, nil
+	}
+	d.idempotency[k] = *record
+	return nil, nil
+}
+
+func (m *MemoryStore) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	k := idempotencyKey(record.Actor, record.Key)
+	if existing, ok := d.idempotency[k]; ok && existing.CreatedAt.Equal(record.CreatedAt) {
+		d.idempotency[k] = *record
+	}
+	return nil
+}
+
+func (m *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	k := idempotencyKey(record.Actor, record.Key)
+	if existing, ok := d.idempotency[k]; ok && existing.CreatedAt.Equal(record.CreatedAt) && !existing.Completed() {
+		delete(d.idempotency, k)
+	}
+	return nil
+}
+
+func (m *MemoryStore) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	var purged int64
+	for k, record := range d.idempotency {
+		if record.ExpiresAt.Before(cutoff) {
+			delete(d.idempotency, k)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (*PurgeResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.data
+
+	result := &PurgeResult{Users: []string{}, Groups: []string{}, Roles: []string{}}
+	for userID, user := range d.users {
+		if user.DeletedAt.Valid && user.DeletedAt.Time.Before(cutoff) {
+			delete(d.users, userID)
+			delete(d.userRoles, userID)
+			delete(d.credentials, userID)
+			for hash, code := range d.authCodes {
+				if code.UserID == userID {
+					delete(d.authCodes, hash)
+				}
+			}
+			for _, members := range d.groupMembers {
+				delete(members, userID)
+			}
+			result.Users = append(result.Users, userID)
+		}
+	}
+	for groupID, group := range d.groups {
+		if group.DeletedAt.Valid && group.DeletedAt.Time.Before(cutoff) {
+			delete(d.groups, groupID)
+			delete(d.groupMembers, groupID)
+			delete(d.groupGroups, groupID)
+			for _, children := range d.groupGroups {
+				delete(children, groupID)
+			}
+			for _, groups := range d.roleGroups {
+				delete(groups, groupID)
+			}
+			result.Groups = append(result.Groups, groupID)
+		}
+	}
+	for roleID, role := range d.roles {
+		if role.DeletedAt.Valid && role.DeletedAt.Time.Before(cutoff) {
+			delete(d.roles, roleID)
+			delete(d.roleGroups, roleID)
+			for _, roles := range d.userRoles {
+				delete(roles, roleID)
+			}
+			result.Roles = append(result.Roles, roleID)
+		}
+	}
+	sort.Strings(result.Users)
+	sort.Strings(result.Groups)
+	sort.Strings(result.Roles)
+	return result, nil
+}
+
+// deletedNow returns a tombstone timestamp for the current time
+func deletedNow() gorm.DeletedAt {
+	return gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+}
+
+// WithTx stages fn's changes on a copy of the data and publishes them only if
+// fn succeeds. Other writers are blocked until the transaction finishes. The
+// copy is proportional to the size of the directory, which is acceptable for
+// the small data sets of tests and demos that this store is meant for.
+func (m *MemoryStore) WithTx(ctx context.Context, fn func(tx DirectoryStore) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryStore{data: m.data.clone(), idFormats: m.idFormats}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.data = tx.data
+	return nil
+}
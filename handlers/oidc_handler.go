@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// validateOIDCClient checks a new client and its redirect URIs
+func validateOIDCClient(client *models.OIDCClient) error {
+	fields := models.Validate(client)
+	if client.PostLogoutRedirectURIs == nil {
+		client.PostLogoutRedirectURIs = models.StringList{}
+	}
+	if client.Scopes == nil {
+		client.Scopes = models.StringList{}
+	}
+	for _, list := range []struct {
+		field string
+		uris  []string
+	}{
+		{"redirect_uris", client.RedirectURIs},
+		{"post_logout_redirect_uris", client.PostLogoutRedirectURIs},
+	} {
+		for _, uri := range list.uris {
+			if !validRedirectURI(uri) {
+				fields = append(fields, models.FieldError{Field: list.field, Rule: "redirect_uri",
+					Message: fmt.Sprintf("%s has an invalid URI %q: expected an absolute https URI without fragment, http on a loopback address or a private-use scheme", list.field, uri)})
+				break
+			}
+		}
+	}
+	for _, scope := range client.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\"\\") {
+			fields = append(fields, models.FieldError{Field: "scopes", Rule: "scope", Message: fmt.Sprintf("scopes has an invalid scope %q", scope)})
+			break
+		}
+	}
+	if !client.Public && client.SecretHash == "" {
+		fields = append(fields, models.FieldError{Field: "public", Rule: "secret", Message: "a confidential client needs a secret"})
+	}
+	if client.Public && client.SecretHash != "" {
+		fields = append(fields, models.FieldError{Field: "public", Rule: "secret", Message: "a public client cannot have a secret"})
+	}
+	return fieldErrors("oidc client", fields)
+}
+
+// validRedirectURI reports whether uri may receive authorization responses:
+// https, http on a loopback address, or a private-use scheme of a native app
+// such as com.example.app (RFC 8252)
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || len(uri) > 2048 {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+// ValidatePassword checks the length of a new password
+func ValidatePassword(password string) error {
+	var fields []models.FieldError
+	switch length := utf8.RuneCountInString(password); {
+	case length < auth.MinPasswordLength:
+		fields = append(fields, models.FieldError{Field: "password", Rule: "min",
+			Message: fmt.Sprintf("password must have at least %d characters", auth.MinPasswordLength)})
+	case length > auth.MaxPasswordLength:
+		fields = append(fields, models.FieldError{Field: "password", Rule: "max",
+			Message: fmt.Sprintf("password must have at most %d characters", auth.MaxPasswordLength)})
+	}
+	return fieldErrors("password", fields)
+}
+
+// CreateOIDCClient registers a client. SecretHash must be set unless the client is public.
+func CreateOIDCClient(db *gorm.DB, client *models.OIDCClient) error {
+	if err := validateOIDCClient(client); err != nil {
+		return fmt.Errorf("failed to create OIDC client: %w", err)
+	}
+	now := time.Now().UTC()
+	client.CreatedAt, client.UpdatedAt = now, now
+	if err := db.Create(client).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			err = alreadyExists("oidc client", client.ID)
+		}
+		return fmt.Errorf("failed to create OIDC client: %w", err)
+	}
+	return nil
+}
+
+// GetOIDCClient retrieves a client, including its secret hash
+func GetOIDCClient(db *gorm.DB, clientID string) (*models.OIDCClient, error) {
+	var client models.OIDCClient
+	result := db.Where("id = ?", clientID).First(&client)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, notFound("oidc client", clientID)
+		}
+		return nil, fmt.Errorf("failed to get OIDC client: %w", result.Error)
+	}
+	return &client, nil
+}
+
+// GetOIDCClients retrieves every client, ordered by ID
+func GetOIDCClients(db *gorm.DB) ([]models.OIDCClient, error) {
+	clients := []models.OIDCClient{}
+	if err := db.Order("id").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to query OIDC clients: %w", err)
+	}
+	return clients, nil
+}
+
+// DeleteOIDCClient removes a client; its unredeemed codes are removed with it
+func DeleteOIDCClient(db *gorm.DB, clientID string) error {
+	result := db.Where("id = ?", clientID).Delete(&models.OIDCClient{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete OIDC client: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return notFound("oidc client", clientID)
+	}
+	return nil
+}
+
+// CreateAuthorizationCode stores an issued code and removes the expired ones
+func CreateAuthorizationCode(db *gorm.DB, code *models.AuthorizationCode) error {
+	now := time.Now().UTC()
+	code.CreatedAt = now
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", now).Delete(&models.AuthorizationCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(code).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode removes and returns the code with the given hash,
+// so that a code is redeemed at most once. Expired codes are not returned.
+func ConsumeAuthorizationCode(db *gorm.DB, hash string) (*models.AuthorizationCode, error) {
+	var codes []models.AuthorizationCode
+	result := db.Clauses(clause.Returning{}).Where("hash = ?", hash).Delete(&codes)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", result.Error)
+	}
+	if len(codes) == 0 || !time.Now().Before(codes[0].ExpiresAt) {
+		return nil, newError(ErrNotFound, "authorization_code_not_found", "authorization code is invalid, expired or already redeemed")
+	}
+	return &codes[0], nil
+}
+
+// SetUserPassword stores the password hash of a user that is not deleted
+func SetUserPassword(db *gorm.DB, userID string, passwordHash string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", userID).First(&models.User{})
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return notFound("user", userID)
+			}
+			return result.Error
+		}
+		credential := models.UserCredential{UserID: userID, PasswordHash: passwordHash, UpdatedAt: time.Now().UTC()}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"password_hash", "updated_at"}),
+		}).Create(&credential).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	return nil
+}
+
+// GetUserPassword retrieves the password hash of a user
+func GetUserPassword(db *gorm.DB, userID string) (*models.UserCredential, error) {
+	var credential models.UserCredential
+	result := db.Where("user_id = ?", userID).First(&credential)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, notFound("password", userID)
+		}
+		return nil, fmt.Errorf("failed to get password: %w", result.Error)
+	}
+	return &credential, nil
+}
+
+// DeleteUserPassword removes the password of a user, who can then no longer log in
+func DeleteUserPassword(db *gorm.DB, userID string) error {
+	result := db.Where("user_id = ?", userID).Delete(&models.UserCredential{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete password: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return notFound("password", userID)
+	}
+	return nil
+}
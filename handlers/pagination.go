@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// ErrInvalidListOptions is returned when a sort key, order or cursor is not valid for a list
+var ErrInvalidListOptions error = newError(ErrInvalidRequest, "invalid_list_options", "invalid list options")
+
+// Page is one page of a list result
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"` // Pass as cursor to fetch the next page; empty on the last page
+	Total      *int64 `json:"total,omitempty"`       // Number of matching items across all pages, when requested
+}
+
+// sortKey is a field a list can be sorted by
+type sortKey struct {
+	column string // Qualified SQL column
+	time   bool   // Values are timestamps rather than text
+}
+
+// sortKeys maps the JSON field names accepted as sort keys to their columns.
+// Every set has an "id" key, which is also the tiebreaker.
+type sortKeys map[string]sortKey
+
+var (
+	userSortKeys = sortKeys{
+		"id":         {column: "users.id"},
+		"name":       {column: "users.name"},
+		"email":      {column: "users.email"},
+		"status":     {column: "users.status"},
+		"created_at": {column: "users.created_at", time: true},
+		"updated_at": {column: "users.updated_at", time: true},
+	}
+	groupSortKeys = sortKeys{
+		"id":   {column: "groups.id"},
+		"name": {column: "groups.name"},
+	}
+	roleSortKeys = sortKeys{
+		"id":   {column: "roles.id"},
+		"name": {column: "roles.name"},
+	}
+)
+
+// idSortKeys is the key set of a plain list of IDs held in column
+func idSortKeys(column string) sortKeys {
+	return sortKeys{"id": {column: column}}
+}
+
+// pageCursor marks the last item of a page. It is handed to clients as an
+// opaque base64 string and is only valid for the list and sort it was issued for.
+type pageCursor struct {
+	List  string `json:"l"` // Table of the list's IDs, e.g. users or group_members
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	var cursor pageCursor
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cursor); err != nil || decoder.More() || cursor.List == "" || cursor.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return &cursor, nil
+}
+
+// listName identifies the list of a sort key set by the table of its IDs
+func (keys sortKeys) listName() string {
+	table, _, _ := strings.Cut(keys["id"].column, ".")
+	return table
+}
+
+// pageSpec is the validated paging and sorting part of ListOptions
+type pageSpec struct {
+	list  string
+	sort  string
+	key   sortKey
+	id    sortKey
+	desc  bool
+	limit int
+	after *pageCursor
+}
+
+// pageSpec validates the paging options against the sort keys of a list.
+// Lists sort by name when they have one and by ID otherwise.
+func (o ListOptions) pageSpec(keys sortKeys) (*pageSpec, error) {
+	spec := &pageSpec{list: keys.listName(), sort: o.Sort, id: keys["id"], limit: o.Limit}
+	if spec.sort == "" {
+		spec.sort = "id"
+		if _, ok := keys["name"]; ok {
+			spec.sort = "name"
+		}
+	}
+	key, ok := keys[spec.sort]
+	if !ok {
+		allowed := make([]string, 0, len(keys))
+		for name := range keys {
+			allowed = append(allowed, name)
+		}
+		sort.Strings(allowed)
+		return nil, fmt.Errorf("%w: cannot sort by %q, expected one of %s", ErrInvalidListOptions, o.Sort, strings.Join(allowed, ", "))
+	}
+	spec.key = key
+
+	switch strings.ToLower(o.Order) {
+	case "", "asc":
+	case "desc":
+		spec.desc = true
+	default:
+		return nil, fmt.Errorf("%w: order must be asc or desc, got %q", ErrInvalidListOptions, o.Order)
+	}
+
+	if spec.limit <= 0 {
+		spec.limit = defaultPageLimit
+	}
+	if spec.limit > maxPageLimit {
+		spec.limit = maxPageLimit
+	}
+
+	if o.Cursor != "" {
+		cursor, err := decodeCursor(o.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.List != spec.list {
+			return nil, fmt.Errorf("%w: cursor was issued for a different list", ErrInvalidListOptions)
+		}
+		if cursor.Sort != spec.sort || cursor.Desc != spec.desc {
+			return nil, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidListOptions)
+		}
+		if key.time {
+			if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+			}
+		}
+		spec.after = cursor
+	}
+	return spec, nil
+}
+
+// direction returns the SQL sort direction and keyset comparison operator
+func (s *pageSpec) direction() (string, string) {
+	if s.desc {
+		return "DESC", "<"
+	}
+	return "ASC", ">"
+}
+
+// cursorValue returns the cursor's sort value typed for a SQL comparison
+func (s *pageSpec) cursorValue() interface{} {
+	if s.key.time {
+		t, _ := time.Parse(time.RFC3339Nano, s.after.Value)
+		return t
+	}
+	return s.after.Value
+}
+
+// itemKey returns the sort value and ID of a list item. Items are either IDs
+// or entities whose JSON fields include the sort key and "id".
+func itemKey(item interface{}, field string) (string, string) {
+	if id, ok := item.(string); ok {
+		return id, id
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", ""
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", ""
+	}
+	value, _ := fields[field].(string)
+	id, _ := fields["id"].(string)
+	return value, id
+}
+
+// nextCursor returns the cursor that continues after item
+func (s *pageSpec) nextCursor(item interface{}) string {
+	value, id := itemKey(item, s.sort)
+	return pageCursor{List: s.list, Sort: s.sort, Desc: s.desc, Value: value, ID: id}.encode()
+}
+
+// findPage loads one page of the rows selected by query, ordered by the
+// requested sort key and then by ID
+func findPage[T any](query *gorm.DB, opts ListOptions, keys sortKeys) (*Page[T], error) {
+	spec, err := opts.pageSpec(keys)
+	if err != nil {
+		return nil, err
+	}
+	base := query.Session(&gorm.Session{})
+	page := &Page[T]{Items: []T{}}
+
+	if opts.IncludeTotal {
+		var total int64
+		if err := base.Model(new(T)).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("failed to count rows: %w", err)
+		}
+		page.Total = &total
+	}
+
+	dir, op := spec.direction()
+	rows := base.Order(fmt.Sprintf("%s %s, %s %s", spec.key.column, dir, spec.id.column, dir))
+	if spec.after != nil {
+		rows = rows.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", spec.key.column, spec.id.column, op), spec.cursorValue(), spec.after.ID)
+	}
+	if err := rows.Limit(spec.limit + 1).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Items) > spec.limit {
+		page.Items = page.Items[:spec.limit]
+		page.NextCursor = spec.nextCursor(page.Items[spec.limit-1])
+	}
+	return page, nil
+}
+
+// pluckPage loads one page of the IDs in column selected by query, ordered by ID
+func pluckPage(query *gorm.DB, column string, opts ListOptions) (*Page[string], error) {
+	spec, err := opts.pageSpec(idSortKeys(column))
+	if err != nil {
+		return nil, err
+	}
+	base := query.Session(&gorm.Session{})
+	page := &Page[string]{Items: []string{}}
+
+	if opts.IncludeTotal {
+		var total int64
+		if err := base.Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("failed to count rows: %w", err)
+		}
+		page.Total = &total
+	}
+
+	dir, op := spec.direction()
+	ids := base.Order(fmt.Sprintf("%s %s", column, dir))
+	if spec.after != nil {
+		ids = ids.Where(fmt.Sprintf("%s %s ?", column, op), spec.after.ID)
+	}
+	if err := ids.Limit(spec.limit+1).Pluck(column, &page.Items).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Items) > spec.limit {
+		page.Items = page.Items[:spec.limit]
+		page.NextCursor = spec.nextCursor(page.Items[spec.limit-1])
+	}
+	return page, nil
+}
+
+// pageSlice returns one page of items held in memory, with the same ordering
+// and cursors as findPage
+func pageSlice[T any](items []T, opts ListOptions, keys sortKeys) (*Page[T], error) {
+	spec, err := opts.pageSpec(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	type keyed struct {
+		item      T
+		value, id string
+	}
+	rows := make([]keyed, len(items))
+	for i, item := range items {
+		value, id := itemKey(item, spec.sort)
+		rows[i] = keyed{item: item, value: value, id: id}
+	}
+	compare := func(value, id, otherValue, otherID string) int {
+		c := compareSortValues(value, otherValue, spec.key.time)
+		if c == 0 {
+			c = strings.Compare(id, otherID)
+		}
+		if spec.desc {
+			return -c
+		}
+		return c
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return compare(rows[i].value, rows[i].id, rows[j].value, rows[j].id) < 0
+	})
+
+	page := &Page[T]{Items: []T{}}
+	if opts.IncludeTotal {
+		total := int64(len(rows))
+		page.Total = &total
+	}
+	for _, row := range rows {
+		if spec.after != nil && compare(row.value, row.id, spec.after.Value, spec.after.ID) <= 0 {
+			continue
+		}
+		if len(page.Items) == spec.limit {
+			page.NextCursor = spec.nextCursor(page.Items[spec.limit-1])
+			break
+		}
+		page.Items = append(page.Items, row.item)
+	}
+	return page, nil
+}
+
+// compareSortValues orders two sort values, comparing timestamps chronologically
+func compareSortValues(a, b string, isTime bool) int {
+	if isTime {
+		ta, errA := time.Parse(time.RFC3339Nano, a)
+		tb, errB := time.Parse(time.RFC3339Nano, b)
+		if errA == nil && errB == nil {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// walkPages collects the IDs of every page of a list, following next_cursor
+func walkPages(t *testing.T, items []models.User, opts ListOptions) []string {
+	t.Helper()
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages > len(items) {
+			t.Fatalf("cursor walk did not end")
+		}
+		page, err := pageSlice(items, opts, userSortKeys)
+		if err != nil {
+			t.Fatalf("pageSlice() error = %v", err)
+		}
+		if len(page.Items) > opts.Limit {
+			t.Fatalf("page of %d items, limit %d", len(page.Items), opts.Limit)
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func TestPageSlice(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Names and creation times tie across pages, so IDs break the ties
+	users := []models.User{
+		{ID: "UI000005", Name: "Bob", CreatedAt: base},
+		{ID: "UI000001", Name: "Ada", CreatedAt: base.Add(time.Second)},
+		{ID: "UI000004", Name: "Ada", CreatedAt: base},
+		{ID: "UI000002", Name: "Cy", CreatedAt: base.Add(500 * time.Millisecond)},
+		{ID: "UI000003", Name: "Ada", CreatedAt: base},
+		{ID: "UI000006", Name: "Bob", CreatedAt: base.Add(time.Second)},
+	}
+	tests := []struct {
+		sort, order string
+		want        []string
+	}{
+		{"", "", []string{"UI000001", "UI000003", "UI000004", "UI000005", "UI000006", "UI000002"}},
+		{"name", "desc", []string{"UI000002", "UI000006", "UI000005", "UI000004", "UI000003", "UI000001"}},
+		{"id", "", []string{"UI000001", "UI000002", "UI000003", "UI000004", "UI000005", "UI000006"}},
+		// Timestamps sort chronologically, with sub-second precision
+		{"created_at", "", []string{"UI000003", "UI000004", "UI000005", "UI000002", "UI000001", "UI000006"}},
+		{"created_at", "DESC", []string{"UI000006", "UI000001", "UI000002", "UI000005", "UI000004", "UI000003"}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 4, 6, 10} {
+			got := walkPages(t, users, ListOptions{Sort: tt.sort, Order: tt.order, Limit: limit})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sort %q %q, limit %d: %v, want %v", tt.sort, tt.order, limit, got, tt.want)
+			}
+		}
+	}
+
+	page, err := pageSlice(users, ListOptions{Limit: 2, IncludeTotal: true}, userSortKeys)
+	if err != nil || page.Total == nil || *page.Total != 6 {
+		t.Errorf("pageSlice() total = %v, %v", page.Total, err)
+	}
+	// A full last page has no next cursor
+	if page, err := pageSlice(users, ListOptions{Limit: 6}, userSortKeys); err != nil || page.NextCursor != "" {
+		t.Errorf("pageSlice() of every item has next cursor %q, %v", page.NextCursor, err)
+	}
+}
+
+func TestPageCursors(t *testing.T) {
+	users := []models.User{{ID: "UI000001", Name: "Ada"}, {ID: "UI000002", Name: "Bob"}}
+	page, err := pageSlice(users, ListOptions{Limit: 1}, userSortKeys)
+	if err != nil || page.NextCursor == "" {
+		t.Fatalf("pageSlice() = %+v, %v", page, err)
+	}
+	cursor := page.NextCursor
+	encode := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	// A cursor can be reused for the same list
+	if _, err := pageSlice(users, ListOptions{Limit: 1, Cursor: cursor}, userSortKeys); err != nil {
+		t.Fatalf("pageSlice() with cursor error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		keys sortKeys
+	}{
+		{"not base64", ListOptions{Cursor: "not a cursor!"}, userSortKeys},
+		{"not JSON", ListOptions{Cursor: encode("name=Ada")}, userSortKeys},
+		{"trailing data", ListOptions{Cursor: encode(`{"l":"users","s":"name","v":"Ada","id":"UI000001"}{}`)}, userSortKeys},
+		{"unknown field", ListOptions{Cursor: encode(`{"l":"users","s":"name","v":"Ada","id":"UI000001","x":1}`)}, userSortKeys},
+		{"wrong type", ListOptions{Cursor: encode(`{"l":"users","s":"name","v":1,"id":"UI000001"}`)}, userSortKeys},
+		{"missing ID", ListOptions{Cursor: encode(`{"l":"users","s":"name","v":"Ada"}`)}, userSortKeys},
+		{"missing list", ListOptions{Cursor: encode(`{"s":"name","v":"Ada","id":"UI000001"}`)}, userSortKeys},
+		{"invalid timestamp", ListOptions{Sort: "created_at", Cursor: encode(`{"l":"users","s":"created_at","v":"yesterday","id":"UI000001"}`)}, userSortKeys},
+		{"other sort", ListOptions{Sort: "email", Cursor: cursor}, userSortKeys},
+		{"other order", ListOptions{Order: "desc", Cursor: cursor}, userSortKeys},
+		{"other list", ListOptions{Cursor: cursor}, groupSortKeys},
+		{"other ID list", ListOptions{Cursor: encode(`{"l":"group_members","s":"id","v":"UI1","id":"UI1"}`)}, idSortKeys("role_groups.group_id")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.opts.pageSpec(tt.keys); !errors.Is(err, ErrInvalidListOptions) {
+				t.Errorf("pageSpec() error = %v, want ErrInvalidListOptions", err)
+			}
+		})
+	}
+}
+
+func TestPageSpec(t *testing.T) {
+	spec, err := ListOptions{}.pageSpec(userSortKeys)
+	if err != nil || spec.sort != "name" || spec.desc || spec.limit != defaultPageLimit || spec.list != "users" {
+		t.Errorf("default pageSpec() = %+v, %v", spec, err)
+	}
+	spec, err = ListOptions{Limit: maxPageLimit + 1, Order: "Desc"}.pageSpec(idSortKeys("group_members.user_id"))
+	if err != nil || spec.sort != "id" || !spec.desc || spec.limit != maxPageLimit || spec.list != "group_members" {
+		t.Errorf("pageSpec() = %+v, %v", spec, err)
+	}
+	for _, opts := range []ListOptions{{Sort: "password"}, {Sort: "email"}, {Order: "up"}} {
+		if _, err := opts.pageSpec(groupSortKeys); !errors.Is(err, ErrInvalidListOptions) {
+			t.Errorf("pageSpec(%+v) error = %v, want ErrInvalidListOptions", opts, err)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// PostgresStore implements DirectoryStore on top of the gorm handlers in this package
+type PostgresStore struct {
+	DB *gorm.DB
+
+	idFormats IDFormats
+}
+
+// NewPostgresStore creates a DirectoryStore backed by the given gorm connection
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{DB: db, idFormats: DefaultIDFormats()}
+}
+
+// SetIDFormats sets the formats of generated and client-supplied IDs
+func (s *PostgresStore) SetIDFormats(formats IDFormats) {
+	s.idFormats = formats
+}
+
+// Close closes the database connection pool
+func (s *PostgresStore) Close() error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database handle: %w", err)
+	}
+	return sqlDB.Close()
+}
+
+// conn returns the connection bound to the request context
+func (s *PostgresStore) conn(ctx context.Context) *gorm.DB {
+	return s.DB.WithContext(ctx)
+}
+
+// assignID checks or generates the ID of a new entity, drawing sequence
+// numbers from the <entity>_id_seq sequence
+func (s *PostgresStore) assignID(ctx context.Context, entity string, id *string) error {
+	seq := postgresSequence{db: s.conn(ctx), table: entityTables[entity], name: entity + "_id_seq"}
+	return assignID(entity, s.idFormats.of(entity), id, seq)
+}
+
+// entityTables names the table of each entity type
+var entityTables = map[string]string{
+	models.EntityUser:  "users",
+	models.EntityGroup: "groups",
+	models.EntityRole:  "roles",
+}
+
+// postgresSequence is the Postgres sequence behind the IDs of an entity type
+type postgresSequence struct {
+	db    *gorm.DB
+	table string
+	name  string
+}
+
+func (s postgresSequence) next() (int64, error) {
+	var n int64
+	// The sequence name is one of the constants created by the id_sequences migration
+	err := s.db.Raw(fmt.Sprintf("SELECT nextval('%s')", s.name)).Scan(&n).Error
+	return n, err
+}
+
+func (s postgresSequence) taken(id string) (bool, error) {
+	var count int64
+	err := s.db.Table(s.table).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user *models.User) error {
+	if err := s.assignID(ctx, models.EntityUser, &user.ID); err != nil {
+		return err
+	}
+	return CreateUser(s.conn(ctx), user)
+}
+
+func (s *PostgresStore) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	return GetUserByID(s.conn(ctx), userID)
+}
+
+func (s *PostgresStore) GetAllUsers(ctx context.Context, opts ListOptions) (*Page[models.User], error) {
+	return GetAllUsers(s.conn(ctx), opts)
+}
+
+func (s *PostgresStore) UpdateUser(ctx context.Context, user *models.User) error {
+	return UpdateUser(s.conn(ctx), user)
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, userID string, expectedVersion int64) error {
+	return DeleteUser(s.conn(ctx), userID, expectedVersion)
+}
+
+func (s *PostgresStore) RestoreUser(ctx context.Context, userID string) (*models.User, error) {
+	return RestoreUser(s.conn(ctx), userID)
+}
+
+func (s *PostgresStore) ChangeUserStatus(ctx context.Context, userID string, status string) (*models.User, error) {
+	return ChangeUserStatus(s.conn(ctx), userID, status)
+}
+
+func (s *PostgresStore) CreateGroup(ctx context.Context, group *models.Group) error {
+	if err := s.assignID(ctx, models.EntityGroup, &group.ID); err != nil {
+		return err
+	}
+	return CreateGroup(s.conn(ctx), group)
+}
+
+func (s *PostgresStore) GetGroupByID(ctx context.Context, groupID string) (*models.Group, error) {
+	return GetGroupByID(s.conn(ctx), groupID)
+}
+
+func (s *PostgresStore) GetAllGroups(ctx context.Context, opts ListOptions) (*Page[models.Group], error) {
+	return GetAllGroups(s.conn(ctx), opts)
+}
+
+func (s *PostgresStore) UpdateGroup(ctx context.Context, group *models.Group) error {
+	return UpdateGroup(s.conn(ctx), group)
+}
+
+func (s *PostgresStore) DeleteGroup(ctx context.Context, groupID string, expectedVersion int64) error {
+	return DeleteGroup(s.conn(ctx), groupID, expectedVersion)
+}
+
+func (s *PostgresStore) RestoreGroup(ctx context.Context, groupID string) (*models.Group, error) {
+	return RestoreGroup(s.conn(ctx), groupID)
+}
+
+func (s *PostgresStore) AddUserToGroup(ctx context.Context, groupID string, userID string) error {
+	return AddUserToGroup(s.conn(ctx), groupID, userID)
+}
+
+func (s *PostgresStore) RemoveUserFromGroup(ctx context.Context, groupID string, userID string) error {
+	return RemoveUserFromGroup(s.conn(ctx), groupID, userID)
+}
+
+func (s *PostgresStore) AddGroupToGroup(ctx context.Context, parentID string, childID string) error {
+	return AddGroupToGroup(s.conn(ctx), parentID, childID)
+}
+
+func (s *PostgresStore) RemoveGroupFromGroup(ctx context.Context, parentID string, childID string) error {
+	return RemoveGroupFromGroup(s.conn(ctx), parentID, childID)
+}
+
+func (s *PostgresStore) GetGroupMembers(ctx context.Context, groupID string, transitive bool, opts ListOptions) (*GroupMembers, error) {
+	return GetGroupMembers(s.conn(ctx), groupID, transitive, opts)
+}
+
+func (s *PostgresStore) GetUserGroups(ctx context.Context, userID string, transitive bool, opts ListOptions) (*Page[models.Group], error) {
+	return GetUserGroups(s.conn(ctx), userID, transitive, opts)
+}
+
+func (s *PostgresStore) CreateRole(ctx context.Context, role *models.Role) error {
+	if err := s.assignID(ctx, models.EntityRole, &role.ID); err != nil {
+		return err
+	}
+	return CreateRole(s.conn(ctx), role)
+}
+
+func (s *PostgresStore) GetRoleByID(ctx context.Context, roleID string) (*models.Role, error) {
+	return GetRoleByID(s.conn(ctx), roleID)
+}
+
+func (s *PostgresStore) GetAllRoles(ctx context.Context, opts ListOptions) (*Page[models.Role], error) {
+	return GetAllRoles(s.conn(ctx), opts)
+}
+
+func (s *PostgresStore) UpdateRole(ctx context.Context, role *models.Role) error {
+	return UpdateRole(s.conn(ctx), role)
+}
+
+func (s *PostgresStore) DeleteRole(ctx context.Context, roleID string, expectedVersion int64) error {
+	return DeleteRole(s.conn(ctx), roleID, expectedVersion)
+}
+
+func (s *PostgresStore) RestoreRole(ctx context.Context, roleID string) (*models.Role, error) {
+	return RestoreRole(s.conn(ctx), roleID)
+}
+
+func (s *PostgresStore) AddGroupToRole(ctx context.Context, roleID string, groupID string) error {
+	return AddGroupToRole(s.conn(ctx), roleID, groupID)
+}
+
+func (s *PostgresStore) RemoveGroupFromRole(ctx context.Context, roleID string, groupID string) error {
+	return RemoveGroupFromRole(s.conn(ctx), roleID, groupID)
+}
+
+func (s *PostgresStore) GetRoleGroups(ctx context.Context, roleID string, opts ListOptions) (*Page[string], error) {
+	return GetRoleGroups(s.conn(ctx), roleID, opts)
+}
+
+func (s *PostgresStore) AssignRoleToUser(ctx context.Context, userID string, roleID string) error {
+	return AssignRoleToUser(s.conn(ctx), userID, roleID)
+}
+
+func (s *PostgresStore) RemoveRoleFromUser(ctx context.Context, userID string, roleID string) error {
+	return RemoveRoleFromUser(s.conn(ctx), userID, roleID)
+}
+
+func (s *PostgresStore) GetUserRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	return GetUserRoles(s.conn(ctx), userID, opts)
+}
+
+func (s *PostgresStore) GetEffectiveRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error) {
+	return GetEffectiveRoles(s.conn(ctx), userID, opts)
+}
+
+func (s *PostgresStore) CreateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	return CreateAttributeDefinition(s.conn(ctx), def)
+}
+
+func (s *PostgresStore) GetAttributeDefinitions(ctx context.Context, entityType string) ([]models.AttributeDefinition, error) {
+	return GetAttributeDefinitions(s.conn(ctx), entityType)
+}
+
+func (s *PostgresStore) GetAttributeDefinition(ctx context.Context, entityType string, name string) (*models.AttributeDefinition, error) {
+	return GetAttributeDefinition(s.conn(ctx), entityType, name)
+}
+
+func (s *PostgresStore) UpdateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error {
+	return UpdateAttributeDefinition(s.conn(ctx), def)
+}
+
+func (s *PostgresStore) DeleteAttributeDefinition(ctx context.Context, entityType string, name string) error {
+	return DeleteAttributeDefinition(s.conn(ctx), entityType, name)
+}
+
+func (s *PostgresStore) CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error {
+	return CreateServiceAccount(s.conn(ctx), account)
+}
+
+func (s *PostgresStore) GetServiceAccount(ctx context.Context, accountID string) (*models.ServiceAccount, error) {
+	return GetServiceAccount(s.conn(ctx), accountID)
+}
+
+func (s *PostgresStore) GetServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	return GetServiceAccounts(s.conn(ctx))
+}
+
+func (s *PostgresStore) DeleteServiceAccount(ctx context.Context, accountID string) error {
+	return DeleteServiceAccount(s.conn(ctx), accountID)
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return CreateAPIKey(s.conn(ctx), key)
+}
+
+func (s *PostgresStore) GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error) {
+	return GetAPIKey(s.conn(ctx), keyID)
+}
+
+func (s *PostgresStore) DeleteAPIKey(ctx context.Context, accountID string, keyID string) error {
+	return DeleteAPIKey(s.conn(ctx), accountID, keyID)
+}
+
+func (s *PostgresStore) CreateOIDCClient(ctx context.Context, client *models.OIDCClient) error {
+	return CreateOIDCClient(s.conn(ctx), client)
+}
+
+func (s *PostgresStore) GetOIDCClient(ctx context.Context, clientID string) (*models.OIDCClient, error) {
+	return GetOIDCClient(s.conn(ctx), clientID)
+}
+
+func (s *PostgresStore) GetOIDCClients(ctx context.Context) ([]models.OIDCClient, error) {
+	return GetOIDCClients(s.conn(ctx))
+}
+
+func (s *PostgresStore) DeleteOIDCClient(ctx context.Context, clientID string) error {
+	return DeleteOIDCClient(s.conn(ctx), clientID)
+}
+
+func (s *PostgresStore) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	return CreateAuthorizationCode(s.conn(ctx), code)
+}
+
+func (s *PostgresStore) ConsumeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	return ConsumeAuthorizationCode(s.conn(ctx), hash)
+}
+
+func (s *PostgresStore) SetUserPassword(ctx context.Context, userID string, passwordHash string) error {
+	return SetUserPassword(s.conn(ctx), userID, passwordHash)
+}
+
+func (s *PostgresStore) GetUserPassword(ctx context.Context, userID string) (*models.UserCredential, error) {
+	return GetUserPassword(s.conn(ctx), userID)
+}
+
+func (s *PostgresStore) DeleteUserPassword(ctx context.Context, userID string) error {
+	return DeleteUserPassword(s.conn(ctx), userID)
+}
+
+func (s *PostgresStore) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return RecordAudit(s.conn(ctx), entry)
+}
+
+func (s *PostgresStore) ListAudit(ctx context.Context, filter AuditFilter) (*Page[models.AuditEntry], error) {
+	return ListAudit(s.conn(ctx), filter)
+}
+
+func (s *PostgresStore) Search(ctx context.Context, query string, opts SearchOptions) (*models.SearchResults, error) {
+	return Search(s.conn(ctx), query, opts)
+}
+
+func (s *PostgresStore) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	return ReserveIdempotencyKey(s.conn(ctx), record)
+}
+
+func (s *PostgresStore) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	return CompleteIdempotencyKey(s.conn(ctx), record)
+}
+
+func (s *PostgresStore) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	return ReleaseIdempotencyKey(s.conn(ctx), record)
+}
+
+func (s *PostgresStore) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	return PurgeIdempotencyKeys(s.conn(ctx), cutoff)
+}
+
+func (s *PostgresStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (*PurgeResult, error) {
+	return PurgeDeleted(s.conn(ctx), cutoff)
+}
+
+func (s *PostgresStore) WithTx(ctx context.Context, fn func(tx DirectoryStore) error) error {
+	return s.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&PostgresStore{DB: tx, idFormats: s.idFormats})
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// PurgeResult lists the IDs of entities removed by a purge
+type PurgeResult struct {
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+}
+
+// Empty reports whether the purge removed nothing
+func (r *PurgeResult) Empty() bool {
+	return len(r.Users) == 0 && len(r.Groups) == 0 && len(r.Roles) == 0
+}
+
+// PurgeDeleted permanently removes users, groups and roles that were
+// soft-deleted before cutoff. Their relationships go with them via the join
+// table cascades.
+func PurgeDeleted(db *gorm.DB, cutoff time.Time) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if result.Users, err = purgeTable(tx, &models.User{}, "users", cutoff); err != nil {
+			return err
+		}
+		if result.Groups, err = purgeTable(tx, &models.Group{}, "groups", cutoff); err != nil {
+			return err
+		}
+		result.Roles, err = purgeTable(tx, &models.Role{}, "roles", cutoff)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// purgeTable hard-deletes the tombstoned rows of one table and returns their IDs
+func purgeTable(db *gorm.DB, model interface{}, table string, cutoff time.Time) ([]string, error) {
+	ids := []string{}
+	err := db.Unscoped().Model(model).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find purgeable %s: %w", table, err)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	if err := db.Unscoped().Where("id IN ?", ids).Delete(model).Error; err != nil {
+		return nil, fmt.Errorf("failed to purge %s: %w", table, err)
+	}
+	return ids, nil
+}
+
+// RunPurgeLoop purges entities soft-deleted longer than retention ago, once
+// at startup and then every interval, until ctx is cancelled
+func RunPurgeLoop(ctx context.Context, store DirectoryStore, retention, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := store.PurgeDeleted(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Purge of deleted entities failed: %v", err)
+		} else if !result.Empty() {
+			log.Printf("Purged %d users, %d groups and %d roles deleted more than %s ago",
+				len(result.Users), len(result.Groups), len(result.Roles), retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+func TestMemoryStorePurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users := make([]*models.User, 3)
+	for i := range users {
+		users[i] = &models.User{Name: "User", Email: "user@example.com"}
+		must(m.CreateUser(ctx, users[i]))
+	}
+	groups := make([]*models.Group, 2)
+	for i := range groups {
+		groups[i] = &models.Group{Name: "Group"}
+		must(m.CreateGroup(ctx, groups[i]))
+	}
+	roles := make([]*models.Role, 2)
+	for i := range roles {
+		roles[i] = &models.Role{Name: "Role"}
+		must(m.CreateRole(ctx, roles[i]))
+	}
+	old, recent, live := users[0].ID, users[1].ID, users[2].ID
+	oldGroup, liveGroup := groups[0].ID, groups[1].ID
+	oldRole, liveRole := roles[0].ID, roles[1].ID
+	for _, userID := range []string{old, recent, live} {
+		must(m.AddUserToGroup(ctx, liveGroup, userID))
+		must(m.AssignRoleToUser(ctx, userID, oldRole))
+	}
+	must(m.AddUserToGroup(ctx, oldGroup, live))
+	must(m.AddGroupToGroup(ctx, liveGroup, oldGroup))
+	must(m.AddGroupToRole(ctx, liveRole, oldGroup))
+
+	// Tombstone the old entities two days ago and the recent user an hour ago
+	now := time.Now()
+	must(m.DeleteUser(ctx, old, 0))
+	must(m.DeleteUser(ctx, recent, 0))
+	must(m.DeleteGroup(ctx, oldGroup, 0))
+	must(m.DeleteRole(ctx, oldRole, 0))
+	backdate := func(at time.Time) gorm.DeletedAt { return gorm.DeletedAt{Time: at, Valid: true} }
+	user := m.data.users[old]
+	user.DeletedAt = backdate(now.Add(-48 * time.Hour))
+	m.data.users[old] = user
+	user = m.data.users[recent]
+	user.DeletedAt = backdate(now.Add(-time.Hour))
+	m.data.users[recent] = user
+	group := m.data.groups[oldGroup]
+	group.DeletedAt = backdate(now.Add(-48 * time.Hour))
+	m.data.groups[oldGroup] = group
+	role := m.data.roles[oldRole]
+	role.DeletedAt = backdate(now.Add(-48 * time.Hour))
+	m.data.roles[oldRole] = role
+
+	// A day of retention removes only the tombstones older than a day
+	result, err := m.PurgeDeleted(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeDeleted() error = %v", err)
+	}
+	want := &PurgeResult{Users: []string{old}, Groups: []string{oldGroup}, Roles: []string{oldRole}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("PurgeDeleted() = %+v, want %+v", result, want)
+	}
+	if _, ok := m.data.users[old]; ok {
+		t.Errorf("purged user %s is still stored", old)
+	}
+	if _, ok := m.data.groups[oldGroup]; ok {
+		t.Errorf("purged group %s is still stored", oldGroup)
+	}
+	if _, ok := m.data.roles[oldRole]; ok {
+		t.Errorf("purged role %s is still stored", oldRole)
+	}
+
+	// Relationships of purged entities go with them
+	if members := m.data.groupMembers[liveGroup]; members[old] || !members[recent] || !members[live] {
+		t.Errorf("members of %s = %v", liveGroup, members)
+	}
+	if m.data.groupGroups[liveGroup][oldGroup] {
+		t.Errorf("purged group %s is still nested in %s", oldGroup, liveGroup)
+	}
+	if m.data.roleGroups[liveRole][oldGroup] {
+		t.Errorf("purged group %s still has role %s", oldGroup, liveRole)
+	}
+	if m.data.userRoles[live][oldRole] {
+		t.Errorf("user %s still has purged role %s", live, oldRole)
+	}
+
+	// The newer tombstone is kept and can still be restored
+	if _, err := m.RestoreUser(ctx, old); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RestoreUser(%s) error = %v, want ErrNotFound", old, err)
+	}
+	if _, err := m.RestoreUser(ctx, recent); err != nil {
+		t.Errorf("RestoreUser(%s) error = %v", recent, err)
+	}
+
+	// Nothing is left to purge, and live entities are never purged
+	result, err = m.PurgeDeleted(ctx, now.Add(time.Hour))
+	if err != nil || !result.Empty() {
+		t.Errorf("second PurgeDeleted() = %+v, %v, want nothing purged", result, err)
+	}
+}
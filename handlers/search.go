@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	minSearchLength    = 2
+	maxSearchLength    = 256
+
+	// Scores of the ways a field can match, best first. Fuzzy matches score
+	// searchScoreFuzzy times their trigram similarity and are only returned
+	// when the similarity reaches searchFuzzyThreshold.
+	searchScoreExact     = 1.0
+	searchScorePrefix    = 0.9
+	searchScoreContains  = 0.8
+	searchScoreWords     = 0.7 // Every query word is a word of the entity (full-text match)
+	searchScoreFuzzy     = 0.6
+	searchFuzzyThreshold = 0.3
+
+	// searchSnippetLength is the longest highlight snippet, in characters
+	searchSnippetLength = 120
+)
+
+// ErrInvalidSearch is returned when a search query or its options are not valid
+var ErrInvalidSearch error = newError(ErrInvalidRequest, "invalid_search", "invalid search")
+
+// SearchOptions narrows a directory search
+type SearchOptions struct {
+	Types []string // Entity types to search: user, group and/or role; all when empty
+	Limit int      // Maximum number of hits; defaults to 20 and is capped at 100
+}
+
+// searchSource describes how one entity type is searched. Every entity has a
+// name and one detail field, which are matched and highlighted.
+type searchSource struct {
+	entity      string
+	table       string
+	detailField string // JSON name of the detail field
+	name        string // SQL expression of the name
+	detail      string // SQL expression of the detail field
+}
+
+var searchSources = []searchSource{
+	{entity: models.EntityUser, table: "users", detailField: "email", name: "users.name", detail: "users.email"},
+	{entity: models.EntityGroup, table: "groups", detailField: "description", name: "groups.name", detail: "COALESCE(groups.description, '')"},
+	{entity: models.EntityRole, table: "roles", detailField: "description", name: "roles.name", detail: "COALESCE(roles.description, '')"},
+}
+
+// searchQuery is a validated search
+type searchQuery struct {
+	text    string   // Trimmed query as entered
+	lower   string   // Lowercase query used for matching
+	words   []string // Lowercase words of the query
+	sources []searchSource
+	limit   int
+}
+
+// newSearchQuery validates a query and its options
+func newSearchQuery(text string, opts SearchOptions) (*searchQuery, error) {
+	text = strings.TrimSpace(text)
+	if n := utf8.RuneCountInString(text); n < minSearchLength || n > maxSearchLength {
+		return nil, fmt.Errorf("%w: query must be between %d and %d characters", ErrInvalidSearch, minSearchLength, maxSearchLength)
+	}
+	q := &searchQuery{text: text, lower: strings.ToLower(text), limit: opts.Limit}
+	for _, word := range searchWords(q.lower) {
+		q.words = append(q.words, word.text)
+	}
+
+	for _, entity := range opts.Types {
+		if !slices.ContainsFunc(searchSources, func(s searchSource) bool { return s.entity == entity }) {
+			return nil, fmt.Errorf("%w: type must be user, group or role, got %q", ErrInvalidSearch, entity)
+		}
+	}
+	for _, source := range searchSources {
+		if len(opts.Types) == 0 || slices.Contains(opts.Types, source.entity) {
+			q.sources = append(q.sources, source)
+		}
+	}
+
+	if q.limit <= 0 {
+		q.limit = defaultSearchLimit
+	}
+	if q.limit > maxSearchLimit {
+		q.limit = maxSearchLimit
+	}
+	return q, nil
+}
+
+// hit builds a search hit with highlights for the matching fields. Scores are
+// rounded to three decimals so that both stores report the same values.
+func (q *searchQuery) hit(source searchSource, id, name, detail string, score float64) models.SearchHit {
+	score = math.Round(score*1000) / 1000
+	hit := models.SearchHit{Type: source.entity, ID: id, Name: name, Detail: detail, Score: score, Highlights: []models.SearchHighlight{}}
+	for _, field := range []struct{ name, value string }{{"name", name}, {source.detailField, detail}} {
+		if snippet, ok := q.highlight(field.value); ok {
+			hit.Highlights = append(hit.Highlights, models.SearchHighlight{Field: field.name, Snippet: snippet})
+		}
+	}
+	return hit
+}
+
+// rankHits orders hits by descending score, then by type, name and ID, and
+// truncates them to the query's limit
+func (q *searchQuery) rankHits(hits []models.SearchHit) []models.SearchHit {
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	if len(hits) > q.limit {
+		hits = hits[:q.limit]
+	}
+	return hits
+}
+
+// score rates how well an entity's name and detail field match the query,
+// mirroring the SQL used by Search. Zero means no match.
+func (q *searchQuery) score(name, detail string) float64 {
+	score := max(q.fieldScore(name), q.fieldScore(detail))
+	if score < searchScoreWords && len(q.words) > 0 {
+		entityWords := searchWords(strings.ToLower(name + " " + detail))
+		if !slices.ContainsFunc(q.words, func(word string) bool {
+			return !slices.ContainsFunc(entityWords, func(w searchWord) bool { return w.text == word })
+		}) {
+			score = searchScoreWords
+		}
+	}
+	return score
+}
+
+// fieldScore rates how well one field matches the query
+func (q *searchQuery) fieldScore(value string) float64 {
+	value = strings.ToLower(value)
+	switch {
+	case value == q.lower:
+		return searchScoreExact
+	case strings.HasPrefix(value, q.lower):
+		return searchScorePrefix
+	case strings.Contains(value, q.lower):
+		return searchScoreContains
+	}
+	if similarity := wordSimilarity(q.lower, value); similarity >= searchFuzzyThreshold {
+		return searchScoreFuzzy * similarity
+	}
+	return 0
+}
+
+// searchWord is a run of letters and digits within a string, with its rune offsets
+type searchWord struct {
+	text       string
+	start, end int
+}
+
+// searchWords splits s into words of letters and digits
+func searchWords(s string) []searchWord {
+	var words []searchWord
+	runes := []rune(s)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, searchWord{text: string(runes[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	return words
+}
+
+// trigrams returns the trigrams of s in order the way pg_trgm builds them:
+// each lowercase word is padded with two spaces in front and one behind
+func trigrams(s string) []string {
+	var list []string
+	for _, word := range searchWords(strings.ToLower(s)) {
+		padded := []rune("  " + word.text + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			list = append(list, string(padded[i:i+3]))
+		}
+	}
+	return list
+}
+
+// wordSimilarity is pg_trgm's word_similarity(query, text): the best
+// similarity between the trigram set of query and any continuous extent of
+// the ordered trigrams of text, i.e. shared trigrams over all distinct trigrams
+func wordSimilarity(query, text string) float64 {
+	queryTrigrams := map[string]struct{}{}
+	for _, trigram := range trigrams(query) {
+		queryTrigrams[trigram] = struct{}{}
+	}
+	textTrigrams := trigrams(text)
+	if len(queryTrigrams) == 0 || len(textTrigrams) == 0 {
+		return 0
+	}
+
+	best := 0.0
+	for i, first := range textTrigrams {
+		if _, ok := queryTrigrams[first]; !ok {
+			// An extent starting with an unshared trigram is never the best one
+			continue
+		}
+		extent := map[string]struct{}{}
+		shared := 0
+		for _, trigram := range textTrigrams[i:] {
+			if _, seen := extent[trigram]; seen {
+				continue
+			}
+			extent[trigram] = struct{}{}
+			if _, ok := queryTrigrams[trigram]; ok {
+				shared++
+				best = max(best, float64(shared)/float64(len(queryTrigrams)+len(extent)-shared))
+			}
+		}
+	}
+	return best
+}
+
+// highlight returns an HTML-escaped snippet of value with the parts matching
+// the query wrapped in <mark></mark>, or false when nothing in value matches.
+// Exact occurrences of the query or its words are marked; failing those,
+// words that are similar to a query word.
+func (q *searchQuery) highlight(value string) (string, bool) {
+	runes := []rune(value)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var marks [][2]int
+	for _, needle := range append([]string{q.lower}, q.words...) {
+		marks = append(marks, occurrences(lower, []rune(needle))...)
+	}
+	if len(marks) == 0 {
+		for _, word := range searchWords(string(lower)) {
+			for _, queryWord := range q.words {
+				if wordSimilarity(queryWord, word.text) >= searchFuzzyThreshold {
+					marks = append(marks, [2]int{word.start, word.end})
+					break
+				}
+			}
+		}
+	}
+	if len(marks) == 0 {
+		return "", false
+	}
+	marks = mergeRanges(marks)
+
+	start, end := 0, len(runes)
+	if end > searchSnippetLength {
+		start = max(marks[0][0]-searchSnippetLength/4, 0)
+		end = min(start+searchSnippetLength, len(runes))
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, mark := range marks {
+		from, to := max(mark[0], start), min(mark[1], end)
+		if from >= to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:from])))
+		b.WriteString("<mark>" + html.EscapeString(string(runes[from:to])) + "</mark>")
+		pos = to
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// occurrences returns the rune ranges where needle occurs in haystack
+func occurrences(haystack, needle []rune) [][2]int {
+	var ranges [][2]int
+	if len(needle) < minSearchLength {
+		return nil
+	}
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if slices.Equal(haystack[i:i+len(needle)], needle) {
+			ranges = append(ranges, [2]int{i, i + len(needle)})
+		}
+	}
+	return ranges
+}
+
+// mergeRanges sorts ranges and joins those that overlap or touch
+func mergeRanges(ranges [][2]int) [][2]int {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
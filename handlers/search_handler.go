@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+)
+
+// searchRow is one ranked hit as returned by the search SQL
+type searchRow struct {
+	Type   string
+	ID     string
+	Name   string
+	Detail string
+	Score  float64
+}
+
+// Search finds users, groups and roles whose name or detail field (email or
+// description) matches the query exactly, by prefix, by substring, by full-text
+// words or by trigram similarity, ranked by score. Soft-deleted entities are
+// not returned. The matching conditions are served by the trigram and
+// full-text indexes of migration 0009.
+func Search(db *gorm.DB, query string, opts SearchOptions) (*models.SearchResults, error) {
+	q, err := newSearchQuery(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q.text)
+	args := map[string]interface{}{
+		"q":        q.text,
+		"prefix":   escaped + "%",
+		"contains": "%" + escaped + "%",
+		"limit":    q.limit,
+	}
+
+	selects := make([]string, 0, len(q.sources))
+	for _, source := range q.sources {
+		selects = append(selects, source.searchSQL())
+	}
+	sql := "SELECT * FROM (" + strings.Join(selects, " UNION ALL ") + ") hits ORDER BY score DESC, type, name, id LIMIT @limit"
+
+	var rows []searchRow
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Lower the threshold of the <% operator from 0.6 so that typos still match
+		if err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', ?, true)", fmt.Sprint(searchFuzzyThreshold)).Error; err != nil {
+			return err
+		}
+		return tx.Raw(sql, args).Scan(&rows).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	results := &models.SearchResults{Query: q.text, Hits: make([]models.SearchHit, 0, len(rows))}
+	for _, row := range rows {
+		for _, source := range q.sources {
+			if source.entity == row.Type {
+				results.Hits = append(results.Hits, q.hit(source, row.ID, row.Name, row.Detail, row.Score))
+			}
+		}
+	}
+	return results, nil
+}
+
+// searchSQL selects the matching rows of one entity type with their score.
+// The document expression must stay identical to the full-text index in
+// migration 0009 for the index to be used.
+func (s searchSource) searchSQL() string {
+	document := fmt.Sprintf("to_tsvector('simple', %s || ' ' || %s)", s.name, s.detail)
+	fieldScore := func(column string) string {
+		return fmt.Sprintf(`CASE WHEN lower(%[1]s) = lower(@q) THEN %[2]v WHEN %[1]s ILIKE @prefix ESCAPE '\' THEN %[3]v `+
+			`WHEN %[1]s ILIKE @contains ESCAPE '\' THEN %[4]v ELSE %[5]v * word_similarity(@q, %[1]s) END`,
+			column, searchScoreExact, searchScorePrefix, searchScoreContains, searchScoreFuzzy)
+	}
+	return fmt.Sprintf(`SELECT '%[1]s' AS type, %[2]s.id AS id, %[3]s AS name, %[4]s AS detail,
+    GREATEST(%[5]s, %[6]s, CASE WHEN %[7]s @@ plainto_tsquery('simple', @q) THEN %[8]v ELSE 0 END) AS score
+FROM %[2]s
+WHERE %[2]s.deleted_at IS NULL AND (
+    %[3]s ILIKE @contains ESCAPE '\' OR %[4]s ILIKE @contains ESCAPE '\'
+    OR @q <%% %[3]s OR @q <%% %[4]s
+    OR %[7]s @@ plainto_tsquery('simple', @q))`,
+		s.entity, s.table, s.name, s.detail, fieldScore(s.name), fieldScore(s.detail), document, searchScoreWords)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// wordSimilarity mirrors pg_trgm, whose documentation gives
+// word_similarity('word', 'two words') = 0.8
+func TestWordSimilarity(t *testing.T) {
+	tests := []struct {
+		query, text string
+		want        float64
+	}{
+		{"word", "two words", 0.8},
+		{"word", "word", 1},
+		{"jonh", "John Doe", 0.4},
+		{"engineering", "engineer", 8.0 / 12},
+		{"ab", "xyz", 0},
+		{"", "word", 0},
+		{"word", "--", 0},
+	}
+	for _, tt := range tests {
+		if got := wordSimilarity(tt.query, tt.text); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("wordSimilarity(%q, %q) = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	q, err := newSearchQuery("Ada King", SearchOptions{})
+	if err != nil {
+		t.Fatalf("newSearchQuery() error = %v", err)
+	}
+	long := strings.Repeat("x", 100) + " ada king " + strings.Repeat("y", 100)
+	tests := []struct {
+		value, want string
+	}{
+		{"Ada King", "<mark>Ada King</mark>"},
+		{"King, Ada & co", "<mark>King</mark>, <mark>Ada</mark> &amp; co"},
+		// Similar words are only marked when nothing matches exactly
+		{"Adda Kingston", "Adda <mark>King</mark>ston"},
+		{"Adda Kign", "<mark>Adda</mark> <mark>Kign</mark>"},
+		// Long values are shortened around the first match
+		{long, "…" + strings.Repeat("x", 29) + " <mark>ada king</mark> " + strings.Repeat("y", 81) + "…"},
+		{"Bob Smith", ""},
+	}
+	for _, tt := range tests {
+		got, ok := q.highlight(tt.value)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("highlight(%q) = %q, %v, want %q", tt.value, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// validateAPIKey checks the rules of a new key and normalizes its networks
+func validateAPIKey(key *models.APIKey, now time.Time) error {
+	fields := models.Validate(key)
+	if len(key.Scopes) > 0 {
+		if _, err := auth.ScopePermissions(key.Scopes); err != nil {
+			fields = append(fields, models.FieldError{Field: "scopes", Rule: "scope", Message: "scopes has an " + err.Error()})
+		}
+	}
+	if key.AllowedCIDRs == nil {
+		key.AllowedCIDRs = models.StringList{}
+	}
+	for i, cidr := range key.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			fields = append(fields, models.FieldError{Field: "allowed_cidrs", Rule: "cidr", Message: fmt.Sprintf("allowed_cidrs has an invalid network %q", cidr)})
+			break
+		}
+		key.AllowedCIDRs[i] = prefix.Masked().String()
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		fields = append(fields, models.FieldError{Field: "expires_at", Rule: "future", Message: "expires_at must be in the future"})
+	}
+	return fieldErrors("api key", fields)
+}
+
+// apiKeyLimitReached reports that a service account holds the maximum number of unexpired keys
+func apiKeyLimitReached(accountID string) error {
+	return newError(ErrConflict, "api_key_limit_reached",
+		"service account %s already has %d active API keys; delete one before creating another", accountID, models.MaxActiveAPIKeys)
+}
+
+// CreateServiceAccount creates a service account without keys
+func CreateServiceAccount(db *gorm.DB, account *models.ServiceAccount) error {
+	if err := validateCreate("service account", account); err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	now := time.Now().UTC()
+	account.CreatedAt, account.UpdatedAt = now, now
+	account.Keys = []models.APIKey{}
+	if err := db.Create(account).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			err = alreadyExists("service account", account.ID)
+		}
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	return nil
+}
+
+// GetServiceAccount retrieves a service account with its keys
+func GetServiceAccount(db *gorm.DB, accountID string) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	result := db.Where("id = ?", accountID).First(&account)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, notFound("service account", accountID)
+		}
+		return nil, fmt.Errorf("failed to get service account: %w", result.Error)
+	}
+	accounts := []models.ServiceAccount{account}
+	if err := attachAPIKeys(db, accounts); err != nil {
+		return nil, err
+	}
+	return &accounts[0], nil
+}
+
+// GetServiceAccounts retrieves every service account with its keys, ordered by ID
+func GetServiceAccounts(db *gorm.DB) ([]models.ServiceAccount, error) {
+	accounts := []models.ServiceAccount{}
+	if err := db.Order("id").Find(&accounts).Error; err != nil {
+		return nil, fmt.Errorf("failed to query service accounts: %w", err)
+	}
+	if err := attachAPIKeys(db, accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// attachAPIKeys loads the keys of the accounts, oldest first
+func attachAPIKeys(db *gorm.DB, accounts []models.ServiceAccount) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	ids := make([]string, len(accounts))
+	for i := range accounts {
+		ids[i] = accounts[i].ID
+		accounts[i].Keys = []models.APIKey{}
+	}
+	var keys []models.APIKey
+	if err := db.Where("service_account_id IN ?", ids).Order("created_at, id").Find(&keys).Error; err != nil {
+		return fmt.Errorf("failed to query API keys: %w", err)
+	}
+	byAccount := map[string][]models.APIKey{}
+	for _, key := range keys {
+		byAccount[key.ServiceAccountID] = append(byAccount[key.ServiceAccountID], key)
+	}
+	for i := range accounts {
+		if keys, ok := byAccount[accounts[i].ID]; ok {
+			accounts[i].Keys = keys
+		}
+	}
+	return nil
+}
+
+// DeleteServiceAccount removes a service account; its keys are removed with it
+func DeleteServiceAccount(db *gorm.DB, accountID string) error {
+	result := db.Where("id = ?", accountID).Delete(&models.ServiceAccount{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete service account: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return notFound("service account", accountID)
+	}
+	return nil
+}
+
+// CreateAPIKey stores a new key of a service account. The ID and hash must be
+// set; the account may hold at most models.MaxActiveAPIKeys unexpired keys.
+func CreateAPIKey(db *gorm.DB, key *models.APIKey) error {
+	now := time.Now().UTC()
+	if err := validateAPIKey(key, now); err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the account so that concurrent creations see each other's keys
+		var account models.ServiceAccount
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", key.ServiceAccountID).First(&account)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return notFound("service account", key.ServiceAccountID)
+			}
+			return result.Error
+		}
+		var active int64
+		err := tx.Model(&models.APIKey{}).
+			Where("service_account_id = ? AND (expires_at IS NULL OR expires_at > ?)", key.ServiceAccountID, now).
+			Count(&active).Error
+		if err != nil {
+			return err
+		}
+		if active >= models.MaxActiveAPIKeys {
+			return apiKeyLimitReached(key.ServiceAccountID)
+		}
+		key.CreatedAt = now
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		return tx.Model(&account).Update("updated_at", now).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKey retrieves a key by its public ID, including its hash
+func GetAPIKey(db *gorm.DB, keyID string) (*models.APIKey, error) {
+	var key models.APIKey
+	result := db.Where("id = ?", keyID).First(&key)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, notFound("api key", keyID)
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", result.Error)
+	}
+	return &key, nil
+}
+
+// DeleteAPIKey revokes a key of a service account
+func DeleteAPIKey(db *gorm.DB, accountID string, keyID string) error {
+	result := db.Where("id = ? AND service_account_id = ?", keyID, accountID).Delete(&models.APIKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return notFound("api key", keyID)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+)
+
+// UserStore covers user operations
+type UserStore interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
+	GetAllUsers(ctx context.Context, opts ListOptions) (*Page[models.User], error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, userID string, expectedVersion int64) error
+	RestoreUser(ctx context.Context, userID string) (*models.User, error)
+	ChangeUserStatus(ctx context.Context, userID string, status string) (*models.User, error)
+}
+
+// GroupStore covers group, group membership and group nesting operations
+type GroupStore interface {
+	CreateGroup(ctx context.Context, group *models.Group) error
+	GetGroupByID(ctx context.Context, groupID string) (*models.Group, error)
+	GetAllGroups(ctx context.Context, opts ListOptions) (*Page[models.Group], error)
+	UpdateGroup(ctx context.Context, group *models.Group) error
+	DeleteGroup(ctx context.Context, groupID string, expectedVersion int64) error
+	RestoreGroup(ctx context.Context, groupID string) (*models.Group, error)
+
+	AddUserToGroup(ctx context.Context, groupID string, userID string) error
+	RemoveUserFromGroup(ctx context.Context, groupID string, userID string) error
+	AddGroupToGroup(ctx context.Context, parentID string, childID string) error
+	RemoveGroupFromGroup(ctx context.Context, parentID string, childID string) error
+	GetGroupMembers(ctx context.Context, groupID string, transitive bool, opts ListOptions) (*GroupMembers, error)
+	GetUserGroups(ctx context.Context, userID string, transitive bool, opts ListOptions) (*Page[models.Group], error)
+}
+
+// RoleStore covers role, role-group and user-role operations
+type RoleStore interface {
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetRoleByID(ctx context.Context, roleID string) (*models.Role, error)
+	GetAllRoles(ctx context.Context, opts ListOptions) (*Page[models.Role], error)
+	UpdateRole(ctx context.Context, role *models.Role) error
+	DeleteRole(ctx context.Context, roleID string, expectedVersion int64) error
+	RestoreRole(ctx context.Context, roleID string) (*models.Role, error)
+
+	AddGroupToRole(ctx context.Context, roleID string, groupID string) error
+	RemoveGroupFromRole(ctx context.Context, roleID string, groupID string) error
+	GetRoleGroups(ctx context.Context, roleID string, opts ListOptions) (*Page[string], error)
+
+	AssignRoleToUser(ctx context.Context, userID string, roleID string) error
+	RemoveRoleFromUser(ctx context.Context, userID string, roleID string) error
+	GetUserRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error)
+	GetEffectiveRoles(ctx context.Context, userID string, opts ListOptions) (*Page[models.Role], error)
+}
+
+// AttributeStore covers the custom attribute schema of users and groups
+type AttributeStore interface {
+	CreateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error
+	GetAttributeDefinitions(ctx context.Context, entityType string) ([]models.AttributeDefinition, error)
+	GetAttributeDefinition(ctx context.Context, entityType string, name string) (*models.AttributeDefinition, error)
+	UpdateAttributeDefinition(ctx context.Context, def *models.AttributeDefinition) error
+	DeleteAttributeDefinition(ctx context.Context, entityType string, name string) error
+}
+
+// SearchStore covers ranked search across users, groups and roles
+type SearchStore interface {
+	Search(ctx context.Context, query string, opts SearchOptions) (*models.SearchResults, error)
+}
+
+// AuditStore covers the append-only audit log
+type AuditStore interface {
+	RecordAudit(ctx context.Context, entry *models.AuditEntry) error
+	ListAudit(ctx context.Context, filter AuditFilter) (*Page[models.AuditEntry], error)
+}
+
+// IdempotencyStore keeps the responses of requests sent with an Idempotency-Key
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey stores record in progress and returns nil, or
+	// returns the unexpired record that already holds the caller's key. An
+	// in-progress record whose LockedUntil has passed is taken over.
+	ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// CompleteIdempotencyKey stores the response of a request that still holds its reservation
+	CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	// ReleaseIdempotencyKey removes the reservation of a request that did not complete
+	ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	// PurgeIdempotencyKeys removes the records that expired before cutoff
+	PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// ServiceAccountStore covers service accounts and their API keys
+type ServiceAccountStore interface {
+	CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error
+	GetServiceAccount(ctx context.Context, accountID string) (*models.ServiceAccount, error)
+	GetServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, accountID string) error
+
+	// CreateAPIKey stores a key whose ID and hash are set, failing with
+	// ErrConflict when the account already holds models.MaxActiveAPIKeys unexpired keys
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// GetAPIKey returns a key by its public ID, including its hash
+	GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, accountID string, keyID string) error
+}
+
+// OIDCStore covers the clients, authorization codes and user passwords of the
+// built-in OpenID Connect provider
+type OIDCStore interface {
+	// CreateOIDCClient registers a client whose SecretHash is set unless it is public
+	CreateOIDCClient(ctx context.Context, client *models.OIDCClient) error
+	GetOIDCClient(ctx context.Context, clientID string) (*models.OIDCClient, error)
+	GetOIDCClients(ctx context.Context) ([]models.OIDCClient, error)
+	DeleteOIDCClient(ctx context.Context, clientID string) error
+
+	// CreateAuthorizationCode stores an issued code and removes the expired ones
+	CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	// ConsumeAuthorizationCode removes and returns the unexpired code with the
+	// given hash, failing with ErrNotFound if there is none
+	ConsumeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error)
+
+	// SetUserPassword stores the password hash of a user that is not deleted
+	SetUserPassword(ctx context.Context, userID string, passwordHash string) error
+	GetUserPassword(ctx context.Context, userID string) (*models.UserCredential, error)
+	DeleteUserPassword(ctx context.Context, userID string) error
+}
+
+// DirectoryStore is the storage backend for users, groups and roles.
+// PostgresStore and MemoryStore are the two implementations.
+type DirectoryStore interface {
+	UserStore
+	GroupStore
+	RoleStore
+	AttributeStore
+	SearchStore
+	AuditStore
+	IdempotencyStore
+	ServiceAccountStore
+	OIDCStore
+
+	// PurgeDeleted permanently removes entities soft-deleted before cutoff
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (*PurgeResult, error)
+
+	// WithTx runs fn against a store whose changes are committed only if fn returns nil
+	WithTx(ctx context.Context, fn func(tx DirectoryStore) error) error
+}
+
+var (
+	_ DirectoryStore = (*PostgresStore)(nil)
+	_ DirectoryStore = (*MemoryStore)(nil)
+	_ DirectoryStore = (*AuditedStore)(nil)
+)
@@ -1,58 +1,167 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"time"
+
 	"github.com/lotusatx/lotus-directory-engine-backend/models"
 	"gorm.io/gorm"
 )
 
+// CreateUser creates a new user along with any roles and group memberships in the request
 func CreateUser(db *gorm.DB, user *models.User) error {
-	result := db.Create(user)
-	if result.Error != nil {
-		return fmt.Errorf("failed to create user: %w", result.Error)
+	if err := validateCreate("user", user); err != nil {
+		return err
 	}
-	return nil
+	user.DeletedAt = gorm.DeletedAt{}
+	user.Version = 1
+	newUserLifecycle(user, time.Now().UTC())
+	err := db.Transaction(func(tx *gorm.DB) error {
+		attrs, err := entityAttributes(tx, models.EntityUser, user.Attributes)
+		if err != nil {
+			return err
+		}
+		user.Attributes = attrs
+		if err := tx.Create(user).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return alreadyExists("user", user.ID)
+			}
+			return err
+		}
+		if err := replaceUserRoles(tx, user.ID, user.Roles); err != nil {
+			return err
+		}
+		return replaceUserGroups(tx, user.ID, user.GroupIDs)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return refreshUserRelations(db, user)
 }
 
+// GetUserByID retrieves a user by ID with its roles and group IDs
 func GetUserByID(db *gorm.DB, userID string) (*models.User, error) {
 	var user models.User
 	result := db.Where("id = ?", userID).First(&user)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found: %s", userID)
+			return nil, notFound("user", userID)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", result.Error)
 	}
-	return &user, nil
+	users := []models.User{user}
+	if err := attachUserRelations(db, users); err != nil {
+		return nil, err
+	}
+	return &users[0], nil
 }
 
-func GetAllUsers(db *gorm.DB) ([]models.User, error) {
-	var users []models.User
-	result := db.Order("name").Find(&users)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query users: %w", result.Error)
+// GetAllUsers retrieves a page of users with their roles and group IDs
+func GetAllUsers(db *gorm.DB, opts ListOptions) (*Page[models.User], error) {
+	query := opts.scope(db)
+	if len(opts.Statuses) > 0 {
+		query = query.Where("status IN ?", opts.Statuses)
+	}
+	query, err := whereAttributes(db, query, models.EntityUser, opts.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	query, err = whereFilter(query, opts.Filter, userFilterFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	page, err := findPage[models.User](query, opts, userSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
-	return users, nil
+	if err := attachUserRelations(db, page.Items); err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
+// UpdateUser updates a user's fields. Roles, GroupIDs and Attributes replace
+// the existing values when present in the request and are left untouched when nil.
+// A non-zero Version makes the update conditional on the stored version.
+// Status changes go through ChangeUserStatus instead.
 func UpdateUser(db *gorm.DB, user *models.User) error {
-	result := db.Save(user)
+	if err := validateUpdate("user", user); err != nil {
+		return err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"email": user.Email, "name": user.Name, "version": gorm.Expr("version + 1")}
+		if user.Attributes != nil {
+			attrs, err := entityAttributes(tx, models.EntityUser, user.Attributes)
+			if err != nil {
+				return err
+			}
+			updates["attributes"] = attrs
+		}
+		result := whereVersion(tx.Model(&models.User{}).Where("id = ?", user.ID), user.Version).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return versionConflict(tx, &models.User{}, "user", user.ID, user.Version)
+		}
+		if user.Roles != nil {
+			if err := replaceUserRoles(tx, user.ID, user.Roles); err != nil {
+				return err
+			}
+		}
+		if user.GroupIDs != nil {
+			if err := replaceUserGroups(tx, user.ID, user.GroupIDs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	updated, err := GetUserByID(db, user.ID)
+	if err != nil {
+		return err
+	}
+	*user = *updated
+	return nil
+}
+
+// DeleteUser soft-deletes a user by ID. Its role assignments and group
+// memberships are kept so that RestoreUser can bring them back. A non-zero
+// expectedVersion makes the delete conditional on the stored version.
+func DeleteUser(db *gorm.DB, userID string, expectedVersion int64) error {
+	result := whereVersion(db, expectedVersion).Delete(&models.User{}, "id = ?", userID)
 	if result.Error != nil {
-		return fmt.Errorf("failed to update user: %w", result.Error)
+		return fmt.Errorf("failed to delete user: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found: %s", user.ID)
+		return versionConflict(db, &models.User{}, "user", userID, expectedVersion)
 	}
 	return nil
 }
 
-func DeleteUser(db *gorm.DB, userID string) error {
-	result := db.Delete(&models.User{}, "id = ?", userID)
+// RestoreUser brings back a soft-deleted user along with its prior relationships
+func RestoreUser(db *gorm.DB, userID string) (*models.User, error) {
+	result := db.Unscoped().Model(&models.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", userID).
+		Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
 	if result.Error != nil {
-		return fmt.Errorf("failed to delete user: %w", result.Error)
+		return nil, fmt.Errorf("failed to restore user: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found: %s", userID)
+		return nil, deletedNotFound("user", userID)
 	}
+	return GetUserByID(db, userID)
+}
+
+// refreshUserRelations reloads a user's roles and group IDs from the join tables
+func refreshUserRelations(db *gorm.DB, user *models.User) error {
+	users := []models.User{*user}
+	if err := attachUserRelations(db, users); err != nil {
+		return err
+	}
+	*user = users[0]
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lotusatx/lotus-directory-engine-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidTransition is returned when a user cannot move to the requested status
+var ErrInvalidTransition error = newError(ErrConflict, "invalid_status_transition", "invalid status transition")
+
+// userTransitions lists the statuses each user status may move to.
+// Deprovisioned is terminal.
+var userTransitions = map[string][]string{
+	models.UserStatusActive:        {models.UserStatusSuspended, models.UserStatusDeprovisioned},
+	models.UserStatusSuspended:     {models.UserStatusActive, models.UserStatusDeprovisioned},
+	models.UserStatusDeprovisioned: {},
+}
+
+// ValidUserStatus reports whether status is a known user status
+func ValidUserStatus(status string) bool {
+	_, ok := userTransitions[status]
+	return ok
+}
+
+// checkUserTransition returns an error unless a user may move from one status to another
+func checkUserTransition(userID, from, to string) error {
+	for _, allowed := range userTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: user %s cannot move from %s to %s", ErrInvalidTransition, userID, from, to)
+}
+
+// newUserLifecycle resets the server-managed lifecycle fields of a user being created
+func newUserLifecycle(user *models.User, now time.Time) {
+	user.Status = models.UserStatusActive
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.StatusChangedAt = now
+	user.DisabledAt = nil
+}
+
+// applyUserStatus moves a user to status, keeping DisabledAt at the moment the
+// user first stopped being active
+func applyUserStatus(user *models.User, status string, now time.Time) {
+	user.Status = status
+	user.StatusChangedAt = now
+	user.UpdatedAt = now
+	if status == models.UserStatusActive {
+		user.DisabledAt = nil
+	} else if user.DisabledAt == nil {
+		user.DisabledAt = &now
+	}
+}
+
+// ChangeUserStatus moves a user to a new lifecycle status, rejecting
+// transitions that userTransitions does not allow
+func ChangeUserStatus(db *gorm.DB, userID string, status string) (*models.User, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return notFound("user", userID)
+			}
+			return result.Error
+		}
+		if err := checkUserTransition(userID, user.Status, status); err != nil {
+			return err
+		}
+
+		applyUserStatus(&user, status, time.Now().UTC())
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"status":            user.Status,
+			"status_changed_at": user.StatusChangedAt,
+			"disabled_at":       user.DisabledAt,
+			"updated_at":        user.UpdatedAt,
+			"version":           gorm.Expr("version + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to change user status: %w", err)
+	}
+	return GetUserByID(db, userID)
+}
+
+// GetEffectiveRoles returns the roles a user holds directly or through any of
+// its groups, including groups nested at any depth. Users that are not active
+// have no effective roles.
+func GetEffectiveRoles(db *gorm.DB, userID string, opts ListOptions) (*Page[models.Role], error) {
+	user, err := GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return pageSlice([]models.Role{}, opts, roleSortKeys)
+	}
+
+	var groupIDs []string
+	if err := db.Raw(userGroupsTransitiveSQL, userID).Scan(&groupIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to resolve user groups: %w", err)
+	}
+	sub := db.Session(&gorm.Session{NewDB: true})
+	direct := sub.Model(&models.UserRole{}).Select("role_id").Where("user_id = ?", userID)
+	inherited := sub.Model(&models.RoleGroup{}).Select("role_id").Where("group_id IN ?", groupIDs)
+	query := db.Where("roles.id IN (?) OR roles.id IN (?)", direct, inherited)
+	page, err := findPage[models.Role](query, opts, roleSortKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get effective roles: %w", err)
+	}
+	if err := attachRoleGroups(db, page.Items); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrVersionMismatch is returned when a conditional write names a version
+// other than the entity's current one
+var ErrVersionMismatch error = newError(ErrPreconditionFailed, "version_mismatch", "version mismatch")
+
+// whereVersion restricts a write to the expected version. An expected version
+// of zero makes the write unconditional.
+func whereVersion(db *gorm.DB, expected int64) *gorm.DB {
+	if expected > 0 {
+		return db.Where("version = ?", expected)
+	}
+	return db
+}
+
+// versionConflict explains why a conditional write on the given entity
+// matched no rows: either the entity is gone or it has moved on
+func versionConflict(db *gorm.DB, model interface{}, kind, id string, expected int64) error {
+	var count int64
+	if err := db.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get %s: %w", kind, err)
+	}
+	if count == 0 || expected <= 0 {
+		return notFound(kind, id)
+	}
+	return fmt.Errorf("%w: %s %s is not at version %d", ErrVersionMismatch, kind, id, expected)
+}
+
+// checkVersion is the in-memory counterpart of whereVersion and versionConflict
+func checkVersion(kind, id string, current, expected int64) error {
+	if expected > 0 && current != expected {
+		return fmt.Errorf("%w: %s %s is not at version %d", ErrVersionMismatch, kind, id, expected)
+	}
+	return nil
+}
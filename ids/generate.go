@@ -0,0 +1,56 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// timestamped returns 16 bytes holding the current Unix time in milliseconds
+// in the first 6 bytes and random bits in the rest
+func timestamped() ([16]byte, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return b, fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(b[:6], ms[2:])
+	return b, nil
+}
+
+// NewUUIDv7 returns a random, time-ordered UUID version 7 (RFC 9562)
+func NewUUIDv7() (string, error) {
+	b, err := timestamped()
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x70 // Version 7
+	b[8] = b[8]&0x3f | 0x80 // Variant 10
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
+
+// NewULID returns a random, time-ordered ULID: a 48-bit millisecond timestamp
+// and 80 random bits in 26 Crockford base32 characters
+func NewULID() (string, error) {
+	b, err := timestamped()
+	if err != nil {
+		return "", err
+	}
+	// 128 bits in 26 characters of 5 bits: the first character holds the top 3 bits
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
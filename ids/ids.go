@@ -0,0 +1,138 @@
+// Package ids describes the formats of entity IDs and generates IDs in them.
+//
+// A format is one of:
+//
+//	sequence:PREFIX:WIDTH  a prefix and a number from a counter, zero-padded to
+//	                       at least WIDTH digits, e.g. sequence:UI:6 gives UI000001
+//	uuidv7                 a time-ordered UUID (RFC 9562), e.g. 0190b6c2-5a3e-7d4f-8b1a-2c3d4e5f6a7b
+//	ulid                   a time-ordered ULID, e.g. 01J2XW4Q8M6V3N5P7R9T0YZABC
+//
+// Sequence numbers come from a counter kept by the caller; the other formats
+// are generated here.
+package ids
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Format kinds
+const (
+	KindSequence = "sequence"
+	KindUUIDv7   = "uuidv7"
+	KindULID     = "ulid"
+)
+
+// maxWidth bounds the zero-padding of sequence numbers to the digits of an int64
+const maxWidth = 18
+
+// prefixPattern restricts sequence prefixes to characters that are safe in URLs
+var prefixPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,15}$`)
+
+var (
+	uuidv7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+// Format is the format of the IDs of one entity type
+type Format struct {
+	Kind   string // sequence, uuidv7 or ulid
+	Prefix string // Sequence only: text before the number
+	Width  int    // Sequence only: minimum number of digits
+
+	pattern *regexp.Regexp
+}
+
+// Sequence returns a sequence format with the given prefix and width
+func Sequence(prefix string, width int) Format {
+	return Format{
+		Kind:    KindSequence,
+		Prefix:  prefix,
+		Width:   width,
+		pattern: regexp.MustCompile(fmt.Sprintf(`^%s[0-9]{%d,%d}$`, regexp.QuoteMeta(prefix), width, maxWidth+1)),
+	}
+}
+
+// UUIDv7 returns the UUIDv7 format
+func UUIDv7() Format {
+	return Format{Kind: KindUUIDv7, pattern: uuidv7Pattern}
+}
+
+// ULID returns the ULID format
+func ULID() Format {
+	return Format{Kind: KindULID, pattern: ulidPattern}
+}
+
+// Parse parses a format such as "sequence:UI:6", "uuidv7" or "ulid"
+func Parse(spec string) (Format, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	switch strings.ToLower(parts[0]) {
+	case KindSequence:
+		if len(parts) != 3 {
+			return Format{}, fmt.Errorf("invalid ID format %q: expected sequence:PREFIX:WIDTH", spec)
+		}
+		if !prefixPattern.MatchString(parts[1]) {
+			return Format{}, fmt.Errorf("invalid ID format %q: the prefix must be up to 16 letters, digits, hyphens and underscores, starting with a letter", spec)
+		}
+		width, err := strconv.Atoi(parts[2])
+		if err != nil || width < 1 || width > maxWidth {
+			return Format{}, fmt.Errorf("invalid ID format %q: the width must be between 1 and %d", spec, maxWidth)
+		}
+		return Sequence(parts[1], width), nil
+	case KindUUIDv7, KindULID:
+		if len(parts) != 1 {
+			return Format{}, fmt.Errorf("invalid ID format %q: %s takes no options", spec, parts[0])
+		}
+		if strings.ToLower(parts[0]) == KindUUIDv7 {
+			return UUIDv7(), nil
+		}
+		return ULID(), nil
+	default:
+		return Format{}, fmt.Errorf("invalid ID format %q: expected sequence:PREFIX:WIDTH, uuidv7 or ulid", spec)
+	}
+}
+
+// String returns the format in the syntax accepted by Parse
+func (f Format) String() string {
+	if f.Kind == KindSequence {
+		return fmt.Sprintf("%s:%s:%d", f.Kind, f.Prefix, f.Width)
+	}
+	return f.Kind
+}
+
+// Match reports whether id is in the format
+func (f Format) Match(id string) bool {
+	return f.pattern != nil && f.pattern.MatchString(id)
+}
+
+// Describe completes "must be ..." in error messages
+func (f Format) Describe() string {
+	switch f.Kind {
+	case KindSequence:
+		return fmt.Sprintf("%s followed by at least %d digits, e.g. %s", f.Prefix, f.Width, f.FormatSequence(1))
+	case KindUUIDv7:
+		return "a lowercase UUIDv7, e.g. 0190b6c2-5a3e-7d4f-8b1a-2c3d4e5f6a7b"
+	default:
+		return "an uppercase ULID, e.g. 01J2XW4Q8M6V3N5P7R9T0YZABC"
+	}
+}
+
+// FormatSequence returns the ID of sequence number n
+func (f Format) FormatSequence(n int64) string {
+	return fmt.Sprintf("%s%0*d", f.Prefix, f.Width, n)
+}
+
+// Generate returns a new ID of a uuidv7 or ulid format. Sequence IDs need a
+// counter and are built with FormatSequence instead.
+func (f Format) Generate() (string, error) {
+	switch f.Kind {
+	case KindUUIDv7:
+		return NewUUIDv7()
+	case KindULID:
+		return NewULID()
+	default:
+		return "", fmt.Errorf("%s IDs cannot be generated without a counter", f.Kind)
+	}
+}
@@ -0,0 +1,193 @@
+package ids
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"sequence:UI:6", "sequence:UI:6"},
+		{"SEQUENCE:grp_x-1:3", "sequence:grp_x-1:3"},
+		{" sequence:ROLE:18 ", "sequence:ROLE:18"},
+		{"uuidv7", "uuidv7"},
+		{"UUIDv7", "uuidv7"},
+		{"ulid", "ulid"},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got := f.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.spec, got, tt.want)
+		}
+		// String is accepted by Parse
+		again, err := Parse(f.String())
+		if err != nil || again.String() != f.String() {
+			t.Errorf("Parse(%q) = %v, %v", f.String(), again, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"sequence",
+		"sequence:UI",
+		"sequence:UI:6:7",
+		"sequence::6",
+		"sequence:1UI:6",
+		"sequence:U/I:6",
+		"sequence:ABCDEFGHIJKLMNOPQ:6",
+		"sequence:UI:0",
+		"sequence:UI:19",
+		"sequence:UI:six",
+		"uuidv7:x",
+		"ulid:26",
+		"uuidv4",
+	} {
+		if f, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", spec, f)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		format Format
+		id     string
+		want   bool
+	}{
+		{Sequence("UI", 6), "UI000001", true},
+		{Sequence("UI", 6), "UI1234567", true},
+		{Sequence("UI", 6), "UI00001", false},
+		{Sequence("UI", 6), "ui000001", false},
+		{Sequence("UI", 6), "UI000001x", false},
+		{Sequence("UI", 6), "XUI000001", false},
+		{Sequence("UI", 6), "UI" + strings.Repeat("1", 20), false},
+		// The prefix is literal, not a pattern
+		{Sequence("A-B", 2), "A-B01", true},
+		{Sequence("A.B", 2), "AxB01", false},
+		{UUIDv7(), "0190b6c2-5a3e-7d4f-8b1a-2c3d4e5f6a7b", true},
+		{UUIDv7(), "0190B6C2-5A3E-7D4F-8B1A-2C3D4E5F6A7B", false},
+		{UUIDv7(), "0190b6c2-5a3e-4d4f-8b1a-2c3d4e5f6a7b", false},
+		{UUIDv7(), "0190b6c2-5a3e-7d4f-cb1a-2c3d4e5f6a7b", false},
+		{UUIDv7(), "0190b6c25a3e7d4f8b1a2c3d4e5f6a7b", false},
+		{ULID(), "01J2XW4Q8M6V3N5P7R9T0YZABC", true},
+		{ULID(), "01j2xw4q8m6v3n5p7r9t0yzabc", false},
+		{ULID(), "81J2XW4Q8M6V3N5P7R9T0YZABC", false},
+		{ULID(), "01J2XW4Q8M6V3N5P7R9T0YZABI", false},
+		{ULID(), "01J2XW4Q8M6V3N5P7R9T0YZAB", false},
+		{Format{}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.format.Match(tt.id); got != tt.want {
+			t.Errorf("%s Match(%q) = %v, want %v", tt.format, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSequence(t *testing.T) {
+	f := Sequence("GRP", 3)
+	for n, want := range map[int64]string{1: "GRP001", 999: "GRP999", 1000: "GRP1000"} {
+		if got := f.FormatSequence(n); got != want || !f.Match(got) {
+			t.Errorf("FormatSequence(%d) = %q, want %q", n, got, want)
+		}
+	}
+	if _, err := f.Generate(); err == nil {
+		t.Errorf("Generate() of a sequence format succeeded")
+	}
+	if got := f.Describe(); got != "GRP followed by at least 3 digits, e.g. GRP001" {
+		t.Errorf("Describe() = %q", got)
+	}
+}
+
+// generateMany returns n IDs of the format, generated over a few milliseconds
+func generateMany(t *testing.T, f Format, n int) []string {
+	t.Helper()
+	out := make([]string, n)
+	for i := range out {
+		id, err := f.Generate()
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if !f.Match(id) {
+			t.Fatalf("generated %s ID %q does not match the format", f, id)
+		}
+		out[i] = id
+		if i%100 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return out
+}
+
+// IDs from different milliseconds sort in generation order, and no two are equal
+func checkOrdered(t *testing.T, ids []string, millis func(string) int64) {
+	t.Helper()
+	seen := map[string]bool{}
+	for i, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+		if i == 0 {
+			continue
+		}
+		prev := ids[i-1]
+		if millis(prev) > millis(id) {
+			t.Errorf("timestamp of %q is after that of %q", prev, id)
+		}
+		if millis(prev) < millis(id) && prev > id {
+			t.Errorf("%q generated in a later millisecond sorts before %q", id, prev)
+		}
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	before := time.Now().UnixMilli()
+	ids := generateMany(t, UUIDv7(), 300)
+	after := time.Now().UnixMilli()
+
+	millis := func(id string) int64 {
+		b, err := hex.DecodeString(strings.ReplaceAll(id, "-", "")[:12])
+		if err != nil {
+			t.Fatalf("invalid UUID %q", id)
+		}
+		var ms int64
+		for _, c := range b {
+			ms = ms<<8 | int64(c)
+		}
+		return ms
+	}
+	checkOrdered(t, ids, millis)
+	if first, last := millis(ids[0]), millis(ids[len(ids)-1]); first < before || last > after {
+		t.Errorf("timestamps %d..%d outside %d..%d", first, last, before, after)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	before := time.Now().UnixMilli()
+	ids := generateMany(t, ULID(), 300)
+	after := time.Now().UnixMilli()
+
+	// The first 10 characters encode the 48-bit millisecond timestamp
+	millis := func(id string) int64 {
+		var ms int64
+		for _, c := range id[:10] {
+			ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+		}
+		return ms
+	}
+	checkOrdered(t, ids, millis)
+	if first, last := millis(ids[0]), millis(ids[len(ids)-1]); first < before || last > after {
+		t.Errorf("timestamps %d..%d outside %d..%d", first, last, before, after)
+	}
+}
@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
-	
+	"net/netip"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/lotusatx/lotus-directory-engine-backend/api"
+	"github.com/lotusatx/lotus-directory-engine-backend/auth"
 	"github.com/lotusatx/lotus-directory-engine-backend/handlers"
+	"github.com/lotusatx/lotus-directory-engine-backend/ids"
+	"github.com/lotusatx/lotus-directory-engine-backend/oidc"
 	"github.com/lotusatx/lotus-directory-engine-backend/secrets"
 )
 
@@ -14,26 +27,250 @@ func main() {
 	// Initialize secret manager (uses environment variables by default)
 	secretManager := secrets.NewSecretManager()
 
-	// Get database connection string
-	cs, err := secretManager.GetConnectionString()
-	if err != nil {
-		log.Fatalf("Failed to get database connection string: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(secretManager, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	// Initialize database connection and migration using existing db_handler
-	db, err := handlers.ConfigureDbConnection(cs)
+	store, err := newDirectoryStore(secretManager)
 	if err != nil {
-		log.Fatalf("Failed to configure database: %v", err)
+		log.Fatalf("Failed to configure directory store: %v", err)
 	}
 
 	// Get server port
 	port := getEnvOrDefault("PORT", "8080")
 
+	// Stop on SIGTERM (Kubernetes) or SIGINT (Ctrl+C); the server drains in-flight requests first
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Create and start the API server
-	server := api.NewServer(db)
-	
+	server := api.NewServer(store)
+	server.Timeouts = timeoutsFromEnv()
+	server.Authenticator, err = authenticatorFromEnv(secretManager)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	server.AdminSubjects = splitList(os.Getenv("AUTH_ADMIN_SUBJECTS"))
+
+	// Audit unauthenticated mutations under the client-supplied X-Actor header, for local development only
+	if value := os.Getenv("AUTH_TRUST_ACTOR_HEADER"); value != "" {
+		server.TrustActorHeader, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid AUTH_TRUST_ACTOR_HEADER: %v", err)
+		}
+		if server.TrustActorHeader && server.Authenticator != nil {
+			log.Fatalf("AUTH_TRUST_ACTOR_HEADER requires AUTH_MODE=none")
+		}
+	}
+
+	// Service accounts authenticate with X-API-Key alongside bearer tokens
+	if server.Authenticator != nil {
+		trustedProxies, err := parsePrefixes(os.Getenv("AUTH_TRUSTED_PROXIES"))
+		if err != nil {
+			log.Fatalf("Invalid AUTH_TRUSTED_PROXIES: %v", err)
+		}
+		server.Authenticator = auth.Chain{server.Authenticator, server.APIKeyAuthenticator(trustedProxies)}
+	}
+
+	// Log users in to client applications when an OIDC issuer is configured
+	server.OIDCAPI.Provider, err = oidcProviderFromEnv(secretManager)
+	if err != nil {
+		log.Fatalf("Failed to configure the OpenID Connect provider: %v", err)
+	}
+	if server.OIDCAPI.Provider != nil {
+		log.Printf("OpenID Connect provider enabled for issuer %s", server.OIDCAPI.Provider.Issuer())
+	}
+
+	// Replay responses to retried POST requests that carry an Idempotency-Key
+	server.IdempotencyTTL = getDurationOrDefault("IDEMPOTENCY_TTL", api.DefaultIdempotencyTTL)
+	server.IdempotencyLease = getDurationOrDefault("IDEMPOTENCY_LEASE", api.DefaultIdempotencyLease)
+	if server.IdempotencyTTL > 0 {
+		go handlers.RunIdempotencyPurgeLoop(ctx, server.Store, getDurationOrDefault("PURGE_INTERVAL", time.Hour))
+	}
+
+	// Permanently remove soft-deleted entities once their retention period has passed
+	retention := getDurationOrDefault("PURGE_RETENTION", 30*24*time.Hour)
+	if retention > 0 {
+		interval := getDurationOrDefault("PURGE_INTERVAL", time.Hour)
+		go handlers.RunPurgeLoop(ctx, server.Store, retention, interval)
+	}
+
 	log.Printf("Starting Lotus Directory Engine API server...")
-	if err := server.Start(port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	err = server.Start(ctx, port)
+
+	// Close the database connection pool once in-flight requests are done
+	if closer, ok := store.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			log.Printf("Failed to close directory store: %v", closeErr)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// authenticatorFromEnv configures authentication from AUTH_MODE: "jwt" (the
+// default) requires bearer tokens, "none" leaves the API open for local development
+func authenticatorFromEnv(secretManager *secrets.SecretManager) (auth.Authenticator, error) {
+	switch mode := getEnvOrDefault("AUTH_MODE", "jwt"); mode {
+	case "none":
+		log.Printf("Warning: AUTH_MODE=none, the API accepts unauthenticated requests")
+		return nil, nil
+	case "jwt":
+		cfg := auth.JWTConfig{
+			Issuer:   os.Getenv("JWT_ISSUER"),
+			Audience: os.Getenv("JWT_AUDIENCE"),
+			JWKSFile: os.Getenv("JWT_JWKS_FILE"),
+			Leeway:   getDurationOrDefault("JWT_LEEWAY", auth.DefaultLeeway),
+		}
+		if secret, err := secretManager.GetJWTSecret(); err == nil {
+			cfg.Secret = []byte(secret)
+		}
+		var missing []string
+		if cfg.Issuer == "" {
+			missing = append(missing, "JWT_ISSUER")
+		}
+		if cfg.Audience == "" {
+			missing = append(missing, "JWT_AUDIENCE")
+		}
+		if len(cfg.Secret) == 0 && cfg.JWKSFile == "" {
+			missing = append(missing, "JWT_SECRET (or JWT_JWKS_FILE)")
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("AUTH_MODE=jwt (the default) requires %s to be set; see .env.template and the Authentication section of API.md, or set AUTH_MODE=none for local development", strings.Join(missing, ", "))
+		}
+		verifier, err := auth.NewJWTVerifier(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_MODE=jwt: %w", err)
+		}
+		return verifier, nil
+	default:
+		return nil, fmt.Errorf("invalid AUTH_MODE %q: expected jwt or none", mode)
+	}
+}
+
+// oidcProviderFromEnv configures the OpenID Connect provider from
+// OIDC_ISSUER; it is disabled when the variable is unset
+func oidcProviderFromEnv(secretManager *secrets.SecretManager) (*oidc.Provider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	pem, err := secretManager.GetOIDCSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := oidc.ParseKeySet([]byte(pem))
+	if err != nil {
+		return nil, err
+	}
+	var claims oidc.ClaimMapping
+	if spec := os.Getenv("OIDC_CLAIMS"); spec != "" {
+		if claims, err = oidc.ParseClaimMapping(spec); err != nil {
+			return nil, fmt.Errorf("invalid OIDC_CLAIMS: %w", err)
+		}
+	}
+	return oidc.NewProvider(oidc.Config{
+		Issuer:     issuer,
+		Keys:       keys,
+		Claims:     claims,
+		CodeTTL:    getDurationOrDefault("OIDC_CODE_TTL", oidc.DefaultCodeTTL),
+		TokenTTL:   getDurationOrDefault("OIDC_TOKEN_TTL", oidc.DefaultTokenTTL),
+		SessionTTL: getDurationOrDefault("OIDC_SESSION_TTL", oidc.DefaultSessionTTL),
+	})
+}
+
+// splitList splits a comma-separated list, dropping blank items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePrefixes parses a comma-separated list of networks in CIDR notation
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// timeoutsFromEnv reads the HTTP server and shutdown timeouts, keeping the
+// default for unset variables
+func timeoutsFromEnv() api.Timeouts {
+	t := api.DefaultTimeouts()
+	t.ReadHeader = getDurationOrDefault("HTTP_READ_HEADER_TIMEOUT", t.ReadHeader)
+	t.Read = getDurationOrDefault("HTTP_READ_TIMEOUT", t.Read)
+	t.Write = getDurationOrDefault("HTTP_WRITE_TIMEOUT", t.Write)
+	t.Idle = getDurationOrDefault("HTTP_IDLE_TIMEOUT", t.Idle)
+	t.Shutdown = getDurationOrDefault("SHUTDOWN_TIMEOUT", t.Shutdown)
+	t.DrainDelay = getDurationOrDefault("SHUTDOWN_DRAIN_DELAY", t.DrainDelay)
+	return t
+}
+
+// newDirectoryStore selects the storage backend from DIRECTORY_STORE ("postgres" or "memory").
+// The memory store is for local development only and requires AUTH_MODE=none.
+func newDirectoryStore(secretManager *secrets.SecretManager) (handlers.DirectoryStore, error) {
+	idFormats, err := idFormatsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if getEnvOrDefault("DIRECTORY_STORE", "postgres") == "memory" {
+		if getEnvOrDefault("AUTH_MODE", "jwt") != "none" {
+			return nil, fmt.Errorf("DIRECTORY_STORE=memory is only for local development and requires AUTH_MODE=none")
+		}
+		log.Printf("Using in-memory directory store; data will not survive a restart")
+		store := handlers.NewMemoryStore()
+		store.SetIDFormats(idFormats)
+		return store, nil
+	}
+
+	// Get database connection string
+	cs, err := secretManager.GetConnectionString()
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize database connection and check the schema version
+	autoMigrate := getEnvOrDefault("DB_AUTO_MIGRATE", "true") == "true"
+	db, err := handlers.ConfigureDbConnection(cs, autoMigrate)
+	if err != nil {
+		return nil, err
+	}
+	store := handlers.NewPostgresStore(db)
+	store.SetIDFormats(idFormats)
+	return store, nil
+}
+
+// idFormatsFromEnv reads the ID format of each entity type from USER_ID_FORMAT,
+// GROUP_ID_FORMAT and ROLE_ID_FORMAT, keeping the default for unset variables
+func idFormatsFromEnv() (handlers.IDFormats, error) {
+	formats := handlers.DefaultIDFormats()
+	for key, format := range map[string]*ids.Format{
+		"USER_ID_FORMAT":  &formats.User,
+		"GROUP_ID_FORMAT": &formats.Group,
+		"ROLE_ID_FORMAT":  &formats.Role,
+	} {
+		if value := os.Getenv(key); value != "" {
+			parsed, err := ids.Parse(value)
+			if err != nil {
+				return formats, fmt.Errorf("%s: %w", key, err)
+			}
+			*format = parsed
+		}
 	}
-}
\ No newline at end of file
+	return formats, nil
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0010_later.up.sql":     {Data: []byte("up 10")},
+		"sql/0010_later.down.sql":   {Data: []byte("down 10")},
+		"sql/0002_second.up.sql":    {Data: []byte("up 2")},
+		"sql/0002_second.down.sql":  {Data: []byte("down 2")},
+		"sql/0001_initial.down.sql": {Data: []byte("down 1")},
+		"sql/0001_initial.up.sql":   {Data: []byte("up 1")},
+		"sql/9_unpadded.up.sql":     {Data: []byte("up 9")},
+		"sql/9_unpadded.down.sql":   {Data: []byte("down 9")},
+	}
+	migrations, err := load(fsys, "sql")
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	// Versions are ordered numerically, not by file name
+	want := []Migration{
+		{Version: 1, Name: "initial", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+		{Version: 9, Name: "unpadded", Up: "up 9", Down: "down 9"},
+		{Version: 10, Name: "later", Up: "up 10", Down: "down 10"},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Errorf("load() = %+v, want %+v", migrations, want)
+	}
+}
+
+func TestLoadRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		err   string
+	}{
+		{"missing down", []string{"0001_initial.up.sql"}, "must have both up and down files"},
+		{"missing up", []string{"0001_initial.down.sql"}, "must have both up and down files"},
+		{"conflicting names", []string{"0001_initial.up.sql", "0001_other.down.sql"}, "conflicting names"},
+		{"unpadded duplicate", []string{"0001_initial.up.sql", "0001_initial.down.sql", "1_initial.up.sql", "1_other.down.sql"}, "conflicting names"},
+		{"no direction", []string{"0001_initial.sql"}, "invalid migration file name"},
+		{"no version", []string{"initial.up.sql"}, "invalid migration file name"},
+		{"upper case name", []string{"0001_Initial.up.sql"}, "invalid migration file name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys["sql/"+name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			if _, err := load(fsys, "sql"); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("load() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// schemaObjects finds the tables, sequences, functions and columns that a
+// script creates (or drops, for a down script). Columns are named table.column
+// after the ALTER TABLE they belong to.
+func schemaObjects(script string, down bool) map[string]bool {
+	verb, column := `CREATE(?: OR REPLACE)?`, `ADD COLUMN`
+	if down {
+		verb, column = `DROP`, `DROP COLUMN`
+	}
+	pattern := regexp.MustCompile(`(?i)\b(ALTER TABLE|` + column + `|` + verb + ` (?:TABLE|SEQUENCE|FUNCTION))\s+(?:IF (?:NOT )?EXISTS\s+)?(\w+)`)
+	objects := map[string]bool{}
+	table := ""
+	for _, match := range pattern.FindAllStringSubmatch(script, -1) {
+		kind, name := strings.ToUpper(match[1]), strings.ToLower(match[2])
+		switch {
+		case kind == "ALTER TABLE":
+			table = name
+		case strings.HasSuffix(kind, "COLUMN"):
+			objects[table+"."+name] = true
+		default:
+			objects[strings.Fields(kind)[len(strings.Fields(kind))-1]+" "+name] = true
+		}
+	}
+	return objects
+}
+
+// Every embedded migration has a down script that drops what its up script creates
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	names := map[string]bool{}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d (%s) follows version %d; versions must be consecutive", migration.Version, migration.Name, i)
+		}
+		if names[migration.Name] {
+			t.Errorf("migration %d reuses the name %q", migration.Version, migration.Name)
+		}
+		names[migration.Name] = true
+
+		created, dropped := schemaObjects(migration.Up, false), schemaObjects(migration.Down, true)
+		for object := range created {
+			// Columns of a table created by the same migration go with the table
+			table, _, isColumn := strings.Cut(object, ".")
+			if isColumn && created["TABLE "+table] {
+				continue
+			}
+			if !dropped[object] {
+				t.Errorf("migration %d (%s) creates %s but its down script does not drop it", migration.Version, migration.Name, object)
+			}
+		}
+	}
+}
+
+func TestCheckNotNewer(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}}}
+	if m.Latest() != 2 {
+		t.Errorf("Latest() = %d, want 2", m.Latest())
+	}
+	now := time.Now()
+	if err := m.checkNotNewer(map[int]time.Time{1: now}); err != nil {
+		t.Errorf("checkNotNewer() with an older schema error = %v", err)
+	}
+	if err := m.checkNotNewer(map[int]time.Time{1: now, 2: now, 3: now}); err == nil || !strings.Contains(err.Error(), "migration 3") {
+		t.Errorf("checkNotNewer() with a newer schema error = %v", err)
+	}
+	if (&Migrator{}).Latest() != 0 {
+		t.Errorf("Latest() without migrations = %d, want 0", (&Migrator{}).Latest())
+	}
+}
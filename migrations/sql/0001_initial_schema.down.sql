@@ -0,0 +1,8 @@
+-- The legacy relationship columns dropped by the up migration are not restored:
+-- their data lives in the join tables, which are dropped with the schema.
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_groups;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS users;
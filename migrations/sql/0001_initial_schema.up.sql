@@ -0,0 +1,81 @@
+-- Core directory tables and the join tables that hold their relationships.
+-- IF NOT EXISTS lets databases created by the former gorm AutoMigrate adopt this schema.
+
+CREATE TABLE IF NOT EXISTS users (
+    id    TEXT PRIMARY KEY,
+    email TEXT,
+    name  TEXT
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id          TEXT PRIMARY KEY,
+    name        TEXT,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id          TEXT PRIMARY KEY,
+    name        TEXT,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id TEXT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS role_groups (
+    role_id  TEXT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    group_id TEXT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, group_id)
+);
+CREATE INDEX IF NOT EXISTS idx_role_groups_group_id ON role_groups (group_id);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id TEXT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+CREATE INDEX IF NOT EXISTS idx_users_name ON users (name);
+CREATE INDEX IF NOT EXISTS idx_groups_name ON groups (name);
+CREATE INDEX IF NOT EXISTS idx_roles_name ON roles (name);
+
+-- Databases created by AutoMigrate kept relationships in array columns:
+-- users.group_ids, groups.members and roles.groups. Copy them into the join
+-- tables, skipping IDs that name no entity, then drop the columns. Array and
+-- JSON columns are supported; any other type stops the migration rather than
+-- losing the relationships.
+DO $$
+DECLARE
+    legacy   RECORD;
+    elements TEXT;
+BEGIN
+    FOR legacy IN
+        SELECT c.table_name, c.column_name, c.data_type, l.statement
+        FROM (VALUES
+            ('users', 'group_ids',
+             'INSERT INTO group_members (group_id, user_id) SELECT DISTINCT e.id, t.id FROM users t, %s AS e(id) WHERE EXISTS (SELECT 1 FROM groups g WHERE g.id = e.id) ON CONFLICT DO NOTHING'),
+            ('groups', 'members',
+             'INSERT INTO group_members (group_id, user_id) SELECT DISTINCT t.id, e.id FROM groups t, %s AS e(id) WHERE EXISTS (SELECT 1 FROM users u WHERE u.id = e.id) ON CONFLICT DO NOTHING'),
+            ('roles', 'groups',
+             'INSERT INTO role_groups (role_id, group_id) SELECT DISTINCT t.id, e.id FROM roles t, %s AS e(id) WHERE EXISTS (SELECT 1 FROM groups g WHERE g.id = e.id) ON CONFLICT DO NOTHING')
+        ) AS l (table_name, column_name, statement)
+        JOIN information_schema.columns c
+            ON c.table_schema = current_schema() AND c.table_name = l.table_name AND c.column_name = l.column_name
+    LOOP
+        elements := CASE legacy.data_type
+            WHEN 'ARRAY' THEN format('unnest(t.%I::text[])', legacy.column_name)
+            WHEN 'jsonb' THEN format('jsonb_array_elements_text(CASE jsonb_typeof(t.%1$I) WHEN ''array'' THEN t.%1$I ELSE ''[]'' END)', legacy.column_name)
+            WHEN 'json' THEN format('jsonb_array_elements_text(CASE jsonb_typeof(t.%1$I::jsonb) WHEN ''array'' THEN t.%1$I::jsonb ELSE ''[]'' END)', legacy.column_name)
+        END;
+        IF elements IS NULL THEN
+            RAISE EXCEPTION 'cannot migrate legacy column %.% of type %', legacy.table_name, legacy.column_name, legacy.data_type;
+        END IF;
+        EXECUTE format(legacy.statement, elements);
+        EXECUTE format('ALTER TABLE %I DROP COLUMN %I', legacy.table_name, legacy.column_name);
+    END LOOP;
+END $$;
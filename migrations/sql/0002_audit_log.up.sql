@@ -0,0 +1,26 @@
+-- Append-only record of every directory mutation.
+
+CREATE TABLE audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor       TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id   TEXT NOT NULL,
+    operation   TEXT NOT NULL,
+    changes     JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_audit_log_entity ON audit_log (entity_type, entity_id, occurred_at DESC);
+CREATE INDEX idx_audit_log_actor ON audit_log (actor, occurred_at DESC);
+CREATE INDEX idx_audit_log_occurred_at ON audit_log (occurred_at DESC);
+
+-- Reject updates and deletes so the log cannot be rewritten through the application role.
+CREATE FUNCTION audit_log_reject_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_reject_change();
@@ -0,0 +1,8 @@
+-- Tombstoned rows are removed rather than silently resurrected.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DELETE FROM groups WHERE deleted_at IS NOT NULL;
+DELETE FROM roles WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE groups DROP COLUMN deleted_at;
+ALTER TABLE roles DROP COLUMN deleted_at;
@@ -0,0 +1,10 @@
+-- Tombstone column for users, groups and roles. Join table rows are kept while
+-- an entity is tombstoned so that restoring it brings its relationships back.
+
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE groups ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE roles ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE INDEX idx_groups_deleted_at ON groups (deleted_at);
+CREATE INDEX idx_roles_deleted_at ON roles (deleted_at);
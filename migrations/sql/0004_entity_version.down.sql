@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN version;
+ALTER TABLE groups DROP COLUMN version;
+ALTER TABLE roles DROP COLUMN version;
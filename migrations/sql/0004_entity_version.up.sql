@@ -0,0 +1,6 @@
+-- Row version for optimistic concurrency. Every update through the API
+-- increments it; clients send it back in If-Match to detect lost updates.
+
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE groups ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE roles ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
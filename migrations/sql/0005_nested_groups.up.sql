@@ -0,0 +1,10 @@
+-- Groups can contain other groups. The application rejects edges that would
+-- form a cycle; the CHECK only guards against the trivial self-loop.
+
+CREATE TABLE group_groups (
+    parent_group_id TEXT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    child_group_id  TEXT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    PRIMARY KEY (parent_group_id, child_group_id),
+    CHECK (parent_group_id <> child_group_id)
+);
+CREATE INDEX idx_group_groups_child_group_id ON group_groups (child_group_id);
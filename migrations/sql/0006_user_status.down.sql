@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP COLUMN status,
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN disabled_at,
+    DROP COLUMN status_changed_at;
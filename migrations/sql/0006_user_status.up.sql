@@ -0,0 +1,11 @@
+-- User lifecycle status and timestamps. Existing users start out active.
+
+ALTER TABLE users
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'suspended', 'deprovisioned')),
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN disabled_at TIMESTAMPTZ,
+    ADD COLUMN status_changed_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX idx_users_status ON users (status);
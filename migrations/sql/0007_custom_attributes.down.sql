@@ -0,0 +1,4 @@
+ALTER TABLE groups DROP COLUMN attributes;
+ALTER TABLE users DROP COLUMN attributes;
+
+DROP TABLE attribute_definitions;
@@ -0,0 +1,22 @@
+-- Admin-defined extension attributes for users and groups. Values live in a
+-- JSONB attributes column on each entity and are validated by the application.
+
+CREATE TABLE attribute_definitions (
+    entity_type  TEXT NOT NULL CHECK (entity_type IN ('user', 'group')),
+    name         TEXT NOT NULL,
+    type         TEXT NOT NULL CHECK (type IN ('string', 'int', 'bool', 'date', 'enum')),
+    multi_valued BOOLEAN NOT NULL DEFAULT false,
+    required     BOOLEAN NOT NULL DEFAULT false,
+    enum_values  JSONB NOT NULL DEFAULT '[]',
+    description  TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (entity_type, name)
+);
+
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE groups ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+-- Supports the containment queries behind attr.<name> list filters.
+CREATE INDEX idx_users_attributes ON users USING GIN (attributes jsonb_path_ops);
+CREATE INDEX idx_groups_attributes ON groups USING GIN (attributes jsonb_path_ops);
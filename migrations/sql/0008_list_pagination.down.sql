@@ -0,0 +1,22 @@
+DROP INDEX idx_roles_name_id;
+DROP INDEX idx_groups_name_id;
+DROP INDEX idx_users_updated_at_id;
+DROP INDEX idx_users_created_at_id;
+DROP INDEX idx_users_email_id;
+DROP INDEX idx_users_name_id;
+
+CREATE INDEX idx_users_name ON users (name);
+CREATE INDEX idx_groups_name ON groups (name);
+CREATE INDEX idx_roles_name ON roles (name);
+
+ALTER TABLE users
+    ALTER COLUMN name DROP NOT NULL,
+    ALTER COLUMN name DROP DEFAULT,
+    ALTER COLUMN email DROP NOT NULL,
+    ALTER COLUMN email DROP DEFAULT;
+ALTER TABLE groups
+    ALTER COLUMN name DROP NOT NULL,
+    ALTER COLUMN name DROP DEFAULT;
+ALTER TABLE roles
+    ALTER COLUMN name DROP NOT NULL,
+    ALTER COLUMN name DROP DEFAULT;
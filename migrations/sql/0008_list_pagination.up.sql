@@ -0,0 +1,30 @@
+-- Keyset pagination compares (sort column, id) row values, which needs the
+-- sort columns to be non-null and indexed together with id.
+
+UPDATE users SET name = '' WHERE name IS NULL;
+UPDATE users SET email = '' WHERE email IS NULL;
+UPDATE groups SET name = '' WHERE name IS NULL;
+UPDATE roles SET name = '' WHERE name IS NULL;
+
+ALTER TABLE users
+    ALTER COLUMN name SET DEFAULT '',
+    ALTER COLUMN name SET NOT NULL,
+    ALTER COLUMN email SET DEFAULT '',
+    ALTER COLUMN email SET NOT NULL;
+ALTER TABLE groups
+    ALTER COLUMN name SET DEFAULT '',
+    ALTER COLUMN name SET NOT NULL;
+ALTER TABLE roles
+    ALTER COLUMN name SET DEFAULT '',
+    ALTER COLUMN name SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_name;
+DROP INDEX IF EXISTS idx_groups_name;
+DROP INDEX IF EXISTS idx_roles_name;
+
+CREATE INDEX idx_users_name_id ON users (name, id);
+CREATE INDEX idx_users_email_id ON users (email, id);
+CREATE INDEX idx_users_created_at_id ON users (created_at, id);
+CREATE INDEX idx_users_updated_at_id ON users (updated_at, id);
+CREATE INDEX idx_groups_name_id ON groups (name, id);
+CREATE INDEX idx_roles_name_id ON roles (name, id);
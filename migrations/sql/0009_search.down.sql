@@ -0,0 +1,12 @@
+DROP INDEX idx_roles_search;
+DROP INDEX idx_groups_search;
+DROP INDEX idx_users_search;
+
+DROP INDEX idx_roles_description_trgm;
+DROP INDEX idx_roles_name_trgm;
+DROP INDEX idx_groups_description_trgm;
+DROP INDEX idx_groups_name_trgm;
+DROP INDEX idx_users_email_trgm;
+DROP INDEX idx_users_name_trgm;
+
+-- pg_trgm is left installed; other schemas may depend on it.
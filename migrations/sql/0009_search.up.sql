@@ -0,0 +1,17 @@
+-- Indexes behind GET /search. Trigram indexes serve substring (ILIKE) and
+-- typo-tolerant (<%) matches on names, emails and descriptions; the full-text
+-- indexes serve word matches. The indexed expressions must stay identical to
+-- the ones in handlers/search_handler.go.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX idx_groups_name_trgm ON groups USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_groups_description_trgm ON groups USING GIN ((COALESCE(description, '')) gin_trgm_ops);
+CREATE INDEX idx_roles_name_trgm ON roles USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_roles_description_trgm ON roles USING GIN ((COALESCE(description, '')) gin_trgm_ops);
+
+CREATE INDEX idx_users_search ON users USING GIN (to_tsvector('simple', name || ' ' || email));
+CREATE INDEX idx_groups_search ON groups USING GIN (to_tsvector('simple', name || ' ' || COALESCE(description, '')));
+CREATE INDEX idx_roles_search ON roles USING GIN (to_tsvector('simple', name || ' ' || COALESCE(description, '')));
@@ -0,0 +1,3 @@
+DROP SEQUENCE role_id_seq;
+DROP SEQUENCE group_id_seq;
+DROP SEQUENCE user_id_seq;
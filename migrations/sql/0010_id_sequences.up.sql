@@ -0,0 +1,15 @@
+-- Counters behind server-generated sequence IDs (USER_ID_FORMAT and friends).
+-- They start after the highest existing ID in the default formats (UI000001,
+-- GRP001, ROLE001); numbers whose ID is taken are skipped at runtime, so other
+-- prefixes work too.
+
+CREATE SEQUENCE user_id_seq;
+CREATE SEQUENCE group_id_seq;
+CREATE SEQUENCE role_id_seq;
+
+SELECT setval('user_id_seq', COALESCE(MAX(SUBSTRING(id FROM 3)::BIGINT), 0) + 1, false)
+FROM users WHERE id ~ '^UI[0-9]{1,18}$';
+SELECT setval('group_id_seq', COALESCE(MAX(SUBSTRING(id FROM 4)::BIGINT), 0) + 1, false)
+FROM groups WHERE id ~ '^GRP[0-9]{1,18}$';
+SELECT setval('role_id_seq', COALESCE(MAX(SUBSTRING(id FROM 5)::BIGINT), 0) + 1, false)
+FROM roles WHERE id ~ '^ROLE[0-9]{1,18}$';
@@ -0,0 +1,18 @@
+-- Responses to requests sent with an Idempotency-Key header, replayed on
+-- retries by the same caller until they expire. A retry may take over the key
+-- of an in-progress request that crashed once its lease, locked_until, ends.
+
+CREATE TABLE idempotency_keys (
+    actor        TEXT NOT NULL,
+    key          TEXT NOT NULL,
+    fingerprint  TEXT NOT NULL,
+    status_code  INTEGER NOT NULL DEFAULT 0,
+    header       JSONB NOT NULL DEFAULT '{}',
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (actor, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1 @@
+DELETE FROM roles WHERE id IN ('directory-admin', 'directory-reader', 'group-manager', 'role-manager');
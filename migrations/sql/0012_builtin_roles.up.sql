@@ -0,0 +1,9 @@
+-- Built-in roles that authorize the directory API itself (see auth.BuiltinRoles).
+-- Existing roles with these IDs are left untouched.
+
+INSERT INTO roles (id, name, description) VALUES
+    ('directory-admin', 'directory-admin', 'Full access to the directory API'),
+    ('directory-reader', 'directory-reader', 'Read users, groups, roles, attributes and the audit log'),
+    ('group-manager', 'group-manager', 'Manage groups, their members and nested groups'),
+    ('role-manager', 'role-manager', 'Manage roles and assign them to users and groups')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,23 @@
+-- Non-human callers of the API and their API keys. Only a SHA-256 hash of
+-- each key is stored; the key itself is shown once when it is created.
+
+CREATE TABLE service_accounts (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE api_keys (
+    id                 TEXT PRIMARY KEY,
+    service_account_id TEXT NOT NULL REFERENCES service_accounts (id) ON DELETE CASCADE,
+    name               TEXT NOT NULL DEFAULT '',
+    hash               TEXT NOT NULL,
+    scopes             JSONB NOT NULL DEFAULT '[]',
+    allowed_cidrs      JSONB NOT NULL DEFAULT '[]',
+    expires_at         TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_api_keys_service_account_id ON api_keys (service_account_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_credentials;
+DROP TABLE IF EXISTS oidc_authorization_codes;
+DROP TABLE IF EXISTS oidc_clients;
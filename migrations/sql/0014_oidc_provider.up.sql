@@ -0,0 +1,37 @@
+-- Built-in OpenID Connect provider: registered clients, single-use
+-- authorization codes and the passwords users log in with. Secrets, codes
+-- and passwords are stored only as hashes.
+
+CREATE TABLE oidc_clients (
+    id                        TEXT PRIMARY KEY,
+    name                      TEXT NOT NULL,
+    public                    BOOLEAN NOT NULL DEFAULT FALSE,
+    secret_hash               TEXT NOT NULL DEFAULT '',
+    redirect_uris             JSONB NOT NULL DEFAULT '[]',
+    post_logout_redirect_uris JSONB NOT NULL DEFAULT '[]',
+    scopes                    JSONB NOT NULL DEFAULT '[]',
+    created_at                TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at                TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oidc_authorization_codes (
+    hash           TEXT PRIMARY KEY,
+    client_id      TEXT NOT NULL REFERENCES oidc_clients (id) ON DELETE CASCADE,
+    user_id        TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri   TEXT NOT NULL,
+    scope          TEXT NOT NULL,
+    nonce          TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL,
+    session_id     TEXT NOT NULL DEFAULT '',
+    auth_time      TIMESTAMPTZ NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_oidc_authorization_codes_expires_at ON oidc_authorization_codes (expires_at);
+
+CREATE TABLE user_credentials (
+    user_id       TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// Attribute value types
+const (
+	AttributeString = "string"
+	AttributeInt    = "int"
+	AttributeBool   = "bool"
+	AttributeDate   = "date" // YYYY-MM-DD
+	AttributeEnum   = "enum"
+)
+
+// AttributeDefinition declares a typed extension attribute for users or groups
+type AttributeDefinition struct {
+	EntityType  string     `json:"entity_type" gorm:"primaryKey"` // user or group
+	Name        string     `json:"name" gorm:"primaryKey"`        // Key in the entity's attributes object
+	Type        string     `json:"type"`                          // string, int, bool, date or enum
+	MultiValued bool       `json:"multi_valued"`                  // Values are lists of the declared type
+	Required    bool       `json:"required"`                      // Entities must set a value
+	EnumValues  StringList `json:"enum_values"`                   // Allowed values of an enum attribute
+	Description string     `json:"description"`                   // Human-readable purpose
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Attributes holds the extension attribute values of a user or group, keyed by attribute name
+type Attributes map[string]interface{}
+
+// Value implements driver.Valuer for the JSONB attributes column
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	return jsonValue(a)
+}
+
+// Scan implements sql.Scanner for the JSONB attributes column
+func (a *Attributes) Scan(src interface{}) error {
+	return scanJSON(src, a)
+}
+
+// StringList is a list of strings stored in a JSONB column
+type StringList []string
+
+// Value implements driver.Valuer for a JSONB array column
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	return jsonValue(l)
+}
+
+// Scan implements sql.Scanner for a JSONB array column
+func (l *StringList) Scan(src interface{}) error {
+	return scanJSON(src, l)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// Audit entity types
+const (
+	EntityUser  = "user"
+	EntityGroup = "group"
+	EntityRole  = "role"
+
+	EntityAttributeDefinition = "attribute_definition"
+)
+
+// FieldChange records the value of a field before and after a mutation
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditChanges maps field names to their before/after values
+type AuditChanges map[string]FieldChange
+
+// Value implements driver.Valuer for the JSONB changes column
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	return jsonValue(c)
+}
+
+// Scan implements sql.Scanner for the JSONB changes column
+func (c *AuditChanges) Scan(src interface{}) error {
+	return scanJSON(src, c)
+}
+
+// AuditEntry is one append-only record of a directory mutation
+type AuditEntry struct {
+	ID         int64        `json:"id" gorm:"primaryKey"`
+	OccurredAt time.Time    `json:"occurred_at"` // When the mutation was committed
+	Actor      string       `json:"actor"`       // Who performed the mutation
+	EntityType string       `json:"entity_type"` // user, group, role, attribute_definition, service_account or oidc_client
+	EntityID   string       `json:"entity_id"`   // ID of the mutated entity
+	Operation  string       `json:"operation"`   // e.g. create, update, delete, assign_role
+	Changes    AuditChanges `json:"changes"`     // Fields that differ between the before and after state
+}
+
+// TableName stores audit entries in the audit_log table
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
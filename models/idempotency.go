@@ -0,0 +1,48 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// ResponseHeader holds the response headers replayed for an idempotent request
+type ResponseHeader map[string]string
+
+// Value implements driver.Valuer for the JSONB header column
+func (h ResponseHeader) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+	return jsonValue(h)
+}
+
+// Scan implements sql.Scanner for the JSONB header column
+func (h *ResponseHeader) Scan(src interface{}) error {
+	return scanJSON(src, h)
+}
+
+// IdempotencyRecord is the stored response to the first request sent with an
+// Idempotency-Key. Retries with the same key and caller receive the stored
+// response until the record expires. A record is owned by the request that
+// reserved it, identified by its CreatedAt.
+type IdempotencyRecord struct {
+	Actor       string         `gorm:"primaryKey"` // Caller that sent the key
+	Key         string         `gorm:"primaryKey"` // Value of the Idempotency-Key header
+	Fingerprint string         // Hash of the method, path and body of the first request
+	StatusCode  int            // Response status, 0 while the first request is in progress
+	LockedUntil time.Time      // End of the lease of an in-progress request; a retry may take over the key after it
+	Header      ResponseHeader // Response headers to replay
+	Body        []byte         // Response body
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// TableName stores idempotency records in the idempotency_keys table
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// Completed reports whether the response of the first request has been stored
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
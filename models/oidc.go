@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// EntityOIDCClient is the audit entity type of OpenID Connect clients
+const EntityOIDCClient = "oidc_client"
+
+// OIDCClient is an application registered to log users in through the
+// built-in OpenID Connect provider
+type OIDCClient struct {
+	ID                     string     `json:"id" gorm:"primaryKey" validate:"required,pattern=id"` // client_id, chosen by the client, e.g. intranet
+	Name                   string     `json:"name" validate:"required,max=256"`                    // Display name, shown on the login page
+	Public                 bool       `json:"public"`                                              // Has no secret, e.g. a single-page or mobile app
+	Secret                 string     `json:"secret,omitempty" gorm:"-"`                           // client_secret, set only when the client is created
+	SecretHash             string     `json:"-"`                                                   // SHA-256 of the secret, hex-encoded; empty for public clients
+	RedirectURIs           StringList `json:"redirect_uris" validate:"required,max=20"`            // Exact URIs authorization responses may be sent to
+	PostLogoutRedirectURIs StringList `json:"post_logout_redirect_uris" validate:"max=20"`         // Exact URIs the end-session endpoint may redirect to
+	Scopes                 StringList `json:"scopes" validate:"max=20"`                            // Scopes the client may request; empty allows every supported scope
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
+}
+
+// TableName stores clients in the oidc_clients table
+func (OIDCClient) TableName() string {
+	return "oidc_clients"
+}
+
+// AuthorizationCode is an issued, unredeemed OpenID Connect authorization
+// code. Only a hash of the code is stored; it can be redeemed once.
+type AuthorizationCode struct {
+	Hash          string    `gorm:"primaryKey"` // SHA-256 of the code, hex-encoded
+	ClientID      string    // Client the code was issued to
+	UserID        string    // User who logged in
+	RedirectURI   string    // redirect_uri of the authorization request, repeated at the token endpoint
+	Scope         string    // Granted scopes, space-separated
+	Nonce         string    // nonce of the authorization request, copied to the ID token
+	CodeChallenge string    // PKCE S256 code_challenge
+	SessionID     string    // sid of the login session
+	AuthTime      time.Time // When the user entered their password
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// TableName stores codes in the oidc_authorization_codes table
+func (AuthorizationCode) TableName() string {
+	return "oidc_authorization_codes"
+}
+
+// UserCredential is the password a user logs in with at the OpenID Connect
+// provider. It is kept apart from the user so that it never appears in user
+// responses, snapshots or the audit log.
+type UserCredential struct {
+	UserID       string    `gorm:"primaryKey"`
+	PasswordHash string    // PBKDF2 hash, see auth.HashPassword
+	UpdatedAt    time.Time // When the password was last set
+}
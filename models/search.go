@@ -0,0 +1,23 @@
+package models
+
+// SearchHighlight shows where a search matched one field of a hit
+type SearchHighlight struct {
+	Field   string `json:"field"`   // JSON field that matched, e.g. "email"
+	Snippet string `json:"snippet"` // HTML-escaped excerpt of the field with matches wrapped in <mark></mark>
+}
+
+// SearchHit is one entity found by a directory search
+type SearchHit struct {
+	Type       string            `json:"type"`   // user, group or role
+	ID         string            `json:"id"`     // Entity ID
+	Name       string            `json:"name"`   // Entity display name
+	Detail     string            `json:"detail"` // Email of a user, description of a group or role
+	Score      float64           `json:"score"`  // Relevance between 0 and 1; 1 is an exact match
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+// SearchResults is the ranked response of a directory search
+type SearchResults struct {
+	Query string      `json:"query"`
+	Hits  []SearchHit `json:"hits"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// EntityServiceAccount is the audit entity type of service accounts and their API keys
+const EntityServiceAccount = "service_account"
+
+// MaxActiveAPIKeys is how many unexpired API keys a service account may hold.
+// Two keys let a client rotate: create the new key, deploy it, delete the old one.
+const MaxActiveAPIKeys = 2
+
+// ServiceAccount is a non-human caller of the API, such as a CI pipeline or an
+// HR integration. It authenticates with API keys and holds the permissions of
+// the key it uses.
+type ServiceAccount struct {
+	ID          string    `json:"id" gorm:"primaryKey" validate:"required,pattern=id"` // Chosen by the client, e.g. hr-sync
+	Name        string    `json:"name" validate:"required,max=256"`                    // Display name
+	Description string    `json:"description" validate:"max=1024"`                     // Purpose of the account
+	Keys        []APIKey  `json:"keys" gorm:"-"`                                       // API keys, loaded from api_keys
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// APIKey is a credential of a service account. Only a hash of the key is
+// stored; the key itself is returned once, in the response that creates it.
+type APIKey struct {
+	ID               string     `json:"id" gorm:"primaryKey"`       // Public part of the key, used to look it up
+	ServiceAccountID string     `json:"service_account_id"`         // Account the key authenticates
+	Name             string     `json:"name" validate:"max=256"`    // Label, e.g. the pipeline using the key
+	Key              string     `json:"key,omitempty" gorm:"-"`     // The full key, set only when it is created
+	Hash             string     `json:"-"`                          // SHA-256 of the full key, hex-encoded
+	Scopes           StringList `json:"scopes" validate:"required"` // Permissions such as users:read, or resources such as users
+	AllowedCIDRs     StringList `json:"allowed_cidrs"`              // Networks the key may be used from; empty allows any
+	ExpiresAt        *time.Time `json:"expires_at"`                 // When the key stops working; nil never expires
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// TableName stores API keys in the api_keys table
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// Active reports whether the key has not expired at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
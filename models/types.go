@@ -1,26 +1,83 @@
 package models
 
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User lifecycle statuses
+const (
+	UserStatusActive        = "active"
+	UserStatusSuspended     = "suspended"
+	UserStatusDeprovisioned = "deprovisioned"
+)
+
 // User represents a user in the directory system
 type User struct {
-	ID       string `json:"id"`        		// Unique identifier (e.g., UI000000)
-	Email    string `json:"email"`     		// Email address (e.g., user@company.onmicrosoft.com)
-	Name     string `json:"name"`      		// Display name (e.g., "John Doe")
-	Roles    []Role `json:"roles"`     		// Assigned roles (users can have multiple roles)
-	GroupIDs []string `json:"group_ids"` 	// IDs of groups the user belongs to
+	ID       string   `json:"id"`                                      // Unique identifier in the configured user ID format (e.g., UI000000)
+	Email    string   `json:"email" validate:"required,email,max=254"` // Email address (e.g., user@company.onmicrosoft.com)
+	Name     string   `json:"name" validate:"required,max=256"`        // Display name (e.g., "John Doe")
+	Roles    []Role   `json:"roles" gorm:"-"`                          // Assigned roles, loaded from user_roles
+	GroupIDs []string `json:"group_ids" gorm:"-"`                      // IDs of groups the user belongs to, loaded from group_members
+	Version  int64    `json:"version"`                                 // Incremented on every update, used for If-Match
+
+	Attributes Attributes `json:"attributes"` // Custom attribute values, validated against the user attribute definitions
+
+	Status          string     `json:"status"`            // Lifecycle status: active, suspended or deprovisioned
+	CreatedAt       time.Time  `json:"created_at"`        // When the user was created
+	UpdatedAt       time.Time  `json:"updated_at"`        // When the user was last changed
+	DisabledAt      *time.Time `json:"disabled_at"`       // When the user stopped being active; nil while active
+	StatusChangedAt time.Time  `json:"status_changed_at"` // When the status last changed
+
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty"` // Set when the user is soft-deleted
 }
 
 // Group represents a group in the directory system
 type Group struct {
-	ID          string   `json:"id"`          // Unique group identifier
-	Name        string   `json:"name"`        // Group display name
-	Description string   `json:"description"` // Group description/purpose
-	Members     []string `json:"members"`     // List of member user IDs
+	ID           string   `json:"id"`                               // Unique group identifier in the configured group ID format (e.g., GRP001)
+	Name         string   `json:"name" validate:"required,max=256"` // Group display name
+	Description  string   `json:"description" validate:"max=1024"`  // Group description/purpose
+	Members      []string `json:"members" gorm:"-"`                 // Member user IDs, loaded from group_members
+	MemberGroups []string `json:"member_groups" gorm:"-"`           // IDs of groups nested directly in this group, loaded from group_groups
+	Version      int64    `json:"version"`                          // Incremented on every update, used for If-Match
+
+	Attributes Attributes `json:"attributes"` // Custom attribute values, validated against the group attribute definitions
+
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty"` // Set when the group is soft-deleted
 }
 
 // Role represents a role with associated permissions
 type Role struct {
-	ID          string   `json:"id"`          // Unique role identifier
-	Name        string   `json:"name"`        // Role display name
-	Description string   `json:"description"` // Role description
-	Groups      []string `json:"groups"`      // List of group IDs associated with this role
-}
\ No newline at end of file
+	ID          string   `json:"id"`                               // Unique role identifier in the configured role ID format (e.g., ROLE001)
+	Name        string   `json:"name" validate:"required,max=256"` // Role display name
+	Description string   `json:"description" validate:"max=1024"`  // Role description
+	Groups      []string `json:"groups" gorm:"-"`                  // Associated group IDs, loaded from role_groups
+	Version     int64    `json:"version"`                          // Incremented on every update, used for If-Match
+
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty"` // Set when the role is soft-deleted
+}
+
+// GroupMember is a row of the group_members join table
+type GroupMember struct {
+	GroupID string `gorm:"primaryKey"`
+	UserID  string `gorm:"primaryKey"`
+}
+
+// GroupGroup is a row of the group_groups join table, nesting the child group in the parent
+type GroupGroup struct {
+	ParentGroupID string `gorm:"primaryKey"`
+	ChildGroupID  string `gorm:"primaryKey"`
+}
+
+// RoleGroup is a row of the role_groups join table
+type RoleGroup struct {
+	RoleID  string `gorm:"primaryKey"`
+	GroupID string `gorm:"primaryKey"`
+}
+
+// UserRole is a row of the user_roles join table
+type UserRole struct {
+	UserID string `gorm:"primaryKey"`
+	RoleID string `gorm:"primaryKey"`
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validation rules are declared in validate struct tags as a comma-separated
+// list, e.g. `validate:"required,max=256"`:
+//
+//	required       the string must not be empty or blank
+//	min=N, max=N   the length of a string in characters, or of a list
+//	email          the string must be a plain email address (local@domain)
+//	pattern=NAME   the string must match the named ID pattern
+//
+// Rules other than required are skipped for empty values.
+
+// idPattern is a named pattern that entity IDs must match
+type idPattern struct {
+	regexp      *regexp.Regexp
+	description string // Completes "must be ..." in error messages
+}
+
+// idPatterns are the patterns available to the pattern rule
+var idPatterns = map[string]idPattern{
+	"id": {regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`), "up to 64 letters, digits, hyphens and underscores, starting with a letter or digit"},
+}
+
+// FieldError is a validation rule that a field does not satisfy
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name of the field
+	Rule    string `json:"rule"`    // Name of the failed rule, e.g. required
+	Message string `json:"message"` // Human-readable description
+}
+
+// Validate checks the validate tags of a struct or pointer to struct and
+// returns the fields that do not satisfy their rules, in field order
+func Validate(v interface{}) []FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs []FieldError
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" || !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		for _, rule := range strings.Split(rules, ",") {
+			if err := checkRule(rule, value.Field(i)); err != "" {
+				ruleName, _, _ := strings.Cut(rule, "=")
+				errs = append(errs, FieldError{Field: name, Rule: ruleName, Message: name + " " + err})
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// checkRule returns why the value does not satisfy the rule, or "" if it does
+func checkRule(rule string, value reflect.Value) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	length := valueLength(value)
+	if name == "required" {
+		if value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" || length == 0 {
+			return "is required"
+		}
+		return ""
+	}
+	if length == 0 {
+		return ""
+	}
+	switch name {
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("models: invalid %s rule %q", name, rule))
+		}
+		unit := "characters"
+		if value.Kind() != reflect.String {
+			unit = "items"
+		}
+		if name == "min" && length < limit {
+			return fmt.Sprintf("must have at least %d %s", limit, unit)
+		}
+		if name == "max" && length > limit {
+			return fmt.Sprintf("must have at most %d %s", limit, unit)
+		}
+	case "email":
+		if !validEmail(value.String()) {
+			return "must be a valid email address"
+		}
+	case "pattern":
+		pattern, ok := idPatterns[arg]
+		if !ok {
+			panic(fmt.Sprintf("models: unknown pattern %q", arg))
+		}
+		if !pattern.regexp.MatchString(value.String()) {
+			return "must be " + pattern.description
+		}
+	default:
+		panic(fmt.Sprintf("models: unknown validation rule %q", rule))
+	}
+	return ""
+}
+
+// valueLength is the length of a string in characters or of a list or map, and -1 for other kinds
+func valueLength(value reflect.Value) int {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String())
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len()
+	default:
+		return -1
+	}
+}
+
+// validEmail accepts a bare address with a dotted domain, rejecting display
+// names, comments and other RFC 5322 forms that mail.ParseAddress allows
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return false
+	}
+	local, domain, ok := strings.Cut(email, "@")
+	return ok && local != "" && strings.Contains(domain, ".") &&
+		!strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".") && !strings.ContainsAny(domain, "[]")
+}
+
+// jsonName is the name of a struct field in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ruleTest exercises each rule on its own field
+type ruleTest struct {
+	Required string   `json:"required" validate:"required"`
+	Short    string   `json:"short" validate:"min=2,max=4"`
+	List     []string `json:"list" validate:"max=2"`
+	Email    string   `json:"email" validate:"email"`
+	ID       string   `json:"id" validate:"pattern=id"`
+	NoJSON   string   `validate:"max=1"`
+	Skipped  string   `json:"-"`
+}
+
+// fieldRules returns the field and rule names of validation errors
+func fieldRules(errs []FieldError) []string {
+	var out []string
+	for _, e := range errs {
+		out = append(out, e.Field+":"+e.Rule)
+	}
+	return out
+}
+
+func TestValidateRules(t *testing.T) {
+	valid := ruleTest{Required: "x"}
+	tests := []struct {
+		name   string
+		modify func(*ruleTest)
+		want   []string
+	}{
+		{"valid", func(v *ruleTest) {}, nil},
+		{"missing required", func(v *ruleTest) { v.Required = "" }, []string{"required:required"}},
+		{"blank required", func(v *ruleTest) { v.Required = " \t" }, []string{"required:required"}},
+		{"too short", func(v *ruleTest) { v.Short = "a" }, []string{"short:min"}},
+		{"too long", func(v *ruleTest) { v.Short = "abcde" }, []string{"short:max"}},
+		// Lengths count characters, not bytes
+		{"multibyte within limit", func(v *ruleTest) { v.Short = "éééé" }, nil},
+		{"multibyte over limit", func(v *ruleTest) { v.Short = "ééééé" }, []string{"short:max"}},
+		{"list within limit", func(v *ruleTest) { v.List = []string{"a", "b"} }, nil},
+		{"list over limit", func(v *ruleTest) { v.List = []string{"a", "b", "c"} }, []string{"list:max"}},
+		{"invalid email", func(v *ruleTest) { v.Email = "nope" }, []string{"email:email"}},
+		{"invalid id", func(v *ruleTest) { v.ID = "-leading-hyphen" }, []string{"id:pattern"}},
+		{"id too long", func(v *ruleTest) { v.ID = strings.Repeat("a", 65) }, []string{"id:pattern"}},
+		{"field without json name", func(v *ruleTest) { v.NoJSON = "ab" }, []string{"NoJSON:max"}},
+		// Every failing field is reported, in field order
+		{
+			"several fields",
+			func(v *ruleTest) { v.Required, v.Email, v.Short = "", "a@b", "a" },
+			[]string{"required:required", "short:min", "email:email"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := valid
+			tt.modify(&v)
+			if got := fieldRules(Validate(&v)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Only the first failing rule of a field is reported
+	errs := Validate(struct {
+		Name string `json:"name" validate:"min=3,max=1"`
+	}{Name: "ab"})
+	if len(errs) != 1 || errs[0].Rule != "min" || errs[0].Message != "name must have at least 3 characters" {
+		t.Errorf("Validate() = %+v", errs)
+	}
+	if errs := Validate("not a struct"); errs != nil {
+		t.Errorf("Validate(string) = %+v", errs)
+	}
+}
+
+func TestValidateEntities(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("x", n) }
+	tests := []struct {
+		name   string
+		entity interface{}
+		want   []string
+	}{
+		{"valid user", &User{ID: "UI000001", Name: "Ada", Email: "ada@example.com"}, nil},
+		{"user without fields", &User{}, []string{"email:required", "name:required"}},
+		{"user name at limit", &User{ID: "UI000001", Name: long(256), Email: "ada@example.com"}, nil},
+		{"user name over limit", &User{ID: "UI000001", Name: long(257), Email: "ada@example.com"}, []string{"name:max"}},
+		{"user email over limit", &User{ID: "UI000001", Name: "Ada", Email: long(245) + "@example.com"}, []string{"email:max"}},
+		{"valid group", &Group{ID: "GRP001", Name: "Engineering"}, nil},
+		{"group description over limit", &Group{ID: "GRP001", Name: "Engineering", Description: long(1025)}, []string{"description:max"}},
+		{"group blank name", &Group{ID: "GRP001", Name: "  "}, []string{"name:required"}},
+		{"valid role", &Role{ID: "ROLE001", Name: "developer", Description: long(1024)}, nil},
+		{"role without name", &Role{ID: "ROLE001"}, []string{"name:required"}},
+		{"role name over limit", &Role{ID: "ROLE001", Name: long(257)}, []string{"name:max"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldRules(Validate(tt.entity)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"ada@example.com", true},
+		{"ada.lovelace+tag@mail.example.co.uk", true},
+		{"ada", false},
+		{"@example.com", false},
+		{"ada@", false},
+		{"ada@localhost", false},
+		{"ada@.example.com", false},
+		{"ada@example.com.", false},
+		{"ada@[127.0.0.1]", false},
+		{"Ada <ada@example.com>", false},
+		{"ada@example.com (Ada)", false},
+		{" ada@example.com", false},
+		{"ada@@example.com", false},
+	}
+	for _, tt := range tests {
+		if got := validEmail(tt.email); got != tt.want {
+			t.Errorf("validEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package oidc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Claim sources. A rule may also take a custom user attribute, attr.<name>.
+const (
+	SourceID     = "id"     // User ID
+	SourceEmail  = "email"  // Email address
+	SourceName   = "name"   // Display name
+	SourceStatus = "status" // Lifecycle status
+	SourceGroups = "groups" // IDs of the groups of the user, including nested groups
+	SourceRoles  = "roles"  // Names of the effective roles of the user
+
+	attributePrefix = "attr."
+)
+
+// ScopeOpenID is the scope every authentication request must include
+const ScopeOpenID = "openid"
+
+// reservedClaims are set by the provider and cannot be mapped
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "nonce", "auth_time", "azp",
+	"at_hash", "sid", "scope", "client_id", "typ",
+}
+
+// ClaimRule puts the value of a user source into a claim of the ID token and
+// the userinfo response
+type ClaimRule struct {
+	Claim  string // Name of the claim
+	Source string // id, email, name, status, groups, roles or attr.<name>
+	Scope  string // Scope the client must be granted; empty for every client
+}
+
+// ClaimMapping lists the claims the provider issues besides sub
+type ClaimMapping []ClaimRule
+
+// DefaultClaimMapping issues the standard profile and email claims and the
+// groups and roles of the user behind their own scopes
+var DefaultClaimMapping = ClaimMapping{
+	{Claim: "name", Source: SourceName, Scope: "profile"},
+	{Claim: "preferred_username", Source: SourceEmail, Scope: "profile"},
+	{Claim: "email", Source: SourceEmail, Scope: "email"},
+	{Claim: "groups", Source: SourceGroups, Scope: "groups"},
+	{Claim: "roles", Source: SourceRoles, Scope: "roles"},
+}
+
+// ParseClaimMapping parses a comma-separated list of claim=source rules, each
+// optionally followed by @scope, e.g.
+//
+//	name=name@profile,email=email@email,groups=groups,department=attr.department@profile
+func ParseClaimMapping(spec string) (ClaimMapping, error) {
+	var mapping ClaimMapping
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		claim, rest, ok := strings.Cut(item, "=")
+		source, scope, _ := strings.Cut(rest, "@")
+		rule := ClaimRule{Claim: strings.TrimSpace(claim), Source: strings.TrimSpace(source), Scope: strings.TrimSpace(scope)}
+		if !ok || rule.Claim == "" {
+			return nil, fmt.Errorf("invalid claim rule %q: expected claim=source[@scope]", item)
+		}
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		for _, existing := range mapping {
+			if existing.Claim == rule.Claim {
+				return nil, fmt.Errorf("claim %q is mapped twice", rule.Claim)
+			}
+		}
+		mapping = append(mapping, rule)
+	}
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("no claim rules given")
+	}
+	return mapping, nil
+}
+
+// validate checks the claim, source and scope of a rule
+func (r ClaimRule) validate() error {
+	if slices.Contains(reservedClaims, r.Claim) {
+		return fmt.Errorf("claim %q is set by the provider and cannot be mapped", r.Claim)
+	}
+	switch r.Source {
+	case SourceID, SourceEmail, SourceName, SourceStatus, SourceGroups, SourceRoles:
+	default:
+		if name, ok := strings.CutPrefix(r.Source, attributePrefix); !ok || name == "" {
+			return fmt.Errorf("claim %q has an unknown source %q", r.Claim, r.Source)
+		}
+	}
+	if r.Scope == ScopeOpenID || strings.ContainsAny(r.Scope, " \t\"\\") {
+		return fmt.Errorf("claim %q has an invalid scope %q", r.Claim, r.Scope)
+	}
+	return nil
+}
+
+// Attribute returns the custom attribute name of an attr.<name> source
+func Attribute(source string) (string, bool) {
+	return strings.CutPrefix(source, attributePrefix)
+}
+
+// Scopes returns openid and the scopes of the rules, in rule order
+func (m ClaimMapping) Scopes() []string {
+	scopes := []string{ScopeOpenID}
+	for _, rule := range m {
+		if rule.Scope != "" && !slices.Contains(scopes, rule.Scope) {
+			scopes = append(scopes, rule.Scope)
+		}
+	}
+	return scopes
+}
+
+// Claims returns the names of the mapped claims
+func (m ClaimMapping) Claims() []string {
+	claims := make([]string, len(m))
+	for i, rule := range m {
+		claims[i] = rule.Claim
+	}
+	return claims
+}
+
+// Resolve returns the claims granted by scopes. value returns the value of a
+// source, or nil to omit the claim; it is called once per rule that applies.
+func (m ClaimMapping) Resolve(scopes []string, value func(source string) (interface{}, error)) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	for _, rule := range m {
+		if rule.Scope != "" && !slices.Contains(scopes, rule.Scope) {
+			continue
+		}
+		v, err := value(rule.Source)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			claims[rule.Claim] = v
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,261 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+const (
+	// minRSABits is the smallest RSA signing key accepted
+	minRSABits = 2048
+	// maxTokenLength bounds the tokens that are parsed
+	maxTokenLength = 8192
+)
+
+// JWK is the public part of a signing key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served by the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// signingKey is a private key usable with one signing algorithm
+type signingKey struct {
+	jwk JWK
+	key crypto.Signer
+}
+
+// KeySet holds the keys tokens are signed with. The first key signs new
+// tokens; every key is published so that tokens signed before a rotation
+// stay valid until they expire.
+type KeySet struct {
+	keys []signingKey
+}
+
+// ParseKeySet reads RSA (2048 bits or more) and EC P-256 private keys from
+// PEM blocks in PKCS #8, PKCS #1 or SEC 1 form. To rotate, put the new key
+// first and keep the old one until the tokens it signed have expired.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	set := &KeySet{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d: %w", len(set.keys)+1, err)
+		}
+		set.keys = append(set.keys, *key)
+	}
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	return set, nil
+}
+
+// parsePrivateKey decodes a PEM private key and derives its public JWK
+func parsePrivateKey(block *pem.Block) (*signingKey, error) {
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		jwk := JWK{Kty: "RSA", Use: "sig", Alg: "RS256", N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E)))}
+		jwk.Kid = thumbprint(fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N))
+		return &signingKey{jwk: jwk, key: key}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("EC keys must use the P-256 curve")
+		}
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		pub := ecdhKey.PublicKey().Bytes() // Uncompressed point: 0x04 || x || y
+		jwk := JWK{Kty: "EC", Use: "sig", Alg: "ES256", Crv: "P-256",
+			X: base64.RawURLEncoding.EncodeToString(pub[1:33]), Y: base64.RawURLEncoding.EncodeToString(pub[33:])}
+		jwk.Kid = thumbprint(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`, jwk.X, jwk.Y))
+		return &signingKey{jwk: jwk, key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T: expected RSA or EC P-256", parsed)
+	}
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the canonical JWK members,
+// used as the key ID
+func thumbprint(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// encodeBigInt encodes an unsigned integer as base64url big-endian bytes
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// JWKS returns the public keys of the set
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, len(s.keys))}
+	for i, key := range s.keys {
+		set.Keys[i] = key.jwk
+	}
+	return set
+}
+
+// Algorithms returns the signing algorithms of the keys, for discovery
+func (s *KeySet) Algorithms() []string {
+	var algs []string
+	for _, key := range s.keys {
+		if !slices.Contains(algs, key.jwk.Alg) {
+			algs = append(algs, key.jwk.Alg)
+		}
+	}
+	return algs
+}
+
+// Sign returns a compact JWS of claims signed with the first key. typ is the
+// media type of the token, which tells the token kinds of the provider apart.
+func (s *KeySet) Sign(typ string, claims map[string]interface{}) (string, error) {
+	key := s.keys[0]
+	header, err := json.Marshal(map[string]string{"alg": key.jwk.Alg, "kid": key.jwk.Kid, "typ": typ})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		// JWS encodes ES256 signatures as the 32-byte r and s values
+		var r, sig *big.Int
+		r, sig, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			sig.FillBytes(signature[32:])
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks that token was signed by a key of the set with the given typ
+// and returns its claims. The claims themselves are not checked.
+func (s *KeySet) Verify(token, typ string) (map[string]interface{}, error) {
+	if len(token) > maxTokenLength {
+		return nil, invalid("token is longer than %d bytes", maxTokenLength)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("token is not a signed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed header")
+	}
+	if header.Typ != typ {
+		return nil, invalid("unexpected token type")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, key := range s.keys {
+		if key.jwk.Kid == header.Kid && key.jwk.Alg == header.Alg {
+			verified = verifySignature(key.key.Public(), digest[:], signature)
+			break
+		}
+	}
+	if !verified {
+		return nil, invalid("signature verification failed")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("malformed claims")
+	}
+	return claims, nil
+}
+
+// verifySignature checks an RS256 or ES256 signature of digest
+func verifySignature(key crypto.PublicKey, digest, signature []byte) bool {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, dest interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
@@ -0,0 +1,368 @@
+// Package oidc implements the built-in OpenID Connect provider, which lets
+// applications log users in against the directory and receive their groups
+// and roles as claims.
+//
+// The provider supports the authorization code flow with PKCE (S256 only):
+//
+//	GET  /.well-known/openid-configuration  discovery document
+//	GET  /oidc/jwks                          public signing keys
+//	GET  /oidc/authorize                     login page
+//	POST /oidc/token                         code exchange
+//	GET  /oidc/userinfo                      claims of the access token's user
+//	GET  /oidc/logout                        end of the login session
+//
+// This package holds the protocol logic: signing keys, tokens, PKCE and the
+// mapping of users to claims. The HTTP endpoints are in the api package.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Endpoint paths, relative to the issuer
+const (
+	DiscoveryPath  = "/.well-known/openid-configuration"
+	JWKSPath       = "/oidc/jwks"
+	AuthorizePath  = "/oidc/authorize"
+	TokenPath      = "/oidc/token"
+	UserInfoPath   = "/oidc/userinfo"
+	EndSessionPath = "/oidc/logout"
+)
+
+// Token media types, set as the typ header so that one kind of token cannot
+// be used as another
+const (
+	typeIDToken     = "JWT"
+	typeAccessToken = "at+jwt" // RFC 9068
+	typeSession     = "lde-session+jwt"
+)
+
+// Default lifetimes
+const (
+	DefaultCodeTTL    = time.Minute
+	DefaultTokenTTL   = 15 * time.Minute
+	DefaultSessionTTL = 8 * time.Hour
+)
+
+// ErrInvalidToken is returned for tokens the provider did not issue, or that
+// have expired
+var ErrInvalidToken = errors.New("invalid token")
+
+// invalid returns an error matching ErrInvalidToken
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+// Config configures a Provider
+type Config struct {
+	Issuer     string        // Public base URL of the server, e.g. https://directory.example.com
+	Keys       *KeySet       // Keys tokens are signed with
+	Claims     ClaimMapping  // Claims issued besides sub; nil uses DefaultClaimMapping
+	CodeTTL    time.Duration // Lifetime of authorization codes
+	TokenTTL   time.Duration // Lifetime of ID and access tokens
+	SessionTTL time.Duration // How long a login lasts before the password is asked again
+}
+
+// Provider issues and verifies the tokens of the OpenID Connect provider
+type Provider struct {
+	cfg Config
+	now func() time.Time
+}
+
+// NewProvider checks the configuration and fills in the defaults
+func NewProvider(cfg Config) (*Provider, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return nil, fmt.Errorf("the OIDC issuer must be an absolute URL without query or fragment")
+	}
+	if issuer.Scheme != "https" && !(issuer.Scheme == "http" && isLoopback(issuer.Hostname())) {
+		return nil, fmt.Errorf("the OIDC issuer must use https, except on localhost")
+	}
+	if cfg.Keys == nil {
+		return nil, fmt.Errorf("no OIDC signing key configured")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Claims == nil {
+		cfg.Claims = DefaultClaimMapping
+	}
+	if cfg.CodeTTL <= 0 {
+		cfg.CodeTTL = DefaultCodeTTL
+	}
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = DefaultTokenTTL
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = DefaultSessionTTL
+	}
+	return &Provider{cfg: cfg, now: time.Now}, nil
+}
+
+// isLoopback reports whether host names the local machine
+func isLoopback(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// Issuer returns the issuer identifier, the iss claim of every token
+func (p *Provider) Issuer() string { return p.cfg.Issuer }
+
+// Keys returns the signing keys
+func (p *Provider) Keys() *KeySet { return p.cfg.Keys }
+
+// Claims returns the claim mapping
+func (p *Provider) Claims() ClaimMapping { return p.cfg.Claims }
+
+// CodeTTL returns the lifetime of authorization codes
+func (p *Provider) CodeTTL() time.Duration { return p.cfg.CodeTTL }
+
+// SessionTTL returns how long a login session lasts
+func (p *Provider) SessionTTL() time.Duration { return p.cfg.SessionTTL }
+
+// SecureCookies reports whether cookies must only be sent over HTTPS
+func (p *Provider) SecureCookies() bool { return strings.HasPrefix(p.cfg.Issuer, "https://") }
+
+// Discovery returns the OpenID Provider Metadata document
+func (p *Provider) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                p.cfg.Issuer,
+		"authorization_endpoint":                p.cfg.Issuer + AuthorizePath,
+		"token_endpoint":                        p.cfg.Issuer + TokenPath,
+		"userinfo_endpoint":                     p.cfg.Issuer + UserInfoPath,
+		"jwks_uri":                              p.cfg.Issuer + JWKSPath,
+		"end_session_endpoint":                  p.cfg.Issuer + EndSessionPath,
+		"scopes_supported":                      p.cfg.Claims.Scopes(),
+		"claims_supported":                      append([]string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid"}, p.cfg.Claims.Claims()...),
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": p.cfg.Keys.Algorithms(),
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"prompt_values_supported":               []string{"none", "login"},
+	}
+}
+
+// Grant is what a user authorized a client to receive
+type Grant struct {
+	Subject   string // User ID
+	ClientID  string
+	Scopes    []string  // Granted scopes, including openid
+	Nonce     string    // nonce of the authorization request
+	SessionID string    // sid of the login session
+	AuthTime  time.Time // When the user entered their password
+}
+
+// AccessToken returns a signed access token for the userinfo endpoint and
+// its lifetime in seconds
+func (p *Provider) AccessToken(g Grant) (string, int, error) {
+	now := p.now()
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", 0, err
+	}
+	token, err := p.cfg.Keys.Sign(typeAccessToken, map[string]interface{}{
+		"iss":       p.cfg.Issuer,
+		"sub":       g.Subject,
+		"aud":       p.cfg.Issuer + UserInfoPath,
+		"client_id": g.ClientID,
+		"scope":     strings.Join(g.Scopes, " "),
+		"iat":       now.Unix(),
+		"exp":       now.Add(p.cfg.TokenTTL).Unix(),
+		"jti":       jti,
+	})
+	return token, int(p.cfg.TokenTTL.Seconds()), err
+}
+
+// IDToken returns a signed ID token carrying the user claims. accessToken is
+// bound to the token by its at_hash claim.
+func (p *Provider) IDToken(g Grant, userClaims map[string]interface{}, accessToken string) (string, error) {
+	now := p.now()
+	claims := map[string]interface{}{}
+	for name, value := range userClaims {
+		claims[name] = value
+	}
+	claims["iss"] = p.cfg.Issuer
+	claims["sub"] = g.Subject
+	claims["aud"] = g.ClientID
+	claims["azp"] = g.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(p.cfg.TokenTTL).Unix()
+	claims["auth_time"] = g.AuthTime.Unix()
+	if g.Nonce != "" {
+		claims["nonce"] = g.Nonce
+	}
+	if g.SessionID != "" {
+		claims["sid"] = g.SessionID
+	}
+	if accessToken != "" {
+		// Left half of the SHA-256 of the access token (OpenID Connect Core 3.1.3.6)
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["at_hash"] = base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	}
+	return p.cfg.Keys.Sign(typeIDToken, claims)
+}
+
+// VerifyAccessToken checks an access token issued by AccessToken and returns
+// its grant. Nonce, session and auth time are not carried by access tokens.
+func (p *Provider) VerifyAccessToken(token string) (*Grant, error) {
+	claims, err := p.cfg.Keys.Verify(token, typeAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkClaims(claims, p.cfg.Issuer+UserInfoPath, true); err != nil {
+		return nil, err
+	}
+	clientID, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	return &Grant{Subject: claims["sub"].(string), ClientID: clientID, Scopes: strings.Fields(scope)}, nil
+}
+
+// VerifyIDTokenHint checks the signature of an ID token passed back to the
+// end-session endpoint and returns its subject and client. The hint may have
+// expired.
+func (p *Provider) VerifyIDTokenHint(token string) (subject, clientID string, err error) {
+	claims, err := p.cfg.Keys.Verify(token, typeIDToken)
+	if err != nil {
+		return "", "", err
+	}
+	if err := p.checkClaims(claims, "", false); err != nil {
+		return "", "", err
+	}
+	clientID, _ = claims["aud"].(string)
+	return claims["sub"].(string), clientID, nil
+}
+
+// Session is a login at the provider, kept in a cookie so that users are not
+// asked for their password by every client
+type Session struct {
+	Subject  string    // User ID
+	ID       string    // sid claim of the ID tokens issued in the session
+	AuthTime time.Time // When the user entered their password
+	Expires  time.Time
+}
+
+// NewSession starts a login session for a user who just entered their password
+func (p *Provider) NewSession(subject string) (*Session, error) {
+	id, err := RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	now := p.now()
+	return &Session{Subject: subject, ID: id, AuthTime: now, Expires: now.Add(p.cfg.SessionTTL)}, nil
+}
+
+// SessionToken returns the signed cookie value of a session
+func (p *Provider) SessionToken(s *Session) (string, error) {
+	return p.cfg.Keys.Sign(typeSession, map[string]interface{}{
+		"iss":       p.cfg.Issuer,
+		"sub":       s.Subject,
+		"aud":       p.cfg.Issuer,
+		"sid":       s.ID,
+		"auth_time": s.AuthTime.Unix(),
+		"exp":       s.Expires.Unix(),
+	})
+}
+
+// VerifySession checks a session cookie value and returns the session
+func (p *Provider) VerifySession(token string) (*Session, error) {
+	claims, err := p.cfg.Keys.Verify(token, typeSession)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkClaims(claims, p.cfg.Issuer, true); err != nil {
+		return nil, err
+	}
+	sid, _ := claims["sid"].(string)
+	authTime, _ := claims["auth_time"].(float64)
+	exp := claims["exp"].(float64)
+	return &Session{
+		Subject:  claims["sub"].(string),
+		ID:       sid,
+		AuthTime: time.Unix(int64(authTime), 0),
+		Expires:  time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// checkClaims checks the issuer, subject, audience (unless empty) and, if
+// checkExpiry is set, the expiry of a token
+func (p *Provider) checkClaims(claims map[string]interface{}, audience string, checkExpiry bool) error {
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return invalid("unexpected issuer")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return invalid("token has no subject")
+	}
+	if aud, _ := claims["aud"].(string); audience != "" && aud != audience {
+		return invalid("token is not intended for this audience")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return invalid("token has no expiry")
+	}
+	if checkExpiry && !p.now().Before(time.Unix(int64(exp), 0)) {
+		return invalid("token has expired")
+	}
+	return nil
+}
+
+// RandomToken returns n random bytes, base64url-encoded
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashSecret returns the hex-encoded SHA-256 of a client secret or
+// authorization code. Both carry 256 random bits, so a fast hash is enough.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewClientSecret generates a client secret and the hash to store
+func NewClientSecret() (secret, hash string, err error) {
+	secret, err = RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, HashSecret(secret), nil
+}
+
+// VerifySecret reports whether secret matches a hash from HashSecret
+func VerifySecret(hash, secret string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) == 1
+}
+
+// ValidCodeChallenge reports whether challenge is a base64url SHA-256 digest,
+// the only PKCE code_challenge accepted (RFC 7636)
+func ValidCodeChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// VerifyCodeVerifier reports whether verifier is a valid PKCE code_verifier
+// whose S256 transform is challenge
+func VerifyCodeVerifier(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
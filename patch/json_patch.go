@@ -0,0 +1,355 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxOperations bounds the length of a JSON Patch document
+const maxOperations = 1000
+
+// Operation is one operation of a JSON Patch document
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	path, from []string    // Decoded JSON Pointers
+	value      interface{} // Decoded value of add, replace and test
+}
+
+// JSONPatch is a JSON Patch (RFC 6902): a list of add, remove, replace, move,
+// copy and test operations applied in order
+type JSONPatch []Operation
+
+// ParseJSONPatch parses and validates a JSON Patch document
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var ops JSONPatch
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, invalid("malformed JSON patch: expected an array of operations: %v", err)
+	}
+	if len(ops) > maxOperations {
+		return nil, invalid("JSON patch has more than %d operations", maxOperations)
+	}
+	for i := range ops {
+		if err := ops[i].parse(); err != nil {
+			return nil, invalid("operation %d: %v", i, err)
+		}
+	}
+	return ops, nil
+}
+
+// parse checks that an operation has the members its op requires
+func (o *Operation) parse() error {
+	var err error
+	if o.path, err = parsePointer(o.Path); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return fmt.Errorf("%s requires a value", o.Op)
+		}
+		if o.value, err = decode(o.Value); err != nil {
+			return fmt.Errorf("malformed value: %w", err)
+		}
+	case "remove":
+	case "move", "copy":
+		if o.from, err = parsePointer(o.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		if o.Op == "move" && len(o.from) < len(o.path) && isPrefix(o.from, o.path) {
+			return fmt.Errorf("cannot move %q into itself", o.From)
+		}
+	case "":
+		return fmt.Errorf("op is required")
+	default:
+		return fmt.Errorf("unknown op %q", o.Op)
+	}
+	return nil
+}
+
+// Apply returns doc with every operation applied, or an error and no result
+// if any operation fails
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	for i, op := range p {
+		if root, err = op.apply(root); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// apply runs one operation against root and returns the new root
+func (o Operation) apply(root interface{}) (interface{}, error) {
+	switch o.Op {
+	case "add":
+		return add(root, o.path, deepCopy(o.value))
+	case "remove":
+		root, _, err := remove(root, o.path)
+		return root, err
+	case "replace":
+		if _, err := get(root, o.path); err != nil {
+			return nil, err
+		}
+		if len(o.path) == 0 {
+			return deepCopy(o.value), nil
+		}
+		root, _, err := remove(root, o.path)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, o.path, deepCopy(o.value))
+	case "move":
+		root, value, err := remove(root, o.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, o.path, value)
+	case "copy":
+		value, err := get(root, o.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, o.path, deepCopy(value))
+	default: // test
+		value, err := get(root, o.path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, o.value) {
+			return nil, fmt.Errorf("%w: value is %s", ErrTestFailed, mustMarshal(value))
+		}
+		return root, nil
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer %q must be empty or start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(token, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("JSON pointer %q has an invalid escape", pointer)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// isPrefix reports whether prefix is a leading part of tokens
+func isPrefix(prefix, tokens []string) bool {
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses an array index token. With allowEnd, "-" and the array
+// length are accepted as the position after the last element.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, conflict("invalid array index %q", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index > length || (index == length && !allowEnd) {
+		return 0, conflict("array index %s is out of range", token)
+	}
+	return index, nil
+}
+
+// get returns the value at the path
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, conflict("member %q does not exist", token)
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, conflict("cannot reference %q in a scalar value", token)
+		}
+	}
+	return node, nil
+}
+
+// add inserts value at the path and returns the new node. The parent of the
+// target must exist; object members are replaced and array elements shifted.
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, conflict("member %q does not exist", token)
+		}
+		updated, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n), len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		updated, err := add(n[index], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = updated
+		return n, nil
+	default:
+		return nil, conflict("cannot reference %q in a scalar value", token)
+	}
+}
+
+// remove deletes the value at the path and returns the new node and the removed value
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, conflict("cannot remove the whole document")
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, conflict("member %q does not exist", token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		updated, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+		return n, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[index]
+			return append(n[:index], n[index+1:]...), removed, nil
+		}
+		updated, removed, err := remove(n[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[index] = updated
+		return n, removed, nil
+	default:
+		return nil, nil, conflict("cannot reference %q in a scalar value", token)
+	}
+}
+
+// deepCopy copies a decoded JSON value so that later operations cannot alias it
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			copied[name] = deepCopy(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// equal compares two decoded JSON values as RFC 6902 test does: numbers by
+// value, objects regardless of member order
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, member := range x {
+			other, ok := y[name]
+			if !ok || !equal(member, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	default:
+		return a == b
+	}
+}
+
+// mustMarshal encodes a decoded JSON value for an error message
+func mustMarshal(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
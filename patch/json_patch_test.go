@@ -0,0 +1,207 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// jsonEqual reports whether two JSON documents encode equal values
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	xs, _ := json.Marshal(x)
+	ys, _ := json.Marshal(y)
+	return string(xs) == string(ys)
+}
+
+// Most cases are the examples of RFC 6902 Appendix A
+func TestJSONPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"add to array end", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+		{"add at array length", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+		{"add nested array", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"add replaces member", `{"foo":"bar"}`, `[{"op":"add","path":"/foo","value":1}]`, `{"foo":1}`},
+		{"add whole document", `{"foo":"bar"}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`},
+		{"remove object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"replace array element", `{"a":[1,2,3]}`, `[{"op":"replace","path":"/a/2","value":4}]`, `{"a":[1,2,4]}`},
+		{"replace whole document", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{
+			"move object member",
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"move to same path", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`},
+		{"copy", `{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":[1]},"c":{"b":[1]}}`},
+		{
+			// A copy is independent of its source
+			"copy then change source",
+			`{"a":{"b":1}}`,
+			`[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/a/b","value":2}]`,
+			`{"a":{"b":2},"c":{"b":1}}`,
+		},
+		{"copy to array end", `{"a":[1,2]}`, `[{"op":"copy","from":"/a/0","path":"/a/-"}]`, `{"a":[1,2,1]}`},
+		{
+			"test then add",
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2},{"op":"add","path":"/x","value":true}]`,
+			`{"baz":"qux","foo":["a",2,"c"],"x":true}`,
+		},
+		{"test number by value", `{"n":1}`, `[{"op":"test","path":"/n","value":1.0}]`, `{"n":1}`},
+		{"test object regardless of order", `{"o":{"a":1,"b":2}}`, `[{"op":"test","path":"/o","value":{"b":2,"a":1}}]`, `{"o":{"a":1,"b":2}}`},
+		{"test whole document", `{"a":[]}`, `[{"op":"test","path":"","value":{"a":[]}}]`, `{"a":[]}`},
+		// ~1 is unescaped to / and ~0 to ~, in that order
+		{"escaped slash", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`},
+		{"escaped tilde", `{"m~n":1}`, `[{"op":"remove","path":"/m~0n"}]`, `{}`},
+		{"escaped tilde then one", `{"~1":1}`, `[{"op":"test","path":"/~01","value":1},{"op":"remove","path":"/~01"}]`, `{}`},
+		{"empty member name", `{"":1}`, `[{"op":"replace","path":"/","value":2}]`, `{"":2}`},
+		{"add member named -", `{}`, `[{"op":"add","path":"/-","value":1}]`, `{"-":1}`},
+		{"large number is exact", `{"n":12345678901234567890}`, `[{"op":"copy","from":"/n","path":"/m"}]`, `{"n":12345678901234567890,"m":12345678901234567890}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("ParseJSONPatch() error = %v", err)
+			}
+			got, err := p.Apply([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if !jsonEqual(t, string(got), tt.want) {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  error
+	}{
+		{"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ErrTestFailed},
+		{"test type differs", `{"n":1}`, `[{"op":"test","path":"/n","value":"1"}]`, ErrTestFailed},
+		{"test array length", `{"a":[1]}`, `[{"op":"test","path":"/a","value":[1,2]}]`, ErrTestFailed},
+		{"test missing member", `{}`, `[{"op":"test","path":"/a","value":1}]`, ErrConflict},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ErrConflict},
+		{"add past array end", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":2}]`, ErrConflict},
+		{"add with leading zero", `{"a":[1,2]}`, `[{"op":"add","path":"/a/01","value":2}]`, ErrConflict},
+		{"add negative index", `{"a":[1]}`, `[{"op":"add","path":"/a/-1","value":2}]`, ErrConflict},
+		{"add into scalar", `{"a":1}`, `[{"op":"add","path":"/a/b","value":2}]`, ErrConflict},
+		{"remove missing member", `{}`, `[{"op":"remove","path":"/a"}]`, ErrConflict},
+		{"remove array end", `{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, ErrConflict},
+		{"remove at array length", `{"a":[1]}`, `[{"op":"remove","path":"/a/1"}]`, ErrConflict},
+		{"remove whole document", `{}`, `[{"op":"remove","path":""}]`, ErrConflict},
+		{"replace missing member", `{}`, `[{"op":"replace","path":"/a","value":1}]`, ErrConflict},
+		{"replace array end", `{"a":[1]}`, `[{"op":"replace","path":"/a/-","value":2}]`, ErrConflict},
+		{"move missing source", `{}`, `[{"op":"move","from":"/a","path":"/b"}]`, ErrConflict},
+		{"copy missing source", `{}`, `[{"op":"copy","from":"/a","path":"/b"}]`, ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("ParseJSONPatch() error = %v", err)
+			}
+			got, err := p.Apply([]byte(tt.doc))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Apply() = %s, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// A failed operation leaves no partial result
+func TestJSONPatchAtomic(t *testing.T) {
+	p, err := ParseJSONPatch([]byte(`[{"op":"add","path":"/a","value":1},{"op":"test","path":"/a","value":2}]`))
+	if err != nil {
+		t.Fatalf("ParseJSONPatch() error = %v", err)
+	}
+	doc := []byte(`{}`)
+	if got, err := p.Apply(doc); err == nil || got != nil {
+		t.Errorf("Apply() = %s, %v, want an error and no result", got, err)
+	}
+	if string(doc) != `{}` {
+		t.Errorf("document changed to %s", doc)
+	}
+}
+
+func TestParseJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"not an array", `{"op":"add","path":"/a","value":1}`},
+		{"malformed JSON", `[{"op":"add"`},
+		{"missing op", `[{"path":"/a"}]`},
+		{"unknown op", `[{"op":"merge","path":"/a"}]`},
+		{"add without value", `[{"op":"add","path":"/a"}]`},
+		{"test without value", `[{"op":"test","path":"/a"}]`},
+		{"path without slash", `[{"op":"remove","path":"a"}]`},
+		{"invalid escape", `[{"op":"remove","path":"/a~2"}]`},
+		{"trailing tilde", `[{"op":"remove","path":"/a~"}]`},
+		{"move without from", `[{"op":"move","path":"/a"}]`},
+		{"move into itself", `[{"op":"move","from":"/a","path":"/a/b"}]`},
+		{"invalid from", `[{"op":"copy","from":"x","path":"/a"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJSONPatch([]byte(tt.patch)); !errors.Is(err, ErrInvalid) {
+				t.Errorf("ParseJSONPatch() error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+	}{
+		{"", []string{}},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b/m~0n", []string{"a/b", "m~n"}},
+		{"/~01", []string{"~1"}},
+		{"/~10", []string{"/0"}},
+		{"//", []string{"", ""}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if err != nil {
+			t.Errorf("parsePointer(%q) error = %v", tt.pointer, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+				break
+			}
+		}
+	}
+}
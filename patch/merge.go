@@ -0,0 +1,51 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MergePatch is a JSON Merge Patch (RFC 7396). Object members of the patch
+// replace those of the document, recursively; null members remove them. Any
+// other patch value replaces the document.
+type MergePatch struct {
+	value interface{}
+}
+
+// ParseMergePatch parses a JSON Merge Patch document
+func ParseMergePatch(data []byte) (*MergePatch, error) {
+	value, err := decode(data)
+	if err != nil {
+		return nil, invalid("malformed merge patch: %v", err)
+	}
+	return &MergePatch{value: value}, nil
+}
+
+// Apply returns doc with the merge patch applied
+func (p *MergePatch) Apply(doc []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return json.Marshal(merge(target, p.value))
+}
+
+// merge implements the MergePatch algorithm of RFC 7396 section 2
+func merge(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	result, ok := target.(map[string]interface{})
+	if !ok {
+		result = map[string]interface{}{}
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(result, name)
+			continue
+		}
+		result[name] = merge(result[name], value)
+	}
+	return result
+}
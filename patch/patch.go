@@ -0,0 +1,75 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON values.
+//
+// Patches operate on the JSON encoding of an entity. A patch either applies
+// completely or returns an error and no result, so callers can apply it to a
+// freshly loaded entity and save the outcome in one transaction.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Media types of the supported patch formats
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	// ErrInvalid is returned for a patch document that is malformed
+	ErrInvalid = errors.New("invalid patch")
+	// ErrConflict is returned when a well-formed patch cannot be applied to the
+	// document, e.g. a path does not exist
+	ErrConflict = errors.New("patch cannot be applied")
+	// ErrTestFailed is returned when a JSON Patch test operation does not match
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Patch is a parsed patch document of either format
+type Patch interface {
+	// Apply returns doc with the patch applied, leaving doc unchanged
+	Apply(doc []byte) ([]byte, error)
+}
+
+// Parse parses a patch document of the given media type. It returns false
+// when the media type is not a supported patch format.
+func Parse(mediaType string, data []byte) (Patch, bool, error) {
+	switch mediaType {
+	case MergePatchType:
+		p, err := ParseMergePatch(data)
+		return p, true, err
+	case JSONPatchType:
+		p, err := ParseJSONPatch(data)
+		return p, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// decode parses a JSON value, keeping numbers exact
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return value, nil
+}
+
+// invalid builds an error wrapping ErrInvalid
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// conflict builds an error wrapping ErrConflict
+func conflict(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrConflict, fmt.Sprintf(format, args...))
+}
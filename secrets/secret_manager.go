@@ -69,6 +69,21 @@ func (sm *SecretManager) GetAdminPassword() (string, error) {
 	return sm.GetSecret(key)
 }
 
+// GetOIDCSigningKeys returns the PEM private keys of the OpenID Connect provider,
+// read from the file named by OIDC_SIGNING_KEYS_FILE (e.g. a mounted Kubernetes
+// secret) or from the OIDC_SIGNING_KEYS variable. The first key signs tokens.
+func (sm *SecretManager) GetOIDCSigningKeys() (string, error) {
+	if path := os.Getenv("OIDC_SIGNING_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read OIDC signing keys: %w", err)
+		}
+		return string(data), nil
+	}
+	key := getEnvOrDefault("OIDC_SIGNING_KEYS_KEY", "OIDC_SIGNING_KEYS")
+	return sm.GetSecret(key)
+}
+
 // getEnvOrDefault returns environment variable value or default
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {